@@ -0,0 +1,272 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.cypherpunks.ru/recfile"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	AddrStateFile = "addrs.state"
+	AddrStateLock = "addrs.lock"
+)
+
+var (
+	AddrBackoffMin = time.Minute
+	AddrBackoffMax = time.Hour
+)
+
+// Remembered result of calling the node's address.
+type AddrState struct {
+	Addr        string
+	LastSuccess time.Time
+	LastFailure time.Time
+	Failures    int
+}
+
+// Backoff duration after the last failure: it doubles with each
+// consecutive failure, starting from AddrBackoffMin up to AddrBackoffMax.
+func (st *AddrState) Backoff() time.Duration {
+	if st.Failures == 0 {
+		return 0
+	}
+	d := AddrBackoffMin
+	for i := 1; i < st.Failures && d < AddrBackoffMax; i++ {
+		d *= 2
+	}
+	if d > AddrBackoffMax {
+		d = AddrBackoffMax
+	}
+	return d
+}
+
+func (st *AddrState) InBackoff(now time.Time) bool {
+	return st.Failures > 0 && st.LastFailure.Add(st.Backoff()).After(now)
+}
+
+func (ctx *Ctx) addrStatePath(nodeId *NodeId) string {
+	return filepath.Join(ctx.Spool, nodeId.String(), AddrStateFile)
+}
+
+func (ctx *Ctx) AddrStatesLoad(nodeId *NodeId) (map[string]*AddrState, error) {
+	states := make(map[string]*AddrState)
+	fd, err := os.Open(ctx.addrStatePath(nodeId))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return states, nil
+		}
+		return nil, err
+	}
+	defer fd.Close()
+	r := recfile.NewReader(fd)
+	for {
+		m, err := r.NextMap()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		st := AddrState{Addr: m["Addr"]}
+		if st.Addr == "" {
+			continue
+		}
+		if v := m["LastSuccess"]; v != "" {
+			if st.LastSuccess, err = time.Parse(time.RFC3339Nano, v); err != nil {
+				return nil, err
+			}
+		}
+		if v := m["LastFailure"]; v != "" {
+			if st.LastFailure, err = time.Parse(time.RFC3339Nano, v); err != nil {
+				return nil, err
+			}
+		}
+		if v := m["Failures"]; v != "" {
+			if st.Failures, err = strconv.Atoi(v); err != nil {
+				return nil, err
+			}
+		}
+		states[st.Addr] = &st
+	}
+	return states, nil
+}
+
+func (ctx *Ctx) AddrStatesSave(nodeId *NodeId, states map[string]*AddrState) error {
+	addrs := make([]string, 0, len(states))
+	for addr := range states {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	var buf bytes.Buffer
+	w := recfile.NewWriter(&buf)
+	for _, addr := range addrs {
+		st := states[addr]
+		if _, err := w.RecordStart(); err != nil {
+			return err
+		}
+		fields := []recfile.Field{
+			{Name: "Addr", Value: st.Addr},
+			{Name: "Failures", Value: strconv.Itoa(st.Failures)},
+		}
+		if !st.LastSuccess.IsZero() {
+			fields = append(fields, recfile.Field{
+				Name:  "LastSuccess",
+				Value: st.LastSuccess.UTC().Format(time.RFC3339Nano),
+			})
+		}
+		if !st.LastFailure.IsZero() {
+			fields = append(fields, recfile.Field{
+				Name:  "LastFailure",
+				Value: st.LastFailure.UTC().Format(time.RFC3339Nano),
+			})
+		}
+		if _, err := w.WriteFields(fields...); err != nil {
+			return err
+		}
+	}
+	dir := filepath.Join(ctx.Spool, nodeId.String())
+	if err := ensureDir(dir); err != nil {
+		return err
+	}
+	tmp, err := TempFile(dir, "addrs")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = os.Rename(tmp.Name(), ctx.addrStatePath(nodeId)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return DirSync(dir)
+}
+
+// Remember the results of calling addresses: true means successful call.
+// State is locked, as the node can be called by several processes.
+func (ctx *Ctx) AddrStatesUpdate(nodeId *NodeId, results map[string]bool) error {
+	if len(results) == 0 {
+		return nil
+	}
+	dir := filepath.Join(ctx.Spool, nodeId.String())
+	if err := ensureDir(dir); err != nil {
+		return err
+	}
+	lock, err := os.OpenFile(
+		filepath.Join(dir, AddrStateLock),
+		os.O_CREATE|os.O_WRONLY,
+		os.FileMode(0666),
+	)
+	if err != nil {
+		return err
+	}
+	if err = unix.Flock(int(lock.Fd()), unix.LOCK_EX); err != nil {
+		lock.Close()
+		return err
+	}
+	defer ctx.UnlockDir(lock)
+	states, err := ctx.AddrStatesLoad(nodeId)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for addr, ok := range results {
+		st := states[addr]
+		if st == nil {
+			st = &AddrState{Addr: addr}
+			states[addr] = st
+		}
+		if ok {
+			st.LastSuccess = now
+			st.Failures = 0
+		} else {
+			st.LastFailure = now
+			st.Failures++
+		}
+	}
+	return ctx.AddrStatesSave(nodeId, states)
+}
+
+// Reorder addresses: recently successful ones go first, addresses with
+// unknown state keep their order after them, and the ones being in
+// backoff are the last, the sooner it ends the earlier.
+func (ctx *Ctx) AddrsPrefer(nodeId *NodeId, addrs []string) []string {
+	states, err := ctx.AddrStatesLoad(nodeId)
+	if err != nil {
+		ctx.LogE("call-addrs-state", LEs{{"Node", nodeId}}, err, func(les LEs) string {
+			return "Loading addresses state of " + ctx.NodeName(nodeId)
+		})
+		return addrs
+	}
+	now := time.Now()
+	seen := make(map[string]struct{}, len(addrs))
+	preferred := make([]string, 0, len(addrs))
+	var backedOff []string
+	for _, addr := range addrs {
+		if _, exists := seen[addr]; exists {
+			continue
+		}
+		seen[addr] = struct{}{}
+		st := states[addrLogged(addr)]
+		if st != nil && st.InBackoff(now) {
+			ctx.LogD("call-addr-backoff", LEs{
+				{"Node", nodeId},
+				{"Addr", st.Addr},
+				{"Failures", st.Failures},
+			}, func(les LEs) string {
+				return "Deferring " + st.Addr + " address: in backoff till " +
+					st.LastFailure.Add(st.Backoff()).Format(time.RFC3339)
+			})
+			backedOff = append(backedOff, addr)
+			continue
+		}
+		preferred = append(preferred, addr)
+	}
+	lastSuccess := func(addr string) time.Time {
+		if st := states[addrLogged(addr)]; st != nil {
+			return st.LastSuccess
+		}
+		return time.Time{}
+	}
+	sort.SliceStable(preferred, func(i, j int) bool {
+		return lastSuccess(preferred[i]).After(lastSuccess(preferred[j]))
+	})
+	backoffTill := func(addr string) time.Time {
+		st := states[addrLogged(addr)]
+		return st.LastFailure.Add(st.Backoff())
+	}
+	sort.SliceStable(backedOff, func(i, j int) bool {
+		return backoffTill(backedOff[i]).Before(backoffTill(backedOff[j]))
+	})
+	return append(preferred, backedOff...)
+}
@@ -19,6 +19,9 @@ package nncp
 
 import (
 	"errors"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
 )
 
 const AreaDir = "area"
@@ -38,13 +41,24 @@ type Area struct {
 	Id   *AreaId
 	Pub  *[32]byte
 	Prv  *[32]byte
+	// Public key able to sign new epochs keys
+	Owner ed25519.PublicKey
 
 	Subs []*NodeId
+	// Configured subscribers, without approved requests applied
+	subsCfg []*NodeId
+	// Policy of remote subscription requests, denied if empty
+	SubsPolicy string
 
-	Exec     map[string][]string
-	Incoming *string
+	Exec       map[string][]string
+	ExecPolicy map[string]*ExecPolicy
+	Incoming   *string
 
 	AllowUnknown bool
+	Moderation   *AreaModeration
+
+	// Retention period of archived messages, no archive if zero
+	Archive time.Duration
 }
 
 func AreaIdFromString(raw string) (*AreaId, error) {
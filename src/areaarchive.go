@@ -0,0 +1,236 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	AreaArchiveDir = "archive"
+
+	// Built-in exec handle receiving area's backfill requests
+	AreaBackfillHandle = "nncp-area-backfill"
+)
+
+var AreaBackfillDenied = errors.New("backfill is allowed only for area's subscribers")
+
+func (ctx *Ctx) areaArchiveDir(area *Area) string {
+	return filepath.Join(ctx.Spool, AreaDir, area.Id.String(), AreaArchiveDir)
+}
+
+func (ctx *Ctx) areaArchived(area *Area, msgHash string) bool {
+	_, err := os.Stat(filepath.Join(ctx.areaArchiveDir(area), msgHash))
+	return err == nil
+}
+
+// Save encrypted area's message to the archive, removing expired ones.
+func (ctx *Ctx) areaArchive(area *Area, msgHash string, r io.Reader) error {
+	dir := ctx.areaArchiveDir(area)
+	if err := ensureDir(dir); err != nil {
+		return err
+	}
+	tmp, err := TempFile(dir, "archive")
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = os.Rename(tmp.Name(), filepath.Join(dir, msgHash)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = DirSync(dir); err != nil {
+		return err
+	}
+	_, err = ctx.AreaArchive(area)
+	return err
+}
+
+// List archived messages of the area, the oldest first. Messages older
+// than area's archive retention period are removed.
+func (ctx *Ctx) AreaArchive(area *Area) ([]string, error) {
+	dir := ctx.areaArchiveDir(area)
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	sort.Slice(fis, func(i, j int) bool {
+		return fis[i].ModTime().Before(fis[j].ModTime())
+	})
+	now := time.Now()
+	msgHashes := make([]string, 0, len(fis))
+	for _, fi := range fis {
+		if !fi.Mode().IsRegular() || strings.HasPrefix(fi.Name(), "nncp") {
+			// Skip temporary files
+			continue
+		}
+		if area.Archive == 0 || now.Sub(fi.ModTime()) > area.Archive {
+			if err = os.Remove(filepath.Join(dir, fi.Name())); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		msgHashes = append(msgHashes, fi.Name())
+	}
+	return msgHashes, nil
+}
+
+// Ask the node to send archived messages of the area we have not seen.
+// Node has to keep area's archive and have us as area's subscriber.
+func (ctx *Ctx) TxAreaBackfill(node *Node, nice uint8, area *Area) error {
+	seenDir := filepath.Join(
+		ctx.Spool, ctx.SelfId.String(), AreaDir, area.Id.String(),
+	)
+	fis, err := ioutil.ReadDir(seenDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	seen := make([]string, 0, len(fis))
+	for _, fi := range fis {
+		seen = append(seen, fi.Name())
+	}
+	body := strings.Join(seen, "\n")
+	return ctx.TxExec(
+		node, nice, nice, AreaBackfillHandle, []string{area.Id.String()},
+		strings.NewReader(body), 0, MaxFileSize, false, nil,
+	)
+}
+
+// Send archived messages of the area to the requesting subscriber,
+// except for the ones it has seen and ones already sent to it. Number
+// of sent messages is returned.
+func (ctx *Ctx) areaBackfill(
+	sender *Node,
+	nice uint8,
+	args []string,
+	body io.Reader,
+) (int, error) {
+	if len(args) != 1 {
+		return 0, errors.New("invalid backfill request")
+	}
+	areaId, err := AreaIdFromString(args[0])
+	if err != nil {
+		return 0, err
+	}
+	area := ctx.AreaId2Area[*areaId]
+	if area == nil {
+		return 0, errors.New("unknown area")
+	}
+	node := ctx.Neigh[*sender.Id]
+	if node == nil {
+		return 0, AreaBackfillDenied
+	}
+	areaLock := ctx.areaLock(area.Id)
+	areaLock.Lock()
+	defer areaLock.Unlock()
+	isSub := false
+	for _, nodeId := range area.Subs {
+		if *nodeId == *node.Id {
+			isSub = true
+			break
+		}
+	}
+	if !isSub {
+		return 0, AreaBackfillDenied
+	}
+	seen := make(map[string]struct{})
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		seen[scanner.Text()] = struct{}{}
+	}
+	if err = scanner.Err(); err != nil {
+		return 0, err
+	}
+	msgHashes, err := ctx.AreaArchive(area)
+	if err != nil {
+		return 0, err
+	}
+	seenDir := filepath.Join(
+		ctx.Spool, node.Id.String(), AreaDir, area.Id.String(),
+	)
+	var sent int
+	for _, msgHash := range msgHashes {
+		if _, exists := seen[msgHash]; exists {
+			continue
+		}
+		seenPath := filepath.Join(seenDir, msgHash)
+		if _, err = os.Stat(seenPath); err == nil {
+			continue
+		}
+		if err = ctx.areaBackfillMsg(node, nice, area, msgHash); err != nil {
+			return sent, err
+		}
+		if err = ensureDir(seenDir); err != nil {
+			return sent, err
+		}
+		if fd, err := os.Create(seenPath); err == nil {
+			fd.Close()
+		} else {
+			return sent, err
+		}
+		sent++
+	}
+	if sent > 0 {
+		return sent, DirSync(seenDir)
+	}
+	return sent, nil
+}
+
+func (ctx *Ctx) areaBackfillMsg(node *Node, nice uint8, area *Area, msgHash string) error {
+	fd, err := os.Open(filepath.Join(ctx.areaArchiveDir(area), msgHash))
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	fi, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+	pkt, err := NewPkt(PktTypeArea, 0, area.Id[:])
+	if err != nil {
+		return err
+	}
+	_, _, _, err = ctx.Tx(
+		node, pkt, nice, fi.Size(), 0, MaxFileSize, fd, msgHash, nil,
+	)
+	return err
+}
@@ -0,0 +1,126 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAreaBackfill(t *testing.T) {
+	spool, err := ioutil.TempDir("", "testareaarchive")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(spool)
+	nodeOur, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeAuthor, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeLate, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	areaId, newArea := testAreaGen(t)
+	ctxAuthor := testAreaCtx(filepath.Join(spool, "author"), nodeAuthor, nodeOur)
+	ctxAuthor.AreaId2Area[areaId] = newArea(nodeOur.Id)
+	ctx := testAreaCtx(filepath.Join(spool, "our"), nodeOur, nodeAuthor, nodeLate)
+	area := newArea(nodeAuthor.Id)
+	area.Archive = time.Hour
+	ctx.AreaId2Area[areaId] = area
+	ctxLate := testAreaCtx(
+		filepath.Join(spool, "late"), nodeLate, nodeOur, nodeAuthor,
+	)
+	ctxLate.AreaId2Area[areaId] = newArea(nodeOur.Id)
+
+	for i := 0; i < 2; i++ {
+		testAreaPost(t, ctxAuthor, areaId, "true", ctx)
+	}
+	archived, err := ctx.AreaArchive(area)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archived) != 2 {
+		t.Fatal("messages are not archived", archived)
+	}
+
+	lateSeenDir := filepath.Join(
+		ctxLate.Spool, nodeLate.Id.String(), AreaDir, areaId.String(),
+	)
+	lateSeen := func() []string {
+		fis, _ := ioutil.ReadDir(lateSeenDir)
+		var seen []string
+		for _, fi := range fis {
+			seen = append(seen, fi.Name())
+		}
+		return seen
+	}
+	if err = os.MkdirAll(lateSeenDir, os.FileMode(0777)); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(
+		filepath.Join(lateSeenDir, archived[0]), nil, os.FileMode(0666),
+	); err != nil {
+		t.Fatal(err)
+	}
+	backfill := func() int {
+		if err := ctxLate.TxAreaBackfill(
+			ctxLate.Neigh[*nodeOur.Id], DefaultNiceExec, ctxLate.AreaId2Area[areaId],
+		); err != nil {
+			t.Fatal(err)
+		}
+		testXx(t, ctxLate, ctx)
+		fis, _ := ioutil.ReadDir(
+			filepath.Join(ctx.Spool, nodeLate.Id.String(), string(TTx)),
+		)
+		return len(fis)
+	}
+
+	// Only subscribers can request backfill
+	if backfill() != 0 {
+		t.Fatal("backfill is sent to non-subscriber")
+	}
+	if len(dirFiles(filepath.Join(ctx.Spool, nodeLate.Id.String(), string(TRx)))) != 1 {
+		t.Fatal("denied request is not left in inbound queue")
+	}
+
+	area.Subs = append(area.Subs, nodeLate.Id)
+	if backfill() != 1 {
+		t.Fatal("seen message is backfilled")
+	}
+	testXx(t, ctx, ctxLate)
+	if seen := lateSeen(); len(seen) != 2 {
+		t.Fatal("backfilled message is not tossed", seen)
+	}
+	if backfill() != 0 {
+		t.Fatal("message is backfilled twice")
+	}
+
+	// Expired messages are removed
+	area.Archive = time.Nanosecond
+	if archived, err = ctx.AreaArchive(area); err != nil || len(archived) != 0 {
+		t.Fatal("expired messages are not removed", archived, err)
+	}
+}
@@ -0,0 +1,363 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	xdr "github.com/davecgh/go-xdr/xdr2"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+const (
+	AreaEpochDir = "epoch"
+
+	// Built-in exec handle receiving area's rekey messages
+	AreaRekeyHandle = "nncp-area-rekey"
+
+	AreaRekeyGrace   = 7 * 24 * time.Hour
+	AreaRekeyMaxSize = 1 << 10
+)
+
+var (
+	AreaNotOwner   = errors.New("we are not area's owner")
+	AreaNoOwner    = errors.New("area has no owner")
+	AreaRekeyStale = errors.New("rekey is not newer than current epoch")
+	AreaKeyExpired = errors.New("area's epoch key is expired")
+)
+
+type AreaRekeyTbs struct {
+	Magic [8]byte
+	Area  AreaId
+	Epoch uint32
+	Pub   [32]byte
+	Prv   [32]byte
+	Grace uint64
+	When  uint64
+}
+
+// New keypair of the area's epoch, signed by area's owner. When is the
+// UNIX time of its creation. Previous epochs keys are accepted during
+// Grace seconds after it.
+type AreaRekey struct {
+	Magic [8]byte
+	Area  AreaId
+	Epoch uint32
+	Pub   [32]byte
+	Prv   [32]byte
+	Grace uint64
+	When  uint64
+	Sign  [ed25519.SignatureSize]byte
+}
+
+func (rk *AreaRekey) tbs() []byte {
+	var buf bytes.Buffer
+	if _, err := xdr.Marshal(&buf, AreaRekeyTbs{
+		Magic: rk.Magic,
+		Area:  rk.Area,
+		Epoch: rk.Epoch,
+		Pub:   rk.Pub,
+		Prv:   rk.Prv,
+		Grace: rk.Grace,
+		When:  rk.When,
+	}); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// Area's keypair of the single epoch. Epoch zero is the configured
+// one, identified by area's id. Later ones are identified by the hash
+// of their public key, used as a recipient of encrypted messages.
+type AreaKey struct {
+	Epoch uint32
+	Id    *NodeId
+	Pub   *[32]byte
+	Prv   *[32]byte
+	Since time.Time
+	// Time after which key is not accepted, zero for the current one
+	Till time.Time
+}
+
+// Parse rekey message and verify that it is signed by area's owner.
+func AreaRekeyParse(data []byte, area *Area) (*AreaRekey, error) {
+	if area.Owner == nil {
+		return nil, AreaNoOwner
+	}
+	var rk AreaRekey
+	if _, err := xdr.Unmarshal(bytes.NewReader(data), &rk); err != nil {
+		return nil, err
+	}
+	if rk.Magic != MagicNNCPKv1.B {
+		return nil, BadMagic
+	}
+	if rk.Area != *area.Id {
+		return nil, errors.New("rekey is for another area")
+	}
+	if rk.Epoch == 0 {
+		return nil, errors.New("invalid rekey epoch")
+	}
+	if !ed25519.Verify(area.Owner, rk.tbs(), rk.Sign[:]) {
+		return nil, errors.New("invalid rekey signature")
+	}
+	return &rk, nil
+}
+
+func (ctx *Ctx) areaEpochDir(area *Area) string {
+	return filepath.Join(ctx.Spool, AreaDir, area.Id.String(), AreaEpochDir)
+}
+
+// Load area's keys of all epochs, the oldest first. Rekeys signed by
+// no longer known owner are ignored.
+func (ctx *Ctx) AreaKeys(area *Area) ([]*AreaKey, error) {
+	var keys []*AreaKey
+	if area.Pub != nil {
+		key := AreaKey{Id: new(NodeId), Pub: area.Pub, Prv: area.Prv}
+		copy(key.Id[:], area.Id[:])
+		keys = append(keys, &key)
+	}
+	if area.Owner == nil {
+		return keys, nil
+	}
+	dir := ctx.areaEpochDir(area)
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return keys, nil
+		}
+		return nil, err
+	}
+	rks := make([]*AreaRekey, 0, len(fis))
+	for _, fi := range fis {
+		if _, err = strconv.ParseUint(fi.Name(), 10, 32); err != nil {
+			// Skip temporary files
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if rk, err := AreaRekeyParse(data, area); err == nil {
+			rks = append(rks, rk)
+		}
+	}
+	sort.Slice(rks, func(i, j int) bool { return rks[i].Epoch < rks[j].Epoch })
+	for _, rk := range rks {
+		since := time.Unix(int64(rk.When), 0)
+		if len(keys) > 0 {
+			keys[len(keys)-1].Till = since.Add(time.Duration(rk.Grace) * time.Second)
+		}
+		idRaw := blake2b.Sum256(rk.Pub[:])
+		id := NodeId(idRaw)
+		pub, prv := rk.Pub, rk.Prv
+		keys = append(keys, &AreaKey{
+			Epoch: rk.Epoch,
+			Id:    &id,
+			Pub:   &pub,
+			Prv:   &prv,
+			Since: since,
+		})
+	}
+	return keys, nil
+}
+
+// Key of the newest epoch, used for sending.
+func (ctx *Ctx) areaKeyCurrent(area *Area) (*AreaKey, error) {
+	keys, err := ctx.AreaKeys(area)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("area has no encryption keys")
+	}
+	return keys[len(keys)-1], nil
+}
+
+// Find the key, messages are encrypted to. Nil is returned for unknown
+// key, that may belong to the epoch whose rekey has not arrived yet.
+func (ctx *Ctx) areaKeyFind(area *Area, id *NodeId) (*AreaKey, error) {
+	keys, err := ctx.AreaKeys(area)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, key := range keys {
+		if *key.Id != *id {
+			continue
+		}
+		if key.Prv == nil || (!key.Till.IsZero() && now.After(key.Till)) {
+			return nil, AreaKeyExpired
+		}
+		return key, nil
+	}
+	return nil, nil
+}
+
+// Verify and remember the rekey message, if it is newer than current
+// epoch. Caller must hold area's lock.
+func (ctx *Ctx) areaRekeySave(area *Area, data []byte) (*AreaRekey, error) {
+	rk, err := AreaRekeyParse(data, area)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := ctx.areaKeyCurrent(area)
+	if err == nil && rk.Epoch <= cur.Epoch {
+		return nil, AreaRekeyStale
+	}
+	dir := ctx.areaEpochDir(area)
+	if err = ensureDir(dir); err != nil {
+		return nil, err
+	}
+	tmp, err := TempFile(dir, "rekey")
+	if err != nil {
+		return nil, err
+	}
+	// It contains area's private key
+	if err = tmp.Chmod(os.FileMode(0600)); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	dst := filepath.Join(dir, strconv.FormatUint(uint64(rk.Epoch), 10))
+	if err = os.Rename(tmp.Name(), dst); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return rk, DirSync(dir)
+}
+
+// Start new area's epoch with freshly generated keypair and send it to
+// the remaining members. Removed members can not decrypt messages of the
+// new epoch. Previous epoch keys are accepted during the grace period.
+func (ctx *Ctx) AreaRekey(
+	area *Area,
+	grace time.Duration,
+	members []*Node,
+	nice uint8,
+) (*AreaRekey, error) {
+	if area.Owner == nil {
+		return nil, AreaNoOwner
+	}
+	if !bytes.Equal(area.Owner, ctx.Self.SignPub) {
+		return nil, AreaNotOwner
+	}
+	areaLock := ctx.areaLock(area.Id)
+	areaLock.Lock()
+	defer areaLock.Unlock()
+	cur, err := ctx.areaKeyCurrent(area)
+	if err != nil {
+		return nil, err
+	}
+	pub, prv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	rk := AreaRekey{
+		Magic: MagicNNCPKv1.B,
+		Area:  *area.Id,
+		Epoch: cur.Epoch + 1,
+		Pub:   *pub,
+		Prv:   *prv,
+		Grace: uint64(grace.Seconds()),
+		When:  uint64(time.Now().Unix()),
+	}
+	copy(rk.Sign[:], ed25519.Sign(ctx.Self.SignPrv, rk.tbs()))
+	var buf bytes.Buffer
+	if _, err = xdr.Marshal(&buf, &rk); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+	if _, err = ctx.areaRekeySave(area, data); err != nil {
+		return nil, err
+	}
+	for _, node := range members {
+		if err = ctx.TxExec(
+			node, nice, nice, AreaRekeyHandle, []string{area.Id.String()},
+			bytes.NewReader(data), 0, MaxFileSize, false, nil,
+		); err != nil {
+			return &rk, err
+		}
+	}
+	return &rk, nil
+}
+
+// Process received rekey message. Exec packets are encrypted to us, so
+// the new private key is not exposed to relaying nodes. Only area's
+// owner is allowed to send it directly to us.
+func (ctx *Ctx) areaRekeyProcess(
+	sender *Node,
+	args []string,
+	body io.Reader,
+) (*AreaRekey, error) {
+	if len(args) != 1 {
+		return nil, errors.New("invalid rekey message")
+	}
+	areaId, err := AreaIdFromString(args[0])
+	if err != nil {
+		return nil, err
+	}
+	area := ctx.AreaId2Area[*areaId]
+	if area == nil {
+		return nil, errors.New("unknown area")
+	}
+	if _, isArea := ctx.AreaId2Area[AreaId(*sender.Id)]; isArea {
+		return nil, errors.New("rekey is sent through the area")
+	}
+	if area.Owner == nil {
+		return nil, AreaNoOwner
+	}
+	if !bytes.Equal(sender.SignPub, area.Owner) {
+		return nil, errors.New("rekey is not sent by area's owner")
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(body, AreaRekeyMaxSize))
+	if err != nil {
+		return nil, err
+	}
+	if _, err = AreaRekeyParse(data, area); err != nil {
+		return nil, err
+	}
+	areaLock := ctx.areaLock(area.Id)
+	areaLock.Lock()
+	defer areaLock.Unlock()
+	return ctx.areaRekeySave(area, data)
+}
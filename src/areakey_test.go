@@ -0,0 +1,215 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAreaRekey(t *testing.T) {
+	spool, err := ioutil.TempDir("", "testareakey")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(spool)
+	nodeOwner, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeMember, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeRemoved, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	areaId, areaGen := testAreaGen(t)
+	newArea := func(subs ...*NodeId) *Area {
+		area := areaGen(subs...)
+		area.Owner = nodeOwner.SignPub
+		return area
+	}
+	decrypted := make(map[*Ctx]int)
+	newCtx := func(name string, self *NodeOur, area *Area, neighs ...*NodeOur) *Ctx {
+		ctx := testAreaCtx(filepath.Join(spool, name), self, neighs...)
+		ctx.AreaId2Area[areaId] = area
+		ctx.ExecRegister(nil, "post", func(cctx context.Context, req *ExecRequest) error {
+			decrypted[ctx]++
+			return nil
+		})
+		return ctx
+	}
+	ctxOwner := newCtx(
+		"owner", nodeOwner, newArea(nodeMember.Id, nodeRemoved.Id),
+		nodeMember, nodeRemoved,
+	)
+	ctxMember := newCtx(
+		"member", nodeMember, newArea(nodeOwner.Id), nodeOwner, nodeRemoved,
+	)
+	ctxRemoved := newCtx(
+		"removed", nodeRemoved, newArea(nodeOwner.Id), nodeOwner, nodeMember,
+	)
+
+	post := func() {
+		testAreaPost(t, ctxOwner, areaId, "post", ctxMember, ctxRemoved)
+	}
+	post()
+	if decrypted[ctxMember] != 1 || decrypted[ctxRemoved] != 1 {
+		t.Fatal("message is not decrypted")
+	}
+
+	if _, err = ctxRemoved.AreaRekey(
+		ctxRemoved.AreaId2Area[areaId], time.Hour, nil, DefaultNiceExec,
+	); !errors.Is(err, AreaNotOwner) {
+		t.Fatal("rekeyed by non-owner", err)
+	}
+	rk, err := ctxOwner.AreaRekey(
+		ctxOwner.AreaId2Area[areaId], time.Hour, nil, DefaultNiceExec,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rk.Epoch != 1 {
+		t.Fatal("unexpected epoch", rk.Epoch)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(
+		ctxOwner.areaEpochDir(ctxOwner.AreaId2Area[areaId]), "1",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rekeySend := func(src, dst *Ctx) {
+		if err := src.TxExec(
+			src.Neigh[*dst.SelfId], DefaultNiceExec, DefaultNiceExec,
+			AreaRekeyHandle, []string{areaId.String()}, bytes.NewReader(data),
+			0, MaxFileSize, false, nil,
+		); err != nil {
+			t.Fatal(err)
+		}
+		// Keep already received packets, unlike testXx
+		txDir := filepath.Join(src.Spool, dst.SelfId.String(), string(TTx))
+		rxDir := filepath.Join(dst.Spool, src.SelfId.String(), string(TRx))
+		if err := os.MkdirAll(rxDir, os.FileMode(0777)); err != nil {
+			t.Fatal(err)
+		}
+		fis, err := ioutil.ReadDir(txDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, fi := range fis {
+			if err = os.Rename(
+				filepath.Join(txDir, fi.Name()),
+				filepath.Join(rxDir, fi.Name()),
+			); err != nil {
+				t.Fatal(err)
+			}
+		}
+		dst.Toss(src.SelfId, TRx, DefaultNiceExec,
+			false, false, false, false, false, false, false, false)
+	}
+	rxJobs := func(ctx *Ctx) (jobs []string) {
+		rx := filepath.Join(ctx.Spool, nodeOwner.Id.String(), string(TRx))
+		fis, _ := ioutil.ReadDir(rx)
+		for _, fi := range fis {
+			if fi.Mode().IsRegular() {
+				jobs = append(jobs, filepath.Join(rx, fi.Name()))
+			}
+		}
+		return
+	}
+
+	// New epoch message, arrived before the rekey, waits for it
+	post()
+	if decrypted[ctxMember] != 1 || len(rxJobs(ctxMember)) != 1 {
+		t.Fatal("new epoch message is not kept before the rekey")
+	}
+
+	// Only owner is allowed to rekey
+	rekeySend(ctxRemoved, ctxMember)
+	keys, err := ctxMember.AreaKeys(ctxMember.AreaId2Area[areaId])
+	if err != nil || len(keys) != 1 {
+		t.Fatal("rekey from non-owner is accepted", keys, err)
+	}
+
+	rekeySend(ctxOwner, ctxMember)
+	keys, err = ctxMember.AreaKeys(ctxMember.AreaId2Area[areaId])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[1].Epoch != 1 || *keys[1].Pub != rk.Pub {
+		t.Fatal("rekey is not saved", keys)
+	}
+	if keys[0].Till.IsZero() || !keys[1].Till.IsZero() {
+		t.Fatal("invalid grace period", keys)
+	}
+	ctxMember.Toss(nodeOwner.Id, TRx, DefaultNiceExec,
+		false, false, false, false, false, false, false, false)
+	if decrypted[ctxMember] != 2 || len(rxJobs(ctxMember)) != 0 {
+		t.Fatal("waiting message is not decrypted after the rekey")
+	}
+
+	// Previous epoch is still accepted during grace period
+	key, err := ctxMember.areaKeyFind(ctxMember.AreaId2Area[areaId], keys[0].Id)
+	if err != nil || key == nil {
+		t.Fatal("previous epoch is not accepted", err)
+	}
+
+	post()
+	if decrypted[ctxMember] != 3 {
+		t.Fatal("new epoch message is not decrypted by member")
+	}
+	if decrypted[ctxRemoved] != 1 {
+		t.Fatal("new epoch message is decrypted by removed member")
+	}
+
+	// Removed member waits for the rekey no longer than grace period
+	jobs := rxJobs(ctxRemoved)
+	if len(jobs) != 1 {
+		t.Fatal("new epoch message is not kept", jobs)
+	}
+	old := time.Now().Add(-AreaRekeyGrace - time.Hour)
+	for _, job := range jobs {
+		if err = os.Chtimes(job, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctxRemoved.Toss(nodeOwner.Id, TRx, DefaultNiceExec,
+		false, false, false, false, false, false, false, false)
+	if len(rxJobs(ctxRemoved)) != 0 {
+		t.Fatal("undecryptable message is not dropped")
+	}
+	known, err := ctxRemoved.areaKnown(ctxRemoved.AreaId2Area[areaId])
+	if err != nil || len(known) != 2 {
+		t.Fatal("undecryptable message is not seen", known, err)
+	}
+
+	// Repeated rekey is ignored
+	if _, err = ctxMember.areaRekeySave(
+		ctxMember.AreaId2Area[areaId], data,
+	); !errors.Is(err, AreaRekeyStale) {
+		t.Fatal("stale rekey is accepted", err)
+	}
+}
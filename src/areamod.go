@@ -0,0 +1,292 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	xdr "github.com/davecgh/go-xdr/xdr2"
+	"golang.org/x/crypto/ed25519"
+)
+
+const (
+	AreaRevokedDir = "revoked"
+	AreaQuotaDir   = "quota"
+
+	AreaRevocationMaxSize = 1 << 10
+)
+
+var (
+	AreaAuthorDenied    = errors.New("author is not allowed to post")
+	AreaAuthorUnknown   = errors.New("author is unknown")
+	AreaAuthorRevoked   = errors.New("author is revoked")
+	AreaAuthorBadSign   = errors.New("invalid author signature")
+	AreaQuotaMsgs       = errors.New("author daily messages quota is exceeded")
+	AreaQuotaBytes      = errors.New("author daily bytes quota is exceeded")
+	AreaNotModerator    = errors.New("we are not area's moderator")
+	AreaNoModeration    = errors.New("area has no moderation")
+	AreaRevocationWrong = errors.New("revocation is for another area")
+)
+
+// Posting policy of the area, applied to the messages before their
+// tossing and echoing to the subscribers.
+type AreaModeration struct {
+	// Allowed authors. Nil means any of them.
+	Authors map[NodeId]struct{}
+	// Public keys able to sign authors revocations
+	Moderators  []ed25519.PublicKey
+	MsgsPerDay  int64
+	BytesPerDay int64
+}
+
+type AreaRevocationTbs struct {
+	Magic  [8]byte
+	Area   AreaId
+	Author NodeId
+	When   uint64
+}
+
+// Author's revocation, signed by one of area's moderators. When is the
+// UNIX time of its creation.
+type AreaRevocation struct {
+	Magic     [8]byte
+	Area      AreaId
+	Author    NodeId
+	When      uint64
+	Moderator [ed25519.PublicKeySize]byte
+	Sign      [ed25519.SignatureSize]byte
+}
+
+func (rev *AreaRevocation) tbs() []byte {
+	var buf bytes.Buffer
+	if _, err := xdr.Marshal(&buf, AreaRevocationTbs{
+		Magic:  rev.Magic,
+		Area:   rev.Area,
+		Author: rev.Author,
+		When:   rev.When,
+	}); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func (m *AreaModeration) isModerator(pub []byte) bool {
+	for _, moderator := range m.Moderators {
+		if bytes.Equal(moderator, pub) {
+			return true
+		}
+	}
+	return false
+}
+
+// Create author's revocation signed with our key, that must be one of
+// area's moderators.
+func (ctx *Ctx) AreaRevocationNew(area *Area, author *NodeId) ([]byte, error) {
+	if area.Moderation == nil {
+		return nil, AreaNoModeration
+	}
+	if !area.Moderation.isModerator(ctx.Self.SignPub) {
+		return nil, AreaNotModerator
+	}
+	rev := AreaRevocation{
+		Magic:  MagicNNCPVv1.B,
+		Area:   *area.Id,
+		Author: *author,
+		When:   uint64(time.Now().Unix()),
+	}
+	copy(rev.Moderator[:], ctx.Self.SignPub)
+	copy(rev.Sign[:], ed25519.Sign(ctx.Self.SignPrv, rev.tbs()))
+	var buf bytes.Buffer
+	if _, err := xdr.Marshal(&buf, &rev); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Parse revocation and verify that it is signed by area's moderator.
+func AreaRevocationParse(data []byte, area *Area) (*AreaRevocation, error) {
+	if area.Moderation == nil {
+		return nil, AreaNoModeration
+	}
+	var rev AreaRevocation
+	if _, err := xdr.Unmarshal(bytes.NewReader(data), &rev); err != nil {
+		return nil, err
+	}
+	if rev.Magic != MagicNNCPVv1.B {
+		return nil, BadMagic
+	}
+	if rev.Area != *area.Id {
+		return nil, AreaRevocationWrong
+	}
+	if !area.Moderation.isModerator(rev.Moderator[:]) {
+		return nil, errors.New("revocation is not signed by area's moderator")
+	}
+	if !ed25519.Verify(rev.Moderator[:], rev.tbs(), rev.Sign[:]) {
+		return nil, errors.New("invalid revocation signature")
+	}
+	return &rev, nil
+}
+
+func (ctx *Ctx) areaModPath(area *Area, kind string, author *NodeId) string {
+	return filepath.Join(
+		ctx.Spool, AreaDir, area.Id.String(), kind, author.String(),
+	)
+}
+
+// Verify and remember the revocation.
+func (ctx *Ctx) AreaRevocationSave(area *Area, data []byte) (*AreaRevocation, error) {
+	rev, err := AreaRevocationParse(data, area)
+	if err != nil {
+		return nil, err
+	}
+	dst := ctx.areaModPath(area, AreaRevokedDir, &rev.Author)
+	dir := filepath.Dir(dst)
+	if err = ensureDir(dir); err != nil {
+		return nil, err
+	}
+	tmp, err := TempFile(dir, "revoke")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	if err = os.Rename(tmp.Name(), dst); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return rev, DirSync(dir)
+}
+
+// Load valid revocations of the area. Ones signed by no longer known
+// moderators are ignored.
+func (ctx *Ctx) AreaRevocations(area *Area) ([]*AreaRevocation, error) {
+	dir := filepath.Join(ctx.Spool, AreaDir, area.Id.String(), AreaRevokedDir)
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var revs []*AreaRevocation
+	for _, fi := range fis {
+		data, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if rev, err := AreaRevocationParse(data, area); err == nil {
+			revs = append(revs, rev)
+		}
+	}
+	return revs, nil
+}
+
+func (ctx *Ctx) areaRevoked(area *Area, author *NodeId) (bool, error) {
+	data, err := ioutil.ReadFile(ctx.areaModPath(area, AreaRevokedDir, author))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	_, err = AreaRevocationParse(data, area)
+	return err == nil, nil
+}
+
+// Check if area's message with the encrypted packet's header is allowed
+// by area's moderation policy. Returned state must be saved with
+// areaAccount after successful processing. Caller must hold area's lock.
+func (ctx *Ctx) areaModerate(
+	area *Area,
+	pktEnc *PktEnc,
+	size int64,
+) (*TransitState, error) {
+	m := area.Moderation
+	if m == nil {
+		return nil, nil
+	}
+	author := pktEnc.Sender
+	if m.Authors != nil {
+		if _, allowed := m.Authors[*author]; !allowed {
+			return nil, AreaAuthorDenied
+		}
+	}
+	// Messages are echoed before decryption, so check the author's
+	// header signature. Unknown author can not be authenticated
+	node := ctx.Neigh[*author]
+	known := node != nil && node.SignPub != nil
+	if known {
+		// Recipient is the key of area's epoch
+		areaNodeOur := NodeOur{Id: pktEnc.Recipient}
+		if _, verified, err := TbsVerify(&areaNodeOur, node, pktEnc); err != nil {
+			return nil, err
+		} else if !verified {
+			return nil, AreaAuthorBadSign
+		}
+	} else if !area.AllowUnknown {
+		return nil, AreaAuthorUnknown
+	}
+	revoked, err := ctx.areaRevoked(area, author)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, AreaAuthorRevoked
+	}
+	// Unknown author can use any identity, so its quota is meaningless
+	if !known || (m.MsgsPerDay == 0 && m.BytesPerDay == 0) {
+		return nil, nil
+	}
+	st, err := dailyStateRead(ctx.areaModPath(area, AreaQuotaDir, author))
+	if err != nil {
+		return nil, err
+	}
+	if m.BytesPerDay > 0 && st.Bytes+size > m.BytesPerDay {
+		return nil, AreaQuotaBytes
+	}
+	if m.MsgsPerDay > 0 && st.Pkts+1 > m.MsgsPerDay {
+		return nil, AreaQuotaMsgs
+	}
+	return st, nil
+}
+
+func (ctx *Ctx) areaAccount(
+	area *Area,
+	author *NodeId,
+	st *TransitState,
+	size int64,
+) error {
+	if st == nil {
+		return nil
+	}
+	st.Bytes += size
+	st.Pkts++
+	return dailyStateWrite(ctx.areaModPath(area, AreaQuotaDir, author), st)
+}
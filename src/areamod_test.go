@@ -0,0 +1,226 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+func testAreaCtx(spool string, self *NodeOur, neighs ...*NodeOur) *Ctx {
+	ctx := Ctx{
+		Spool:       spool,
+		Self:        self,
+		SelfId:      self.Id,
+		Neigh:       make(map[NodeId]*Node),
+		Alias:       make(map[string]*NodeId),
+		AreaId2Area: make(map[AreaId]*Area),
+		LogPath:     filepath.Join(spool, "log.log"),
+		Debug:       TDebug,
+		Quiet:       true,
+	}
+	ctx.Neigh[*self.Id] = self.Their()
+	for _, neigh := range neighs {
+		ctx.Neigh[*neigh.Id] = neigh.Their()
+	}
+	return &ctx
+}
+
+func testMove(t *testing.T, src, dst string) {
+	os.RemoveAll(dst)
+	if err := os.MkdirAll(filepath.Dir(dst), os.FileMode(0777)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(src, dst); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Generate area's keys, returning its identifier and constructor of its
+// instances for the test nodes.
+func testAreaGen(t *testing.T) (AreaId, func(subs ...*NodeId) *Area) {
+	pub, prv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	areaId := AreaId(blake2b.Sum256(pub[:]))
+	return areaId, func(subs ...*NodeId) *Area {
+		id := areaId
+		return &Area{
+			Name: "test",
+			Id:   &id,
+			Pub:  pub,
+			Prv:  prv,
+			Subs: subs,
+			Exec: map[string][]string{"true": {"true"}},
+		}
+	}
+}
+
+// Move src's outbound packets for dst to dst's inbound queue and toss
+// them, returning their number.
+func testXx(t *testing.T, src, dst *Ctx) int {
+	txDir := filepath.Join(src.Spool, dst.SelfId.String(), string(TTx))
+	fis, _ := ioutil.ReadDir(txDir)
+	if len(fis) == 0 {
+		return 0
+	}
+	testMove(t, txDir, filepath.Join(dst.Spool, src.SelfId.String(), string(TRx)))
+	dst.Toss(src.SelfId, TRx, DefaultNiceExec,
+		false, false, false, false, false, false, false, false)
+	return len(fis)
+}
+
+// Post message to the area from the author, handled by the handle, and
+// deliver it to the specified subscribers.
+func testAreaPost(t *testing.T, author *Ctx, areaId AreaId, handle string, subs ...*Ctx) {
+	if err := author.TxExec(
+		author.Neigh[*author.SelfId], DefaultNiceExec, DefaultNiceFile,
+		handle, nil, strings.NewReader("BODY"),
+		0, MaxFileSize, false, &areaId,
+	); err != nil {
+		t.Fatal(err)
+	}
+	testXx(t, author, author)
+	for _, sub := range subs {
+		testXx(t, author, sub)
+	}
+}
+
+func TestAreaModeration(t *testing.T) {
+	spool, err := ioutil.TempDir("", "testareamod")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(spool)
+	nodeOur, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeAuthor, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeSub, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	areaId, newArea := testAreaGen(t)
+	ctxAuthor := testAreaCtx(filepath.Join(spool, "author"), nodeAuthor, nodeOur)
+	ctxAuthor.AreaId2Area[areaId] = newArea(nodeOur.Id)
+	ctx := testAreaCtx(filepath.Join(spool, "our"), nodeOur, nodeAuthor, nodeSub)
+	area := newArea(nodeAuthor.Id, nodeSub.Id)
+	area.Moderation = &AreaModeration{
+		Moderators: []ed25519.PublicKey{nodeOur.SignPub},
+		MsgsPerDay: 2,
+	}
+	ctx.AreaId2Area[areaId] = area
+
+	rxPath := filepath.Join(ctx.Spool, nodeAuthor.Id.String(), string(TRx))
+	subTxPath := filepath.Join(ctx.Spool, nodeSub.Id.String(), string(TTx))
+	echoed := func() int {
+		fis, _ := ioutil.ReadDir(subTxPath)
+		return len(fis)
+	}
+	post := func() {
+		testAreaPost(t, ctxAuthor, areaId, "true", ctx)
+		if len(dirFiles(rxPath)) != 0 {
+			t.Fatal("area message is left in inbound queue")
+		}
+	}
+
+	post()
+	if echoed() != 1 {
+		t.Fatal("allowed message is not echoed")
+	}
+
+	// Messages quota
+	post()
+	post()
+	if echoed() != 2 {
+		t.Fatal("messages quota is not applied")
+	}
+
+	// Revoked author
+	area.Moderation.MsgsPerDay = 0
+	data, err := ctx.AreaRevocationNew(area, nodeAuthor.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 1
+	if _, err = ctx.AreaRevocationSave(area, data); err == nil {
+		t.Fatal("corrupted revocation is accepted")
+	}
+	post()
+	if echoed() != 3 {
+		t.Fatal("message is not echoed")
+	}
+	data[len(data)-1] ^= 1
+	if _, err = ctx.AreaRevocationSave(area, data); err != nil {
+		t.Fatal(err)
+	}
+	post()
+	if echoed() != 3 {
+		t.Fatal("revoked author's message is echoed")
+	}
+	revs, err := ctx.AreaRevocations(area)
+	if err != nil || len(revs) != 1 || revs[0].Author != *nodeAuthor.Id {
+		t.Fatal("unexpected revocations", revs, err)
+	}
+
+	// Revocations are valid only with known moderators
+	area.Moderation.Moderators = nil
+	post()
+	if echoed() != 4 {
+		t.Fatal("message is not echoed")
+	}
+
+	// Authors allowlist
+	area.Moderation.Authors = map[NodeId]struct{}{*nodeSub.Id: {}}
+	post()
+	if echoed() != 4 {
+		t.Fatal("not allowed author's message is echoed")
+	}
+
+	// Unknown authors are accepted only if allowed, without quotas
+	stranger, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	area.Moderation.Authors = nil
+	area.Moderation.MsgsPerDay = 1
+	pktEnc := &PktEnc{Sender: stranger.Id, Recipient: new(NodeId)}
+	if _, err = ctx.areaModerate(area, pktEnc, 1); err != AreaAuthorUnknown {
+		t.Fatal("unknown author is accepted:", err)
+	}
+	area.AllowUnknown = true
+	for i := 0; i < 2; i++ {
+		st, err := ctx.areaModerate(area, pktEnc, 1)
+		if err != nil || st != nil {
+			t.Fatal("unknown author is not accepted:", err)
+		}
+	}
+}
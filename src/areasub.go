@@ -0,0 +1,381 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	xdr "github.com/davecgh/go-xdr/xdr2"
+	"golang.org/x/crypto/ed25519"
+)
+
+const (
+	AreaSubReqDir  = "subreq"
+	AreaSubsAddDir = "subs-add"
+	AreaSubsDelDir = "subs-del"
+
+	// Built-in exec handle receiving area's subscription requests
+	AreaSubHandle = "nncp-area-sub"
+
+	AreaSubPolicyAuto   = "auto"
+	AreaSubPolicyManual = "manual"
+
+	AreaSubReqMaxSize = 1 << 10
+)
+
+var AreaSubDenied = errors.New("area subscription requests are not accepted")
+
+type AreaSubReqTbs struct {
+	Magic [8]byte
+	Area  AreaId
+	Node  NodeId
+	Unsub bool
+	When  uint64
+}
+
+// Request of the node to (un)subscribe it to the area, signed by the
+// node. When is the UNIX time of its creation.
+type AreaSubReq struct {
+	Magic [8]byte
+	Area  AreaId
+	Node  NodeId
+	Unsub bool
+	When  uint64
+	Sign  [ed25519.SignatureSize]byte
+}
+
+func (req *AreaSubReq) tbs() []byte {
+	var buf bytes.Buffer
+	if _, err := xdr.Marshal(&buf, AreaSubReqTbs{
+		Magic: req.Magic,
+		Area:  req.Area,
+		Node:  req.Node,
+		Unsub: req.Unsub,
+		When:  req.When,
+	}); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// Parse the request and verify that it is signed by known node.
+func (ctx *Ctx) AreaSubReqParse(data []byte) (*AreaSubReq, error) {
+	var req AreaSubReq
+	if _, err := xdr.Unmarshal(bytes.NewReader(data), &req); err != nil {
+		return nil, err
+	}
+	if req.Magic != MagicNNCPUv1.B {
+		return nil, BadMagic
+	}
+	node := ctx.Neigh[req.Node]
+	if node == nil || node.SignPub == nil {
+		return nil, errors.New("request from unknown node")
+	}
+	if !ed25519.Verify(node.SignPub, req.tbs(), req.Sign[:]) {
+		return nil, errors.New("invalid request signature")
+	}
+	return &req, nil
+}
+
+// Ask the node to (un)subscribe us to the area.
+func (ctx *Ctx) TxAreaSub(node *Node, nice uint8, area *Area, unsub bool) error {
+	req := AreaSubReq{
+		Magic: MagicNNCPUv1.B,
+		Area:  *area.Id,
+		Node:  *ctx.SelfId,
+		Unsub: unsub,
+		When:  uint64(time.Now().Unix()),
+	}
+	copy(req.Sign[:], ed25519.Sign(ctx.Self.SignPrv, req.tbs()))
+	var buf bytes.Buffer
+	if _, err := xdr.Marshal(&buf, &req); err != nil {
+		return err
+	}
+	return ctx.TxExec(
+		node, nice, nice, AreaSubHandle, []string{area.Id.String()},
+		&buf, 0, MaxFileSize, false, nil,
+	)
+}
+
+func (ctx *Ctx) areaSubReqPath(area *Area, nodeId *NodeId) string {
+	return filepath.Join(
+		ctx.Spool, AreaDir, area.Id.String(), AreaSubReqDir, nodeId.String(),
+	)
+}
+
+// Process received request according to area's subscription policy.
+// Returned request is non-nil if it is queued for approval.
+func (ctx *Ctx) areaSubProcess(
+	sender *Node,
+	args []string,
+	body io.Reader,
+) (*AreaSubReq, error) {
+	if len(args) != 1 {
+		return nil, errors.New("invalid subscription request")
+	}
+	areaId, err := AreaIdFromString(args[0])
+	if err != nil {
+		return nil, err
+	}
+	area := ctx.AreaId2Area[*areaId]
+	if area == nil {
+		return nil, errors.New("unknown area")
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(body, AreaSubReqMaxSize))
+	if err != nil {
+		return nil, err
+	}
+	req, err := ctx.AreaSubReqParse(data)
+	if err != nil {
+		return nil, err
+	}
+	if req.Node != *sender.Id || req.Area != *area.Id {
+		return nil, errors.New("request does not correspond to its packet")
+	}
+	switch area.SubsPolicy {
+	case AreaSubPolicyAuto:
+	case AreaSubPolicyManual:
+		if !req.Unsub {
+			dst := ctx.areaSubReqPath(area, &req.Node)
+			dir := filepath.Dir(dst)
+			if err = ensureDir(dir); err != nil {
+				return nil, err
+			}
+			tmp, err := TempFile(dir, "subreq")
+			if err != nil {
+				return nil, err
+			}
+			if _, err = tmp.Write(data); err != nil {
+				tmp.Close()
+				os.Remove(tmp.Name())
+				return nil, err
+			}
+			if err = tmp.Close(); err != nil {
+				os.Remove(tmp.Name())
+				return nil, err
+			}
+			if err = os.Rename(tmp.Name(), dst); err != nil {
+				os.Remove(tmp.Name())
+				return nil, err
+			}
+			return req, DirSync(dir)
+		}
+		// Unsubscription does not need an approval
+		os.Remove(ctx.areaSubReqPath(area, &req.Node))
+	default:
+		return nil, AreaSubDenied
+	}
+	_, err = ctx.AreaSubsUpdate(area, &req.Node, req.Unsub)
+	return nil, err
+}
+
+// List requests queued for approval, removing invalid ones.
+func (ctx *Ctx) AreaSubReqs(area *Area) ([]*AreaSubReq, error) {
+	dir := filepath.Join(ctx.Spool, AreaDir, area.Id.String(), AreaSubReqDir)
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var reqs []*AreaSubReq
+	for _, fi := range fis {
+		if strings.HasPrefix(fi.Name(), "nncp") {
+			// Skip temporary files
+			continue
+		}
+		p := filepath.Join(dir, fi.Name())
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		req, err := ctx.AreaSubReqParse(data)
+		if err != nil || req.Area != *area.Id {
+			os.Remove(p)
+			continue
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs, nil
+}
+
+// Approve or reject queued node's request.
+func (ctx *Ctx) AreaSubReqDecide(area *Area, nodeId *NodeId, approve bool) error {
+	p := ctx.areaSubReqPath(area, nodeId)
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errors.New("no request from that node")
+		}
+		return err
+	}
+	if approve {
+		req, err := ctx.AreaSubReqParse(data)
+		if err != nil {
+			return err
+		}
+		if _, err = ctx.AreaSubsUpdate(area, &req.Node, req.Unsub); err != nil {
+			return err
+		}
+	}
+	return os.Remove(p)
+}
+
+func (ctx *Ctx) areaSubsDir(area *Area, unsub bool) string {
+	name := AreaSubsAddDir
+	if unsub {
+		name = AreaSubsDelDir
+	}
+	return filepath.Join(ctx.Spool, AreaDir, area.Id.String(), name)
+}
+
+func areaSubsDirList(dir string) ([]*NodeId, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	nodeIds := make([]*NodeId, 0, len(fis))
+	for _, fi := range fis {
+		nodeId, err := NodeIdFromString(fi.Name())
+		if err != nil {
+			continue
+		}
+		nodeIds = append(nodeIds, nodeId)
+	}
+	return nodeIds, nil
+}
+
+// Area's subscribers: configured ones with the changes made by approved
+// requests, kept in the spool. Unknown nodes are skipped.
+func (ctx *Ctx) areaSubsLoad(area *Area) ([]*NodeId, error) {
+	added, err := areaSubsDirList(ctx.areaSubsDir(area, false))
+	if err != nil {
+		return nil, err
+	}
+	removed, err := areaSubsDirList(ctx.areaSubsDir(area, true))
+	if err != nil {
+		return nil, err
+	}
+	isRemoved := make(map[NodeId]struct{}, len(removed))
+	for _, nodeId := range removed {
+		isRemoved[*nodeId] = struct{}{}
+	}
+	subs := make([]*NodeId, 0, len(area.subsCfg)+len(added))
+	seen := make(map[NodeId]struct{}, cap(subs))
+	for _, nodeId := range append(append([]*NodeId{}, area.subsCfg...), added...) {
+		if _, removed := isRemoved[*nodeId]; removed {
+			continue
+		}
+		if _, dup := seen[*nodeId]; dup {
+			continue
+		}
+		if _, known := ctx.Neigh[*nodeId]; !known {
+			continue
+		}
+		seen[*nodeId] = struct{}{}
+		subs = append(subs, nodeId)
+	}
+	return subs, nil
+}
+
+// Apply approved requests, kept in the spool, to all areas.
+func (ctx *Ctx) areasSubsLoad() (err error) {
+	for _, area := range ctx.AreaId2Area {
+		if area.Subs, err = ctx.areaSubsLoad(area); err != nil {
+			return fmt.Errorf("area %s: %w", area.Name, err)
+		}
+	}
+	return nil
+}
+
+// Add or remove area's subscriber. Configuration is never changed:
+// the change is kept in area's spool directory, applied over the
+// configured subscribers. Returns false if the node is already
+// (un)subscribed.
+func (ctx *Ctx) AreaSubsUpdate(area *Area, nodeId *NodeId, unsub bool) (bool, error) {
+	if ctx.Neigh[*nodeId] == nil {
+		return false, errors.New("unknown node")
+	}
+	areaLock := ctx.areaLock(area.Id)
+	areaLock.Lock()
+	defer areaLock.Unlock()
+	subs, err := ctx.areaSubsLoad(area)
+	if err != nil {
+		return false, err
+	}
+	found := false
+	for _, sub := range subs {
+		if *sub == *nodeId {
+			found = true
+			break
+		}
+	}
+	if found != unsub {
+		area.Subs = subs
+		return false, nil
+	}
+	configured := false
+	for _, sub := range area.subsCfg {
+		if *sub == *nodeId {
+			configured = true
+			break
+		}
+	}
+	// Opposite change is cancelled, and the change itself is remembered
+	// only if it differs from the configuration
+	oppositeDir := ctx.areaSubsDir(area, !unsub)
+	if err = os.Remove(filepath.Join(
+		oppositeDir, nodeId.String(),
+	)); err != nil && !os.IsNotExist(err) {
+		return false, err
+	} else if err == nil {
+		if err = DirSync(oppositeDir); err != nil {
+			return false, err
+		}
+	}
+	if configured == unsub {
+		dir := ctx.areaSubsDir(area, unsub)
+		if err = ensureDir(dir); err != nil {
+			return false, err
+		}
+		fd, err := os.Create(filepath.Join(dir, nodeId.String()))
+		if err != nil {
+			return false, err
+		}
+		fd.Close()
+		if err = DirSync(dir); err != nil {
+			return false, err
+		}
+	}
+	if area.Subs, err = ctx.areaSubsLoad(area); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,214 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hjson/hjson-go"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/nacl/box"
+)
+
+func TestAreaSub(t *testing.T) {
+	spool, err := ioutil.TempDir("", "testareasub")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(spool)
+	nodeHub, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeReq, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, _, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	areaId := AreaId(blake2b.Sum256(pub[:]))
+	nodeJSON := func(node *NodeOur) NodeJSON {
+		return NodeJSON{
+			Id:      node.Id.String(),
+			ExchPub: Base32Codec.EncodeToString(node.ExchPub[:]),
+			SignPub: Base32Codec.EncodeToString(node.SignPub[:]),
+		}
+	}
+	policy := AreaSubPolicyAuto
+	cfg := &CfgJSON{
+		Spool: filepath.Join(spool, "hub"),
+		Log:   filepath.Join(spool, "hub.log"),
+		NoHdr: true,
+		Self: &NodeOurJSON{
+			Id:       nodeHub.Id.String(),
+			ExchPub:  Base32Codec.EncodeToString(nodeHub.ExchPub[:]),
+			ExchPrv:  Base32Codec.EncodeToString(nodeHub.ExchPrv[:]),
+			SignPub:  Base32Codec.EncodeToString(nodeHub.SignPub[:]),
+			SignPrv:  Base32Codec.EncodeToString(nodeHub.SignPrv[:]),
+			NoisePub: Base32Codec.EncodeToString(nodeHub.NoisePub[:]),
+			NoisePrv: Base32Codec.EncodeToString(nodeHub.NoisePrv[:]),
+		},
+		Neigh: map[string]NodeJSON{
+			"self": nodeJSON(nodeHub),
+			"req":  nodeJSON(nodeReq),
+		},
+		Areas: map[string]AreaJSON{"test": {
+			Id:         areaId.String(),
+			Subs:       []string{"self"},
+			SubsPolicy: &policy,
+		}},
+	}
+	cfgPath := filepath.Join(spool, "hub.hjson")
+	cfgRaw, err := hjson.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(cfgPath, cfgRaw, os.FileMode(0600)); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = CfgLoad(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := Cfg2Ctx(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx.Quiet = true
+	area := ctx.AreaId2Area[areaId]
+
+	ctxReq := testAreaCtx(filepath.Join(spool, "req"), nodeReq, nodeHub)
+	ctxReq.AreaId2Area[areaId] = &Area{Name: "test", Id: &areaId}
+	request := func(unsub bool) {
+		if err := ctxReq.TxAreaSub(
+			ctxReq.Neigh[*nodeHub.Id], DefaultNiceExec,
+			ctxReq.AreaId2Area[areaId], unsub,
+		); err != nil {
+			t.Fatal(err)
+		}
+		testXx(t, ctxReq, ctx)
+		if len(dirFiles(filepath.Join(ctx.Spool, nodeReq.Id.String(), string(TRx)))) != 0 {
+			t.Fatal("request is not processed")
+		}
+	}
+	subscribed := func() bool {
+		if raw, err := ioutil.ReadFile(cfgPath); err != nil || !bytes.Equal(raw, cfgRaw) {
+			t.Fatal("configuration is changed", err)
+		}
+		ctxLoaded, err := Cfg2Ctx(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inLoaded := false
+		for _, nodeId := range ctxLoaded.AreaId2Area[areaId].Subs {
+			if *nodeId == *nodeReq.Id {
+				inLoaded = true
+			}
+		}
+		inCtx := false
+		for _, nodeId := range area.Subs {
+			if *nodeId == *nodeReq.Id {
+				inCtx = true
+			}
+		}
+		if inLoaded != inCtx {
+			t.Fatal("loaded and current subscriptions differ")
+		}
+		return inCtx
+	}
+
+	request(false)
+	if !subscribed() {
+		t.Fatal("not subscribed")
+	}
+	request(true)
+	if subscribed() {
+		t.Fatal("not unsubscribed")
+	}
+
+	// Manual approval
+	area.SubsPolicy = AreaSubPolicyManual
+	request(false)
+	if subscribed() {
+		t.Fatal("subscribed without approval")
+	}
+	reqs, err := ctx.AreaSubReqs(area)
+	if err != nil || len(reqs) != 1 || reqs[0].Node != *nodeReq.Id || reqs[0].Unsub {
+		t.Fatal("unexpected requests", reqs, err)
+	}
+	if err = ctx.AreaSubReqDecide(area, nodeReq.Id, true); err != nil {
+		t.Fatal(err)
+	}
+	if !subscribed() {
+		t.Fatal("not subscribed after approval")
+	}
+	if reqs, err = ctx.AreaSubReqs(area); err != nil || len(reqs) != 0 {
+		t.Fatal("approved request is left", reqs, err)
+	}
+	request(true)
+	if subscribed() {
+		t.Fatal("not unsubscribed")
+	}
+
+	// Configured subscriber unsubscribes and subscribes back
+	if _, err = ctx.AreaSubsUpdate(area, nodeHub.Id, true); err != nil {
+		t.Fatal(err)
+	}
+	ctxLoaded, err := Cfg2Ctx(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(area.Subs) != 0 || len(ctxLoaded.AreaId2Area[areaId].Subs) != 0 {
+		t.Fatal("configured subscriber is not unsubscribed")
+	}
+	if _, err = ctx.AreaSubsUpdate(area, nodeHub.Id, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(area.Subs) != 1 || *area.Subs[0] != *nodeHub.Id {
+		t.Fatal("configured subscriber is not subscribed back")
+	}
+	if fis, _ := ioutil.ReadDir(ctx.areaSubsDir(area, true)); len(fis) != 0 {
+		t.Fatal("unsubscription is left")
+	}
+	if fis, _ := ioutil.ReadDir(ctx.areaSubsDir(area, false)); len(fis) != 0 {
+		t.Fatal("subscription of configured subscriber is kept")
+	}
+
+	area.SubsPolicy = ""
+	if err := ctxReq.TxAreaSub(
+		ctxReq.Neigh[*nodeHub.Id], DefaultNiceExec,
+		ctxReq.AreaId2Area[areaId], false,
+	); err != nil {
+		t.Fatal(err)
+	}
+	testMove(t,
+		filepath.Join(ctxReq.Spool, nodeHub.Id.String(), string(TTx)),
+		filepath.Join(ctx.Spool, nodeReq.Id.String(), string(TRx)),
+	)
+	if !ctx.Toss(nodeReq.Id, TRx, DefaultNiceExec,
+		false, false, false, false, false, false, false, false) {
+		t.Fatal("request is accepted without policy")
+	}
+}
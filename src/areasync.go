@@ -0,0 +1,339 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	xdr "github.com/davecgh/go-xdr/xdr2"
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	// Built-in exec handle receiving area's synchronization summaries
+	AreaSyncHandle = "nncp-area-sync"
+
+	// Number of hash functions, each one indexes its own part of cells
+	areaSyncK = 3
+
+	AreaSyncCells    = areaSyncK * 128
+	AreaSyncMaxCells = areaSyncK * (1 << 14)
+)
+
+var AreaSyncDenied = errors.New("synchronization is allowed only for area's subscribers")
+
+type AreaSyncCell struct {
+	Count   int32
+	KeySum  [blake2b.Size256]byte
+	HashSum uint64
+}
+
+// Invertible Bloom lookup table of area's message hashes. Difference
+// of two tables with the same number of cells can be decoded to the
+// hashes, known only to one of the sides, if there are not too many
+// of them. Reply tells if the receiver is allowed to answer with its
+// own summary.
+type AreaSyncSummary struct {
+	Magic [8]byte
+	Area  AreaId
+	Reply bool
+	Cells []AreaSyncCell
+}
+
+// Result of the received summary processing.
+type AreaSyncResult struct {
+	// Number of messages sent to the peer
+	Sent int
+	// Hashes of messages the peer lacks, but we have no archive of
+	Unsendable []string
+	// Hashes of messages known to the peer, but missed by us
+	Missed []string
+	// Difference is too big to be decoded with that number of cells
+	Undecodable bool
+}
+
+func areaSyncHashSum(key *[blake2b.Size256]byte) uint64 {
+	h := blake2b.Sum256(key[:])
+	return binary.BigEndian.Uint64(h[:8])
+}
+
+func (sum *AreaSyncSummary) toggle(
+	key *[blake2b.Size256]byte,
+	hashSum uint64,
+	count int32,
+) {
+	part := uint64(len(sum.Cells) / areaSyncK)
+	for i := 0; i < areaSyncK; i++ {
+		idx := uint64(i)*part + binary.BigEndian.Uint64(key[i*8:i*8+8])%part
+		cell := &sum.Cells[idx]
+		cell.Count += count
+		for j := 0; j < len(key); j++ {
+			cell.KeySum[j] ^= key[j]
+		}
+		cell.HashSum ^= hashSum
+	}
+}
+
+// Subtract other table from ours and decode the difference: hashes
+// known only to us and only to the other side.
+func (sum *AreaSyncSummary) diff(other *AreaSyncSummary) (ours, theirs []string, ok bool) {
+	d := AreaSyncSummary{Cells: make([]AreaSyncCell, len(sum.Cells))}
+	for i := range sum.Cells {
+		d.Cells[i].Count = sum.Cells[i].Count - other.Cells[i].Count
+		for j := 0; j < blake2b.Size256; j++ {
+			d.Cells[i].KeySum[j] = sum.Cells[i].KeySum[j] ^ other.Cells[i].KeySum[j]
+		}
+		d.Cells[i].HashSum = sum.Cells[i].HashSum ^ other.Cells[i].HashSum
+	}
+	for peeled := true; peeled; {
+		peeled = false
+		for i := range d.Cells {
+			cell := d.Cells[i]
+			if cell.Count != 1 && cell.Count != -1 {
+				continue
+			}
+			if areaSyncHashSum(&cell.KeySum) != cell.HashSum {
+				continue
+			}
+			if cell.Count == 1 {
+				ours = append(ours, Base32Codec.EncodeToString(cell.KeySum[:]))
+			} else {
+				theirs = append(theirs, Base32Codec.EncodeToString(cell.KeySum[:]))
+			}
+			d.toggle(&cell.KeySum, cell.HashSum, -cell.Count)
+			peeled = true
+		}
+	}
+	var empty AreaSyncCell
+	for _, cell := range d.Cells {
+		if cell != empty {
+			return nil, nil, false
+		}
+	}
+	return ours, theirs, true
+}
+
+// Hashes of area's messages known to us: seen or archived ones.
+func (ctx *Ctx) areaKnown(area *Area) (map[string]struct{}, error) {
+	known := make(map[string]struct{})
+	for _, dir := range []string{
+		filepath.Join(ctx.Spool, ctx.SelfId.String(), AreaDir, area.Id.String()),
+		ctx.areaArchiveDir(area),
+	} {
+		fis, err := ioutil.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, fi := range fis {
+			if !fi.Mode().IsRegular() {
+				continue
+			}
+			raw, err := Base32Codec.DecodeString(fi.Name())
+			if err != nil || len(raw) != blake2b.Size256 {
+				// Skip temporary files
+				continue
+			}
+			known[fi.Name()] = struct{}{}
+		}
+	}
+	return known, nil
+}
+
+func areaSyncSummaryNew(
+	area *Area,
+	known map[string]struct{},
+	cells int,
+	reply bool,
+) *AreaSyncSummary {
+	sum := AreaSyncSummary{
+		Magic: MagicNNCPYv1.B,
+		Area:  *area.Id,
+		Reply: reply,
+		Cells: make([]AreaSyncCell, cells),
+	}
+	var key [blake2b.Size256]byte
+	for msgHash := range known {
+		raw, _ := Base32Codec.DecodeString(msgHash)
+		copy(key[:], raw)
+		sum.toggle(&key, areaSyncHashSum(&key), 1)
+	}
+	return &sum
+}
+
+func (ctx *Ctx) txAreaSync(node *Node, nice uint8, sum *AreaSyncSummary) error {
+	var buf bytes.Buffer
+	if _, err := xdr.Marshal(&buf, sum); err != nil {
+		return err
+	}
+	return ctx.TxExec(
+		node, nice, nice, AreaSyncHandle, []string{sum.Area.String()},
+		&buf, 0, MaxFileSize, false, nil,
+	)
+}
+
+// Send the summary of area's messages we know to the node. Node sends
+// us messages we lack, if it has them archived, and replies with its
+// own summary if it misses some of ours. Cells is the number of
+// summary's cells, AreaSyncCells by default.
+func (ctx *Ctx) TxAreaSync(node *Node, nice uint8, area *Area, cells int) error {
+	if cells == 0 {
+		cells = AreaSyncCells
+	}
+	if cells%areaSyncK != 0 || cells > AreaSyncMaxCells {
+		return errors.New("invalid number of summary cells")
+	}
+	areaLock := ctx.areaLock(area.Id)
+	areaLock.Lock()
+	known, err := ctx.areaKnown(area)
+	areaLock.Unlock()
+	if err != nil {
+		return err
+	}
+	return ctx.txAreaSync(node, nice, areaSyncSummaryNew(area, known, cells, true))
+}
+
+// Reconcile received summary with messages we know. Archived messages
+// the peer lacks are sent to it. Messages known to both sides are
+// marked as sent to the peer, preventing their echoing. If we miss
+// some messages and peer allows reply, our summary is sent back. If
+// difference can not be decoded, summary with twice as many cells is
+// sent back.
+func (ctx *Ctx) areaSyncProcess(
+	sender *Node,
+	nice uint8,
+	args []string,
+	body io.Reader,
+) (*AreaSyncResult, error) {
+	if len(args) != 1 {
+		return nil, errors.New("invalid synchronization summary")
+	}
+	areaId, err := AreaIdFromString(args[0])
+	if err != nil {
+		return nil, err
+	}
+	area := ctx.AreaId2Area[*areaId]
+	if area == nil {
+		return nil, errors.New("unknown area")
+	}
+	node := ctx.Neigh[*sender.Id]
+	if node == nil {
+		return nil, AreaSyncDenied
+	}
+	var their AreaSyncSummary
+	if _, err = xdr.UnmarshalLimited(body, &their, AreaSyncMaxCells); err != nil {
+		return nil, err
+	}
+	if their.Magic != MagicNNCPYv1.B {
+		return nil, BadMagic
+	}
+	if their.Area != *area.Id {
+		return nil, errors.New("area mismatch")
+	}
+	cells := len(their.Cells)
+	if cells == 0 || cells%areaSyncK != 0 {
+		return nil, errors.New("invalid number of summary cells")
+	}
+
+	areaLock := ctx.areaLock(area.Id)
+	areaLock.Lock()
+	defer areaLock.Unlock()
+	isSub := false
+	for _, nodeId := range area.Subs {
+		if *nodeId == *node.Id {
+			isSub = true
+			break
+		}
+	}
+	if !isSub {
+		return nil, AreaSyncDenied
+	}
+	known, err := ctx.areaKnown(area)
+	if err != nil {
+		return nil, err
+	}
+	our := areaSyncSummaryNew(area, known, cells, false)
+	lacked, missed, ok := our.diff(&their)
+	res := AreaSyncResult{Missed: missed}
+	if !ok {
+		res.Undecodable = true
+		if their.Reply && cells*2 <= AreaSyncMaxCells {
+			err = ctx.txAreaSync(
+				node, nice, areaSyncSummaryNew(area, known, cells*2, true),
+			)
+		}
+		return &res, err
+	}
+
+	seenDir := filepath.Join(
+		ctx.Spool, node.Id.String(), AreaDir, area.Id.String(),
+	)
+	if err = ensureDir(seenDir); err != nil {
+		return nil, err
+	}
+	lackedSet := make(map[string]struct{}, len(lacked))
+	for _, msgHash := range lacked {
+		lackedSet[msgHash] = struct{}{}
+		seenPath := filepath.Join(seenDir, msgHash)
+		if _, err = os.Stat(seenPath); err == nil {
+			continue
+		}
+		if !ctx.areaArchived(area, msgHash) {
+			res.Unsendable = append(res.Unsendable, msgHash)
+			continue
+		}
+		if err = ctx.areaBackfillMsg(node, nice, area, msgHash); err != nil {
+			return &res, err
+		}
+		if fd, err := os.Create(seenPath); err == nil {
+			fd.Close()
+		} else {
+			return &res, err
+		}
+		res.Sent++
+	}
+	for msgHash := range known {
+		if _, exists := lackedSet[msgHash]; exists {
+			continue
+		}
+		seenPath := filepath.Join(seenDir, msgHash)
+		if _, err = os.Stat(seenPath); err == nil {
+			continue
+		}
+		if fd, err := os.Create(seenPath); err == nil {
+			fd.Close()
+		} else {
+			return &res, err
+		}
+	}
+	if err = DirSync(seenDir); err != nil {
+		return &res, err
+	}
+	if len(missed) > 0 && their.Reply {
+		err = ctx.txAreaSync(node, nice, our)
+	}
+	return &res, err
+}
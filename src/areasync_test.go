@@ -0,0 +1,171 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+func TestAreaSyncSummary(t *testing.T) {
+	area := &Area{Id: new(AreaId)}
+	ours := make(map[string]struct{})
+	theirs := make(map[string]struct{})
+	for i := 0; i < 50; i++ {
+		key := blake2b.Sum256([]byte{byte(i)})
+		msgHash := Base32Codec.EncodeToString(key[:])
+		switch i % 5 {
+		case 0:
+			ours[msgHash] = struct{}{}
+		case 1:
+			theirs[msgHash] = struct{}{}
+		default:
+			ours[msgHash] = struct{}{}
+			theirs[msgHash] = struct{}{}
+		}
+	}
+	sumOurs := areaSyncSummaryNew(area, ours, AreaSyncCells, false)
+	sumTheirs := areaSyncSummaryNew(area, theirs, AreaSyncCells, false)
+	onlyOurs, onlyTheirs, ok := sumOurs.diff(sumTheirs)
+	if !ok || len(onlyOurs) != 10 || len(onlyTheirs) != 10 {
+		t.Fatal("difference is not decoded", len(onlyOurs), len(onlyTheirs))
+	}
+	for _, msgHash := range onlyOurs {
+		if _, exists := theirs[msgHash]; exists {
+			t.Fatal("common hash is decoded")
+		}
+	}
+	sumOurs = areaSyncSummaryNew(area, ours, areaSyncK, false)
+	sumTheirs = areaSyncSummaryNew(area, theirs, areaSyncK, false)
+	if _, _, ok = sumOurs.diff(sumTheirs); ok {
+		t.Fatal("too big difference is decoded")
+	}
+}
+
+func TestAreaSync(t *testing.T) {
+	spool, err := ioutil.TempDir("", "testareasync")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(spool)
+	nodeAuthor, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeA, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeB, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	areaId, areaGen := testAreaGen(t)
+	newArea := func() *Area {
+		area := areaGen()
+		area.Archive = time.Hour
+		return area
+	}
+	ctxAuthor := testAreaCtx(
+		filepath.Join(spool, "author"), nodeAuthor, nodeA, nodeB,
+	)
+	ctxAuthor.AreaId2Area[areaId] = newArea()
+	ctxA := testAreaCtx(filepath.Join(spool, "a"), nodeA, nodeAuthor, nodeB)
+	areaA := newArea()
+	ctxA.AreaId2Area[areaId] = areaA
+	ctxB := testAreaCtx(filepath.Join(spool, "b"), nodeB, nodeAuthor, nodeA)
+	areaB := newArea()
+	ctxB.AreaId2Area[areaId] = areaB
+
+	post := func(to ...*NodeOur) {
+		ctxAuthor.AreaId2Area[areaId].Subs = nil
+		for _, node := range to {
+			ctxAuthor.AreaId2Area[areaId].Subs = append(
+				ctxAuthor.AreaId2Area[areaId].Subs, node.Id,
+			)
+		}
+		testAreaPost(t, ctxAuthor, areaId, "true", ctxA, ctxB)
+	}
+	known := func(ctx *Ctx) map[string]struct{} {
+		known, err := ctx.areaKnown(ctx.AreaId2Area[areaId])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return known
+	}
+	post(nodeA, nodeB)
+	post(nodeA)
+	post(nodeB)
+	if len(known(ctxA)) != 2 || len(known(ctxB)) != 2 {
+		t.Fatal("messages are not diverged")
+	}
+
+	// Only subscribers can synchronize
+	if err = ctxA.TxAreaSync(ctxA.Neigh[*nodeB.Id], DefaultNiceExec, areaA, 0); err != nil {
+		t.Fatal(err)
+	}
+	testXx(t, ctxA, ctxB)
+	if fis, _ := ioutil.ReadDir(
+		filepath.Join(ctxB.Spool, nodeA.Id.String(), string(TTx)),
+	); len(fis) != 0 {
+		t.Fatal("synchronized with non-subscriber")
+	}
+
+	areaA.Subs = []*NodeId{nodeB.Id}
+	areaB.Subs = []*NodeId{nodeA.Id}
+	if err = ctxA.TxAreaSync(ctxA.Neigh[*nodeB.Id], DefaultNiceExec, areaA, 0); err != nil {
+		t.Fatal(err)
+	}
+	// Summary, then B's message and B's summary, then A's message
+	if testXx(t, ctxA, ctxB) != 1 {
+		t.Fatal("no summary")
+	}
+	if testXx(t, ctxB, ctxA) != 2 {
+		t.Fatal("no message and reply summary")
+	}
+	if testXx(t, ctxA, ctxB) != 1 {
+		t.Fatal("no message")
+	}
+	if testXx(t, ctxB, ctxA) != 0 {
+		t.Fatal("redundant echo")
+	}
+	if len(known(ctxA)) != 3 || len(known(ctxB)) != 3 {
+		t.Fatal("messages are not synchronized")
+	}
+
+	// Too small summary is enlarged until difference is decoded
+	post(nodeA)
+	post(nodeA)
+	post(nodeB)
+	if err = ctxB.TxAreaSync(ctxB.Neigh[*nodeA.Id], DefaultNiceExec, areaB, areaSyncK); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 16; i++ {
+		if testXx(t, ctxB, ctxA)+testXx(t, ctxA, ctxB) == 0 {
+			break
+		}
+	}
+	if len(known(ctxA)) != 6 || len(known(ctxB)) != 6 {
+		t.Fatal("messages are not synchronized")
+	}
+}
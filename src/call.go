@@ -43,6 +43,11 @@ type Call struct {
 	NoCK           bool
 	MCDIgnore      bool
 
+	TxTrigger            bool
+	TxTriggerNice        uint8
+	TxTriggerMinInterval time.Duration
+	TxTriggerJitter      time.Duration
+
 	AutoToss       bool
 	AutoTossDoSeen bool
 	AutoTossNoFile bool
@@ -53,6 +58,128 @@ type Call struct {
 	AutoTossNoACK  bool
 }
 
+// Minimal interval between calls triggered by appeared outbound packets.
+var DefaultTxTriggerMinInterval = time.Minute
+
+// Delay before starting the next address dialing attempt, while
+// previous ones are still in progress.
+var CallRaceDelay = 250 * time.Millisecond
+
+// Address representation suitable for logging and state keeping.
+func addrLogged(addr string) string {
+	if IsProxyAddr(addr) {
+		return ProxyAddrRedacted(addr)
+	}
+	return addr
+}
+
+func (ctx *Ctx) callDial(addr string) (conn ConnDeadlined, addrReal string, err error) {
+	addrReal = addrLogged(addr)
+	if addr[0] == '|' {
+		conn, err = NewPipeConn(addr[1:])
+	} else if addr == UCSPITCPClient {
+		ucspiConn := UCSPIConn{R: os.NewFile(6, "R"), W: os.NewFile(7, "W")}
+		if ucspiConn.R == nil {
+			err = errors.New("no 6 file descriptor")
+		}
+		if ucspiConn.W == nil {
+			err = errors.New("no 7 file descriptor")
+		}
+		conn = ucspiConn
+		addrReal = UCSPITCPRemoteAddr()
+		if addrReal == "" {
+			addrReal = UCSPITCPClient
+		}
+	} else if strings.HasPrefix(addr, "yggdrasilc://") {
+		conn, err = nncpYggdrasil.NewConn(ctx.YggdrasilAliases, addr)
+	} else if IsProxyAddr(addr) {
+		conn, err = NewProxyConn(addr, DefaultDeadline)
+	} else {
+		conn, err = net.Dial("tcp", addr)
+	}
+	return
+}
+
+type callDialResult struct {
+	idx int
+	// Configured address, keying its state
+	addrCfg string
+	// Actually dialed one, for logging
+	addr string
+	conn ConnDeadlined
+	err  error
+}
+
+// Dial addresses in parallel: each next attempt is started after
+// CallRaceDelay, or immediately if all previous ones have already
+// failed. The first established connection wins, all others are closed
+// in background. Failed attempts are returned too.
+func (ctx *Ctx) callRace(node *Node, addrs []string) (
+	winner *callDialResult,
+	failed []*callDialResult,
+) {
+	results := make(chan *callDialResult, len(addrs))
+	inFlight := 0
+	next := 0
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for next < len(addrs) || inFlight > 0 {
+		var timerC <-chan time.Time
+		if next < len(addrs) {
+			timerC = timer.C
+		}
+		select {
+		case <-timerC:
+			idx := next
+			next++
+			inFlight++
+			ctx.LogD("calling", LEs{
+				{"Node", node.Id}, {"Addr", addrLogged(addrs[idx])},
+			}, func(les LEs) string {
+				return fmt.Sprintf("Calling %s (%s)", node.Name, addrLogged(addrs[idx]))
+			})
+			go func() {
+				conn, addr, err := ctx.callDial(addrs[idx])
+				results <- &callDialResult{
+					idx:     idx,
+					addrCfg: addrLogged(addrs[idx]),
+					addr:    addr,
+					conn:    conn,
+					err:     err,
+				}
+			}()
+			timer.Reset(CallRaceDelay)
+		case r := <-results:
+			inFlight--
+			if r.err != nil {
+				failed = append(failed, r)
+				if inFlight == 0 && next < len(addrs) {
+					if !timer.Stop() {
+						select {
+						case <-timer.C:
+						default:
+						}
+					}
+					timer.Reset(0)
+				}
+				continue
+			}
+			winner = r
+			if inFlight > 0 {
+				go func(n int) {
+					for ; n > 0; n-- {
+						if r := <-results; r.err == nil {
+							r.conn.Close()
+						}
+					}
+				}(inFlight)
+			}
+			return
+		}
+	}
+	return
+}
+
 func (ctx *Ctx) CallNode(
 	node *Node,
 	addrs []string,
@@ -64,39 +191,65 @@ func (ctx *Ctx) CallNode(
 	noCK bool,
 	onlyPkts map[[MTHSize]byte]bool,
 ) (isGood bool) {
-	for _, addr := range addrs {
-		les := LEs{{"Node", node.Id}, {"Addr", addr}}
-		ctx.LogD("calling", les, func(les LEs) string {
-			return fmt.Sprintf("Calling %s (%s)", node.Name, addr)
-		})
-		var conn ConnDeadlined
-		var err error
-		if addr[0] == '|' {
-			conn, err = NewPipeConn(addr[1:])
-		} else if addr == UCSPITCPClient {
-			ucspiConn := UCSPIConn{R: os.NewFile(6, "R"), W: os.NewFile(7, "W")}
-			if ucspiConn.R == nil {
-				err = errors.New("no 6 file descriptor")
-			}
-			if ucspiConn.W == nil {
-				err = errors.New("no 7 file descriptor")
-			}
-			conn = ucspiConn
-			addr = UCSPITCPRemoteAddr()
-			if addr == "" {
-				addr = UCSPITCPClient
-			}
-		} else if strings.HasPrefix(addr, "yggdrasilc://") {
-			conn, err = nncpYggdrasil.NewConn(ctx.YggdrasilAliases, addr)
-		} else {
-			conn, err = net.Dial("tcp", addr)
-		}
-		if err != nil {
-			ctx.LogE("calling", les, err, func(les LEs) string {
-				return fmt.Sprintf("Calling %s (%s)", node.Name, addr)
+	return ctx.callNode(
+		nil, node, addrs, nice, xxOnly, rxRate, txRate,
+		onlineDeadline, maxOnlineTime, listOnly, noCK, onlyPkts,
+	)
+}
+
+// Call the node, terminating the session when done is closed.
+func (ctx *Ctx) callNode(
+	done <-chan struct{},
+	node *Node,
+	addrs []string,
+	nice uint8,
+	xxOnly TRxTx,
+	rxRate, txRate int,
+	onlineDeadline, maxOnlineTime time.Duration,
+	listOnly bool,
+	noCK bool,
+	onlyPkts map[[MTHSize]byte]bool,
+) (isGood bool) {
+	results := make(map[string]bool, len(addrs))
+	defer func() {
+		if err := ctx.AddrStatesUpdate(node.Id, results); err != nil {
+			ctx.LogE("call-addrs-state", LEs{{"Node", node.Id}}, err, func(les LEs) string {
+				return "Saving addresses state of " + node.Name
 			})
-			continue
 		}
+	}()
+	for len(addrs) > 0 {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		winner, failed := ctx.callRace(node, addrs)
+		for _, r := range failed {
+			results[r.addrCfg] = false
+			ctx.LogE("calling", LEs{{"Node", node.Id}, {"Addr", r.addr}}, r.err,
+				func(les LEs) string {
+					return fmt.Sprintf("Calling %s (%s)", node.Name, r.addr)
+				},
+			)
+		}
+		if winner == nil {
+			break
+		}
+		tried := map[int]struct{}{winner.idx: {}}
+		for _, r := range failed {
+			tried[r.idx] = struct{}{}
+		}
+		left := make([]string, 0, len(addrs)-len(tried))
+		for i, addr := range addrs {
+			if _, exists := tried[i]; !exists {
+				left = append(left, addr)
+			}
+		}
+		addrs = left
+		addr := winner.addr
+		conn := winner.conn
+		les := LEs{{"Node", node.Id}, {"Addr", addr}}
 		ctx.LogD("call-connected", les, func(les LEs) string {
 			return fmt.Sprintf("Connected %s (%s)", node.Name, addr)
 		})
@@ -113,10 +266,20 @@ func (ctx *Ctx) CallNode(
 			NoCK:           noCK,
 			onlyPkts:       onlyPkts,
 		}
-		if err = state.StartI(conn); err == nil {
+		if err := state.StartI(conn); err == nil {
+			results[winner.addrCfg] = true
 			ctx.LogI("call-started", les, func(les LEs) string {
 				return fmt.Sprintf("Connection to %s (%s)", node.Name, addr)
 			})
+			if done != nil {
+				go func() {
+					select {
+					case <-done:
+						state.SetDead()
+					case <-state.isDead:
+					}
+				}()
+			}
 			isGood = state.Wait()
 			ctx.LogI("call-finished", append(
 				les,
@@ -141,6 +304,7 @@ func (ctx *Ctx) CallNode(
 			conn.Close()
 			break
 		} else {
+			results[winner.addrCfg] = false
 			ctx.LogE("call-started", les, err, func(les LEs) string {
 				return fmt.Sprintf("Connection to %s (%s)", node.Name, addr)
 			})
@@ -22,10 +22,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"net/url"
 	"os"
 	"path"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorhill/cronexpr"
@@ -65,6 +66,55 @@ type NodeJSON struct {
 	TxRate         *int  `json:"txrate,omitempty"`
 	OnlineDeadline *uint `json:"onlinedeadline,omitempty"`
 	MaxOnlineTime  *uint `json:"maxonlinetime,omitempty"`
+
+	ViaAlt      [][]string `json:"via-alt,omitempty"`
+	ViaFailover *uint      `json:"via-failover,omitempty"`
+
+	RouteCost *uint `json:"route-cost,omitempty"`
+
+	Transit *TransitJSON `json:"transit,omitempty"`
+
+	SpoolQuota *SpoolQuotaJSON `json:"spool-quota,omitempty"`
+
+	ExecPolicy map[string]ExecPolicyJSON `json:"exec-policy,omitempty"`
+
+	Placement   []PlacementJSON `json:"placement,omitempty"`
+	PostReceive []string        `json:"post-receive,omitempty"`
+}
+
+type PlacementJSON struct {
+	Pattern   string  `json:"pattern"`
+	Dir       *string `json:"dir,omitempty"`
+	Collision *string `json:"collision,omitempty"`
+}
+
+type ExecPolicyJSON struct {
+	Timeout    *uint             `json:"timeout,omitempty"`
+	MaxOutput  *uint64           `json:"max-output,omitempty"`
+	Dir        *string           `json:"dir,omitempty"`
+	Env        []string          `json:"env,omitempty"`
+	Rlimits    map[string]uint64 `json:"rlimits,omitempty"`
+	Uid        *uint             `json:"uid,omitempty"`
+	Gid        *uint             `json:"gid,omitempty"`
+	Groups     []uint            `json:"groups,omitempty"`
+	Namespaces []string          `json:"namespaces,omitempty"`
+
+	Reply       *string `json:"reply,omitempty"`
+	ReplyHandle *string `json:"reply-handle,omitempty"`
+}
+
+type TransitJSON struct {
+	Dsts        []string `json:"dsts,omitempty"`
+	MaxSize     *uint64  `json:"maxsize,omitempty"`
+	BytesPerDay *uint64  `json:"bytes-per-day,omitempty"`
+	PktsPerDay  *uint64  `json:"pkts-per-day,omitempty"`
+	Nice        *string  `json:"nice,omitempty"`
+}
+
+type SpoolQuotaJSON struct {
+	Tx     *uint64 `json:"tx,omitempty"`
+	Rx     *uint64 `json:"rx,omitempty"`
+	Policy *string `json:"policy,omitempty"`
 }
 
 type NodeFreqJSON struct {
@@ -72,6 +122,11 @@ type NodeFreqJSON struct {
 	Chunked *uint64 `json:"chunked,omitempty"`
 	MinSize *uint64 `json:"minsize,omitempty"`
 	MaxSize *uint64 `json:"maxsize,omitempty"`
+
+	Roots       map[string]string `json:"roots,omitempty"`
+	Allow       []string          `json:"allow,omitempty"`
+	Deny        []string          `json:"deny,omitempty"`
+	BytesPerDay *uint64           `json:"bytes-per-day,omitempty"`
 }
 
 type CallJSON struct {
@@ -87,6 +142,10 @@ type CallJSON struct {
 	NoCK           bool    `json:"nock,omitempty"`
 	MCDIgnore      bool    `json:"mcd-ignore,omitempty"`
 
+	TxTriggerNice        *string `json:"tx-trigger-nice,omitempty"`
+	TxTriggerMinInterval *uint   `json:"tx-trigger-min-interval,omitempty"`
+	TxTriggerJitter      *uint   `json:"tx-trigger-jitter,omitempty"`
+
 	AutoToss       bool `json:"autotoss,omitempty"`
 	AutoTossDoSeen bool `json:"autotoss-doseen,omitempty"`
 	AutoTossNoFile bool `json:"autotoss-nofile,omitempty"`
@@ -112,9 +171,20 @@ type FromToJSON struct {
 }
 
 type NotifyJSON struct {
-	File *FromToJSON            `json:"file,omitempty"`
-	Freq *FromToJSON            `json:"freq,omitempty"`
-	Exec map[string]*FromToJSON `json:"exec,omitempty"`
+	File      *FromToJSON            `json:"file,omitempty"`
+	Freq      *FromToJSON            `json:"freq,omitempty"`
+	Exec      map[string]*FromToJSON `json:"exec,omitempty"`
+	Notifiers []NotifierJSON         `json:"notifiers,omitempty"`
+}
+
+type NotifierJSON struct {
+	Events  []string `json:"events,omitempty"`
+	Cmd     []string `json:"cmd,omitempty"`
+	Webhook *string  `json:"webhook,omitempty"`
+	Timeout *uint    `json:"timeout,omitempty"`
+	Maildir *string  `json:"maildir,omitempty"`
+	From    *string  `json:"from,omitempty"`
+	To      *string  `json:"to,omitempty"`
 }
 
 type AreaJSON struct {
@@ -122,12 +192,44 @@ type AreaJSON struct {
 	Pub *string `json:"pub,omitempty"`
 	Prv *string `json:"prv,omitempty"`
 
-	Subs []string `json:"subs"`
+	Owner *string `json:"owner,omitempty"`
+
+	Subs       []string `json:"subs"`
+	SubsPolicy *string  `json:"subs-policy,omitempty"`
 
 	Incoming *string             `json:"incoming,omitempty"`
 	Exec     map[string][]string `json:"exec,omitempty"`
 
 	AllowUnknown bool `json:"allow-unknown,omitempty"`
+
+	ExecPolicy map[string]ExecPolicyJSON `json:"exec-policy,omitempty"`
+
+	Moderation *AreaModerationJSON `json:"moderation,omitempty"`
+
+	Archive *uint `json:"archive,omitempty"`
+}
+
+type AreaModerationJSON struct {
+	Authors     []string `json:"authors,omitempty"`
+	Moderators  []string `json:"moderators,omitempty"`
+	MsgsPerDay  *uint64  `json:"msgs-per-day,omitempty"`
+	BytesPerDay *uint64  `json:"bytes-per-day,omitempty"`
+}
+
+type QuarantineJSON struct {
+	Retries *uint `json:"retries,omitempty"`
+	Backoff *uint `json:"backoff,omitempty"`
+}
+
+type EventsJSON struct {
+	Journal string  `json:"journal"`
+	Socket  *string `json:"socket,omitempty"`
+}
+
+type RoutingJSON struct {
+	Interval *uint   `json:"interval,omitempty"`
+	MaxAge   *uint   `json:"max-age,omitempty"`
+	Nice     *string `json:"nice,omitempty"`
 }
 
 type CfgJSON struct {
@@ -138,6 +240,8 @@ type CfgJSON struct {
 	OmitPrgrs bool `json:"noprogress,omitempty"`
 	NoHdr     bool `json:"nohdr,omitempty"`
 
+	TossWorkers *uint `json:"toss-workers,omitempty"`
+
 	MCDRxIfis []string       `json:"mcd-listen,omitempty"`
 	MCDTxIfis map[string]int `json:"mcd-send,omitempty"`
 
@@ -149,6 +253,15 @@ type CfgJSON struct {
 	Areas map[string]AreaJSON `json:"areas,omitempty"`
 
 	YggdrasilAliases map[string]string `json:"yggdrasil-aliases,omitempty"`
+
+	Routing *RoutingJSON `json:"routing,omitempty"`
+	Transit *TransitJSON `json:"transit,omitempty"`
+
+	SpoolQuota *SpoolQuotaJSON `json:"spool-quota,omitempty"`
+
+	Quarantine *QuarantineJSON `json:"quarantine,omitempty"`
+
+	Events *EventsJSON `json:"events,omitempty"`
 }
 
 func NewNode(name string, cfg NodeJSON) (*Node, error) {
@@ -197,6 +310,8 @@ func NewNode(name string, cfg NodeJSON) (*Node, error) {
 	var freqChunked int64
 	var freqMinSize int64
 	freqMaxSize := int64(MaxFileSize)
+	var freqRoots map[string]string
+	var freqBytesPerDay int64
 	if cfg.Freq != nil {
 		f := cfg.Freq
 		if f.Path != nil {
@@ -218,6 +333,27 @@ func NewNode(name string, cfg NodeJSON) (*Node, error) {
 		if f.MaxSize != nil {
 			freqMaxSize = int64(*f.MaxSize) * 1024
 		}
+		for name, dir := range f.Roots {
+			if name == "" || strings.ContainsAny(name, ":/") {
+				return nil, errors.New("invalid freq.roots name: " + name)
+			}
+			dir = path.Clean(dir)
+			if !path.IsAbs(dir) {
+				return nil, errors.New("freq.roots path must be absolute")
+			}
+			if freqRoots == nil {
+				freqRoots = make(map[string]string, len(f.Roots))
+			}
+			freqRoots[name] = dir
+		}
+		for _, pattern := range append(f.Allow, f.Deny...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid freq pattern %s: %w", pattern, err)
+			}
+		}
+		if f.BytesPerDay != nil {
+			freqBytesPerDay = int64(*f.BytesPerDay) * 1024
+		}
 	}
 
 	defRxRate := 0
@@ -241,6 +377,22 @@ func NewNode(name string, cfg NodeJSON) (*Node, error) {
 		defMaxOnlineTime = time.Duration(*cfg.MaxOnlineTime) * time.Second
 	}
 
+	viaFailover := DefaultViaFailover
+	if cfg.ViaFailover != nil {
+		if *cfg.ViaFailover == 0 {
+			return nil, errors.New("via-failover must be greater than zero")
+		}
+		viaFailover = time.Duration(*cfg.ViaFailover) * time.Second
+	}
+
+	var routeCost uint32
+	if cfg.RouteCost != nil {
+		if *cfg.RouteCost == 0 || *cfg.RouteCost >= RouteCostInf {
+			return nil, fmt.Errorf("route-cost must be in 1..%d range", RouteCostInf-1)
+		}
+		routeCost = uint32(*cfg.RouteCost)
+	}
+
 	var calls []*Call
 	for _, callCfg := range cfg.Calls {
 		expr, err := cronexpr.Parse(callCfg.Cron)
@@ -310,6 +462,22 @@ func NewNode(name string, cfg NodeJSON) (*Node, error) {
 		call.WhenTxExists = callCfg.WhenTxExists
 		call.NoCK = callCfg.NoCK
 		call.MCDIgnore = callCfg.MCDIgnore
+		if callCfg.TxTriggerNice != nil {
+			call.TxTrigger = true
+			call.TxTriggerNice, err = NicenessParse(*callCfg.TxTriggerNice)
+			if err != nil {
+				return nil, err
+			}
+			call.TxTriggerMinInterval = DefaultTxTriggerMinInterval
+			if callCfg.TxTriggerMinInterval != nil {
+				call.TxTriggerMinInterval = time.Duration(
+					*callCfg.TxTriggerMinInterval,
+				) * time.Second
+			}
+			if callCfg.TxTriggerJitter != nil {
+				call.TxTriggerJitter = time.Duration(*callCfg.TxTriggerJitter) * time.Second
+			}
+		}
 		call.AutoToss = callCfg.AutoToss
 		call.AutoTossDoSeen = callCfg.AutoTossDoSeen
 		call.AutoTossNoFile = callCfg.AutoTossNoFile
@@ -322,31 +490,220 @@ func NewNode(name string, cfg NodeJSON) (*Node, error) {
 	}
 
 	node := Node{
-		Name:           name,
-		Id:             nodeId,
-		ExchPub:        new([32]byte),
-		SignPub:        ed25519.PublicKey(signPub),
-		Exec:           cfg.Exec,
-		Incoming:       incoming,
-		FreqPath:       freqPath,
-		FreqChunked:    freqChunked,
-		FreqMinSize:    freqMinSize,
-		FreqMaxSize:    freqMaxSize,
-		Calls:          calls,
-		Addrs:          cfg.Addrs,
-		RxRate:         defRxRate,
-		TxRate:         defTxRate,
-		OnlineDeadline: defOnlineDeadline,
-		MaxOnlineTime:  defMaxOnlineTime,
+		Name:            name,
+		Id:              nodeId,
+		ExchPub:         new([32]byte),
+		SignPub:         ed25519.PublicKey(signPub),
+		Exec:            cfg.Exec,
+		Incoming:        incoming,
+		FreqPath:        freqPath,
+		FreqChunked:     freqChunked,
+		FreqMinSize:     freqMinSize,
+		FreqMaxSize:     freqMaxSize,
+		FreqRoots:       freqRoots,
+		FreqBytesPerDay: freqBytesPerDay,
+		Calls:           calls,
+		ViaFailover:     viaFailover,
+		RouteCost:       routeCost,
+		Addrs:           cfg.Addrs,
+		RxRate:          defRxRate,
+		TxRate:          defTxRate,
+		OnlineDeadline:  defOnlineDeadline,
+		MaxOnlineTime:   defMaxOnlineTime,
 	}
 	copy(node.ExchPub[:], exchPub)
 	if len(noisePub) > 0 {
 		node.NoisePub = new([32]byte)
 		copy(node.NoisePub[:], noisePub)
 	}
+	if node.ExecPolicy, err = NewExecPolicies(cfg.ExecPolicy); err != nil {
+		return nil, err
+	}
+	if len(cfg.Placement) > 0 || len(cfg.PostReceive) > 0 {
+		if incoming == nil {
+			return nil, errors.New("placement and post-receive require incoming")
+		}
+	}
+	for _, placementJSON := range cfg.Placement {
+		rule, err := NewPlacementRule(&placementJSON)
+		if err != nil {
+			return nil, err
+		}
+		node.Placement = append(node.Placement, rule)
+	}
+	node.PostReceive = cfg.PostReceive
+	if cfg.Freq != nil {
+		node.FreqAllow = cfg.Freq.Allow
+		node.FreqDeny = cfg.Freq.Deny
+	}
+	if cfg.SpoolQuota != nil {
+		if node.SpoolQuota, err = NewSpoolQuota(cfg.SpoolQuota); err != nil {
+			return nil, err
+		}
+	}
 	return &node, nil
 }
 
+func NewPlacementRule(cfg *PlacementJSON) (*PlacementRule, error) {
+	rule := PlacementRule{Pattern: cfg.Pattern, Collision: PlacementRename}
+	if _, err := path.Match(rule.Pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid placement pattern %s: %w", rule.Pattern, err)
+	}
+	if cfg.Dir != nil {
+		rule.Dir = *cfg.Dir
+		if _, err := placementExpand(
+			rule.Dir, &Node{Id: new(NodeId)}, time.Now(),
+		); err != nil {
+			return nil, fmt.Errorf("invalid placement dir %s: %w", rule.Dir, err)
+		}
+	}
+	if cfg.Collision != nil {
+		switch *cfg.Collision {
+		case PlacementRename, PlacementOverwrite, PlacementReject:
+			rule.Collision = *cfg.Collision
+		default:
+			return nil, errors.New("unknown collision policy: " + *cfg.Collision)
+		}
+	}
+	return &rule, nil
+}
+
+func NewNotifier(cfg NotifierJSON) (*Notifier, error) {
+	n := Notifier{Cmd: cfg.Cmd, Timeout: DefaultNotifyTimeout}
+	backends := 0
+	if len(cfg.Cmd) > 0 {
+		backends++
+	}
+	if cfg.Webhook != nil {
+		backends++
+		u, err := url.Parse(*cfg.Webhook)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webhook %s: %w", *cfg.Webhook, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, errors.New("webhook must be HTTP(S) URL: " + *cfg.Webhook)
+		}
+		n.Webhook = *cfg.Webhook
+	}
+	if cfg.Maildir != nil {
+		backends++
+		if !path.IsAbs(*cfg.Maildir) {
+			return nil, errors.New("maildir path must be absolute: " + *cfg.Maildir)
+		}
+		n.Maildir = *cfg.Maildir
+	}
+	if backends != 1 {
+		return nil, errors.New("notifier must have exactly one of cmd, webhook, maildir")
+	}
+	if cfg.Timeout != nil {
+		n.Timeout = time.Duration(*cfg.Timeout) * time.Second
+	}
+	if cfg.From != nil {
+		n.From = *cfg.From
+	}
+	if cfg.To != nil {
+		n.To = *cfg.To
+	}
+	if len(cfg.Events) > 0 {
+		n.Events = make(map[string]struct{}, len(cfg.Events))
+	Events:
+		for _, ev := range cfg.Events {
+			for _, known := range NotifyEvents {
+				if ev == known {
+					n.Events[ev] = struct{}{}
+					continue Events
+				}
+			}
+			return nil, errors.New("unknown notification event: " + ev)
+		}
+	}
+	return &n, nil
+}
+
+func NewExecPolicies(cfg map[string]ExecPolicyJSON) (map[string]*ExecPolicy, error) {
+	if len(cfg) == 0 {
+		return nil, nil
+	}
+	policies := make(map[string]*ExecPolicy, len(cfg))
+	for handle, policyJSON := range cfg {
+		policy, err := NewExecPolicy(&policyJSON)
+		if err != nil {
+			return nil, fmt.Errorf("exec-policy: %s: %w", handle, err)
+		}
+		policies[handle] = policy
+	}
+	return policies, nil
+}
+
+func NewExecPolicy(cfg *ExecPolicyJSON) (*ExecPolicy, error) {
+	policy := ExecPolicy{Env: cfg.Env, Rlimits: cfg.Rlimits}
+	if cfg.Timeout != nil {
+		policy.Timeout = time.Duration(*cfg.Timeout) * time.Second
+	}
+	if cfg.MaxOutput != nil {
+		policy.MaxOutput = int64(*cfg.MaxOutput) * 1024
+	}
+	if cfg.Dir != nil {
+		if !path.IsAbs(*cfg.Dir) {
+			return nil, errors.New("dir path must be absolute")
+		}
+		policy.Dir = *cfg.Dir
+	}
+	for name := range cfg.Rlimits {
+		known := false
+		for _, n := range ExecRlimitNames {
+			if n == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, errors.New("unknown rlimit: " + name)
+		}
+	}
+	if cfg.Uid != nil {
+		uid := uint32(*cfg.Uid)
+		policy.Uid = &uid
+	}
+	if cfg.Gid != nil {
+		gid := uint32(*cfg.Gid)
+		policy.Gid = &gid
+	}
+	for _, gid := range cfg.Groups {
+		policy.Groups = append(policy.Groups, uint32(gid))
+	}
+	if len(policy.Groups) > 0 && policy.Uid == nil && policy.Gid == nil {
+		return nil, errors.New("groups require uid or gid")
+	}
+	for _, ns := range cfg.Namespaces {
+		known := false
+		for _, n := range ExecNamespaces {
+			if n == ns {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, errors.New("unknown namespace: " + ns)
+		}
+		policy.Namespaces = append(policy.Namespaces, ns)
+	}
+	if cfg.Reply != nil {
+		switch *cfg.Reply {
+		case ExecReplyFile, ExecReplyRexec:
+		case ExecReplyExec:
+			if cfg.ReplyHandle == nil || *cfg.ReplyHandle == "" {
+				return nil, errors.New("reply-handle is required for exec reply")
+			}
+			policy.ReplyHandle = *cfg.ReplyHandle
+		default:
+			return nil, errors.New("unknown reply mode: " + *cfg.Reply)
+		}
+		policy.Reply = *cfg.Reply
+	}
+	return &policy, nil
+}
+
 func NewNodeOur(cfg *NodeOurJSON) (*NodeOur, error) {
 	id, err := NodeIdFromString(cfg.Id)
 	if err != nil {
@@ -417,6 +774,57 @@ func NewNodeOur(cfg *NodeOurJSON) (*NodeOur, error) {
 	return &node, nil
 }
 
+func NewTransit(ctx *Ctx, cfg *TransitJSON) (*Transit, error) {
+	t := Transit{}
+	if cfg.Dsts != nil {
+		t.Dsts = make(map[NodeId]struct{}, len(cfg.Dsts))
+		for _, dst := range cfg.Dsts {
+			node, err := ctx.FindNode(dst)
+			if err != nil {
+				return nil, fmt.Errorf("transit.dsts: %s: %w", dst, err)
+			}
+			t.Dsts[*node.Id] = struct{}{}
+		}
+	}
+	if cfg.MaxSize != nil {
+		t.MaxSize = int64(*cfg.MaxSize) * 1024
+	}
+	if cfg.BytesPerDay != nil {
+		t.BytesPerDay = int64(*cfg.BytesPerDay) * 1024
+	}
+	if cfg.PktsPerDay != nil {
+		t.PktsPerDay = int64(*cfg.PktsPerDay)
+	}
+	if cfg.Nice != nil {
+		var err error
+		t.Nice, err = NicenessParse(*cfg.Nice)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &t, nil
+}
+
+func NewSpoolQuota(cfg *SpoolQuotaJSON) (*SpoolQuota, error) {
+	q := SpoolQuota{}
+	if cfg.Tx != nil {
+		q.Tx = int64(*cfg.Tx) * 1024
+	}
+	if cfg.Rx != nil {
+		q.Rx = int64(*cfg.Rx) * 1024
+	}
+	if cfg.Policy != nil {
+		switch *cfg.Policy {
+		case SpoolQuotaPolicyRefuse:
+		case SpoolQuotaPolicyEvict:
+			q.Evict = true
+		default:
+			return nil, fmt.Errorf("unknown spool-quota policy: %s", *cfg.Policy)
+		}
+	}
+	return &q, nil
+}
+
 func NewArea(ctx *Ctx, name string, cfg *AreaJSON) (*Area, error) {
 	areaId, err := AreaIdFromString(cfg.Id)
 	if err != nil {
@@ -434,6 +842,7 @@ func NewArea(ctx *Ctx, name string, cfg *AreaJSON) (*Area, error) {
 		Name:     name,
 		Id:       areaId,
 		Subs:     subs,
+		subsCfg:  subs,
 		Exec:     cfg.Exec,
 		Incoming: cfg.Incoming,
 	}
@@ -462,17 +871,77 @@ func NewArea(ctx *Ctx, name string, cfg *AreaJSON) (*Area, error) {
 		area.Prv = new([32]byte)
 		copy(area.Prv[:], prv)
 	}
+	if cfg.Owner != nil {
+		pub, err := Base32Codec.DecodeString(*cfg.Owner)
+		if err != nil {
+			return nil, fmt.Errorf("area %s: owner: %w", name, err)
+		}
+		if len(pub) != ed25519.PublicKeySize {
+			return nil, errors.New("Invalid owner's key size")
+		}
+		area.Owner = ed25519.PublicKey(pub)
+	}
 	area.AllowUnknown = cfg.AllowUnknown
+	if area.ExecPolicy, err = NewExecPolicies(cfg.ExecPolicy); err != nil {
+		return nil, fmt.Errorf("area %s: %w", name, err)
+	}
+	if cfg.SubsPolicy != nil {
+		switch *cfg.SubsPolicy {
+		case AreaSubPolicyAuto, AreaSubPolicyManual:
+			area.SubsPolicy = *cfg.SubsPolicy
+		default:
+			return nil, fmt.Errorf("area %s: unknown subs-policy: %s", name, *cfg.SubsPolicy)
+		}
+	}
+	if cfg.Archive != nil {
+		area.Archive = time.Duration(*cfg.Archive) * 24 * time.Hour
+	}
+	if cfg.Moderation != nil {
+		if area.Moderation, err = NewAreaModeration(ctx, cfg.Moderation); err != nil {
+			return nil, fmt.Errorf("area %s: %w", name, err)
+		}
+	}
 	return &area, nil
 }
 
+func NewAreaModeration(ctx *Ctx, cfg *AreaModerationJSON) (*AreaModeration, error) {
+	m := AreaModeration{}
+	if cfg.Authors != nil {
+		m.Authors = make(map[NodeId]struct{}, len(cfg.Authors))
+		for _, author := range cfg.Authors {
+			node, err := ctx.FindNode(author)
+			if err != nil {
+				return nil, fmt.Errorf("moderation.authors: %s: %w", author, err)
+			}
+			m.Authors[*node.Id] = struct{}{}
+		}
+	}
+	for _, moderator := range cfg.Moderators {
+		pub, err := Base32Codec.DecodeString(moderator)
+		if err != nil {
+			return nil, fmt.Errorf("moderation.moderators: %s: %w", moderator, err)
+		}
+		if len(pub) != ed25519.PublicKeySize {
+			return nil, errors.New("Invalid moderator's key size")
+		}
+		m.Moderators = append(m.Moderators, ed25519.PublicKey(pub))
+	}
+	if cfg.MsgsPerDay != nil {
+		m.MsgsPerDay = int64(*cfg.MsgsPerDay)
+	}
+	if cfg.BytesPerDay != nil {
+		m.BytesPerDay = int64(*cfg.BytesPerDay) * 1024
+	}
+	return &m, nil
+}
+
 func CfgParse(data []byte) (*CfgJSON, error) {
 	var err error
 	if bytes.Compare(data[:8], MagicNNCPBv3.B[:]) == 0 {
 		os.Stderr.WriteString("Passphrase:")
 		password, err := term.ReadPassword(0)
 		if err != nil {
-			log.Fatalln(err)
+			return nil, err
 		}
 		os.Stderr.WriteString("\n")
 		data, err = DeEBlob(data, password)
@@ -480,9 +949,9 @@ func CfgParse(data []byte) (*CfgJSON, error) {
 			return nil, err
 		}
 	} else if bytes.Compare(data[:8], MagicNNCPBv2.B[:]) == 0 {
-		log.Fatalln(MagicNNCPBv2.TooOld())
+		return nil, MagicNNCPBv2.TooOld()
 	} else if bytes.Compare(data[:8], MagicNNCPBv1.B[:]) == 0 {
-		log.Fatalln(MagicNNCPBv1.TooOld())
+		return nil, MagicNNCPBv1.TooOld()
 	}
 	var cfgGeneral map[string]interface{}
 	if err = hjson.Unmarshal(data, &cfgGeneral); err != nil {
@@ -548,6 +1017,9 @@ func Cfg2Ctx(cfgJSON *CfgJSON) (*Ctx, error) {
 
 		YggdrasilAliases: cfgJSON.YggdrasilAliases,
 	}
+	if cfgJSON.TossWorkers != nil {
+		ctx.TossWorkers = int(*cfgJSON.TossWorkers)
+	}
 	if cfgJSON.Notify != nil {
 		if cfgJSON.Notify.File != nil {
 			ctx.NotifyFile = cfgJSON.Notify.File
@@ -558,8 +1030,17 @@ func Cfg2Ctx(cfgJSON *CfgJSON) (*Ctx, error) {
 		if cfgJSON.Notify.Exec != nil {
 			ctx.NotifyExec = cfgJSON.Notify.Exec
 		}
+		for _, notifierJSON := range cfgJSON.Notify.Notifiers {
+			notifier, err := NewNotifier(notifierJSON)
+			if err != nil {
+				return nil, err
+			}
+			ctx.Notifiers = append(ctx.Notifiers, notifier)
+		}
 	}
+	transits := make(map[NodeId]*TransitJSON)
 	vias := make(map[NodeId][]string)
+	viasAlt := make(map[NodeId][][]string)
 	for name, neighJSON := range cfgJSON.Neigh {
 		neigh, err := NewNode(name, neighJSON)
 		if err != nil {
@@ -571,9 +1052,15 @@ func Cfg2Ctx(cfgJSON *CfgJSON) (*Ctx, error) {
 		}
 		ctx.Alias[name] = neigh.Id
 		vias[*neigh.Id] = neighJSON.Via
+		viasAlt[*neigh.Id] = neighJSON.ViaAlt
+		transits[*neigh.Id] = neighJSON.Transit
 	}
 	ctx.SelfId = ctx.Alias["self"]
 	for neighId, viasRaw := range vias {
+		if viasRaw != nil && len(viasRaw) == 0 {
+			// Explicitly empty via forbids routing to that node
+			ctx.Neigh[neighId].Via = make([]*NodeId, 0)
+		}
 		for _, viaRaw := range viasRaw {
 			foundNodeId, err := ctx.FindNode(viaRaw)
 			if err != nil {
@@ -585,6 +1072,87 @@ func Cfg2Ctx(cfgJSON *CfgJSON) (*Ctx, error) {
 			)
 		}
 	}
+	for neighId, pathsRaw := range viasAlt {
+		neigh := ctx.Neigh[neighId]
+		for _, pathRaw := range pathsRaw {
+			path := make([]*NodeId, 0, len(pathRaw))
+			for _, viaRaw := range pathRaw {
+				foundNodeId, err := ctx.FindNode(viaRaw)
+				if err != nil {
+					return nil, err
+				}
+				path = append(path, foundNodeId.Id)
+			}
+			neigh.ViaAlt = append(neigh.ViaAlt, path)
+		}
+		if len(neigh.ViaAlt) > 0 && neigh.Via == nil {
+			// Main path is the direct one then, not the routed
+			neigh.Via = make([]*NodeId, 0)
+		}
+	}
+	for neighId, transitJSON := range transits {
+		if transitJSON == nil {
+			transitJSON = cfgJSON.Transit
+		}
+		if transitJSON == nil {
+			continue
+		}
+		transit, err := NewTransit(&ctx, transitJSON)
+		if err != nil {
+			return nil, err
+		}
+		ctx.Neigh[neighId].Transit = transit
+	}
+	if cfgJSON.SpoolQuota != nil {
+		q, err := NewSpoolQuota(cfgJSON.SpoolQuota)
+		if err != nil {
+			return nil, err
+		}
+		ctx.SpoolQuota = q
+	}
+	if r := cfgJSON.Routing; r != nil {
+		ctx.RouteInterval = DefaultRouteInterval
+		if r.Interval != nil {
+			if *r.Interval == 0 {
+				return nil, errors.New("routing.interval must be greater than zero")
+			}
+			ctx.RouteInterval = time.Duration(*r.Interval) * time.Second
+		}
+		ctx.RouteMaxAge = 3 * ctx.RouteInterval
+		if r.MaxAge != nil {
+			ctx.RouteMaxAge = time.Duration(*r.MaxAge) * time.Second
+		}
+		ctx.RouteNice = DefaultRouteNice
+		if r.Nice != nil {
+			var err error
+			ctx.RouteNice, err = NicenessParse(*r.Nice)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if q := cfgJSON.Quarantine; q != nil {
+		ctx.Quarantine = &Quarantine{
+			Retries: DefaultQuarantineRetries,
+			Backoff: DefaultQuarantineBackoff,
+		}
+		if q.Retries != nil {
+			ctx.Quarantine.Retries = int(*q.Retries)
+		}
+		if q.Backoff != nil {
+			ctx.Quarantine.Backoff = time.Duration(*q.Backoff) * time.Second
+		}
+	}
+	if e := cfgJSON.Events; e != nil {
+		if !path.IsAbs(e.Journal) {
+			return nil, errors.New("events journal path must be absolute")
+		}
+		ctx.EventsJournal = e.Journal
+		ctx.EventsSocket = e.Journal + EventsSockSuffix
+		if e.Socket != nil {
+			ctx.EventsSocket = *e.Socket
+		}
+	}
 	ctx.AreaId2Area = make(map[AreaId]*Area, len(cfgJSON.Areas))
 	ctx.AreaName2Id = make(map[string]*AreaId, len(cfgJSON.Areas))
 	for name, areaJSON := range cfgJSON.Areas {
@@ -595,5 +1163,8 @@ func Cfg2Ctx(cfgJSON *CfgJSON) (*Ctx, error) {
 		ctx.AreaId2Area[*area.Id] = area
 		ctx.AreaName2Id[name] = area.Id
 	}
+	if err := ctx.areasSubsLoad(); err != nil {
+		return nil, err
+	}
 	return &ctx, nil
 }
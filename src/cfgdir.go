@@ -100,6 +100,9 @@ func CfgToDir(dst string, cfg *CfgJSON) (err error) {
 			return
 		}
 	}
+	if err = cfgDirSave(cfg.TossWorkers, dst, "toss-workers"); err != nil {
+		return
+	}
 
 	if len(cfg.MCDRxIfis) > 0 {
 		if err = cfgDirSave(
@@ -166,6 +169,43 @@ func CfgToDir(dst string, cfg *CfgJSON) (err error) {
 				return
 			}
 		}
+		for i, n := range cfg.Notify.Notifiers {
+			is := strconv.Itoa(i)
+			if err = cfgDirMkdir(dst, "notify", "notifiers", is); err != nil {
+				return
+			}
+			if len(n.Events) > 0 {
+				if err = cfgDirSave(
+					strings.Join(n.Events, "\n"),
+					dst, "notify", "notifiers", is, "events",
+				); err != nil {
+					return
+				}
+			}
+			if len(n.Cmd) > 0 {
+				if err = cfgDirSave(
+					strings.Join(n.Cmd, "\n"),
+					dst, "notify", "notifiers", is, "cmd",
+				); err != nil {
+					return
+				}
+			}
+			if err = cfgDirSave(n.Webhook, dst, "notify", "notifiers", is, "webhook"); err != nil {
+				return
+			}
+			if err = cfgDirSave(n.Timeout, dst, "notify", "notifiers", is, "timeout"); err != nil {
+				return
+			}
+			if err = cfgDirSave(n.Maildir, dst, "notify", "notifiers", is, "maildir"); err != nil {
+				return
+			}
+			if err = cfgDirSave(n.From, dst, "notify", "notifiers", is, "from"); err != nil {
+				return
+			}
+			if err = cfgDirSave(n.To, dst, "notify", "notifiers", is, "to"); err != nil {
+				return
+			}
+		}
 	}
 
 	if cfg.Self != nil {
@@ -257,6 +297,38 @@ func CfgToDir(dst string, cfg *CfgJSON) (err error) {
 			); err != nil {
 				return
 			}
+			if len(n.Freq.Roots) > 0 {
+				if err = cfgDirMkdir(dst, "neigh", name, "freq", "roots"); err != nil {
+					return
+				}
+				for k, v := range n.Freq.Roots {
+					if err = cfgDirSave(v, dst, "neigh", name, "freq", "roots", k); err != nil {
+						return
+					}
+				}
+			}
+			if len(n.Freq.Allow) > 0 {
+				if err = cfgDirSave(
+					strings.Join(n.Freq.Allow, "\n"),
+					dst, "neigh", name, "freq", "allow",
+				); err != nil {
+					return
+				}
+			}
+			if len(n.Freq.Deny) > 0 {
+				if err = cfgDirSave(
+					strings.Join(n.Freq.Deny, "\n"),
+					dst, "neigh", name, "freq", "deny",
+				); err != nil {
+					return
+				}
+			}
+			if err = cfgDirSave(
+				n.Freq.BytesPerDay,
+				dst, "neigh", name, "freq", "bytes-per-day",
+			); err != nil {
+				return
+			}
 		}
 
 		if len(n.Via) > 0 {
@@ -268,6 +340,22 @@ func CfgToDir(dst string, cfg *CfgJSON) (err error) {
 			}
 		}
 
+		if len(n.ViaAlt) > 0 {
+			paths := make([]string, 0, len(n.ViaAlt))
+			for _, path := range n.ViaAlt {
+				paths = append(paths, strings.Join(path, ","))
+			}
+			if err = cfgDirSave(
+				strings.Join(paths, "\n"),
+				dst, "neigh", name, "via-alt",
+			); err != nil {
+				return
+			}
+		}
+		if err = cfgDirSave(n.ViaFailover, dst, "neigh", name, "via-failover"); err != nil {
+			return
+		}
+
 		if len(n.Addrs) > 0 {
 			if err = cfgDirMkdir(dst, "neigh", name, "addrs"); err != nil {
 				return
@@ -291,6 +379,45 @@ func CfgToDir(dst string, cfg *CfgJSON) (err error) {
 		if err = cfgDirSave(n.MaxOnlineTime, dst, "neigh", name, "maxonlinetime"); err != nil {
 			return
 		}
+		if err = cfgDirSave(n.RouteCost, dst, "neigh", name, "route-cost"); err != nil {
+			return
+		}
+		if n.Transit != nil {
+			if err = cfgDirSaveTransit(n.Transit, dst, "neigh", name, "transit"); err != nil {
+				return
+			}
+		}
+		if n.SpoolQuota != nil {
+			if err = cfgDirSaveSpoolQuota(n.SpoolQuota, dst, "neigh", name, "spool-quota"); err != nil {
+				return
+			}
+		}
+		if err = cfgDirSaveExecPolicies(n.ExecPolicy, dst, "neigh", name, "exec-policy"); err != nil {
+			return
+		}
+		for i, rule := range n.Placement {
+			is := strconv.Itoa(i)
+			if err = cfgDirMkdir(dst, "neigh", name, "placement", is); err != nil {
+				return
+			}
+			if err = cfgDirSave(rule.Pattern, dst, "neigh", name, "placement", is, "pattern"); err != nil {
+				return
+			}
+			if err = cfgDirSave(rule.Dir, dst, "neigh", name, "placement", is, "dir"); err != nil {
+				return
+			}
+			if err = cfgDirSave(rule.Collision, dst, "neigh", name, "placement", is, "collision"); err != nil {
+				return
+			}
+		}
+		if len(n.PostReceive) > 0 {
+			if err = cfgDirSave(
+				strings.Join(n.PostReceive, "\n"),
+				dst, "neigh", name, "post-receive",
+			); err != nil {
+				return
+			}
+		}
 
 		for i, call := range n.Calls {
 			is := strconv.Itoa(i)
@@ -336,6 +463,24 @@ func CfgToDir(dst string, cfg *CfgJSON) (err error) {
 					return
 				}
 			}
+			if err = cfgDirSave(
+				call.TxTriggerNice,
+				dst, "neigh", name, "calls", is, "tx-trigger-nice",
+			); err != nil {
+				return
+			}
+			if err = cfgDirSave(
+				call.TxTriggerMinInterval,
+				dst, "neigh", name, "calls", is, "tx-trigger-min-interval",
+			); err != nil {
+				return
+			}
+			if err = cfgDirSave(
+				call.TxTriggerJitter,
+				dst, "neigh", name, "calls", is, "tx-trigger-jitter",
+			); err != nil {
+				return
+			}
 			if call.AutoToss {
 				if err = cfgDirTouch(dst, "neigh", name, "calls", is, "autotoss"); err != nil {
 					return
@@ -416,6 +561,76 @@ func CfgToDir(dst string, cfg *CfgJSON) (err error) {
 				return
 			}
 		}
+		if err = cfgDirSaveExecPolicies(a.ExecPolicy, dst, "areas", name, "exec-policy"); err != nil {
+			return
+		}
+		if err = cfgDirSave(a.Owner, dst, "areas", name, "owner"); err != nil {
+			return
+		}
+		if err = cfgDirSave(a.SubsPolicy, dst, "areas", name, "subs-policy"); err != nil {
+			return
+		}
+		if err = cfgDirSave(a.Archive, dst, "areas", name, "archive"); err != nil {
+			return
+		}
+		if a.Moderation != nil {
+			if err = cfgDirSaveAreaModeration(
+				a.Moderation, dst, "areas", name, "moderation",
+			); err != nil {
+				return
+			}
+		}
+	}
+
+	if cfg.Transit != nil {
+		if err = cfgDirSaveTransit(cfg.Transit, dst, "transit"); err != nil {
+			return
+		}
+	}
+
+	if cfg.SpoolQuota != nil {
+		if err = cfgDirSaveSpoolQuota(cfg.SpoolQuota, dst, "spool-quota"); err != nil {
+			return
+		}
+	}
+
+	if cfg.Quarantine != nil {
+		if err = cfgDirMkdir(dst, "quarantine"); err != nil {
+			return
+		}
+		if err = cfgDirSave(cfg.Quarantine.Retries, dst, "quarantine", "retries"); err != nil {
+			return
+		}
+		if err = cfgDirSave(cfg.Quarantine.Backoff, dst, "quarantine", "backoff"); err != nil {
+			return
+		}
+	}
+
+	if cfg.Events != nil {
+		if err = cfgDirMkdir(dst, "events"); err != nil {
+			return
+		}
+		if err = cfgDirSave(cfg.Events.Journal, dst, "events", "journal"); err != nil {
+			return
+		}
+		if err = cfgDirSave(cfg.Events.Socket, dst, "events", "socket"); err != nil {
+			return
+		}
+	}
+
+	if cfg.Routing != nil {
+		if err = cfgDirMkdir(dst, "routing"); err != nil {
+			return
+		}
+		if err = cfgDirSave(cfg.Routing.Interval, dst, "routing", "interval"); err != nil {
+			return
+		}
+		if err = cfgDirSave(cfg.Routing.MaxAge, dst, "routing", "max-age"); err != nil {
+			return
+		}
+		if err = cfgDirSave(cfg.Routing.Nice, dst, "routing", "nice"); err != nil {
+			return
+		}
 	}
 
 	if len(cfg.YggdrasilAliases) > 0 {
@@ -504,6 +719,319 @@ func cfgDirReadFromTo(src ...string) (*FromToJSON, error) {
 	return &fromTo, nil
 }
 
+func cfgDirSaveTransit(t *TransitJSON, dst ...string) (err error) {
+	if err = cfgDirMkdir(dst...); err != nil {
+		return
+	}
+	if t.Dsts != nil {
+		if err = cfgDirSave(strings.Join(t.Dsts, "\n"), append(dst, "dsts")...); err != nil {
+			return
+		}
+	}
+	if err = cfgDirSave(t.MaxSize, append(dst, "maxsize")...); err != nil {
+		return
+	}
+	if err = cfgDirSave(t.BytesPerDay, append(dst, "bytes-per-day")...); err != nil {
+		return
+	}
+	if err = cfgDirSave(t.PktsPerDay, append(dst, "pkts-per-day")...); err != nil {
+		return
+	}
+	return cfgDirSave(t.Nice, append(dst, "nice")...)
+}
+
+func cfgDirReadTransit(src ...string) (*TransitJSON, error) {
+	t := TransitJSON{}
+	s, err := cfgDirLoadOpt(append(src, "dsts")...)
+	if err != nil {
+		return nil, err
+	}
+	if s != nil {
+		t.Dsts = []string{}
+		if *s != "" {
+			t.Dsts = strings.Split(*s, "\n")
+		}
+	}
+	for _, v := range []struct {
+		name string
+		dst  **uint64
+	}{
+		{"maxsize", &t.MaxSize},
+		{"bytes-per-day", &t.BytesPerDay},
+		{"pkts-per-day", &t.PktsPerDay},
+	} {
+		i64, err := cfgDirLoadIntOpt(append(src, v.name)...)
+		if err != nil {
+			return nil, err
+		}
+		if i64 != nil {
+			i := uint64(*i64)
+			*v.dst = &i
+		}
+	}
+	if t.Nice, err = cfgDirLoadOpt(append(src, "nice")...); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func cfgDirSaveSpoolQuota(q *SpoolQuotaJSON, dst ...string) (err error) {
+	if err = cfgDirMkdir(dst...); err != nil {
+		return
+	}
+	if err = cfgDirSave(q.Tx, append(dst, "tx")...); err != nil {
+		return
+	}
+	if err = cfgDirSave(q.Rx, append(dst, "rx")...); err != nil {
+		return
+	}
+	return cfgDirSave(q.Policy, append(dst, "policy")...)
+}
+
+func cfgDirReadSpoolQuota(src ...string) (*SpoolQuotaJSON, error) {
+	q := SpoolQuotaJSON{}
+	for _, v := range []struct {
+		name string
+		dst  **uint64
+	}{{"tx", &q.Tx}, {"rx", &q.Rx}} {
+		i64, err := cfgDirLoadIntOpt(append(src, v.name)...)
+		if err != nil {
+			return nil, err
+		}
+		if i64 != nil {
+			i := uint64(*i64)
+			*v.dst = &i
+		}
+	}
+	var err error
+	if q.Policy, err = cfgDirLoadOpt(append(src, "policy")...); err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
+
+func cfgDirSaveAreaModeration(m *AreaModerationJSON, dst ...string) (err error) {
+	if err = cfgDirMkdir(dst...); err != nil {
+		return
+	}
+	if m.Authors != nil {
+		if err = cfgDirSave(strings.Join(m.Authors, "\n"), append(dst, "authors")...); err != nil {
+			return
+		}
+	}
+	if len(m.Moderators) > 0 {
+		if err = cfgDirSave(strings.Join(m.Moderators, "\n"), append(dst, "moderators")...); err != nil {
+			return
+		}
+	}
+	if err = cfgDirSave(m.MsgsPerDay, append(dst, "msgs-per-day")...); err != nil {
+		return
+	}
+	return cfgDirSave(m.BytesPerDay, append(dst, "bytes-per-day")...)
+}
+
+func cfgDirReadAreaModeration(src ...string) (*AreaModerationJSON, error) {
+	m := AreaModerationJSON{}
+	s, err := cfgDirLoadOpt(append(src, "authors")...)
+	if err != nil {
+		return nil, err
+	}
+	if s != nil {
+		m.Authors = []string{}
+		if *s != "" {
+			m.Authors = strings.Split(*s, "\n")
+		}
+	}
+	if s, err = cfgDirLoadOpt(append(src, "moderators")...); err != nil {
+		return nil, err
+	}
+	if s != nil && *s != "" {
+		m.Moderators = strings.Split(*s, "\n")
+	}
+	for _, v := range []struct {
+		name string
+		dst  **uint64
+	}{
+		{"msgs-per-day", &m.MsgsPerDay},
+		{"bytes-per-day", &m.BytesPerDay},
+	} {
+		i64, err := cfgDirLoadIntOpt(append(src, v.name)...)
+		if err != nil {
+			return nil, err
+		}
+		if i64 != nil {
+			i := uint64(*i64)
+			*v.dst = &i
+		}
+	}
+	return &m, nil
+}
+
+func cfgDirSaveExecPolicies(policies map[string]ExecPolicyJSON, dst ...string) (err error) {
+	for handle, p := range policies {
+		dstP := append(append([]string{}, dst...), handle)
+		if err = cfgDirMkdir(dstP...); err != nil {
+			return
+		}
+		if err = cfgDirSave(p.Timeout, append(dstP, "timeout")...); err != nil {
+			return
+		}
+		if err = cfgDirSave(p.MaxOutput, append(dstP, "max-output")...); err != nil {
+			return
+		}
+		if err = cfgDirSave(p.Dir, append(dstP, "dir")...); err != nil {
+			return
+		}
+		if len(p.Env) > 0 {
+			if err = cfgDirSave(strings.Join(p.Env, "\n"), append(dstP, "env")...); err != nil {
+				return
+			}
+		}
+		if len(p.Rlimits) > 0 {
+			if err = cfgDirMkdir(append(dstP, "rlimits")...); err != nil {
+				return
+			}
+			for k, v := range p.Rlimits {
+				v := v
+				if err = cfgDirSave(&v, append(dstP, "rlimits", k)...); err != nil {
+					return
+				}
+			}
+		}
+		if err = cfgDirSave(p.Uid, append(dstP, "uid")...); err != nil {
+			return
+		}
+		if err = cfgDirSave(p.Gid, append(dstP, "gid")...); err != nil {
+			return
+		}
+		if len(p.Groups) > 0 {
+			groups := make([]string, 0, len(p.Groups))
+			for _, gid := range p.Groups {
+				groups = append(groups, strconv.FormatUint(uint64(gid), 10))
+			}
+			if err = cfgDirSave(
+				strings.Join(groups, "\n"), append(dstP, "groups")...,
+			); err != nil {
+				return
+			}
+		}
+		if len(p.Namespaces) > 0 {
+			if err = cfgDirSave(
+				strings.Join(p.Namespaces, "\n"), append(dstP, "namespaces")...,
+			); err != nil {
+				return
+			}
+		}
+		if err = cfgDirSave(p.Reply, append(dstP, "reply")...); err != nil {
+			return
+		}
+		if err = cfgDirSave(p.ReplyHandle, append(dstP, "reply-handle")...); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func cfgDirReadExecPolicies(src ...string) (map[string]ExecPolicyJSON, error) {
+	fis, err := ioutil.ReadDir(filepath.Join(src...))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	policies := make(map[string]ExecPolicyJSON)
+	for _, fi := range fis {
+		handle := fi.Name()
+		if handle[0] == '.' || !fi.IsDir() {
+			continue
+		}
+		srcP := append(append([]string{}, src...), handle)
+		p := ExecPolicyJSON{}
+		for _, v := range []struct {
+			name string
+			dst  **uint
+		}{
+			{"timeout", &p.Timeout},
+			{"uid", &p.Uid},
+			{"gid", &p.Gid},
+		} {
+			i64, err := cfgDirLoadIntOpt(append(srcP, v.name)...)
+			if err != nil {
+				return nil, err
+			}
+			if i64 != nil {
+				i := uint(*i64)
+				*v.dst = &i
+			}
+		}
+		i64, err := cfgDirLoadIntOpt(append(srcP, "max-output")...)
+		if err != nil {
+			return nil, err
+		}
+		if i64 != nil {
+			i := uint64(*i64)
+			p.MaxOutput = &i
+		}
+		if p.Dir, err = cfgDirLoadOpt(append(srcP, "dir")...); err != nil {
+			return nil, err
+		}
+		s, err := cfgDirLoadOpt(append(srcP, "env")...)
+		if err != nil {
+			return nil, err
+		}
+		if s != nil {
+			p.Env = strings.Split(*s, "\n")
+		}
+		fis2, err := ioutil.ReadDir(filepath.Join(append(srcP, "rlimits")...))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, fi2 := range fis2 {
+			n := fi2.Name()
+			if n[0] == '.' {
+				continue
+			}
+			i64, err := cfgDirLoadIntOpt(append(srcP, "rlimits", n)...)
+			if err != nil {
+				return nil, err
+			}
+			if p.Rlimits == nil {
+				p.Rlimits = make(map[string]uint64)
+			}
+			p.Rlimits[n] = uint64(*i64)
+		}
+		s, err = cfgDirLoadOpt(append(srcP, "groups")...)
+		if err != nil {
+			return nil, err
+		}
+		if s != nil {
+			for _, g := range strings.Split(*s, "\n") {
+				gid, err := strconv.ParseUint(g, 10, 32)
+				if err != nil {
+					return nil, err
+				}
+				p.Groups = append(p.Groups, uint(gid))
+			}
+		}
+		s, err = cfgDirLoadOpt(append(srcP, "namespaces")...)
+		if err != nil {
+			return nil, err
+		}
+		if s != nil {
+			p.Namespaces = strings.Split(*s, "\n")
+		}
+		if p.Reply, err = cfgDirLoadOpt(append(srcP, "reply")...); err != nil {
+			return nil, err
+		}
+		if p.ReplyHandle, err = cfgDirLoadOpt(append(srcP, "reply-handle")...); err != nil {
+			return nil, err
+		}
+		policies[handle] = p
+	}
+	return policies, nil
+}
+
 func DirToCfg(src string) (*CfgJSON, error) {
 	cfg := CfgJSON{}
 	var err error
@@ -522,6 +1050,16 @@ func DirToCfg(src string) (*CfgJSON, error) {
 	}
 	cfg.OmitPrgrs = cfgDirExists(src, "noprogress")
 	cfg.NoHdr = cfgDirExists(src, "nohdr")
+	{
+		i64, err := cfgDirLoadIntOpt(src, "toss-workers")
+		if err != nil {
+			return nil, err
+		}
+		if i64 != nil {
+			i := uint(*i64)
+			cfg.TossWorkers = &i
+		}
+	}
 
 	sp, err := cfgDirLoadOpt(src, "mcd-listen")
 	if err != nil {
@@ -578,7 +1116,63 @@ func DirToCfg(src string) (*CfgJSON, error) {
 			return nil, err
 		}
 	}
-	if notify.File != nil || notify.Freq != nil || len(notify.Exec) > 0 {
+	fis, err = ioutil.ReadDir(filepath.Join(src, "notify", "notifiers"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	notifierIdx := make([]int, 0, len(fis))
+	for _, fi := range fis {
+		if !fi.IsDir() {
+			continue
+		}
+		i, err := strconv.Atoi(fi.Name())
+		if err != nil {
+			continue
+		}
+		notifierIdx = append(notifierIdx, i)
+	}
+	sort.Ints(notifierIdx)
+	for _, i := range notifierIdx {
+		srcN := []string{src, "notify", "notifiers", strconv.Itoa(i)}
+		n := NotifierJSON{}
+		s, err := cfgDirLoadOpt(append(srcN, "events")...)
+		if err != nil {
+			return nil, err
+		}
+		if s != nil {
+			n.Events = strings.Split(*s, "\n")
+		}
+		s, err = cfgDirLoadOpt(append(srcN, "cmd")...)
+		if err != nil {
+			return nil, err
+		}
+		if s != nil {
+			n.Cmd = strings.Split(*s, "\n")
+		}
+		if n.Webhook, err = cfgDirLoadOpt(append(srcN, "webhook")...); err != nil {
+			return nil, err
+		}
+		i64, err := cfgDirLoadIntOpt(append(srcN, "timeout")...)
+		if err != nil {
+			return nil, err
+		}
+		if i64 != nil {
+			timeout := uint(*i64)
+			n.Timeout = &timeout
+		}
+		if n.Maildir, err = cfgDirLoadOpt(append(srcN, "maildir")...); err != nil {
+			return nil, err
+		}
+		if n.From, err = cfgDirLoadOpt(append(srcN, "from")...); err != nil {
+			return nil, err
+		}
+		if n.To, err = cfgDirLoadOpt(append(srcN, "to")...); err != nil {
+			return nil, err
+		}
+		notify.Notifiers = append(notify.Notifiers, n)
+	}
+	if notify.File != nil || notify.Freq != nil ||
+		len(notify.Exec) > 0 || len(notify.Notifiers) > 0 {
 		cfg.Notify = &notify
 	}
 
@@ -686,6 +1280,49 @@ func DirToCfg(src string) (*CfgJSON, error) {
 				i := uint64(*i64)
 				node.Freq.MaxSize = &i
 			}
+
+			fis2, err := ioutil.ReadDir(filepath.Join(src, "neigh", n, "freq", "roots"))
+			if err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			for _, fi2 := range fis2 {
+				n2 := fi2.Name()
+				if n2[0] == '.' {
+					continue
+				}
+				s, err := cfgDirLoadMust(src, "neigh", n, "freq", "roots", n2)
+				if err != nil {
+					return nil, err
+				}
+				if node.Freq.Roots == nil {
+					node.Freq.Roots = make(map[string]string)
+				}
+				node.Freq.Roots[n2] = s
+			}
+			for _, v := range []struct {
+				name string
+				dst  *[]string
+			}{
+				{"allow", &node.Freq.Allow},
+				{"deny", &node.Freq.Deny},
+			} {
+				s, err := cfgDirLoadOpt(src, "neigh", n, "freq", v.name)
+				if err != nil {
+					return nil, err
+				}
+				if s != nil {
+					*v.dst = strings.Split(*s, "\n")
+				}
+			}
+
+			i64, err = cfgDirLoadIntOpt(src, "neigh", n, "freq", "bytes-per-day")
+			if err != nil {
+				return nil, err
+			}
+			if i64 != nil {
+				i := uint64(*i64)
+				node.Freq.BytesPerDay = &i
+			}
 		}
 
 		via, err := cfgDirLoadOpt(src, "neigh", n, "via")
@@ -696,6 +1333,20 @@ func DirToCfg(src string) (*CfgJSON, error) {
 			node.Via = strings.Split(*via, "\n")
 		}
 
+		via, err = cfgDirLoadOpt(src, "neigh", n, "via-alt")
+		if err != nil {
+			return nil, err
+		}
+		if via != nil {
+			for _, path := range strings.Split(*via, "\n") {
+				if path == "" {
+					node.ViaAlt = append(node.ViaAlt, []string{})
+				} else {
+					node.ViaAlt = append(node.ViaAlt, strings.Split(path, ","))
+				}
+			}
+		}
+
 		node.Addrs = make(map[string]string)
 		fis2, err = ioutil.ReadDir(filepath.Join(src, "neigh", n, "addrs"))
 		if err != nil && !os.IsNotExist(err) {
@@ -747,6 +1398,81 @@ func DirToCfg(src string) (*CfgJSON, error) {
 			node.MaxOnlineTime = &i
 		}
 
+		i64, err = cfgDirLoadIntOpt(src, "neigh", n, "via-failover")
+		if err != nil {
+			return nil, err
+		}
+		if i64 != nil {
+			i := uint(*i64)
+			node.ViaFailover = &i
+		}
+
+		i64, err = cfgDirLoadIntOpt(src, "neigh", n, "route-cost")
+		if err != nil {
+			return nil, err
+		}
+		if i64 != nil {
+			i := uint(*i64)
+			node.RouteCost = &i
+		}
+
+		if cfgDirExists(src, "neigh", n, "transit") {
+			if node.Transit, err = cfgDirReadTransit(src, "neigh", n, "transit"); err != nil {
+				return nil, err
+			}
+		}
+		if cfgDirExists(src, "neigh", n, "spool-quota") {
+			if node.SpoolQuota, err = cfgDirReadSpoolQuota(src, "neigh", n, "spool-quota"); err != nil {
+				return nil, err
+			}
+		}
+		if node.ExecPolicy, err = cfgDirReadExecPolicies(src, "neigh", n, "exec-policy"); err != nil {
+			return nil, err
+		}
+		fis2, err = ioutil.ReadDir(filepath.Join(src, "neigh", n, "placement"))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		placementIdx := make([]int, 0, len(fis2))
+		for _, fi2 := range fis2 {
+			if !fi2.IsDir() {
+				continue
+			}
+			i, err := strconv.Atoi(fi2.Name())
+			if err != nil {
+				continue
+			}
+			placementIdx = append(placementIdx, i)
+		}
+		sort.Ints(placementIdx)
+		for _, i := range placementIdx {
+			is := strconv.Itoa(i)
+			rule := PlacementJSON{}
+			if rule.Pattern, err = cfgDirLoadMust(
+				src, "neigh", n, "placement", is, "pattern",
+			); err != nil {
+				return nil, err
+			}
+			if rule.Dir, err = cfgDirLoadOpt(
+				src, "neigh", n, "placement", is, "dir",
+			); err != nil {
+				return nil, err
+			}
+			if rule.Collision, err = cfgDirLoadOpt(
+				src, "neigh", n, "placement", is, "collision",
+			); err != nil {
+				return nil, err
+			}
+			node.Placement = append(node.Placement, rule)
+		}
+		postReceive, err := cfgDirLoadOpt(src, "neigh", n, "post-receive")
+		if err != nil {
+			return nil, err
+		}
+		if postReceive != nil {
+			node.PostReceive = strings.Split(*postReceive, "\n")
+		}
+
 		fis2, err = ioutil.ReadDir(filepath.Join(src, "neigh", n, "calls"))
 		if err != nil && !os.IsNotExist(err) {
 			return nil, err
@@ -834,6 +1560,30 @@ func DirToCfg(src string) (*CfgJSON, error) {
 			if cfgDirExists(src, "neigh", n, "calls", is, "mcd-ignore") {
 				call.MCDIgnore = true
 			}
+			if call.TxTriggerNice, err = cfgDirLoadOpt(
+				src, "neigh", n, "calls", is, "tx-trigger-nice",
+			); err != nil {
+				return nil, err
+			}
+
+			i64, err = cfgDirLoadIntOpt(src, "neigh", n, "calls", is, "tx-trigger-min-interval")
+			if err != nil {
+				return nil, err
+			}
+			if i64 != nil {
+				i := uint(*i64)
+				call.TxTriggerMinInterval = &i
+			}
+
+			i64, err = cfgDirLoadIntOpt(src, "neigh", n, "calls", is, "tx-trigger-jitter")
+			if err != nil {
+				return nil, err
+			}
+			if i64 != nil {
+				i := uint(*i64)
+				call.TxTriggerJitter = &i
+			}
+
 			if cfgDirExists(src, "neigh", n, "calls", is, "autotoss") {
 				call.AutoToss = true
 			}
@@ -913,9 +1663,96 @@ func DirToCfg(src string) (*CfgJSON, error) {
 		if cfgDirExists(src, "areas", n, "allow-unknown") {
 			area.AllowUnknown = true
 		}
+		if area.ExecPolicy, err = cfgDirReadExecPolicies(src, "areas", n, "exec-policy"); err != nil {
+			return nil, err
+		}
+		if area.Owner, err = cfgDirLoadOpt(src, "areas", n, "owner"); err != nil {
+			return nil, err
+		}
+		if area.SubsPolicy, err = cfgDirLoadOpt(src, "areas", n, "subs-policy"); err != nil {
+			return nil, err
+		}
+		if i64, err := cfgDirLoadIntOpt(src, "areas", n, "archive"); err != nil {
+			return nil, err
+		} else if i64 != nil {
+			i := uint(*i64)
+			area.Archive = &i
+		}
+		if cfgDirExists(src, "areas", n, "moderation") {
+			if area.Moderation, err = cfgDirReadAreaModeration(
+				src, "areas", n, "moderation",
+			); err != nil {
+				return nil, err
+			}
+		}
 		cfg.Areas[n] = area
 	}
 
+	if cfgDirExists(src, "transit") {
+		if cfg.Transit, err = cfgDirReadTransit(src, "transit"); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfgDirExists(src, "spool-quota") {
+		if cfg.SpoolQuota, err = cfgDirReadSpoolQuota(src, "spool-quota"); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfgDirExists(src, "quarantine") {
+		q := QuarantineJSON{}
+		for _, v := range []struct {
+			name string
+			dst  **uint
+		}{{"retries", &q.Retries}, {"backoff", &q.Backoff}} {
+			i64, err := cfgDirLoadIntOpt(src, "quarantine", v.name)
+			if err != nil {
+				return nil, err
+			}
+			if i64 != nil {
+				i := uint(*i64)
+				*v.dst = &i
+			}
+		}
+		cfg.Quarantine = &q
+	}
+
+	if cfgDirExists(src, "events") {
+		events := EventsJSON{}
+		if events.Journal, err = cfgDirLoadMust(src, "events", "journal"); err != nil {
+			return nil, err
+		}
+		if events.Socket, err = cfgDirLoadOpt(src, "events", "socket"); err != nil {
+			return nil, err
+		}
+		cfg.Events = &events
+	}
+
+	if cfgDirExists(src, "routing") {
+		routing := RoutingJSON{}
+		i64, err := cfgDirLoadIntOpt(src, "routing", "interval")
+		if err != nil {
+			return nil, err
+		}
+		if i64 != nil {
+			i := uint(*i64)
+			routing.Interval = &i
+		}
+		i64, err = cfgDirLoadIntOpt(src, "routing", "max-age")
+		if err != nil {
+			return nil, err
+		}
+		if i64 != nil {
+			i := uint(*i64)
+			routing.MaxAge = &i
+		}
+		if routing.Nice, err = cfgDirLoadOpt(src, "routing", "nice"); err != nil {
+			return nil, err
+		}
+		cfg.Routing = &routing
+	}
+
 	fis, err = ioutil.ReadDir(filepath.Join(src, "yggdrasil-aliases"))
 	if err != nil && !os.IsNotExist(err) {
 		return nil, err
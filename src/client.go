@@ -0,0 +1,332 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+var (
+	ClientTossFailed = errors.New("some packets are not tossed")
+	ClientCallFailed = errors.New("call failed")
+)
+
+// Client is an API for embedding NNCP into Go programs. Contrary to
+// CtxFromCmdline, it does not look at environment variables, does not
+// print anything to stderr and does not touch process-wide logging
+// and umask settings.
+type Client struct {
+	Ctx *Ctx
+}
+
+type ClientOpts struct {
+	// Override configuration's spool directory
+	Spool string
+	// Override configuration's log file path
+	LogPath string
+	// Write log records there instead of log file
+	Log io.Writer
+	// Number of concurrently tossed packets
+	TossWorkers int
+	// Write debug messages there, disabled if nil
+	Debug io.Writer
+}
+
+// Open configuration file or directory.
+func NewClient(cfgPath string, opts *ClientOpts) (*Client, error) {
+	cfg, err := CfgLoad(cfgPath)
+	if err != nil {
+		return nil, err
+	}
+	return NewClientFromCfg(cfg, opts)
+}
+
+func NewClientFromCfg(cfg *CfgJSON, opts *ClientOpts) (*Client, error) {
+	ctx, err := Cfg2Ctx(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.Self == nil {
+		return nil, errors.New("config lacks private keys")
+	}
+	ctx.Quiet = true
+	ctx.ShowPrgrs = false
+	if opts != nil {
+		if opts.Spool != "" {
+			ctx.Spool = opts.Spool
+		}
+		if opts.LogPath != "" {
+			ctx.LogPath = opts.LogPath
+		}
+		ctx.LogWriter = opts.Log
+		if opts.TossWorkers > 0 {
+			ctx.TossWorkers = opts.TossWorkers
+		}
+		ctx.DebugWriter = opts.Debug
+		ctx.Debug = opts.Debug != nil
+	}
+	if strings.HasPrefix(ctx.LogPath, LogFdPrefix) && ctx.LogWriter == nil {
+		return nil, errors.New("file descriptor log requires Log writer")
+	}
+	return &Client{Ctx: ctx}, nil
+}
+
+// Options of the outgoing packet. Zero values mean defaults.
+type SendOpts struct {
+	// Packet's niceness, default one for packet's type if zero
+	Nice uint8
+	// Reply's niceness for exec and freq, DefaultNiceFile if zero
+	ReplyNice uint8
+	// Pad packet to that size
+	MinSize int64
+	// Maximal packet size, MaxFileSize if zero
+	MaxSize int64
+	// Chunk the file into parts of that size. Negative value means
+	// node's freq.chunked setting
+	ChunkSize int64
+	// Do not compress exec's body
+	NoCompress bool
+}
+
+// Destination is either node's name/identifier, or "area:NAME".
+func (c *Client) target(to string) (*Node, *AreaId, error) {
+	if strings.HasPrefix(to, AreaDir+":") {
+		areaId := c.Ctx.AreaName2Id[strings.TrimPrefix(to, AreaDir+":")]
+		if areaId == nil {
+			return nil, nil, errors.New("unknown area: " + to)
+		}
+		return c.Ctx.Neigh[*c.Ctx.SelfId], areaId, nil
+	}
+	node, err := c.Ctx.FindNode(to)
+	return node, nil, err
+}
+
+func (opts *SendOpts) nices(nice uint8) (uint8, uint8) {
+	replyNice := uint8(DefaultNiceFile)
+	if opts.Nice != 0 {
+		nice = opts.Nice
+	}
+	if opts.ReplyNice != 0 {
+		replyNice = opts.ReplyNice
+	}
+	return nice, replyNice
+}
+
+func (opts *SendOpts) maxSize() int64 {
+	if opts.MaxSize == 0 {
+		return MaxFileSize
+	}
+	return opts.MaxSize
+}
+
+// Send file (or directory, or "-" for stdin) to the destination path.
+// Names of all created packets are returned, chunked file's metadata
+// one is the last.
+func (c *Client) SendFile(to, src, dst string, opts *SendOpts) ([]string, error) {
+	if opts == nil {
+		opts = &SendOpts{}
+	}
+	node, areaId, err := c.target(to)
+	if err != nil {
+		return nil, err
+	}
+	nice, _ := opts.nices(DefaultNiceFile)
+	chunkSize := opts.ChunkSize
+	if chunkSize < 0 {
+		chunkSize = node.FreqChunked
+	}
+	return c.Ctx.txFile(
+		node, nice, src, dst, chunkSize, opts.MinSize, opts.maxSize(), areaId,
+	)
+}
+
+// Send exec packet with the body read from in. Packet's name is
+// returned.
+func (c *Client) SendExec(
+	to, handle string,
+	args []string,
+	in io.Reader,
+	opts *SendOpts,
+) (string, error) {
+	if opts == nil {
+		opts = &SendOpts{}
+	}
+	node, areaId, err := c.target(to)
+	if err != nil {
+		return "", err
+	}
+	nice, replyNice := opts.nices(DefaultNiceExec)
+	return c.Ctx.txExec(
+		node, nice, replyNice, handle, args, in,
+		opts.MinSize, opts.maxSize(), opts.NoCompress, areaId,
+	)
+}
+
+// Send file request. kind is either empty, FreqKindList or
+// FreqKindGlob. Packet's name is returned.
+func (c *Client) SendFreq(to, src, dst, kind string, opts *SendOpts) (string, error) {
+	if opts == nil {
+		opts = &SendOpts{}
+	}
+	node, err := c.Ctx.FindNode(to)
+	if err != nil {
+		return "", err
+	}
+	nice, replyNice := opts.nices(DefaultNiceFreq)
+	return c.Ctx.txFreq(node, nice, replyNice, src, dst, opts.MinSize, kind)
+}
+
+// Subscribe to the events happening during tossing, see Ctx.Subscribe.
+func (c *Client) Subscribe(size int, events ...string) (<-chan NotifyEvent, func()) {
+	return c.Ctx.Subscribe(size, events...)
+}
+
+type TossOpts struct {
+	// Toss only packets with that or lower niceness, all if zero
+	Nice   uint8
+	DryRun bool
+	Seen   bool
+	NoFile bool
+	NoFreq bool
+	NoExec bool
+	NoTrns bool
+	NoArea bool
+	NoACK  bool
+}
+
+// Toss node's inbound packets, of all nodes if name is empty.
+// Cancellation stops processing before the next packet.
+func (c *Client) Toss(cctx context.Context, name string, opts *TossOpts) error {
+	if opts == nil {
+		opts = &TossOpts{}
+	}
+	nice := opts.Nice
+	if nice == 0 {
+		nice = 255
+	}
+	var nodeIds []*NodeId
+	if name == "" {
+		for nodeId := range c.Ctx.Neigh {
+			nodeId := nodeId
+			nodeIds = append(nodeIds, &nodeId)
+		}
+	} else {
+		node, err := c.Ctx.FindNode(name)
+		if err != nil {
+			return err
+		}
+		nodeIds = append(nodeIds, node.Id)
+	}
+	isBad := false
+	for _, nodeId := range nodeIds {
+		if cctx.Err() != nil {
+			break
+		}
+		isBad = c.Ctx.tossJobs(
+			cctx.Done(), nodeId, TRx, nice,
+			opts.DryRun, opts.Seen, opts.NoFile, opts.NoFreq,
+			opts.NoExec, opts.NoTrns, opts.NoArea, opts.NoACK,
+		) || isBad
+	}
+	if err := cctx.Err(); err != nil {
+		return err
+	}
+	if isBad {
+		return ClientTossFailed
+	}
+	return nil
+}
+
+type CallOpts struct {
+	// Addresses to try, node's ones if empty
+	Addrs []string
+	// Exchange only packets with that or lower niceness, all if zero
+	Nice uint8
+	// Either TRx or TTx to only receive or transmit
+	XxOnly TRxTx
+	RxRate int
+	TxRate int
+	// Node's settings are used if zero
+	OnlineDeadline time.Duration
+	MaxOnlineTime  time.Duration
+	NoCK           bool
+}
+
+// Call the node. Cancellation terminates the session.
+func (c *Client) Call(cctx context.Context, name string, opts *CallOpts) error {
+	if opts == nil {
+		opts = &CallOpts{}
+	}
+	node, err := c.Ctx.FindNode(name)
+	if err != nil {
+		return err
+	}
+	addrs := opts.Addrs
+	if len(addrs) == 0 {
+		for _, addr := range node.Addrs {
+			addrs = append(addrs, addr)
+		}
+	}
+	if len(addrs) == 0 {
+		return errors.New("no addresses for " + node.Name)
+	}
+	nice := opts.Nice
+	if nice == 0 {
+		nice = 255
+	}
+	onlineDeadline := node.OnlineDeadline
+	if opts.OnlineDeadline != 0 {
+		onlineDeadline = opts.OnlineDeadline
+	}
+	maxOnlineTime := node.MaxOnlineTime
+	if opts.MaxOnlineTime != 0 {
+		maxOnlineTime = opts.MaxOnlineTime
+	}
+	isGood := c.Ctx.callNode(
+		cctx.Done(), node, addrs, nice, opts.XxOnly,
+		opts.RxRate, opts.TxRate, onlineDeadline, maxOnlineTime,
+		false, opts.NoCK, nil,
+	)
+	c.Ctx.SPCheckerWg.Wait()
+	if err = cctx.Err(); err != nil {
+		return err
+	}
+	if !isGood {
+		return ClientCallFailed
+	}
+	return nil
+}
+
+// Register in-process exec handler for the node, or for all nodes if
+// name is empty. See Ctx.ExecRegister.
+func (c *Client) ExecRegister(name, handle string, fn ExecFunc) error {
+	var nodeId *NodeId
+	if name != "" {
+		node, err := c.Ctx.FindNode(name)
+		if err != nil {
+			return err
+		}
+		nodeId = node.Id
+	}
+	c.Ctx.ExecRegister(nodeId, handle, fn)
+	return nil
+}
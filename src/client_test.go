@@ -0,0 +1,145 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestClient(t *testing.T) {
+	spool, err := ioutil.TempDir("", "testclient")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(spool)
+	nodeOur, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	incoming := filepath.Join(spool, "incoming")
+	noisePub := Base32Codec.EncodeToString(nodeOur.NoisePub[:])
+	cfg := &CfgJSON{
+		Spool: filepath.Join(spool, "spool"),
+		Log:   filepath.Join(spool, "log"),
+		Self: &NodeOurJSON{
+			Id:       nodeOur.Id.String(),
+			ExchPub:  Base32Codec.EncodeToString(nodeOur.ExchPub[:]),
+			ExchPrv:  Base32Codec.EncodeToString(nodeOur.ExchPrv[:]),
+			SignPub:  Base32Codec.EncodeToString(nodeOur.SignPub[:]),
+			SignPrv:  Base32Codec.EncodeToString(nodeOur.SignPrv[:]),
+			NoisePub: noisePub,
+			NoisePrv: Base32Codec.EncodeToString(nodeOur.NoisePrv[:]),
+		},
+		Neigh: map[string]NodeJSON{"self": {
+			Id:       nodeOur.Id.String(),
+			ExchPub:  Base32Codec.EncodeToString(nodeOur.ExchPub[:]),
+			SignPub:  Base32Codec.EncodeToString(nodeOur.SignPub[:]),
+			NoisePub: &noisePub,
+			Incoming: &incoming,
+			Exec:     map[string][]string{"cat": {"/bin/cat"}},
+		}},
+	}
+	var log, debug bytes.Buffer
+	client, err := NewClientFromCfg(cfg, &ClientOpts{Log: &log, Debug: &debug})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srcPath := filepath.Join(spool, "src")
+	if err = ioutil.WriteFile(srcPath, []byte("0123456789"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	pktNames, err := client.SendFile("self", srcPath, "dst", &SendOpts{ChunkSize: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pktName, err := client.SendExec(
+		"self", "cat", nil, strings.NewReader("BODY"), nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pktNames = append(pktNames, pktName)
+	sort.Strings(pktNames)
+	txPath := filepath.Join(cfg.Spool, nodeOur.Id.String(), string(TTx))
+	rxPath := filepath.Join(cfg.Spool, nodeOur.Id.String(), string(TRx))
+	pkts := func(dir string) (names []string) {
+		for _, name := range dirFiles(dir) {
+			if name != HdrDir {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		return
+	}
+	names := pkts(txPath)
+	if len(pktNames) != 5 || strings.Join(names, " ") != strings.Join(pktNames, " ") {
+		t.Fatal("unexpected packets:", pktNames, names)
+	}
+	if !strings.Contains(log.String(), "Pkt: "+pktName) {
+		t.Fatal("log is not written")
+	}
+	if !strings.Contains(debug.String(), "Debug: true") {
+		t.Fatal("debug is not written")
+	}
+	if err = os.Rename(txPath, rxPath); err != nil {
+		t.Fatal(err)
+	}
+
+	cctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = client.Toss(cctx, "self", nil); err != context.Canceled {
+		t.Fatal("toss is not cancelled:", err)
+	}
+	if len(pkts(rxPath)) != 5 {
+		t.Fatal("packets are tossed after cancellation")
+	}
+
+	events, unsubscribe := client.Subscribe(16, NotifyEventExec)
+	if err = client.Toss(context.Background(), "", nil); err != nil {
+		t.Fatal(err)
+	}
+	unsubscribe()
+	var got []NotifyEvent
+	for ev := range events {
+		got = append(got, ev)
+	}
+	if len(got) != 1 || got[0].Pkt != pktName || got[0].Output != "BODY" {
+		t.Fatalf("unexpected events: %+v", got)
+	}
+	if len(pkts(rxPath)) != 0 {
+		t.Fatal("packets are not tossed")
+	}
+	if _, err = os.Stat(filepath.Join(incoming, "dst.nncp.meta")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientCfgTooOld(t *testing.T) {
+	for _, magic := range []Magic{MagicNNCPBv1, MagicNNCPBv2} {
+		if _, err := CfgParse(append(magic.B[:], "{}"...)); err == nil {
+			t.Fatal("too old configuration is accepted:", magic.Name)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Rotate NNCP area's keys.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"go.cypherpunks.ru/nncp/v8"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, nncp.UsageHeader())
+	fmt.Fprintf(os.Stderr, "nncp-area-rekey -- rotate area's keys\n\n")
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] AREA NODE [...]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [options] -list AREA\n", os.Args[0])
+	fmt.Fprintln(os.Stderr, "Options:")
+	flag.PrintDefaults()
+}
+
+func main() {
+	var (
+		cfgPath   = flag.String("cfg", nncp.DefaultCfgPath, "Path to configuration file")
+		doList    = flag.Bool("list", false, "List area's epochs")
+		grace     = flag.Uint("grace", uint(nncp.AreaRekeyGrace/(24*time.Hour)), "Days previous epoch is still accepted")
+		niceRaw   = flag.String("nice", nncp.NicenessFmt(nncp.DefaultNiceExec), "Outbound packet niceness")
+		spoolPath = flag.String("spool", "", "Override path to spool")
+		logPath   = flag.String("log", "", "Override path to logfile")
+		quiet     = flag.Bool("quiet", false, "Print only errors")
+		debug     = flag.Bool("debug", false, "Print debug messages")
+		version   = flag.Bool("version", false, "Print version information")
+		warranty  = flag.Bool("warranty", false, "Print warranty information")
+	)
+	log.SetFlags(log.Lshortfile)
+	flag.Usage = usage
+	flag.Parse()
+	if *warranty {
+		fmt.Println(nncp.Warranty)
+		return
+	}
+	if *version {
+		fmt.Println(nncp.VersionGet())
+		return
+	}
+	if (*doList && flag.NArg() != 1) || (!*doList && flag.NArg() < 2) {
+		usage()
+		os.Exit(1)
+	}
+	nice, err := nncp.NicenessParse(*niceRaw)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	ctx, err := nncp.CtxFromCmdline(
+		*cfgPath,
+		*spoolPath,
+		*logPath,
+		*quiet,
+		false,
+		false,
+		*debug,
+	)
+	if err != nil {
+		log.Fatalln("Error during initialization:", err)
+	}
+	areaId := ctx.AreaName2Id[flag.Arg(0)]
+	if areaId == nil {
+		log.Fatalln("Unknown area:", flag.Arg(0))
+	}
+	area := ctx.AreaId2Area[*areaId]
+
+	if *doList {
+		keys, err := ctx.AreaKeys(area)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		tm := func(t time.Time) string {
+			if t.IsZero() {
+				return "-"
+			}
+			return t.UTC().Format(time.RFC3339)
+		}
+		for _, key := range keys {
+			fmt.Printf("%d %s %s %s\n", key.Epoch, key.Id, tm(key.Since), tm(key.Till))
+		}
+		return
+	}
+
+	if ctx.Self == nil {
+		log.Fatalln("Config lacks private keys")
+	}
+	members := make([]*nncp.Node, 0, flag.NArg()-1)
+	for _, arg := range flag.Args()[1:] {
+		node, err := ctx.FindNode(arg)
+		if err != nil {
+			log.Fatalln("Invalid NODE specified:", err)
+		}
+		members = append(members, node)
+	}
+	ctx.Umask()
+	rk, err := ctx.AreaRekey(
+		area, time.Duration(*grace)*24*time.Hour, members, nice,
+	)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	ctx.LogI("area-rekey", nncp.LEs{
+		{K: "Area", V: area.Id},
+		{K: "Epoch", V: int64(rk.Epoch)},
+	}, func(les nncp.LEs) string {
+		return fmt.Sprintf(
+			"Area %s epoch %d is sent to %d members",
+			area.Name, rk.Epoch, len(members),
+		)
+	})
+}
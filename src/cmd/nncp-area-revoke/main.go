@@ -0,0 +1,156 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Revoke NNCP area's authors.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"go.cypherpunks.ru/nncp/v8"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, nncp.UsageHeader())
+	fmt.Fprintf(os.Stderr, "nncp-area-revoke -- revoke area's authors\n\n")
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] AREA AUTHOR > revocation\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [options] -import AREA < revocation\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [options] -list AREA\n", os.Args[0])
+	fmt.Fprintln(os.Stderr, "Options:")
+	flag.PrintDefaults()
+}
+
+func main() {
+	var (
+		cfgPath   = flag.String("cfg", nncp.DefaultCfgPath, "Path to configuration file")
+		doImport  = flag.Bool("import", false, "Verify and import revocation from stdin")
+		doList    = flag.Bool("list", false, "List revoked authors")
+		spoolPath = flag.String("spool", "", "Override path to spool")
+		logPath   = flag.String("log", "", "Override path to logfile")
+		quiet     = flag.Bool("quiet", false, "Print only errors")
+		debug     = flag.Bool("debug", false, "Print debug messages")
+		version   = flag.Bool("version", false, "Print version information")
+		warranty  = flag.Bool("warranty", false, "Print warranty information")
+	)
+	log.SetFlags(log.Lshortfile)
+	flag.Usage = usage
+	flag.Parse()
+	if *warranty {
+		fmt.Println(nncp.Warranty)
+		return
+	}
+	if *version {
+		fmt.Println(nncp.VersionGet())
+		return
+	}
+	if (*doImport && *doList) ||
+		((*doImport || *doList) && flag.NArg() != 1) ||
+		(!*doImport && !*doList && flag.NArg() != 2) {
+		usage()
+		os.Exit(1)
+	}
+
+	ctx, err := nncp.CtxFromCmdline(
+		*cfgPath,
+		*spoolPath,
+		*logPath,
+		*quiet,
+		false,
+		false,
+		*debug,
+	)
+	if err != nil {
+		log.Fatalln("Error during initialization:", err)
+	}
+	areaId := ctx.AreaName2Id[flag.Arg(0)]
+	if areaId == nil {
+		log.Fatalln("Unknown area:", flag.Arg(0))
+	}
+	area := ctx.AreaId2Area[*areaId]
+	les := nncp.LEs{{K: "Area", V: area.Id}}
+
+	if *doList {
+		revs, err := ctx.AreaRevocations(area)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		for _, rev := range revs {
+			fmt.Printf(
+				"%s %s\n",
+				ctx.NodeName(&rev.Author),
+				time.Unix(int64(rev.When), 0).UTC().Format(time.RFC3339),
+			)
+		}
+		return
+	}
+
+	if *doImport {
+		data, err := ioutil.ReadAll(io.LimitReader(os.Stdin, nncp.AreaRevocationMaxSize))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		rev, err := ctx.AreaRevocationSave(area, data)
+		logMsg := func(les nncp.LEs) string {
+			return fmt.Sprintf("Importing revocation for area %s", area.Name)
+		}
+		if err != nil {
+			ctx.LogE("area-revoke", les, err, logMsg)
+			os.Exit(1)
+		}
+		les = append(les, nncp.LE{K: "Author", V: rev.Author})
+		ctx.LogI("area-revoke", les, func(les nncp.LEs) string {
+			return fmt.Sprintf(
+				"Author %s is revoked in area %s",
+				ctx.NodeName(&rev.Author), area.Name,
+			)
+		})
+		return
+	}
+
+	if ctx.Self == nil {
+		log.Fatalln("Config lacks private keys")
+	}
+	var author *nncp.NodeId
+	if node, err := ctx.FindNode(flag.Arg(1)); err == nil {
+		author = node.Id
+	} else if author, err = nncp.NodeIdFromString(flag.Arg(1)); err != nil {
+		log.Fatalln("Invalid AUTHOR specified:", err)
+	}
+	data, err := ctx.AreaRevocationNew(area, author)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if _, err = ctx.AreaRevocationSave(area, data); err != nil {
+		log.Fatalln(err)
+	}
+	les = append(les, nncp.LE{K: "Author", V: author})
+	ctx.LogI("area-revoke", les, func(les nncp.LEs) string {
+		return fmt.Sprintf(
+			"Author %s is revoked in area %s",
+			ctx.NodeName(author), area.Name,
+		)
+	})
+	if _, err = os.Stdout.Write(data); err != nil {
+		log.Fatalln(err)
+	}
+}
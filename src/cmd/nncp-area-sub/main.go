@@ -0,0 +1,157 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Manage NNCP area's subscriptions.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"go.cypherpunks.ru/nncp/v8"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, nncp.UsageHeader())
+	fmt.Fprintf(os.Stderr, "nncp-area-sub -- manage area's subscriptions\n\n")
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] [-unsub] AREA NODE\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [options] -list [AREA]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [options] {-approve|-reject} AREA NODE\n", os.Args[0])
+	fmt.Fprintln(os.Stderr, "Options:")
+	flag.PrintDefaults()
+}
+
+func main() {
+	var (
+		cfgPath   = flag.String("cfg", nncp.DefaultCfgPath, "Path to configuration file")
+		niceRaw   = flag.String("nice", nncp.NicenessFmt(nncp.DefaultNiceExec), "Outbound packet niceness")
+		doUnsub   = flag.Bool("unsub", false, "Request unsubscription")
+		doList    = flag.Bool("list", false, "List requests waiting for approval")
+		doApprove = flag.Bool("approve", false, "Approve node's request")
+		doReject  = flag.Bool("reject", false, "Reject node's request")
+		spoolPath = flag.String("spool", "", "Override path to spool")
+		logPath   = flag.String("log", "", "Override path to logfile")
+		quiet     = flag.Bool("quiet", false, "Print only errors")
+		debug     = flag.Bool("debug", false, "Print debug messages")
+		version   = flag.Bool("version", false, "Print version information")
+		warranty  = flag.Bool("warranty", false, "Print warranty information")
+	)
+	log.SetFlags(log.Lshortfile)
+	flag.Usage = usage
+	flag.Parse()
+	if *warranty {
+		fmt.Println(nncp.Warranty)
+		return
+	}
+	if *version {
+		fmt.Println(nncp.VersionGet())
+		return
+	}
+	actions := 0
+	for _, action := range []bool{*doUnsub, *doList, *doApprove, *doReject} {
+		if action {
+			actions++
+		}
+	}
+	if actions > 1 || (*doList && flag.NArg() > 1) || (!*doList && flag.NArg() != 2) {
+		usage()
+		os.Exit(1)
+	}
+	nice, err := nncp.NicenessParse(*niceRaw)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	ctx, err := nncp.CtxFromCmdline(
+		*cfgPath,
+		*spoolPath,
+		*logPath,
+		*quiet,
+		false,
+		false,
+		*debug,
+	)
+	if err != nil {
+		log.Fatalln("Error during initialization:", err)
+	}
+	var areas []*nncp.Area
+	if flag.NArg() == 0 {
+		for _, area := range ctx.AreaId2Area {
+			areas = append(areas, area)
+		}
+		sort.Slice(areas, func(i, j int) bool { return areas[i].Name < areas[j].Name })
+	} else {
+		areaId := ctx.AreaName2Id[flag.Arg(0)]
+		if areaId == nil {
+			log.Fatalln("Unknown area:", flag.Arg(0))
+		}
+		areas = append(areas, ctx.AreaId2Area[*areaId])
+	}
+
+	if *doList {
+		for _, area := range areas {
+			reqs, err := ctx.AreaSubReqs(area)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			for _, req := range reqs {
+				fmt.Printf(
+					"%s %s %s\n", area.Name, ctx.NodeName(&req.Node),
+					time.Unix(int64(req.When), 0).UTC().Format(time.RFC3339),
+				)
+			}
+		}
+		return
+	}
+
+	area := areas[0]
+	node, err := ctx.FindNode(flag.Arg(1))
+	if err != nil {
+		log.Fatalln("Invalid NODE specified:", err)
+	}
+	les := nncp.LEs{{K: "Area", V: area.Id}, {K: "Node", V: node.Id}}
+	if *doApprove || *doReject {
+		logMsg := func(les nncp.LEs) string {
+			action := "Approving"
+			if *doReject {
+				action = "Rejecting"
+			}
+			return fmt.Sprintf(
+				"%s area %s subscription request from %s",
+				action, area.Name, node.Name,
+			)
+		}
+		if err = ctx.AreaSubReqDecide(area, node.Id, *doApprove); err != nil {
+			ctx.LogE("area-sub", les, err, logMsg)
+			os.Exit(1)
+		}
+		ctx.LogI("area-sub", les, logMsg)
+		return
+	}
+
+	if ctx.Self == nil {
+		log.Fatalln("Config lacks private keys")
+	}
+	ctx.Umask()
+	if err = ctx.TxAreaSub(node, nice, area, *doUnsub); err != nil {
+		log.Fatalln(err)
+	}
+}
@@ -0,0 +1,97 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Synchronize NNCP area's messages with the node.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"go.cypherpunks.ru/nncp/v8"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, nncp.UsageHeader())
+	fmt.Fprintf(os.Stderr, "nncp-area-sync -- synchronize area's messages with the node\n\n")
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] AREA NODE\nOptions:\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	var (
+		cfgPath   = flag.String("cfg", nncp.DefaultCfgPath, "Path to configuration file")
+		niceRaw   = flag.String("nice", nncp.NicenessFmt(nncp.DefaultNiceExec), "Outbound packet niceness")
+		cells     = flag.Int("cells", nncp.AreaSyncCells, "Number of summary's cells")
+		spoolPath = flag.String("spool", "", "Override path to spool")
+		logPath   = flag.String("log", "", "Override path to logfile")
+		quiet     = flag.Bool("quiet", false, "Print only errors")
+		debug     = flag.Bool("debug", false, "Print debug messages")
+		version   = flag.Bool("version", false, "Print version information")
+		warranty  = flag.Bool("warranty", false, "Print warranty information")
+	)
+	log.SetFlags(log.Lshortfile)
+	flag.Usage = usage
+	flag.Parse()
+	if *warranty {
+		fmt.Println(nncp.Warranty)
+		return
+	}
+	if *version {
+		fmt.Println(nncp.VersionGet())
+		return
+	}
+	if flag.NArg() != 2 {
+		usage()
+		os.Exit(1)
+	}
+	nice, err := nncp.NicenessParse(*niceRaw)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	ctx, err := nncp.CtxFromCmdline(
+		*cfgPath,
+		*spoolPath,
+		*logPath,
+		*quiet,
+		false,
+		false,
+		*debug,
+	)
+	if err != nil {
+		log.Fatalln("Error during initialization:", err)
+	}
+	if ctx.Self == nil {
+		log.Fatalln("Config lacks private keys")
+	}
+	areaId := ctx.AreaName2Id[flag.Arg(0)]
+	if areaId == nil {
+		log.Fatalln("Unknown area:", flag.Arg(0))
+	}
+	area := ctx.AreaId2Area[*areaId]
+	node, err := ctx.FindNode(flag.Arg(1))
+	if err != nil {
+		log.Fatalln("Invalid NODE specified:", err)
+	}
+	ctx.Umask()
+	if err = ctx.TxAreaSync(node, nice, area, *cells); err != nil {
+		log.Fatalln(err)
+	}
+}
@@ -273,7 +273,11 @@ func main() {
 				})
 				continue
 			}
-			if !ctx.IsEnoughSpace(entry.Size) {
+			enough, err := ctx.IsEnoughSpace(entry.Size)
+			if err != nil {
+				log.Fatalln("Can not stat spool:", err)
+			}
+			if !enough {
 				ctx.LogE("bundle-rx", les, errors.New("not enough spool space"), logMsg)
 				continue
 			}
@@ -414,6 +418,10 @@ func main() {
 				})
 				continue
 			}
+			if !ctx.SpoolQuotaRxAllows(pktEnc.Sender, entry.Size) {
+				ctx.LogE("bundle-rx", les, nncp.SpoolQuotaExceeded, logMsg)
+				continue
+			}
 			if _, err = os.Stat(filepath.Join(
 				dstDirPath, nncp.SeenDir, pktName,
 			)); err == nil || !os.IsNotExist(err) {
@@ -240,7 +240,7 @@ func main() {
 		close(autoTossFinish)
 		badCode = (<-autoTossBadCode) || badCode
 	}
-	nncp.SPCheckerWg.Wait()
+	ctx.SPCheckerWg.Wait()
 	if badCode {
 		os.Exit(1)
 	}
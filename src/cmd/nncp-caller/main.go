@@ -19,12 +19,15 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 package main
 
 import (
+	"crypto/rand"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"math/big"
 	"net"
 	"os"
+	"path/filepath"
 	"regexp"
 	"sync"
 	"time"
@@ -146,124 +149,270 @@ func main() {
 		}
 	}
 
+	if ctx.RouteInterval > 0 {
+		go ctx.RouteAdvertiser()
+	}
+	go func() {
+		for {
+			ctx.ViaFailoverAll()
+			time.Sleep(nncp.ViaFailoverCheckInterval)
+		}
+	}()
+
 	var wg sync.WaitGroup
 	for _, node := range nodes {
+		waiters := new(callWaiters)
 		for i, call := range node.Calls {
-			wg.Add(1)
-			go func(node *nncp.Node, i int, call *nncp.Call) {
-				defer wg.Done()
-				var addrsFromCfg []string
-				if call.Addr == nil {
-					for _, addr := range node.Addrs {
-						addrsFromCfg = append(addrsFromCfg, addr)
-					}
-				} else {
-					addrsFromCfg = append(addrsFromCfg, *call.Addr)
+			var addrsFromCfg []string
+			if call.Addr == nil {
+				for _, addr := range node.Addrs {
+					addrsFromCfg = append(addrsFromCfg, addr)
 				}
-				les := nncp.LEs{{K: "Node", V: node.Id}, {K: "CallIndex", V: i}}
-				logMsg := func(les nncp.LEs) string {
+			} else {
+				addrsFromCfg = append(addrsFromCfg, *call.Addr)
+			}
+			les := nncp.LEs{{K: "Node", V: node.Id}, {K: "CallIndex", V: i}}
+			logMsg := func(node *nncp.Node, i int) func(les nncp.LEs) string {
+				return func(les nncp.LEs) string {
 					return fmt.Sprintf("%s node, call %d", node.Name, i)
 				}
+			}(node, i)
+			c := caller{
+				ctx:          ctx,
+				node:         node,
+				call:         call,
+				addrsFromCfg: addrsFromCfg,
+				les:          les,
+				logMsg:       logMsg,
+				waiters:      waiters,
+
+				autoToss:       *autoToss,
+				autoTossDoSeen: *autoTossDoSeen,
+				autoTossNoFile: *autoTossNoFile,
+				autoTossNoFreq: *autoTossNoFreq,
+				autoTossNoExec: *autoTossNoExec,
+				autoTossNoTrns: *autoTossNoTrns,
+				autoTossNoArea: *autoTossNoArea,
+				autoTossNoACK:  *autoTossNoACK,
+			}
+			if call.TxTrigger {
+				c.redial = make(chan struct{}, 1)
+				wg.Add(1)
+				go func(c *caller) {
+					defer wg.Done()
+					c.triggered()
+				}(&c)
+			}
+			wg.Add(1)
+			go func(c *caller) {
+				defer wg.Done()
 				for {
 					n := time.Now()
-					t := call.Cron.Next(n)
-					ctx.LogD("caller-time", les, func(les nncp.LEs) string {
-						return logMsg(les) + ": " + t.String()
+					t := c.call.Cron.Next(n)
+					ctx.LogD("caller-time", c.les, func(les nncp.LEs) string {
+						return c.logMsg(les) + ": " + t.String()
 					})
 					if t.IsZero() {
-						ctx.LogE("caller", les, errors.New("got zero time"), logMsg)
+						ctx.LogE("caller", c.les, errors.New("got zero time"), c.logMsg)
 						return
 					}
 					time.Sleep(t.Sub(n))
-					node.Lock()
-					if node.Busy {
-						node.Unlock()
-						ctx.LogD("caller-busy", les, func(les nncp.LEs) string {
-							return logMsg(les) + ": busy"
-						})
-						continue
-					} else {
-						node.Busy = true
-						node.Unlock()
+					c.run(c.call.WhenTxExists && c.call.Xx != "TRx", c.call.Nice, nil)
+				}
+			}(&c)
+		}
+	}
+	wg.Wait()
+	ctx.SPCheckerWg.Wait()
+}
 
-						if call.WhenTxExists && call.Xx != "TRx" {
-							ctx.LogD("caller", les, func(les nncp.LEs) string {
-								return logMsg(les) + ": checking tx existence"
-							})
-							txExists := false
-							for job := range ctx.Jobs(node.Id, nncp.TTx) {
-								if job.PktEnc.Nice > call.Nice {
-									continue
-								}
-								txExists = true
-							}
-							if !txExists {
-								ctx.LogD("caller-no-tx", les, func(les nncp.LEs) string {
-									return logMsg(les) + ": no tx"
-								})
-								node.Lock()
-								node.Busy = false
-								node.Unlock()
-								continue
-							}
-						}
+// Channels of triggered callers, that found the node busy and have to
+// redial after its call ends. Guarded by node's lock.
+type callWaiters []chan struct{}
 
-						var autoTossFinish chan struct{}
-						var autoTossBadCode chan bool
-						if call.AutoToss || *autoToss {
-							autoTossFinish, autoTossBadCode = ctx.AutoToss(
-								node.Id,
-								call.Nice,
-								call.AutoTossDoSeen || *autoTossDoSeen,
-								call.AutoTossNoFile || *autoTossNoFile,
-								call.AutoTossNoFreq || *autoTossNoFreq,
-								call.AutoTossNoExec || *autoTossNoExec,
-								call.AutoTossNoTrns || *autoTossNoTrns,
-								call.AutoTossNoArea || *autoTossNoArea,
-								call.AutoTossNoACK || *autoTossNoACK,
-							)
-						}
+type caller struct {
+	ctx          *nncp.Ctx
+	node         *nncp.Node
+	call         *nncp.Call
+	addrsFromCfg []string
+	les          nncp.LEs
+	logMsg       func(les nncp.LEs) string
+	waiters      *callWaiters
+	redial       chan struct{}
 
-						var addrs []string
-						if !call.MCDIgnore {
-							nncp.MCDAddrsM.RLock()
-							for _, mcdAddr := range nncp.MCDAddrs[*node.Id] {
-								ctx.LogD("caller", les, func(les nncp.LEs) string {
-									return logMsg(les) + ": adding MCD address: " +
-										mcdAddr.Addr.String()
-								})
-								addrs = append(addrs, mcdAddr.Addr.String())
-							}
-							nncp.MCDAddrsM.RUnlock()
-						}
+	autoToss       bool
+	autoTossDoSeen bool
+	autoTossNoFile bool
+	autoTossNoFreq bool
+	autoTossNoExec bool
+	autoTossNoTrns bool
+	autoTossNoArea bool
+	autoTossNoACK  bool
 
-						ctx.CallNode(
-							node,
-							append(addrs, addrsFromCfg...),
-							call.Nice,
-							call.Xx,
-							call.RxRate,
-							call.TxRate,
-							call.OnlineDeadline,
-							call.MaxOnlineTime,
-							false,
-							call.NoCK,
-							nil,
-						)
+	lastCall  time.Time
+	lastCallM sync.Mutex
+}
 
-						if call.AutoToss || *autoToss {
-							close(autoTossFinish)
-							<-autoTossBadCode
-						}
+// Are there outbound packets with niceness lower than or equal to nice.
+func (c *caller) txExists(nice uint8) bool {
+	txExists := false
+	for job := range c.ctx.Jobs(c.node.Id, nncp.TTx) {
+		if job.PktEnc.Nice > nice {
+			continue
+		}
+		txExists = true
+	}
+	return txExists
+}
 
-						node.Lock()
-						node.Busy = false
-						node.Unlock()
-					}
-				}
-			}(node, i, call)
+// Call the node if it is not busy. Otherwise the redial channel, if
+// specified, is signalled after the current call ends.
+func (c *caller) run(whenTxExists bool, txNice uint8, redial chan struct{}) {
+	ctx := c.ctx
+	node := c.node
+	call := c.call
+	les := c.les
+	logMsg := c.logMsg
+	node.Lock()
+	if node.Busy {
+		if redial != nil {
+			*c.waiters = append(*c.waiters, redial)
 		}
+		node.Unlock()
+		ctx.LogD("caller-busy", les, func(les nncp.LEs) string {
+			return logMsg(les) + ": busy"
+		})
+		return
+	}
+	node.Busy = true
+	node.Unlock()
+	defer func() {
+		node.Lock()
+		node.Busy = false
+		waiters := *c.waiters
+		*c.waiters = nil
+		node.Unlock()
+		for _, redial := range waiters {
+			select {
+			case redial <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	if whenTxExists {
+		ctx.LogD("caller", les, func(les nncp.LEs) string {
+			return logMsg(les) + ": checking tx existence"
+		})
+		if !c.txExists(txNice) {
+			ctx.LogD("caller-no-tx", les, func(les nncp.LEs) string {
+				return logMsg(les) + ": no tx"
+			})
+			return
+		}
+	}
+
+	var addrs []string
+	if !call.MCDIgnore {
+		nncp.MCDAddrsM.RLock()
+		for _, mcdAddr := range nncp.MCDAddrs[*node.Id] {
+			ctx.LogD("caller", les, func(les nncp.LEs) string {
+				return logMsg(les) + ": adding MCD address: " +
+					mcdAddr.Addr.String()
+			})
+			addrs = append(addrs, mcdAddr.Addr.String())
+		}
+		nncp.MCDAddrsM.RUnlock()
+	}
+
+	addrs = ctx.AddrsPrefer(node.Id, append(addrs, c.addrsFromCfg...))
+
+	var autoTossFinish chan struct{}
+	var autoTossBadCode chan bool
+	if call.AutoToss || c.autoToss {
+		autoTossFinish, autoTossBadCode = ctx.AutoToss(
+			node.Id,
+			call.Nice,
+			call.AutoTossDoSeen || c.autoTossDoSeen,
+			call.AutoTossNoFile || c.autoTossNoFile,
+			call.AutoTossNoFreq || c.autoTossNoFreq,
+			call.AutoTossNoExec || c.autoTossNoExec,
+			call.AutoTossNoTrns || c.autoTossNoTrns,
+			call.AutoTossNoArea || c.autoTossNoArea,
+			call.AutoTossNoACK || c.autoTossNoACK,
+		)
+	}
+
+	c.lastCallM.Lock()
+	c.lastCall = time.Now()
+	c.lastCallM.Unlock()
+	ctx.CallNode(
+		node,
+		addrs,
+		call.Nice,
+		call.Xx,
+		call.RxRate,
+		call.TxRate,
+		call.OnlineDeadline,
+		call.MaxOnlineTime,
+		false,
+		call.NoCK,
+		nil,
+	)
+
+	if call.AutoToss || c.autoToss {
+		close(autoTossFinish)
+		<-autoTossBadCode
+	}
+}
+
+// Watch node's tx directory and call as soon as packets with niceness
+// lower than call.TxTriggerNice appear, but not more often than
+// call.TxTriggerMinInterval and with random call.TxTriggerJitter delay.
+// If the node is busy, then the check is repeated after its call.
+func (c *caller) triggered() {
+	ctx := c.ctx
+	les := c.les
+	txNice := c.call.TxTriggerNice - 1
+	dw, err := ctx.NewDirWatcher(
+		filepath.Join(ctx.Spool, c.node.Id.String(), string(nncp.TTx)),
+		time.Second,
+	)
+	if err != nil {
+		ctx.LogE("caller-trigger", les, err, c.logMsg)
+		return
+	}
+	defer dw.Close()
+	for {
+		select {
+		case <-dw.C:
+		case <-c.redial:
+			ctx.LogD("caller-trigger-redial", les, func(les nncp.LEs) string {
+				return c.logMsg(les) + ": busy call ended, rechecking urgent tx"
+			})
+		}
+		if !c.txExists(txNice) {
+			continue
+		}
+		c.lastCallM.Lock()
+		wait := time.Until(c.lastCall.Add(c.call.TxTriggerMinInterval))
+		c.lastCallM.Unlock()
+		if c.call.TxTriggerJitter > 0 {
+			jitter, err := rand.Int(rand.Reader, big.NewInt(int64(c.call.TxTriggerJitter)))
+			if err != nil {
+				log.Fatalln(err)
+			}
+			wait += time.Duration(jitter.Int64())
+		}
+		if wait > 0 {
+			ctx.LogD("caller-trigger-wait", les, func(les nncp.LEs) string {
+				return c.logMsg(les) + ": urgent tx, waiting " + wait.String()
+			})
+			time.Sleep(wait)
+		}
+		ctx.LogD("caller-trigger", les, func(les nncp.LEs) string {
+			return c.logMsg(les) + ": urgent tx triggered call"
+		})
+		c.run(true, txNice, c.redial)
 	}
-	wg.Wait()
-	nncp.SPCheckerWg.Wait()
 }
@@ -142,6 +142,7 @@ func main() {
 		maxConn   = flag.Int("maxconn", 128, "Maximal number of simultaneous connections")
 		noCK      = flag.Bool("nock", false, "Do no checksum checking")
 		mcdOnce   = flag.Bool("mcd-once", false, "Send MCDs once and quit")
+		events    = flag.Bool("events", false, "Serve events socket")
 		spoolPath = flag.String("spool", "", "Override path to spool")
 		logPath   = flag.String("log", "", "Override path to logfile")
 		quiet     = flag.Bool("quiet", false, "Print only errors")
@@ -230,6 +231,25 @@ func main() {
 		return
 	}
 
+	if *events {
+		ln, err := ctx.EventsListen()
+		if err != nil {
+			log.Fatalln("Can not listen events socket:", err)
+		}
+		go func() {
+			if err := ctx.EventsServe(ln); err != nil {
+				log.Fatalln("Can not accept events connection:", err)
+			}
+		}()
+	}
+
+	go func() {
+		for {
+			ctx.ViaFailoverAll()
+			time.Sleep(nncp.ViaFailoverCheckInterval)
+		}
+	}()
+
 	conns := make(chan net.Conn)
 	if *bind != "" {
 		cols := strings.Split(*bind, ":")
@@ -0,0 +1,103 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Stream NNCP events.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+
+	"go.cypherpunks.ru/nncp/v8"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, nncp.UsageHeader())
+	fmt.Fprintf(os.Stderr, "nncp-events -- stream events as JSON lines\n\n")
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] [-since SEQ]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [options] -serve\n", os.Args[0])
+	fmt.Fprintln(os.Stderr, "Options:")
+	flag.PrintDefaults()
+}
+
+func main() {
+	var (
+		cfgPath   = flag.String("cfg", nncp.DefaultCfgPath, "Path to configuration file")
+		since     = flag.Uint64("since", 0, "Sequence number of the last seen event")
+		serve     = flag.Bool("serve", false, "Serve events socket")
+		socket    = flag.String("socket", "", "Override path to events socket")
+		spoolPath = flag.String("spool", "", "Override path to spool")
+		logPath   = flag.String("log", "", "Override path to logfile")
+		debug     = flag.Bool("debug", false, "Print debug messages")
+		version   = flag.Bool("version", false, "Print version information")
+		warranty  = flag.Bool("warranty", false, "Print warranty information")
+	)
+	log.SetFlags(log.Lshortfile)
+	flag.Usage = usage
+	flag.Parse()
+	if *warranty {
+		fmt.Println(nncp.Warranty)
+		return
+	}
+	if *version {
+		fmt.Println(nncp.VersionGet())
+		return
+	}
+
+	ctx, err := nncp.CtxFromCmdline(
+		*cfgPath,
+		*spoolPath,
+		*logPath,
+		false,
+		false,
+		false,
+		*debug,
+	)
+	if err != nil {
+		log.Fatalln("Error during initialization:", err)
+	}
+	if *socket != "" {
+		ctx.EventsSocket = *socket
+	}
+	if ctx.EventsSocket == "" {
+		log.Fatalln("Events are not configured")
+	}
+
+	if *serve {
+		ctx.Umask()
+		ln, err := ctx.EventsListen()
+		if err != nil {
+			log.Fatalln("Can not listen:", err)
+		}
+		log.Fatalln(ctx.EventsServe(ln))
+	}
+
+	conn, err := net.Dial("unix", ctx.EventsSocket)
+	if err != nil {
+		log.Fatalln("Can not connect:", err)
+	}
+	if _, err = fmt.Fprintf(conn, "%d\n", *since); err != nil {
+		log.Fatalln(err)
+	}
+	if _, err = io.Copy(os.Stdout, conn); err != nil {
+		log.Fatalln(err)
+	}
+}
@@ -21,18 +21,24 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/dustin/go-humanize"
 	"go.cypherpunks.ru/nncp/v8"
 )
 
 func usage() {
 	fmt.Fprintf(os.Stderr, nncp.UsageHeader())
 	fmt.Fprintf(os.Stderr, "nncp-freq -- send file request\n\n")
-	fmt.Fprintf(os.Stderr, "Usage: %s [options] NODE:SRC [DST]\nOptions:\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] NODE:SRC [DST]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [options] -list NODE:[GLOB] [DST]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [options] -glob NODE:GLOB [DSTDIR]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [options] -verify MANIFEST\nOptions:\n", os.Args[0])
 	flag.PrintDefaults()
 }
 
@@ -43,6 +49,9 @@ func main() {
 		replyNiceRaw = flag.String("replynice", nncp.NicenessFmt(nncp.DefaultNiceFile), "Reply file packet niceness")
 		minSize      = flag.Uint64("minsize", 0, "Minimal required resulting packet size, in KiB")
 		viaOverride  = flag.String("via", "", "Override Via path to destination node")
+		doList       = flag.Bool("list", false, "Request signed listing of files")
+		doGlob       = flag.Bool("glob", false, "Request all files matching glob")
+		doVerify     = flag.Bool("verify", false, "Verify and print received listing")
 		spoolPath    = flag.String("spool", "", "Override path to spool")
 		logPath      = flag.String("log", "", "Override path to logfile")
 		quiet        = flag.Bool("quiet", false, "Print only errors")
@@ -63,7 +72,7 @@ func main() {
 		fmt.Println(nncp.VersionGet())
 		return
 	}
-	if flag.NArg() == 0 {
+	if flag.NArg() == 0 || (*doList && *doGlob) {
 		usage()
 		os.Exit(1)
 	}
@@ -92,6 +101,31 @@ func main() {
 		log.Fatalln("Config lacks private keys")
 	}
 
+	if *doVerify {
+		data, err := ioutil.ReadFile(flag.Arg(0))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		manifest, err := ctx.FreqManifestVerify(data)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		fmt.Printf(
+			"Listing of %s (%s), created %s, signature: valid\n",
+			ctx.NodeName(manifest.Node), manifest.Pattern,
+			manifest.Created.Format(time.RFC3339),
+		)
+		for _, e := range manifest.Entries {
+			fmt.Printf(
+				"%s\t%s\t%s\t%s\n",
+				e.Path, humanize.IBytes(uint64(e.Size)),
+				e.MTime.Format(time.RFC3339),
+				nncp.Base32Codec.EncodeToString(e.MTH),
+			)
+		}
+		return
+	}
+
 	splitted := strings.SplitN(flag.Arg(0), ":", 2)
 	if len(splitted) != 2 {
 		usage()
@@ -105,20 +139,32 @@ func main() {
 	nncp.ViaOverride(*viaOverride, ctx, node)
 	ctx.Umask()
 
+	var kind string
 	var dst string
-	if flag.NArg() == 2 {
+	switch {
+	case flag.NArg() == 2:
 		dst = flag.Arg(1)
-	} else {
+	case *doList:
+		dst = node.Name + ".list"
+	case *doGlob:
+		dst = "."
+	default:
 		dst = filepath.Base(splitted[1])
 	}
+	if *doList {
+		kind = nncp.FreqKindList
+	} else if *doGlob {
+		kind = nncp.FreqKindGlob
+	}
 
-	if err = ctx.TxFreq(
+	if err = ctx.TxFreqKind(
 		node,
 		nice,
 		replyNice,
 		splitted[1],
 		dst,
 		int64(*minSize)*1024,
+		kind,
 	); err != nil {
 		log.Fatalln(err)
 	}
@@ -26,6 +26,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"strings"
 
 	xdr "github.com/davecgh/go-xdr/xdr2"
 	"github.com/klauspost/compress/zstd"
@@ -76,6 +77,8 @@ func doPlain(ctx *nncp.Ctx, pkt nncp.Pkt, dump, decompress bool) {
 		payloadType = "area"
 	case nncp.PktTypeACK:
 		payloadType = "acknowledgement"
+	case nncp.PktTypeRoute:
+		payloadType = "routing advertisement"
 	}
 	var path string
 	switch pkt.Type {
@@ -84,16 +87,29 @@ func doPlain(ctx *nncp.Ctx, pkt nncp.Pkt, dump, decompress bool) {
 			pkt.Path[:pkt.PathLen], []byte{0}, []byte(" "), -1,
 		))
 	case nncp.PktTypeTrns:
-		path = nncp.Base32Codec.EncodeToString(pkt.Path[:pkt.PathLen])
-		node, err := ctx.FindNode(path)
-		if err == nil {
-			path = fmt.Sprintf("%s (%s)", path, node.Name)
+		raw := pkt.Path[:pkt.PathLen]
+		ids := make([]string, 0, len(raw)/len(nncp.NodeId{}))
+		for len(raw) >= len(nncp.NodeId{}) {
+			id := nncp.Base32Codec.EncodeToString(raw[:len(nncp.NodeId{})])
+			node, err := ctx.FindNode(id)
+			if err == nil {
+				id = fmt.Sprintf("%s (%s)", id, node.Name)
+			}
+			ids = append(ids, id)
+			raw = raw[len(nncp.NodeId{}):]
 		}
+		path = strings.Join(ids, " ")
 	case nncp.PktTypeArea:
 		path = nncp.Base32Codec.EncodeToString(pkt.Path[:pkt.PathLen])
 		if areaId, err := nncp.AreaIdFromString(path); err == nil {
 			path = fmt.Sprintf("%s (%s)", path, ctx.AreaName(areaId))
 		}
+	case nncp.PktTypeFreq:
+		raw := bytes.SplitN(pkt.Path[:pkt.PathLen], []byte{0}, 2)
+		path = string(raw[0])
+		if len(raw) == 2 {
+			path = fmt.Sprintf("%s (%s)", path, raw[1])
+		}
 	case nncp.PktTypeACK:
 		path = nncp.Base32Codec.EncodeToString(pkt.Path[:pkt.PathLen])
 	default:
@@ -0,0 +1,185 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Manage quarantined NNCP packets.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"go.cypherpunks.ru/nncp/v8"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, nncp.UsageHeader())
+	fmt.Fprintf(os.Stderr, "nncp-quarantine -- manage quarantined packets\n\n")
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] [-node NODE]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [options] -node NODE -pkt PKT\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [options] {-node NODE -pkt PKT|[-node NODE] -all} {-retry|-rm}\n", os.Args[0])
+	fmt.Fprintln(os.Stderr, "Options:")
+	flag.PrintDefaults()
+}
+
+func main() {
+	var (
+		cfgPath   = flag.String("cfg", nncp.DefaultCfgPath, "Path to configuration file")
+		nodeRaw   = flag.String("node", "", "Process only that node")
+		pktRaw    = flag.String("pkt", "", "Process only that packet")
+		doAll     = flag.Bool("all", false, "Process all quarantined packets")
+		doRetry   = flag.Bool("retry", false, "Move packets back to inbound queue")
+		doRm      = flag.Bool("rm", false, "Remove packets")
+		spoolPath = flag.String("spool", "", "Override path to spool")
+		logPath   = flag.String("log", "", "Override path to logfile")
+		quiet     = flag.Bool("quiet", false, "Print only errors")
+		debug     = flag.Bool("debug", false, "Print debug messages")
+		version   = flag.Bool("version", false, "Print version information")
+		warranty  = flag.Bool("warranty", false, "Print warranty information")
+	)
+	log.SetFlags(log.Lshortfile)
+	flag.Usage = usage
+	flag.Parse()
+	if *warranty {
+		fmt.Println(nncp.Warranty)
+		return
+	}
+	if *version {
+		fmt.Println(nncp.VersionGet())
+		return
+	}
+	if (*doRetry && *doRm) || (*pktRaw != "" && (*nodeRaw == "" || *doAll)) ||
+		((*doRetry || *doRm) && *pktRaw == "" && !*doAll) {
+		usage()
+		os.Exit(1)
+	}
+
+	ctx, err := nncp.CtxFromCmdline(
+		*cfgPath,
+		*spoolPath,
+		*logPath,
+		*quiet,
+		false,
+		false,
+		*debug,
+	)
+	if err != nil {
+		log.Fatalln("Error during initialization:", err)
+	}
+
+	var nodes []*nncp.Node
+	if *nodeRaw == "" {
+		for _, node := range ctx.Neigh {
+			nodes = append(nodes, node)
+		}
+		sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	} else {
+		node, err := ctx.FindNode(*nodeRaw)
+		if err != nil {
+			log.Fatalln("Invalid -node specified:", err)
+		}
+		nodes = append(nodes, node)
+	}
+
+	if *pktRaw != "" && !*doRetry && !*doRm {
+		job, err := ctx.QuarantineJob(nodes[0].Id, *pktRaw)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		fmt.Printf("Node: %s\nPacket: %s\nSize: %s\n",
+			nodes[0].Name, job.PktName, humanize.IBytes(uint64(job.Size)))
+		if f := job.Failure; f != nil {
+			fmt.Printf("Attempts: %d\nLast: %s\nReason: %s\n",
+				f.Attempts, f.Last.Format(time.RFC3339), f.Reason)
+			if f.ExitCode != nil {
+				fmt.Printf("Exit code: %d\n", *f.ExitCode)
+			}
+			if f.Output != "" {
+				fmt.Printf("Output:\n\t%s\n", strings.ReplaceAll(f.Output, "\n", "\n\t"))
+			}
+		}
+		return
+	}
+
+	isBad := false
+	for _, node := range nodes {
+		var jobs []*nncp.QuarantineJob
+		if *pktRaw == "" {
+			jobs, err = ctx.QuarantineJobs(node.Id)
+		} else {
+			var job *nncp.QuarantineJob
+			job, err = ctx.QuarantineJob(node.Id, *pktRaw)
+			jobs = []*nncp.QuarantineJob{job}
+		}
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if len(jobs) == 0 {
+			continue
+		}
+		if !*doRetry && !*doRm {
+			fmt.Println(node.Name)
+		}
+		for _, job := range jobs {
+			les := nncp.LEs{{K: "Node", V: node.Id}, {K: "Pkt", V: job.PktName}}
+			switch {
+			case *doRetry:
+				err = ctx.QuarantineRetry(node.Id, job.PktName)
+				logMsg := func(les nncp.LEs) string {
+					return fmt.Sprintf("Retrying quarantined %s/%s", node.Name, job.PktName)
+				}
+				if err == nil {
+					ctx.LogI("quarantine-retry", les, logMsg)
+				} else {
+					ctx.LogE("quarantine-retry", les, err, logMsg)
+					isBad = true
+				}
+			case *doRm:
+				err = ctx.QuarantineRemove(node.Id, job.PktName)
+				logMsg := func(les nncp.LEs) string {
+					return fmt.Sprintf("Removing quarantined %s/%s", node.Name, job.PktName)
+				}
+				if err == nil {
+					ctx.LogI("quarantine-rm", les, logMsg)
+				} else {
+					ctx.LogE("quarantine-rm", les, err, logMsg)
+					isBad = true
+				}
+			default:
+				reason := "unknown"
+				if f := job.Failure; f != nil {
+					reason = fmt.Sprintf(
+						"%s, %d attempts, last %s",
+						f.Reason, f.Attempts, f.Last.Format(time.RFC3339),
+					)
+				}
+				fmt.Printf(
+					"\t%s %s: %s\n",
+					job.PktName, humanize.IBytes(uint64(job.Size)), reason,
+				)
+			}
+		}
+	}
+	if isBad {
+		os.Exit(1)
+	}
+}
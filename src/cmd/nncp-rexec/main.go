@@ -0,0 +1,156 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Execute command on remote NNCP node and wait for its result.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"go.cypherpunks.ru/nncp/v8"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, nncp.UsageHeader())
+	fmt.Fprintf(os.Stderr, "nncp-rexec -- execute command remotely and wait for result\n\n")
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] NODE HANDLE [ARG0 ARG1 ...]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [options] -id ID\nOptions:\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	var (
+		noCompress   = flag.Bool("nocompress", false, "Do not compress input data")
+		cfgPath      = flag.String("cfg", nncp.DefaultCfgPath, "Path to configuration file")
+		niceRaw      = flag.String("nice", nncp.NicenessFmt(nncp.DefaultNiceExec), "Outbound packet niceness")
+		replyNiceRaw = flag.String("replynice", nncp.NicenessFmt(nncp.DefaultNiceExec), "Reply packet niceness")
+		minSize      = flag.Uint64("minsize", 0, "Minimal required resulting packet size, in KiB")
+		argMaxSize   = flag.Uint64("maxsize", 0, "Maximal allowable resulting packet size, in KiB")
+		viaOverride  = flag.String("via", "", "Override Via path to destination node")
+		wait         = flag.Duration("wait", 0, "Give up waiting for the reply after that time")
+		doToss       = flag.Bool("toss", false, "Toss inbound packets while waiting")
+		waitId       = flag.String("id", "", "Wait for the reply to already sent request")
+		spoolPath    = flag.String("spool", "", "Override path to spool")
+		logPath      = flag.String("log", "", "Override path to logfile")
+		quiet        = flag.Bool("quiet", false, "Print only errors")
+		debug        = flag.Bool("debug", false, "Print debug messages")
+		version      = flag.Bool("version", false, "Print version information")
+		warranty     = flag.Bool("warranty", false, "Print warranty information")
+	)
+	log.SetFlags(log.Lshortfile)
+	flag.Usage = usage
+	flag.Parse()
+	if *warranty {
+		fmt.Println(nncp.Warranty)
+		return
+	}
+	if *version {
+		fmt.Println(nncp.VersionGet())
+		return
+	}
+	if (*waitId == "" && flag.NArg() < 2) || (*waitId != "" && flag.NArg() != 0) {
+		usage()
+		os.Exit(1)
+	}
+	nice, err := nncp.NicenessParse(*niceRaw)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	replyNice, err := nncp.NicenessParse(*replyNiceRaw)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	ctx, err := nncp.CtxFromCmdline(
+		*cfgPath,
+		*spoolPath,
+		*logPath,
+		*quiet,
+		false,
+		true,
+		*debug,
+	)
+	if err != nil {
+		log.Fatalln("Error during initialization:", err)
+	}
+	if ctx.Self == nil {
+		log.Fatalln("Config lacks private keys")
+	}
+	ctx.Umask()
+
+	id := *waitId
+	if id == "" {
+		node, err := ctx.FindNode(flag.Arg(0))
+		if err != nil {
+			log.Fatalln("Invalid NODE specified:", err)
+		}
+		maxSize := int64(nncp.MaxFileSize)
+		if *argMaxSize > 0 {
+			maxSize = int64(*argMaxSize) * 1024
+		}
+		nncp.ViaOverride(*viaOverride, ctx, node)
+		id, err = ctx.TxRexec(
+			node,
+			nice,
+			replyNice,
+			flag.Args()[1],
+			flag.Args()[2:],
+			bufio.NewReaderSize(os.Stdin, nncp.MTHBlockSize),
+			int64(*minSize)*1024,
+			maxSize,
+			*noCompress,
+		)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	var deadline time.Time
+	if *wait > 0 {
+		deadline = time.Now().Add(*wait)
+	}
+	for {
+		if *doToss {
+			for nodeId := range ctx.Neigh {
+				nodeId := nodeId
+				ctx.Toss(&nodeId, nncp.TRx, replyNice,
+					false, false, false, false, false, false, false, false)
+			}
+		}
+		reply, err := ctx.RexecReplyLoad(id)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if reply != nil {
+			os.Stdout.Write(reply.Stdout)
+			os.Stderr.Write(reply.Stderr)
+			if err = ctx.RexecRemove(id); err != nil {
+				log.Fatalln(err)
+			}
+			os.Exit(reply.ExitCode)
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			log.Fatalln("Reply has not arrived in time, wait for it with: -id", id)
+		}
+		time.Sleep(time.Second)
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"log"
 	"os"
 	"sort"
+	"time"
 
 	"github.com/dustin/go-humanize"
 	"go.cypherpunks.ru/nncp/v8"
@@ -32,7 +33,7 @@ import (
 func usage() {
 	fmt.Fprintf(os.Stderr, nncp.UsageHeader())
 	fmt.Fprintf(os.Stderr, "nncp-stat -- show queued Rx/Tx stats\n\n")
-	fmt.Fprintf(os.Stderr, "Usage: %s [options] [-pkt] [-node NODE]\nOptions:\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] [-pkt] [-route] [-node NODE]\nOptions:\n", os.Args[0])
 	flag.PrintDefaults()
 }
 
@@ -46,9 +47,40 @@ func jobPrint(xx nncp.TRxTx, job nncp.Job, suffix string) {
 	)
 }
 
+func quotaFmt(used, limit int64) string {
+	if limit == 0 {
+		return humanize.IBytes(uint64(used))
+	}
+	return fmt.Sprintf(
+		"%s / %s (%d%%)",
+		humanize.IBytes(uint64(used)), humanize.IBytes(uint64(limit)),
+		100*used/limit,
+	)
+}
+
+func quotaPrint(prefix string, q *nncp.SpoolQuota, rx, tx func() (int64, error)) {
+	rxUsed, err := rx()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	txUsed, err := tx()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	policy := nncp.SpoolQuotaPolicyRefuse
+	if q.Evict {
+		policy = nncp.SpoolQuotaPolicyEvict
+	}
+	fmt.Printf(
+		"%squota: Rx: %s | Tx: %s | %s\n",
+		prefix, quotaFmt(rxUsed, q.Rx), quotaFmt(txUsed, q.Tx), policy,
+	)
+}
+
 func main() {
 	var (
 		showPkt   = flag.Bool("pkt", false, "Show packets listing")
+		showRoute = flag.Bool("route", false, "Show routing table")
 		cfgPath   = flag.String("cfg", nncp.DefaultCfgPath, "Path to configuration file")
 		nodeRaw   = flag.String("node", "", "Process only that node")
 		spoolPath = flag.String("spool", "", "Override path to spool")
@@ -81,6 +113,23 @@ func main() {
 		}
 	}
 
+	if *showRoute {
+		for _, route := range ctx.RoutesSorted() {
+			if nodeOnly != nil && *route.Dst != *nodeOnly.Id {
+				continue
+			}
+			age := "direct"
+			if !route.Updated.IsZero() {
+				age = time.Since(route.Updated).Truncate(time.Second).String() + " ago"
+			}
+			fmt.Printf(
+				"%s via %s, cost: %d (%s)\n",
+				ctx.NodeName(route.Dst), ctx.NodeName(route.Via), route.Cost, age,
+			)
+		}
+		return
+	}
+
 	nodeNames := make([]string, 0, len(ctx.Neigh))
 	nodeNameToNode := make(map[string]*nncp.Node, len(ctx.Neigh))
 	for _, node := range ctx.Neigh {
@@ -90,6 +139,13 @@ func main() {
 	sort.Strings(nodeNames)
 
 	ctx.Umask()
+	if ctx.SpoolQuota != nil && nodeOnly == nil {
+		quotaPrint("spool ", ctx.SpoolQuota, func() (int64, error) {
+			return ctx.SpoolUsageTotal(nncp.TRx)
+		}, func() (int64, error) {
+			return ctx.SpoolUsageTotal(nncp.TTx)
+		})
+	}
 	var node *nncp.Node
 	for _, nodeName := range nodeNames {
 		node = nodeNameToNode[nodeName]
@@ -97,6 +153,13 @@ func main() {
 			continue
 		}
 		fmt.Println(node.Name)
+		if node.SpoolQuota != nil {
+			quotaPrint("\t", node.SpoolQuota, func() (int64, error) {
+				return ctx.SpoolUsage(node.Id, nncp.TRx)
+			}, func() (int64, error) {
+				return ctx.SpoolUsage(node.Id, nncp.TTx)
+			})
+		}
 		rxNums := make(map[uint8]int)
 		rxBytes := make(map[uint8]int64)
 		noCKNums := make(map[uint8]int)
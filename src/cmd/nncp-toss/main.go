@@ -24,6 +24,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"go.cypherpunks.ru/nncp/v8"
@@ -44,10 +45,11 @@ func main() {
 		dryRun    = flag.Bool("dryrun", false, "Do not actually write any tossed data")
 		doSeen    = flag.Bool("seen", false, "Create seen/ files")
 		cycle     = flag.Uint("cycle", 0, "Repeat tossing after N seconds in infinite loop")
+		workers   = flag.Int("workers", 0, "Number of concurrently tossed packets, overriding configuration")
 		noFile    = flag.Bool("nofile", false, "Do not process \"file\" packets")
 		noFreq    = flag.Bool("nofreq", false, "Do not process \"freq\" packets")
 		noExec    = flag.Bool("noexec", false, "Do not process \"exec\" packets")
-		noTrns    = flag.Bool("notrns", false, "Do not process \"transitional\" packets")
+		noTrns    = flag.Bool("notrns", false, "Do not process \"transitional\" and \"route\" packets")
 		noArea    = flag.Bool("noarea", false, "Do not process \"area\" packets")
 		noACK     = flag.Bool("noack", false, "Do not process \"ack\" packets")
 		spoolPath = flag.String("spool", "", "Override path to spool")
@@ -99,29 +101,54 @@ func main() {
 		}
 	}
 
+	if *workers > 0 {
+		ctx.TossWorkers = *workers
+	}
+
 	ctx.Umask()
 
+	toss := func(nodeId *nncp.NodeId) bool {
+		isBad := ctx.Toss(
+			nodeId,
+			nncp.TRx,
+			nice,
+			*dryRun, *doSeen, *noFile, *noFreq, *noExec, *noTrns, *noArea, *noACK,
+		)
+		if *nodeId == *ctx.SelfId {
+			isBad = ctx.Toss(
+				nodeId,
+				nncp.TTx,
+				nice,
+				*dryRun, false, true, true, true, true, *noArea, *noACK,
+			) || isBad
+		}
+		return isBad
+	}
+
 	if *cycle == 0 {
 		isBad := false
+		var wg sync.WaitGroup
+		var isBadM sync.Mutex
 		for nodeId, node := range ctx.Neigh {
 			if nodeOnly != nil && nodeId != *nodeOnly.Id {
 				continue
 			}
-			isBad = ctx.Toss(
-				node.Id,
-				nncp.TRx,
-				nice,
-				*dryRun, *doSeen, *noFile, *noFreq, *noExec, *noTrns, *noArea, *noACK,
-			) || isBad
-			if nodeId == *ctx.SelfId {
-				isBad = ctx.Toss(
-					node.Id,
-					nncp.TTx,
-					nice,
-					*dryRun, false, true, true, true, true, *noArea, *noACK,
-				) || isBad
+			if ctx.TossWorkers <= 1 {
+				isBad = toss(node.Id) || isBad
+				continue
 			}
+			// Nodes are tossed concurrently, sharing the workers
+			wg.Add(1)
+			go func(nodeId *nncp.NodeId) {
+				defer wg.Done()
+				if toss(nodeId) {
+					isBadM.Lock()
+					isBad = true
+					isBadM.Unlock()
+				}
+			}(node.Id)
 		}
+		wg.Wait()
 		if isBad {
 			os.Exit(1)
 		}
@@ -142,24 +169,17 @@ func main() {
 		}
 		go func(nodeId *nncp.NodeId) {
 			for range dw.C {
-				nodeIds <- nodeId
+				if ctx.TossWorkers > 1 {
+					// Nodes are tossed concurrently, sharing the
+					// workers, but tosses of the node never overlap
+					toss(nodeId)
+				} else {
+					nodeIds <- nodeId
+				}
 			}
 		}(node.Id)
 	}
 	for nodeId := range nodeIds {
-		ctx.Toss(
-			nodeId,
-			nncp.TRx,
-			nice,
-			*dryRun, *doSeen, *noFile, *noFreq, *noExec, *noTrns, *noArea, *noACK,
-		)
-		if *nodeId == *ctx.SelfId {
-			ctx.Toss(
-				nodeId,
-				nncp.TTx,
-				nice,
-				*dryRun, false, true, true, true, true, *noArea, *noACK,
-			)
-		}
+		toss(nodeId)
 	}
 }
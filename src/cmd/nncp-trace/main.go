@@ -0,0 +1,164 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Trace store-and-forward path to NNCP node.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"go.cypherpunks.ru/nncp/v8"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, nncp.UsageHeader())
+	fmt.Fprintf(os.Stderr, "nncp-trace -- trace store-and-forward path to the node\n\n")
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] [-wait DURATION] NODE\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [options] -show [ID]\n", os.Args[0])
+	fmt.Fprintln(os.Stderr, "Options:")
+	flag.PrintDefaults()
+}
+
+func unixNano(t uint64) time.Time {
+	return time.Unix(0, int64(t))
+}
+
+func tracePrint(ctx *nncp.Ctx, probe *nncp.TraceProbe) {
+	sent := unixNano(probe.Sent)
+	fmt.Printf("Trace %s, sent %s\n", probe.IdString(), sent.Format(time.RFC3339))
+	prev := sent
+	for i, hop := range probe.Hops {
+		sign := "valid"
+		if known, valid := ctx.TraceHopVerify(probe, &hop); !known {
+			sign = "unknown node"
+		} else if !valid {
+			sign = "INVALID"
+		}
+		arrived, tossed := unixNano(hop.Arrived), unixNano(hop.Tossed)
+		fmt.Printf(
+			"\t%d. %s: arrived %s (+%s), tossed %s (+%s), signature: %s\n",
+			i+1, ctx.NodeName(&hop.Node),
+			arrived.Format(time.RFC3339),
+			arrived.Sub(prev).Truncate(time.Second),
+			tossed.Format(time.RFC3339),
+			tossed.Sub(arrived).Truncate(time.Second),
+			sign,
+		)
+		prev = tossed
+	}
+}
+
+func main() {
+	var (
+		cfgPath   = flag.String("cfg", nncp.DefaultCfgPath, "Path to configuration file")
+		niceRaw   = flag.String("nice", nncp.NicenessFmt(nncp.DefaultNiceFreq), "Outbound packet niceness")
+		wait      = flag.Duration("wait", 0, "Wait for the trace to return")
+		doShow    = flag.Bool("show", false, "Show returned traces")
+		spoolPath = flag.String("spool", "", "Override path to spool")
+		logPath   = flag.String("log", "", "Override path to logfile")
+		quiet     = flag.Bool("quiet", false, "Print only errors")
+		debug     = flag.Bool("debug", false, "Print debug messages")
+		version   = flag.Bool("version", false, "Print version information")
+		warranty  = flag.Bool("warranty", false, "Print warranty information")
+	)
+	log.SetFlags(log.Lshortfile)
+	flag.Usage = usage
+	flag.Parse()
+	if *warranty {
+		fmt.Println(nncp.Warranty)
+		return
+	}
+	if *version {
+		fmt.Println(nncp.VersionGet())
+		return
+	}
+	if (!*doShow && flag.NArg() != 1) || flag.NArg() > 1 {
+		usage()
+		os.Exit(1)
+	}
+	nice, err := nncp.NicenessParse(*niceRaw)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	ctx, err := nncp.CtxFromCmdline(
+		*cfgPath,
+		*spoolPath,
+		*logPath,
+		*quiet,
+		false,
+		false,
+		*debug,
+	)
+	if err != nil {
+		log.Fatalln("Error during initialization:", err)
+	}
+	if ctx.Self == nil {
+		log.Fatalln("Config lacks private keys")
+	}
+
+	if *doShow {
+		var ids []string
+		if flag.NArg() == 1 {
+			ids = []string{flag.Arg(0)}
+		} else if ids, err = ctx.Traces(); err != nil {
+			log.Fatalln(err)
+		}
+		for _, id := range ids {
+			probe, err := ctx.TraceLoad(id)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			if probe == nil {
+				log.Fatalln("Trace has not returned yet:", id)
+			}
+			tracePrint(ctx, probe)
+		}
+		return
+	}
+
+	node, err := ctx.FindNode(flag.Arg(0))
+	if err != nil {
+		log.Fatalln("Invalid NODE specified:", err)
+	}
+	ctx.Umask()
+	probe, err := ctx.TxTrace(node, nice)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	fmt.Println(probe.IdString())
+	if *wait == 0 {
+		return
+	}
+	deadline := time.Now().Add(*wait)
+	for time.Now().Before(deadline) {
+		returned, err := ctx.TraceLoad(probe.IdString())
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if returned != nil {
+			tracePrint(ctx, returned)
+			return
+		}
+		time.Sleep(time.Second)
+	}
+	log.Fatalln("Trace has not returned in time")
+}
@@ -279,11 +279,20 @@ func main() {
 					humanize.IBytes(uint64(fiInt.Size())),
 				)
 			}
-			if !ctx.IsEnoughSpace(fiInt.Size()) {
+			enough, err := ctx.IsEnoughSpace(fiInt.Size())
+			if err != nil {
+				log.Fatalln("Can not stat spool:", err)
+			}
+			if !enough {
 				ctx.LogE("xfer-rx", les, errors.New("is not enough space"), logMsg)
 				fd.Close()
 				continue
 			}
+			if !ctx.SpoolQuotaRxAllows(nodeId, fiInt.Size()) {
+				ctx.LogE("xfer-rx", les, nncp.SpoolQuotaExceeded, logMsg)
+				fd.Close()
+				continue
+			}
 			if _, err = fd.Seek(0, io.SeekStart); err != nil {
 				log.Fatalln(err)
 			}
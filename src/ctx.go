@@ -20,13 +20,18 @@ package nncp
 import (
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"syscall"
+
+	"github.com/klauspost/compress/zstd"
 )
 
 type Ctx struct {
@@ -38,8 +43,16 @@ type Ctx struct {
 	AreaId2Area map[AreaId]*Area
 	AreaName2Id map[string]*AreaId
 
-	Spool      string
-	LogPath    string
+	Spool     string
+	LogPath   string
+	LogWriter io.Writer
+	// Write debug messages there instead of stderr
+	DebugWriter io.Writer
+
+	// Events journal and socket to stream it, if enabled
+	EventsJournal string
+	EventsSocket  string
+
 	UmaskForce *int
 	Quiet      bool
 	ShowPrgrs  bool
@@ -48,11 +61,39 @@ type Ctx struct {
 	NotifyFile *FromToJSON
 	NotifyFreq *FromToJSON
 	NotifyExec map[string]*FromToJSON
+	Notifiers  []*Notifier
 
 	MCDRxIfis []string
 	MCDTxIfis map[string]int
 
 	YggdrasilAliases map[string]string
+
+	RouteInterval time.Duration
+	RouteMaxAge   time.Duration
+	RouteNice     uint8
+
+	Quarantine *Quarantine
+	SpoolQuota *SpoolQuota
+
+	// Number of concurrently tossed packets, shared by all Toss calls
+	TossWorkers  int
+	tossPool     chan *zstd.Decoder
+	tossPoolOnce sync.Once
+	areaLocks    sync.Map
+	execFuncs    sync.Map
+	transitLock  sync.Mutex
+	freqLock     sync.Mutex
+	routeLock    sync.Mutex
+	routeTable   map[NodeId]*Route
+	routeStamps  map[NodeId]time.Time
+	routeExpire  time.Time
+	subs         map[*notifySub]struct{}
+	subsLock     sync.Mutex
+
+	// Wait for it to be sure that all received packets are checksummed
+	SPCheckerWg    sync.WaitGroup
+	spCheckerTasks chan SPCheckerTask
+	spCheckerOnce  sync.Once
 }
 
 func (ctx *Ctx) FindNode(id string) (*Node, error) {
@@ -97,6 +138,22 @@ func (ctx *Ctx) ensureRxDir(nodeId *NodeId) error {
 	return err
 }
 
+// Load either Hjson configuration file or configuration directory.
+func CfgLoad(cfgPath string) (*CfgJSON, error) {
+	fi, err := os.Stat(cfgPath)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return DirToCfg(cfgPath)
+	}
+	cfgRaw, err := ioutil.ReadFile(cfgPath)
+	if err != nil {
+		return nil, err
+	}
+	return CfgParse(cfgRaw)
+}
+
 func CtxFromCmdline(
 	cfgPath, spoolPath, logPath string,
 	quiet, showPrgrs, omitPrgrs, debug bool,
@@ -108,26 +165,10 @@ func CtxFromCmdline(
 	if showPrgrs && omitPrgrs {
 		return nil, errors.New("simultaneous -progress and -noprogress")
 	}
-	fi, err := os.Stat(cfgPath)
+	cfg, err := CfgLoad(cfgPath)
 	if err != nil {
 		return nil, err
 	}
-	var cfg *CfgJSON
-	if fi.IsDir() {
-		cfg, err = DirToCfg(cfgPath)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		cfgRaw, err := ioutil.ReadFile(cfgPath)
-		if err != nil {
-			return nil, err
-		}
-		cfg, err = CfgParse(cfgRaw)
-		if err != nil {
-			return nil, err
-		}
-	}
 	ctx, err := Cfg2Ctx(cfg)
 	if err != nil {
 		return nil, err
@@ -140,6 +181,11 @@ func CtxFromCmdline(
 	} else {
 		ctx.Spool = spoolPath
 	}
+	if ctx.Spool != cfg.Spool {
+		if err = ctx.areasSubsLoad(); err != nil {
+			return nil, err
+		}
+	}
 	if logPath == "" {
 		env = os.Getenv(CfgLogEnv)
 		if env != "" {
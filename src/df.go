@@ -3,16 +3,12 @@
 
 package nncp
 
-import (
-	"log"
+import "golang.org/x/sys/unix"
 
-	"golang.org/x/sys/unix"
-)
-
-func (ctx *Ctx) IsEnoughSpace(want int64) bool {
+func (ctx *Ctx) IsEnoughSpace(want int64) (bool, error) {
 	var s unix.Statfs_t
 	if err := unix.Statfs(ctx.Spool, &s); err != nil {
-		log.Fatalln("Can not stat spool:", err)
+		return false, err
 	}
-	return int64(s.Bavail)*int64(s.Bsize) > want
+	return int64(s.Bavail)*int64(s.Bsize) > want, nil
 }
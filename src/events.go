@@ -0,0 +1,360 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	EventQueued          = "queued"
+	EventOffered         = "offered"
+	EventSent            = "sent"
+	EventReceived        = "received"
+	EventTossed          = "tossed"
+	EventFailed          = "failed"
+	EventSessionStarted  = "session-started"
+	EventSessionFinished = "session-finished"
+	EventLog             = "log"
+
+	EventsLockSuffix = ".lock"
+	EventsSockSuffix = ".sock"
+
+	// Sequence number in the lock file is zero-padded, so it is
+	// overwritten in place
+	eventsSeqWidth = 20
+)
+
+// Event types of informational log records, other ones have EventLog
+// type. Error records are EventFailed.
+var eventTypes = map[string]string{
+	"tx":            EventQueued,
+	"tx-area":       EventQueued,
+	"sp-infos-tx":   EventOffered,
+	"sp-done":       EventSent,
+	"sp-file-done":  EventReceived,
+	"rx":            EventTossed,
+	"call-started":  EventSessionStarted,
+	"call-finished": EventSessionFinished,
+}
+
+// Log record as it is written to the events journal and streamed to
+// the subscribers, one JSON object per line.
+type Event struct {
+	Seq    uint64                 `json:"seq"`
+	When   time.Time              `json:"when"`
+	Type   string                 `json:"type"`
+	Who    string                 `json:"who"`
+	Fields map[string]interface{} `json:"fields"`
+}
+
+func eventFields(les LEs) map[string]interface{} {
+	fields := make(map[string]interface{}, len(les))
+	for _, le := range les {
+		switch v := le.V.(type) {
+		case int, int8, uint8, int64, uint64, bool:
+			fields[le.K] = v
+		case []string:
+			if len(v) > 0 {
+				fields[le.K] = v
+			}
+		default:
+			fields[le.K] = fmt.Sprintf("%s", v)
+		}
+	}
+	return fields
+}
+
+// Append log record to the events journal, assigning it the next
+// sequence number. Journal is locked, so many processes can write to
+// it. Failures are not logged as usual, as
+// they would be journalled again.
+func (ctx *Ctx) eventWrite(who string, les LEs, isErr bool) {
+	if ctx.EventsJournal == "" {
+		return
+	}
+	typ := EventLog
+	if isErr {
+		typ = EventFailed
+	} else if t, exists := eventTypes[who]; exists {
+		typ = t
+	}
+	ev := Event{
+		When:   time.Now().UTC(),
+		Type:   typ,
+		Who:    who,
+		Fields: eventFields(les),
+	}
+	if err := ctx.eventAppend(&ev); err != nil {
+		ctx.LogD("events-write", LEs{{"Who", who}}, func(les LEs) string {
+			return "Can not write events journal: " + err.Error()
+		})
+	}
+}
+
+// Sequence number is taken from the journal's last record. Lock file
+// also keeps the last one, without syncing it, so numbering continues
+// after journal's rotation. Journal is not synced too, like the log.
+func (ctx *Ctx) eventAppend(ev *Event) error {
+	fdLock, err := os.OpenFile(
+		ctx.EventsJournal+EventsLockSuffix,
+		os.O_CREATE|os.O_RDWR,
+		os.FileMode(0666),
+	)
+	if err != nil {
+		return err
+	}
+	defer fdLock.Close()
+	fdLockFd := int(fdLock.Fd())
+	if err = unix.Flock(fdLockFd, unix.LOCK_EX); err != nil {
+		return err
+	}
+	defer unix.Flock(fdLockFd, unix.LOCK_UN)
+	ev.Seq, err = eventsJournalLastSeq(ctx.EventsJournal)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, eventsSeqWidth+1)
+	if n, _ := fdLock.ReadAt(buf, 0); n == len(buf) {
+		if seq, err := strconv.ParseUint(
+			string(buf[:eventsSeqWidth]), 10, 64,
+		); err == nil && seq > ev.Seq {
+			ev.Seq = seq
+		}
+	}
+	ev.Seq++
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	fd, err := os.OpenFile(
+		ctx.EventsJournal,
+		os.O_CREATE|os.O_WRONLY|os.O_APPEND,
+		os.FileMode(0666),
+	)
+	if err != nil {
+		return err
+	}
+	_, err = fd.Write(append(data, '\n'))
+	fd.Close()
+	if err != nil {
+		return err
+	}
+	_, err = fdLock.WriteAt(
+		[]byte(fmt.Sprintf("%0*d\n", eventsSeqWidth, ev.Seq)), 0,
+	)
+	return err
+}
+
+// Sequence number of the last complete event in the journal, zero if it
+// is empty, absent or its last record is malformed.
+func eventsJournalLastSeq(path string) (uint64, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer fd.Close()
+	fi, err := fd.Stat()
+	if err != nil {
+		return 0, err
+	}
+	// Read backwards until the beginning of the last line is found
+	end := fi.Size()
+	var tail []byte
+	chunk := make([]byte, 1<<12)
+	for off := end; off > 0; {
+		n := int64(len(chunk))
+		if off < n {
+			n = off
+		}
+		off -= n
+		if _, err = fd.ReadAt(chunk[:n], off); err != nil {
+			return 0, err
+		}
+		tail = append(append([]byte{}, chunk[:n]...), tail...)
+		trimmed := bytes.TrimRight(tail, "\n")
+		if i := bytes.LastIndexByte(trimmed, '\n'); i != -1 {
+			tail = trimmed[i+1:]
+			break
+		}
+	}
+	var ev struct {
+		Seq uint64 `json:"seq"`
+	}
+	if json.Unmarshal(tail, &ev) != nil {
+		return 0, nil
+	}
+	return ev.Seq, nil
+}
+
+// Write journal's events with sequence number greater than since to w,
+// following the journal until writing fails or done is closed. Journal
+// is reopened from the beginning if it is rotated.
+func (ctx *Ctx) EventsFollow(w io.Writer, since uint64, done <-chan struct{}) error {
+	var fd *os.File
+	var br *bufio.Reader
+	defer func() {
+		if fd != nil {
+			fd.Close()
+		}
+	}()
+	var line []byte
+	// Read and emit all complete lines, returning io.EOF at the end
+	readLines := func() error {
+		for {
+			chunk, err := br.ReadBytes('\n')
+			line = append(line, chunk...)
+			if err != nil {
+				return err
+			}
+			var ev struct {
+				Seq uint64 `json:"seq"`
+			}
+			if json.Unmarshal(line, &ev) == nil && ev.Seq > since {
+				if _, err = w.Write(line); err != nil {
+					return err
+				}
+				since = ev.Seq
+			}
+			line = line[:0]
+		}
+	}
+	for {
+		select {
+		case <-done:
+			return nil
+		default:
+		}
+		if fd == nil {
+			var err error
+			fd, err = os.Open(ctx.EventsJournal)
+			if err != nil {
+				if !os.IsNotExist(err) {
+					return err
+				}
+				time.Sleep(time.Second)
+				continue
+			}
+			br = bufio.NewReader(fd)
+		}
+		if err := readLines(); err != io.EOF {
+			return err
+		}
+		time.Sleep(time.Second)
+		fi, err := os.Stat(ctx.EventsJournal)
+		if err != nil {
+			continue
+		}
+		fiOur, err := fd.Stat()
+		if err != nil {
+			return err
+		}
+		if !os.SameFile(fi, fiOur) {
+			// Journal is rotated: finish the old one first
+			if err = readLines(); err != io.EOF {
+				return err
+			}
+			line = line[:0]
+			fd.Close()
+			fd = nil
+		}
+	}
+}
+
+// Serve events over the listener. Client sends decimal sequence number
+// of the last seen event (0 for the whole journal), followed by newline,
+// and then receives events as JSON lines.
+func (ctx *Ctx) EventsServe(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			les := LEs{{"Addr", conn.RemoteAddr()}}
+			br := bufio.NewReader(io.LimitReader(conn, 32))
+			sinceRaw, err := br.ReadString('\n')
+			if err != nil {
+				ctx.LogD("events-since", les, func(les LEs) string {
+					return "Events subscriber did not send sequence number"
+				})
+				return
+			}
+			since, err := strconv.ParseUint(strings.TrimSpace(sinceRaw), 10, 64)
+			if err != nil {
+				fmt.Fprintln(conn, `{"error": "invalid sequence number"}`)
+				return
+			}
+			done := make(chan struct{})
+			go func() {
+				// Subscriber sends nothing more, so reading fails
+				// only when it disconnects
+				io.Copy(ioutil.Discard, conn)
+				close(done)
+			}()
+			if err = ctx.EventsFollow(
+				eventsConnWriter{conn}, since, done,
+			); err != nil {
+				ctx.LogD("events-follow", les, func(les LEs) string {
+					return "Streaming events: " + err.Error()
+				})
+			}
+		}()
+	}
+}
+
+type eventsConnWriter struct {
+	conn net.Conn
+}
+
+func (w eventsConnWriter) Write(p []byte) (int, error) {
+	w.conn.SetWriteDeadline(time.Now().Add(DefaultDeadline))
+	return w.conn.Write(p)
+}
+
+// Listen on events socket, removing the stale one.
+func (ctx *Ctx) EventsListen() (net.Listener, error) {
+	if ctx.EventsSocket == "" {
+		return nil, errors.New("events are not configured")
+	}
+	if conn, err := net.Dial("unix", ctx.EventsSocket); err == nil {
+		conn.Close()
+		return nil, errors.New("events socket is already served")
+	}
+	if err := os.Remove(ctx.EventsSocket); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return net.Listen("unix", ctx.EventsSocket)
+}
@@ -0,0 +1,133 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEvents(t *testing.T) {
+	spool, err := ioutil.TempDir("", "testevents")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(spool)
+	ctx := Ctx{
+		Spool:         spool,
+		LogPath:       filepath.Join(spool, "log.log"),
+		EventsJournal: filepath.Join(spool, "events"),
+		EventsSocket:  filepath.Join(spool, "events"+EventsSockSuffix),
+		Debug:         TDebug,
+		Quiet:         true,
+	}
+	ctx.LogI("tx", LEs{{"Pkt", "PKT"}, {"Size", int64(123)}}, func(les LEs) string {
+		return "sent"
+	})
+	ctx.LogE("rx", LEs{{"Pkt", "PKT"}}, errors.New("broken"), func(les LEs) string {
+		return "failed"
+	})
+	ctx.LogI("something", nil, func(les LEs) string { return "" })
+
+	ln, err := ctx.EventsListen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go ctx.EventsServe(ln)
+	if _, err = ctx.EventsListen(); err == nil {
+		t.Fatal("socket is served twice")
+	}
+	conn, err := net.Dial("unix", ctx.EventsSocket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintln(conn, 1)
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	br := bufio.NewReader(conn)
+	read := func() Event {
+		line, err := br.ReadBytes('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ev Event
+		if err = json.Unmarshal(line, &ev); err != nil {
+			t.Fatal(err)
+		}
+		return ev
+	}
+	ev := read()
+	if ev.Seq != 2 || ev.Type != EventFailed || ev.Who != "rx" ||
+		ev.Fields["Pkt"] != "PKT" || ev.Fields["Err"] != "broken" {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+	if ev = read(); ev.Seq != 3 || ev.Type != EventLog {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+
+	// Following continues after journal's rotation
+	if err = os.Rename(
+		ctx.EventsJournal, ctx.EventsJournal+".old",
+	); err != nil {
+		t.Fatal(err)
+	}
+	ctx.LogI("call-started", nil, func(les LEs) string { return "" })
+	if ev = read(); ev.Seq != 4 || ev.Type != EventSessionStarted {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+
+	// Lost or lagging sequence in the lock file is recovered from the
+	// journal
+	lockPath := ctx.EventsJournal + EventsLockSuffix
+	if err = os.Remove(lockPath); err != nil {
+		t.Fatal(err)
+	}
+	ctx.LogI("call-finished", nil, func(les LEs) string { return "" })
+	if ev = read(); ev.Seq != 5 {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+	if err = ioutil.WriteFile(lockPath, []byte("garbage"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	ctx.LogI("call-finished", nil, func(les LEs) string { return "" })
+	if ev = read(); ev.Seq != 6 {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+	if err = ioutil.WriteFile(
+		lockPath, []byte(fmt.Sprintf("%020d\n", 2)), 0666,
+	); err != nil {
+		t.Fatal(err)
+	}
+	ctx.LogI("call-finished", nil, func(les LEs) string { return "" })
+	if ev = read(); ev.Seq != 7 {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+	data, err := ioutil.ReadFile(lockPath)
+	if err != nil || string(data) != fmt.Sprintf("%020d\n", 7) {
+		t.Fatalf("unexpected sequence: %q", data)
+	}
+}
@@ -0,0 +1,228 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Policy used to handle packets when exec handle has none.
+	ExecPolicyDefault = "*"
+
+	ExecReplyFile  = "file"
+	ExecReplyExec  = "exec"
+	ExecReplyRexec = "rexec"
+)
+
+var (
+	ExecTimeout       = errors.New("exec handler timed out")
+	ExecOutputTooBig  = errors.New("exec handler output is too big")
+	ExecRlimitCPU     = errors.New("exec handler exceeded CPU time limit")
+	ExecRlimitFSize   = errors.New("exec handler exceeded file size limit")
+	ExecUnsupportedNS = errors.New("namespaces are unsupported on that platform")
+	ExecUnsupportedRL = errors.New("rlimits are unsupported on that platform")
+
+	ExecRlimitNames = []string{"as", "core", "cpu", "data", "fsize", "nofile", "nproc", "stack"}
+	ExecNamespaces  = []string{"ipc", "mount", "net", "pid", "user", "uts"}
+)
+
+// Restrictions applied to the exec handler's process.
+type ExecPolicy struct {
+	Timeout   time.Duration
+	MaxOutput int64
+	Dir       string
+
+	// Names of our environment variables passed through to the handler
+	Env []string
+
+	Rlimits    map[string]uint64
+	Uid        *uint32
+	Gid        *uint32
+	Groups     []uint32
+	Namespaces []string
+
+	// Send handler's stdout back to the sender as a file, exec or
+	// nncp-rexec reply
+	Reply       string
+	ReplyHandle string
+}
+
+// Returns nil if handle has no policy.
+func (node *Node) ExecPolicyFind(handle string) *ExecPolicy {
+	if policy := node.ExecPolicy[handle]; policy != nil {
+		return policy
+	}
+	return node.ExecPolicy[ExecPolicyDefault]
+}
+
+func execIsLimitErr(err error) bool {
+	switch err {
+	case ExecTimeout, ExecOutputTooBig, ExecRlimitCPU, ExecRlimitFSize:
+		return true
+	}
+	return false
+}
+
+type execLimitWriter struct {
+	w        io.Writer
+	left     int64
+	exceeded func()
+}
+
+func (w *execLimitWriter) Write(p []byte) (int, error) {
+	if w.left >= 0 && int64(len(p)) > w.left {
+		p = p[:w.left]
+		n, _ := w.w.Write(p)
+		w.left = 0
+		w.exceeded()
+		return n, ExecOutputTooBig
+	}
+	n, err := w.w.Write(p)
+	if w.left >= 0 {
+		w.left -= int64(n)
+	}
+	return n, err
+}
+
+// Run exec handler's command with policy applied and return its
+// combined output. If command's stdout is already set, then only stderr
+// is returned. Policy violations are returned as distinct errors.
+func execRun(cmd *exec.Cmd, policy *ExecPolicy) ([]byte, error) {
+	if policy == nil {
+		return cmd.CombinedOutput()
+	}
+	cmd.Dir = policy.Dir
+	for _, k := range policy.Env {
+		if v, exists := os.LookupEnv(k); exists {
+			cmd.Env = append(cmd.Env, k+"="+v)
+		}
+	}
+	var err error
+	if cmd.SysProcAttr, err = execSysProcAttr(policy); err != nil {
+		return nil, err
+	}
+	if policy.Uid != nil || policy.Gid != nil {
+		// Our supplementary groups are dropped too
+		cred := syscall.Credential{
+			Uid:    uint32(os.Getuid()),
+			Gid:    uint32(os.Getgid()),
+			Groups: append([]uint32{}, policy.Groups...),
+		}
+		if policy.Uid != nil {
+			cred.Uid = *policy.Uid
+		}
+		if policy.Gid != nil {
+			cred.Gid = *policy.Gid
+		}
+		cmd.SysProcAttr.Credential = &cred
+	}
+	var limitErr error
+	var limitOnce sync.Once
+	kill := func(err error) {
+		limitOnce.Do(func() {
+			limitErr = err
+			// Whole process group is killed
+			unix.Kill(-cmd.Process.Pid, unix.SIGKILL)
+		})
+	}
+	limit := int64(-1)
+	if policy.MaxOutput > 0 {
+		limit = policy.MaxOutput
+	}
+	exceeded := func() { kill(ExecOutputTooBig) }
+	var out bytes.Buffer
+	cmd.Stderr = &execLimitWriter{w: &out, left: limit, exceeded: exceeded}
+	if cmd.Stdout == nil {
+		cmd.Stdout = cmd.Stderr
+	} else {
+		cmd.Stdout = &execLimitWriter{w: cmd.Stdout, left: limit, exceeded: exceeded}
+	}
+	if err = execRlimitsWrap(cmd, policy.Rlimits); err != nil {
+		return nil, fmt.Errorf("setting rlimits: %w", err)
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	if policy.Timeout > 0 {
+		t := time.AfterFunc(policy.Timeout, func() { kill(ExecTimeout) })
+		defer t.Stop()
+	}
+	err = cmd.Wait()
+	// Synchronize with possible killing, preventing it after the exit
+	limitOnce.Do(func() {})
+	if limitErr != nil {
+		return out.Bytes(), limitErr
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			switch ws.Signal() {
+			case syscall.SIGXCPU:
+				err = ExecRlimitCPU
+			case syscall.SIGXFSZ:
+				err = ExecRlimitFSize
+			}
+		}
+	}
+	return out.Bytes(), err
+}
+
+// Send handler's stdout back to the sender, according to the reply
+// mode. Exit code is passed in the file name, or as the exec argument.
+func (ctx *Ctx) execReply(
+	sender *Node,
+	handle, pktName string,
+	nice uint8,
+	exitCode int,
+	policy *ExecPolicy,
+	stdout *os.File,
+) error {
+	node := ctx.Neigh[*sender.Id]
+	if node == nil {
+		return errors.New("reply to unknown node")
+	}
+	if _, err := stdout.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	switch policy.Reply {
+	case ExecReplyFile:
+		return ctx.TxFile(
+			node, nice, stdout.Name(),
+			fmt.Sprintf("%s.%s.%d", handle, pktName, exitCode),
+			0, 0, MaxFileSize, nil,
+		)
+	case ExecReplyExec:
+		return ctx.TxExec(
+			node, nice, nice, policy.ReplyHandle,
+			[]string{handle, pktName, strconv.Itoa(exitCode)},
+			stdout, 0, MaxFileSize, false, nil,
+		)
+	}
+	return errors.New("unknown reply mode: " + policy.Reply)
+}
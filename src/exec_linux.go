@@ -0,0 +1,165 @@
+//go:build linux
+// +build linux
+
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Limits passed to our executable, started before the handler
+const execRlimitsEnv = "NNCP_EXEC_RLIMITS"
+
+var execRlimitResources = map[string]int{
+	"as":     unix.RLIMIT_AS,
+	"core":   unix.RLIMIT_CORE,
+	"cpu":    unix.RLIMIT_CPU,
+	"data":   unix.RLIMIT_DATA,
+	"fsize":  unix.RLIMIT_FSIZE,
+	"nofile": unix.RLIMIT_NOFILE,
+	"nproc":  unix.RLIMIT_NPROC,
+	"stack":  unix.RLIMIT_STACK,
+}
+
+var execCloneFlags = map[string]uintptr{
+	"ipc":   syscall.CLONE_NEWIPC,
+	"mount": syscall.CLONE_NEWNS,
+	"net":   syscall.CLONE_NEWNET,
+	"pid":   syscall.CLONE_NEWPID,
+	"user":  syscall.CLONE_NEWUSER,
+	"uts":   syscall.CLONE_NEWUTS,
+}
+
+func execSysProcAttr(policy *ExecPolicy) (*syscall.SysProcAttr, error) {
+	attr := syscall.SysProcAttr{Setpgid: true, Pdeathsig: syscall.SIGKILL}
+	for _, ns := range policy.Namespaces {
+		flag, known := execCloneFlags[ns]
+		if !known {
+			return nil, errors.New("unknown namespace: " + ns)
+		}
+		attr.Cloneflags |= flag
+		if ns == "user" {
+			// We become root inside it, keeping our privileges outside
+			attr.UidMappings = []syscall.SysProcIDMap{
+				{ContainerID: 0, HostID: os.Getuid(), Size: 1},
+			}
+			attr.GidMappings = []syscall.SysProcIDMap{
+				{ContainerID: 0, HostID: os.Getgid(), Size: 1},
+			}
+		}
+	}
+	return &attr, nil
+}
+
+// Limits are set by our own executable, started instead of the handler
+// with the limits passed through the environment. It sets them on
+// itself and executes the handler, so they are already in effect when
+// handler starts.
+func execRlimitsWrap(cmd *exec.Cmd, rlimits map[string]uint64) error {
+	if len(rlimits) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(rlimits))
+	for name := range rlimits {
+		if _, known := execRlimitResources[name]; !known {
+			return errors.New("unknown rlimit: " + name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	spec := make([]string, 0, len(names))
+	for _, name := range names {
+		spec = append(spec, name+"="+strconv.FormatUint(rlimits[name], 10))
+	}
+	if filepath.Base(cmd.Path) == cmd.Path {
+		// Not found in PATH, so report it now, as Start does
+		if _, err := exec.LookPath(cmd.Path); err != nil {
+			return err
+		}
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, execRlimitsEnv+"="+strings.Join(spec, ","))
+	cmd.Args = append([]string{self, cmd.Path}, cmd.Args...)
+	cmd.Path = self
+	return nil
+}
+
+func execRlimitsApply(spec string) error {
+	for _, nameValue := range strings.Split(spec, ",") {
+		i := strings.IndexByte(nameValue, '=')
+		if i == -1 {
+			return errors.New("invalid rlimit: " + nameValue)
+		}
+		resource, known := execRlimitResources[nameValue[:i]]
+		if !known {
+			return errors.New("unknown rlimit: " + nameValue[:i])
+		}
+		v, err := strconv.ParseUint(nameValue[i+1:], 10, 64)
+		if err != nil {
+			return err
+		}
+		rlimit := syscall.Rlimit{Cur: v, Max: v}
+		if resource == unix.RLIMIT_CPU {
+			// Otherwise handler is killed without SIGXCPU
+			rlimit.Max++
+		}
+		if err = syscall.Setrlimit(resource, &rlimit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Our executable started by execRlimitsWrap sets the limits and becomes
+// the handler, with its path and arguments following ours.
+func init() {
+	spec, exists := os.LookupEnv(execRlimitsEnv)
+	if !exists {
+		return
+	}
+	os.Unsetenv(execRlimitsEnv)
+	if len(os.Args) < 3 {
+		fmt.Fprintln(os.Stderr, "nncp: no handler to execute")
+		os.Exit(126)
+	}
+	if err := execRlimitsApply(spec); err != nil {
+		fmt.Fprintln(os.Stderr, "nncp: setting rlimits:", err)
+		os.Exit(126)
+	}
+	err := syscall.Exec(os.Args[1], os.Args[2:], os.Environ())
+	fmt.Fprintln(os.Stderr, "nncp: executing handler:", err)
+	os.Exit(127)
+}
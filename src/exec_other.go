@@ -0,0 +1,40 @@
+//go:build !linux
+// +build !linux
+
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+func execSysProcAttr(policy *ExecPolicy) (*syscall.SysProcAttr, error) {
+	if len(policy.Namespaces) > 0 {
+		return nil, ExecUnsupportedNS
+	}
+	return &syscall.SysProcAttr{Setpgid: true}, nil
+}
+
+func execRlimitsWrap(cmd *exec.Cmd, rlimits map[string]uint64) error {
+	if len(rlimits) > 0 {
+		return ExecUnsupportedRL
+	}
+	return nil
+}
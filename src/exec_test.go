@@ -0,0 +1,131 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecRun(t *testing.T) {
+	sh := func(script string) *exec.Cmd {
+		cmd := exec.Command("/bin/sh", "-c", script)
+		cmd.Env = []string{"NNCP_SELF=self"}
+		return cmd
+	}
+	started := time.Now()
+	if _, err := execRun(
+		sh("sleep 10"), &ExecPolicy{Timeout: 100 * time.Millisecond},
+	); err != ExecTimeout {
+		t.Fatal("no timeout:", err)
+	}
+	if time.Since(started) > 5*time.Second {
+		t.Fatal("handler is not killed")
+	}
+
+	output, err := execRun(
+		sh("while :; do echo garbage; done"), &ExecPolicy{MaxOutput: 1024},
+	)
+	if err != ExecOutputTooBig {
+		t.Fatal("output is not limited:", err)
+	}
+	if len(output) != 1024 {
+		t.Fatal("unexpected captured output size:", len(output))
+	}
+
+	os.Setenv("NNCP_TEST_PASS", "passed")
+	os.Setenv("NNCP_TEST_HIDE", "hidden")
+	defer os.Unsetenv("NNCP_TEST_PASS")
+	defer os.Unsetenv("NNCP_TEST_HIDE")
+	output, err = execRun(
+		sh("pwd ; echo $NNCP_SELF $NNCP_TEST_PASS $NNCP_TEST_HIDE"),
+		&ExecPolicy{Dir: "/", Env: []string{"NNCP_TEST_PASS"}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(output)) != "/\nself passed" {
+		t.Fatalf("unexpected output: %q", output)
+	}
+
+	if _, err = execRun(sh("exit 1"), &ExecPolicy{}); err == nil || execIsLimitErr(err) {
+		t.Fatal("failure is not reported:", err)
+	}
+}
+
+func TestExecRunRlimits(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("rlimits are supported only on Linux")
+	}
+	// Limit must be in effect already when handler starts
+	output, err := execRun(
+		exec.Command("/bin/sh", "-c", "ulimit -n"),
+		&ExecPolicy{Rlimits: map[string]uint64{"nofile": 17}},
+	)
+	if err != nil {
+		t.Fatal(err, string(output))
+	}
+	if strings.TrimSpace(string(output)) != "17" {
+		t.Fatalf("limit is not set: %q", output)
+	}
+	if _, err = execRun(
+		exec.Command("/bin/sh", "-c", "while :; do :; done"),
+		&ExecPolicy{Rlimits: map[string]uint64{"cpu": 1}},
+	); err != ExecRlimitCPU {
+		t.Fatal("CPU time is not limited:", err)
+	}
+	if _, err = execRun(
+		exec.Command("/bin/sh", "-c", "true"),
+		&ExecPolicy{Rlimits: map[string]uint64{"unknown": 1}},
+	); err == nil {
+		t.Fatal("unknown rlimit is accepted")
+	}
+	if _, err = execRun(
+		exec.Command("nncp-nonexistent-handler"),
+		&ExecPolicy{Rlimits: map[string]uint64{"nofile": 17}},
+	); err == nil {
+		t.Fatal("nonexistent handler is started")
+	}
+}
+
+func TestExecRunCredential(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("switching credentials requires root")
+	}
+	uid, gid := uint32(65534), uint32(65534)
+	for _, v := range []struct {
+		policy   *ExecPolicy
+		expected string
+	}{
+		{&ExecPolicy{Uid: &uid, Gid: &gid}, "65534"},
+		{&ExecPolicy{Gid: &gid}, "65534"},
+		{&ExecPolicy{Uid: &uid, Gid: &gid, Groups: []uint32{12345, 23456}}, "65534 12345 23456"},
+	} {
+		output, err := execRun(exec.Command("id", "-G"), v.policy)
+		if err != nil {
+			t.Fatal(err, string(output))
+		}
+		if strings.TrimSpace(string(output)) != v.expected {
+			t.Fatalf("unexpected groups: %q", output)
+		}
+	}
+}
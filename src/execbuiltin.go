@@ -0,0 +1,206 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+var ExecBuiltinDenied = errors.New("built-in handle is allowed only for known neighbours")
+
+// Built-in handler of the exec packet, addressed to one of the reserved
+// handles. It logs its failures itself and returns log entries extended
+// with the processing results. logMsg describes the packet.
+type execBuiltin func(
+	ctx *Ctx,
+	req *ExecRequest,
+	pktName string,
+	les LEs,
+	logMsg func(LEs) string,
+) (LEs, error)
+
+// Reserved handles, processed by NNCP itself. They take precedence over
+// configured exec handles and in-process handlers.
+var execBuiltins = map[string]execBuiltin{
+	AreaBackfillHandle: execBuiltinAreaBackfill,
+	EvictedHandle:      execBuiltinEvicted,
+	AreaRekeyHandle:    execBuiltinAreaRekey,
+	AreaSyncHandle:     execBuiltinAreaSync,
+	AreaSubHandle:      execBuiltinAreaSub,
+	RexecHandle:        execBuiltinRexec,
+	TraceHandle:        execBuiltinTrace,
+}
+
+// Built-in handles trigger actions on our side, so they are served only
+// for known neighbours. Area's messages are not allowed to use them, as
+// any area's member could post them.
+func (ctx *Ctx) execBuiltinAllowed(sender *Node) bool {
+	if ctx.Neigh[*sender.Id] == nil {
+		return false
+	}
+	_, isArea := ctx.AreaId2Area[AreaId(*sender.Id)]
+	return !isArea
+}
+
+func execBuiltinAreaBackfill(
+	ctx *Ctx, req *ExecRequest, pktName string, les LEs, logMsg func(LEs) string,
+) (LEs, error) {
+	sent, err := ctx.areaBackfill(req.Sender, req.Nice, req.Args, req.Body)
+	if err != nil {
+		ctx.LogE("rx-area-backfill", les, err, func(les LEs) string {
+			return logMsg(les) + ": backfilling"
+		})
+		return les, err
+	}
+	return append(les, LE{"Msgs", sent}), nil
+}
+
+func execBuiltinTrace(
+	ctx *Ctx, req *ExecRequest, pktName string, les LEs, logMsg func(LEs) string,
+) (LEs, error) {
+	probe, err := ctx.traceReturned(req.Body)
+	if err != nil {
+		ctx.LogE("rx-trace", les, err, func(les LEs) string {
+			return logMsg(les) + ": saving trace"
+		})
+		return les, err
+	}
+	return append(les, LE{"Trace", probe.IdString()}), nil
+}
+
+func execBuiltinRexec(
+	ctx *Ctx, req *ExecRequest, pktName string, les LEs, logMsg func(LEs) string,
+) (LEs, error) {
+	if err := ctx.rexecReplySave(req.Sender, req.Args, req.Body); err != nil {
+		ctx.LogE("rx-rexec", les, err, func(les LEs) string {
+			return logMsg(les) + ": saving rexec reply"
+		})
+		return les, err
+	}
+	return les, nil
+}
+
+func execBuiltinAreaSub(
+	ctx *Ctx, req *ExecRequest, pktName string, les LEs, logMsg func(LEs) string,
+) (LEs, error) {
+	sub, err := ctx.areaSubProcess(req.Sender, req.Args, req.Body)
+	if err != nil {
+		ctx.LogE("rx-area-sub", les, err, func(les LEs) string {
+			return logMsg(les) + ": area subscription"
+		})
+		return les, err
+	}
+	if sub != nil {
+		ctx.notifyPkt(
+			NotifyEventAreaSub, req.Sender.Id, pktName,
+			fmt.Sprintf(
+				"Area %s subscription request from %s",
+				ctx.AreaName(&sub.Area), req.Sender.Name,
+			),
+			nil, nil,
+		)
+	}
+	return les, nil
+}
+
+func execBuiltinAreaSync(
+	ctx *Ctx, req *ExecRequest, pktName string, les LEs, logMsg func(LEs) string,
+) (LEs, error) {
+	res, err := ctx.areaSyncProcess(req.Sender, req.Nice, req.Args, req.Body)
+	if err != nil {
+		ctx.LogE("rx-area-sync", les, err, func(les LEs) string {
+			return logMsg(les) + ": synchronizing"
+		})
+		return les, err
+	}
+	les = append(les, LE{"Msgs", res.Sent})
+	if res.Undecodable {
+		ctx.LogI("rx-area-sync-undecodable", les, func(les LEs) string {
+			return logMsg(les) + ": too many differences"
+		})
+	}
+	if len(res.Missed) > 0 {
+		ctx.LogI(
+			"rx-area-sync-missed",
+			append(les, LE{"Missed", len(res.Missed)}),
+			func(les LEs) string {
+				return fmt.Sprintf(
+					"%s: %d messages missed", logMsg(les), len(res.Missed),
+				)
+			},
+		)
+	}
+	if len(res.Unsendable) > 0 {
+		ctx.LogI(
+			"rx-area-sync-unsendable",
+			append(les, LE{"Unsendable", len(res.Unsendable)}),
+			func(les LEs) string {
+				return fmt.Sprintf(
+					"%s: %d messages are not archived",
+					logMsg(les), len(res.Unsendable),
+				)
+			},
+		)
+	}
+	return les, nil
+}
+
+func execBuiltinAreaRekey(
+	ctx *Ctx, req *ExecRequest, pktName string, les LEs, logMsg func(LEs) string,
+) (LEs, error) {
+	rk, err := ctx.areaRekeyProcess(req.Sender, req.Args, req.Body)
+	if err != nil {
+		ctx.LogE("rx-area-rekey", les, err, func(les LEs) string {
+			return logMsg(les) + ": area rekey"
+		})
+		if errors.Is(err, AreaRekeyStale) {
+			return les, nil
+		}
+		return les, err
+	}
+	return append(les, LE{"Epoch", int64(rk.Epoch)}), nil
+}
+
+func execBuiltinEvicted(
+	ctx *Ctx, req *ExecRequest, pktName string, les LEs, logMsg func(LEs) string,
+) (LEs, error) {
+	subject, err := ioutil.ReadAll(io.LimitReader(req.Body, 1<<10))
+	if err != nil {
+		ctx.LogE("rx-evicted", les, err, func(les LEs) string {
+			return logMsg(les) + ": reading"
+		})
+		return les, err
+	}
+	io.Copy(ioutil.Discard, req.Body)
+	ctx.LogI("rx-evicted", les, func(les LEs) string {
+		return logMsg(les) + ": evicted by " + req.Sender.Name
+	})
+	var evictedPkt string
+	if len(req.Args) > 0 {
+		evictedPkt = req.Args[0]
+	}
+	ctx.notifyPkt(
+		NotifyEventEvict, req.Sender.Id, evictedPkt,
+		fmt.Sprintf("%s: %s", req.Sender.Name, subject),
+		nil, nil,
+	)
+	return les, nil
+}
@@ -0,0 +1,81 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	xdr "github.com/davecgh/go-xdr/xdr2"
+)
+
+func TestExecBuiltinDenied(t *testing.T) {
+	spool, err := ioutil.TempDir("", "testexecbuiltin")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(spool)
+	nodeOur, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeStranger, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := testAreaCtx(spool, nodeOur)
+	process := func(sender *Node, handle string) error {
+		pkt, err := NewPkt(PktTypeExecFat, DefaultNiceExec, []byte(handle))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pipeR, pipeW := io.Pipe()
+		go func() {
+			xdr.Marshal(pipeW, pkt)
+			pipeW.Close()
+		}()
+		return jobProcess(
+			ctx, pipeR, "pkt", nil, sender, DefaultNiceExec, 0, "", nil,
+			false, false, false, false, false, false, false, false,
+		)
+	}
+
+	// Unknown sender, like the area's message author
+	for handle := range execBuiltins {
+		if err = process(nodeStranger.Their(), handle); !errors.Is(err, ExecBuiltinDenied) {
+			t.Fatal("built-in handle is served for stranger:", handle, err)
+		}
+	}
+
+	// Area's pseudo-node
+	areaId := AreaId(*nodeStranger.Id)
+	ctx.AreaId2Area[areaId] = &Area{Name: "test", Id: &areaId}
+	ctx.Neigh[*nodeStranger.Id] = nodeStranger.Their()
+	for handle := range execBuiltins {
+		if err = process(nodeStranger.Their(), handle); !errors.Is(err, ExecBuiltinDenied) {
+			t.Fatal("built-in handle is served for area:", handle, err)
+		}
+	}
+	delete(ctx.AreaId2Area, areaId)
+	if err = process(nodeStranger.Their(), AreaBackfillHandle); errors.Is(err, ExecBuiltinDenied) {
+		t.Fatal("built-in handle is denied for neighbour")
+	}
+}
@@ -0,0 +1,157 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Exec request passed to the in-process handler.
+type ExecRequest struct {
+	Sender *Node
+	Handle string
+	Args   []string
+	Nice   uint8
+	// Unread remainder of the body is discarded after handler returns
+	Body io.Reader
+
+	// Handler's output, sent back to the sender if exec policy has
+	// reply, otherwise it is combined with Stderr. Writers are not
+	// safe for concurrent use.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// In-process exec handler. Context is cancelled when exec policy's
+// timeout or output limit is exceeded.
+type ExecFunc func(cctx context.Context, req *ExecRequest) error
+
+// Non-zero exit code returned by in-process handler. Like external
+// handler's one, it is delivered with the reply, if policy has it.
+type ExecExit int
+
+func (e ExecExit) Error() string {
+	return "exit status " + strconv.Itoa(int(e))
+}
+
+func execFuncKey(nodeId *NodeId, handle string) string {
+	if nodeId == nil {
+		return "*." + handle
+	}
+	return nodeId.String() + "." + handle
+}
+
+// Register in-process exec handler for the node, or for all nodes if
+// nodeId is nil. It takes precedence over node's exec commands.
+func (ctx *Ctx) ExecRegister(nodeId *NodeId, handle string, fn ExecFunc) {
+	ctx.execFuncs.Store(execFuncKey(nodeId, handle), fn)
+}
+
+func (ctx *Ctx) ExecUnregister(nodeId *NodeId, handle string) {
+	ctx.execFuncs.Delete(execFuncKey(nodeId, handle))
+}
+
+func (ctx *Ctx) execFuncFind(nodeId *NodeId, handle string) ExecFunc {
+	if fn, ok := ctx.execFuncs.Load(execFuncKey(nodeId, handle)); ok {
+		return fn.(ExecFunc)
+	}
+	if fn, ok := ctx.execFuncs.Load(execFuncKey(nil, handle)); ok {
+		return fn.(ExecFunc)
+	}
+	return nil
+}
+
+// Is that handler's non-zero exit code.
+func execIsExit(err error) bool {
+	if _, ok := err.(*exec.ExitError); ok {
+		return true
+	}
+	var exit ExecExit
+	return errors.As(err, &exit)
+}
+
+// Run in-process handler with policy's timeout and output limit,
+// similarly to execRun. Exit code is -1 if handler failed without
+// ExecExit.
+func execFuncRun(
+	fn ExecFunc,
+	req *ExecRequest,
+	policy *ExecPolicy,
+	stdout io.Writer,
+) (output []byte, exitCode int, err error) {
+	cctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var limitErr error
+	var limitOnce sync.Once
+	stop := func(err error) {
+		limitOnce.Do(func() {
+			limitErr = err
+			cancel()
+		})
+	}
+	limit := int64(-1)
+	if policy != nil {
+		if policy.MaxOutput > 0 {
+			limit = policy.MaxOutput
+		}
+		if policy.Timeout > 0 {
+			t := time.AfterFunc(policy.Timeout, func() { stop(ExecTimeout) })
+			defer t.Stop()
+		}
+	}
+	exceeded := func() { stop(ExecOutputTooBig) }
+	var out bytes.Buffer
+	req.Stderr = &execLimitWriter{w: &out, left: limit, exceeded: exceeded}
+	if stdout == nil {
+		req.Stdout = req.Stderr
+	} else {
+		req.Stdout = &execLimitWriter{w: stdout, left: limit, exceeded: exceeded}
+	}
+	err = func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("exec handler panicked: %v", r)
+			}
+		}()
+		return fn(cctx, req)
+	}()
+	// Handler may leave the body unread, but packet's decoder still
+	// writes to it and fails the toss on the closed pipe
+	io.Copy(ioutil.Discard, req.Body)
+	limitOnce.Do(func() {})
+	if limitErr != nil {
+		return out.Bytes(), -1, limitErr
+	}
+	if err == nil {
+		return out.Bytes(), 0, nil
+	}
+	var exit ExecExit
+	if errors.As(err, &exit) {
+		return out.Bytes(), int(exit), err
+	}
+	return out.Bytes(), -1, err
+}
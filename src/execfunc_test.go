@@ -0,0 +1,179 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTossExecFunc(t *testing.T) {
+	spool, err := ioutil.TempDir("", "testtoss")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(spool)
+	nodeOur, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := Ctx{
+		Spool:   spool,
+		Self:    nodeOur,
+		SelfId:  nodeOur.Id,
+		Neigh:   make(map[NodeId]*Node),
+		Alias:   make(map[string]*NodeId),
+		LogPath: filepath.Join(spool, "log.log"),
+		Debug:   TDebug,
+		Quiet:   true,
+	}
+	node := nodeOur.Their()
+	ctx.Neigh[*nodeOur.Id] = node
+	incomingPath := filepath.Join(spool, "incoming")
+	node.Incoming = &incomingPath
+	txPath := filepath.Join(spool, nodeOur.Id.String(), string(TTx))
+	rxPath := filepath.Join(spool, nodeOur.Id.String(), string(TRx))
+	send := func(handle string, args ...string) {
+		if err := ctx.TxExec(
+			node, DefaultNiceExec, DefaultNiceFile, handle, args,
+			strings.NewReader("BODY"), 0, MaxFileSize, false, nil,
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+	toss := func() {
+		os.RemoveAll(rxPath)
+		if err := os.Rename(txPath, rxPath); err != nil {
+			t.Fatal(err)
+		}
+		ctx.Toss(nodeOur.Id, TRx, DefaultNiceFile,
+			false, false, false, false, false, false, false, false)
+	}
+
+	var got []string
+	ctx.ExecRegister(nil, "echo", func(cctx context.Context, req *ExecRequest) error {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return err
+		}
+		got = append(got, fmt.Sprintf(
+			"%s %s %d %s", req.Sender.Name, strings.Join(req.Args, ","), req.Nice, body,
+		))
+		fmt.Fprint(req.Stdout, "out")
+		fmt.Fprint(req.Stderr, "err")
+		if len(req.Args) > 0 && req.Args[0] == "fail" {
+			return errors.New("failed")
+		}
+		if len(req.Args) > 0 && req.Args[0] == "exit" {
+			return ExecExit(3)
+		}
+		return nil
+	})
+	ctx.ExecRegister(nodeOur.Id, "sleep", func(cctx context.Context, req *ExecRequest) error {
+		<-cctx.Done()
+		return cctx.Err()
+	})
+	node.ExecPolicy = map[string]*ExecPolicy{
+		"sleep": {Timeout: 10 * time.Millisecond},
+	}
+	events, unsubscribe := ctx.Subscribe(8, NotifyEventExec)
+	send("echo", "a", "b")
+	toss()
+	if len(dirFiles(rxPath)) != 0 {
+		t.Fatal("exec packet is not processed")
+	}
+	if len(got) != 1 || got[0] != fmt.Sprintf("self a,b %d BODY", DefaultNiceFile) {
+		t.Fatal("unexpected requests:", got)
+	}
+	unsubscribe()
+	if ev := <-events; ev.Output != "outerr" {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+
+	send("echo", "fail")
+	send("sleep")
+	send("unknown")
+	toss()
+	if len(dirFiles(rxPath)) != 3 {
+		t.Fatal("failed packets are removed")
+	}
+	log, err := ioutil.ReadFile(ctx.LogPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(log), "Who: rx-handle-limit") {
+		t.Fatal("timeout is not logged")
+	}
+
+	// Non-zero exit code is delivered with the reply
+	got = nil
+	node.ExecPolicy["echo"] = &ExecPolicy{Reply: ExecReplyFile}
+	send("echo", "exit")
+	toss()
+	if len(dirFiles(rxPath)) != 0 {
+		t.Fatal("exec packet is not processed")
+	}
+	toss()
+	matches, err := filepath.Glob(filepath.Join(incomingPath, "echo.*.3"))
+	if err != nil || len(matches) != 1 {
+		t.Fatal("no reply is received", matches)
+	}
+	data, err := ioutil.ReadFile(matches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "out" {
+		t.Fatalf("unexpected reply: %q", data)
+	}
+
+	// Unread body does not fail the packet
+	ctx.ExecRegister(nil, "ignore", func(cctx context.Context, req *ExecRequest) error {
+		return nil
+	})
+	body := make([]byte, 1<<20)
+	if _, err = io.ReadFull(rand.Reader, body); err != nil {
+		t.Fatal(err)
+	}
+	if err = ctx.TxExec(
+		node, DefaultNiceExec, DefaultNiceFile, "ignore", nil,
+		bytes.NewReader(body), 0, MaxFileSize, false, nil,
+	); err != nil {
+		t.Fatal(err)
+	}
+	os.RemoveAll(rxPath)
+	if err = os.Rename(txPath, rxPath); err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Toss(nodeOur.Id, TRx, DefaultNiceFile,
+		false, false, false, false, false, false, false, false) {
+		t.Fatal("exec packet with unread body is failed")
+	}
+	if len(dirFiles(rxPath)) != 0 {
+		t.Fatal("exec packet with unread body is not processed")
+	}
+}
@@ -0,0 +1,694 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.cypherpunks.ru/recfile"
+	"golang.org/x/crypto/ed25519"
+)
+
+const (
+	// Freq packet's path is "SRC\x00KIND" for non-ordinary requests
+	FreqKindList = "list"
+	FreqKindGlob = "glob"
+)
+
+const (
+	FreqStateFile = "freq.state"
+
+	// Cache of freq files hashes, used for listing manifests
+	FreqHashesFile = "freq.hashes"
+
+	// Suffix of the notification file sent on rejected request
+	FreqRejectedSuffix = ".rejected"
+)
+
+var (
+	FreqEscape      = errors.New("path escapes freq directory")
+	FreqUnknownRoot = errors.New("unknown freq root")
+	FreqDenied      = errors.New("freq is denied by policy")
+	FreqQuotaBytes  = errors.New("freq daily bytes quota is exceeded")
+)
+
+// Entry of the signed freq listing manifest.
+type FreqEntry struct {
+	Path  string
+	Size  int64
+	MTime time.Time
+	MTH   []byte
+}
+
+type FreqManifest struct {
+	Node    *NodeId
+	Created time.Time
+	Pattern string
+	Entries []FreqEntry
+}
+
+func freqSplit(src string) (string, string) {
+	if i := strings.IndexByte(src, 0); i != -1 {
+		return src[:i], src[i+1:]
+	}
+	return src, ""
+}
+
+func freqIsRejection(err error) bool {
+	switch err {
+	case FreqEscape, FreqUnknownRoot, FreqDenied, FreqQuotaBytes:
+		return true
+	}
+	return false
+}
+
+type freqRoot struct {
+	name string
+	dir  string
+}
+
+// Name of the path as it is seen by the requester.
+func (root *freqRoot) full(rel string) string {
+	rel = filepath.ToSlash(rel)
+	if root.name == "" {
+		return rel
+	}
+	return root.name + ":" + rel
+}
+
+// Freq roots the request refers to and the path (or pattern) relative to
+// them. "NAME:" prefix selects the named root, otherwise default
+// freq.path is used. Empty listing pattern refers to all roots.
+func (node *Node) freqRoots(src string) ([]*freqRoot, string, error) {
+	if i := strings.IndexByte(src, ':'); i > 0 {
+		if dir, exists := node.FreqRoots[src[:i]]; exists {
+			return []*freqRoot{{name: src[:i], dir: dir}}, src[i+1:], nil
+		}
+	}
+	var roots []*freqRoot
+	if node.FreqPath != nil {
+		roots = append(roots, &freqRoot{dir: *node.FreqPath})
+	}
+	if src == "" {
+		names := make([]string, 0, len(node.FreqRoots))
+		for name := range node.FreqRoots {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			roots = append(roots, &freqRoot{name: name, dir: node.FreqRoots[name]})
+		}
+	}
+	if len(roots) == 0 {
+		return nil, "", FreqUnknownRoot
+	}
+	return roots, src, nil
+}
+
+// Check requested path against allow and deny patterns. Patterns are
+// matched against the path and all its parent directories. Deny ones
+// take precedence, then path must be allowed, if allow list is set.
+func (node *Node) freqAllowed(full string) bool {
+	matches := func(patterns []string) bool {
+		for p := full; p != "." && p != "/" && p != ""; p = path.Dir(p) {
+			for _, pattern := range patterns {
+				if matched, _ := path.Match(pattern, p); matched {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	if matches(node.FreqDeny) {
+		return false
+	}
+	return len(node.FreqAllow) == 0 || matches(node.FreqAllow)
+}
+
+// Check that requested relative path (or pattern) does not lead outside
+// the freq directory.
+func freqRel(src string) (string, error) {
+	if filepath.IsAbs(src) {
+		return "", FreqEscape
+	}
+	src = filepath.Clean(src)
+	if src == ".." || strings.HasPrefix(src, ".."+string(filepath.Separator)) {
+		return "", FreqEscape
+	}
+	return src, nil
+}
+
+// Resolve relative path to the real one, including symbolic links, and
+// check that it is inside real freq directory.
+func freqResolve(freqPath, rel string) (string, error) {
+	root, err := filepath.EvalSymlinks(freqPath)
+	if err != nil {
+		return "", err
+	}
+	p, err := filepath.EvalSymlinks(filepath.Join(freqPath, rel))
+	if err != nil {
+		return "", err
+	}
+	if p != root && !strings.HasPrefix(p, root+string(filepath.Separator)) {
+		return "", FreqEscape
+	}
+	return p, nil
+}
+
+// Regular files inside freq directory matching the pattern. Empty
+// pattern means all files recursively. Returned paths are relative.
+func freqGlob(freqPath, pattern string) ([]string, error) {
+	var rels []string
+	if pattern == "" {
+		root, err := filepath.EvalSymlinks(freqPath)
+		if err != nil {
+			return nil, err
+		}
+		err = filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if fi.Mode().IsRegular() {
+				rel, err := filepath.Rel(root, p)
+				if err != nil {
+					return err
+				}
+				rels = append(rels, rel)
+			}
+			return nil
+		})
+		return rels, err
+	}
+	pattern, err := freqRel(pattern)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := filepath.Glob(filepath.Join(freqPath, pattern))
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range matches {
+		rel, err := filepath.Rel(freqPath, p)
+		if err != nil {
+			return nil, err
+		}
+		real, err := freqResolve(freqPath, rel)
+		if err != nil {
+			if err == FreqEscape || os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		fi, err := os.Stat(real)
+		if err != nil {
+			return nil, err
+		}
+		if fi.Mode().IsRegular() {
+			rels = append(rels, rel)
+		}
+	}
+	sort.Strings(rels)
+	return rels, nil
+}
+
+// Cached MTH of the file, valid while its size and modification time
+// are the same.
+type freqHash struct {
+	Size  int64
+	MTime time.Time
+	MTH   []byte
+}
+
+func (ctx *Ctx) freqHashesPath() string {
+	return filepath.Join(ctx.Spool, FreqHashesFile)
+}
+
+// Load cached hashes, keyed by real file paths.
+func (ctx *Ctx) freqHashesLoad() (map[string]*freqHash, error) {
+	hashes := make(map[string]*freqHash)
+	fd, err := os.Open(ctx.freqHashesPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return hashes, nil
+		}
+		return nil, err
+	}
+	defer fd.Close()
+	r := recfile.NewReader(fd)
+	for {
+		m, err := r.NextMap()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		var h freqHash
+		if h.Size, err = strconv.ParseInt(m["Size"], 10, 64); err != nil {
+			return nil, err
+		}
+		if h.MTime, err = time.Parse(time.RFC3339Nano, m["MTime"]); err != nil {
+			return nil, err
+		}
+		if h.MTH, err = Base32Codec.DecodeString(m["MTH"]); err != nil {
+			return nil, err
+		}
+		hashes[m["Path"]] = &h
+	}
+	return hashes, nil
+}
+
+// Atomically save cached hashes, skipping already removed files.
+// Concurrent savers can lose each other's entries, leading only to
+// their rehashing.
+func (ctx *Ctx) freqHashesSave(hashes map[string]*freqHash) error {
+	paths := make([]string, 0, len(hashes))
+	for p := range hashes {
+		if _, err := os.Stat(p); err == nil {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	var buf bytes.Buffer
+	w := recfile.NewWriter(&buf)
+	for _, p := range paths {
+		h := hashes[p]
+		if _, err := w.RecordStart(); err != nil {
+			return err
+		}
+		if _, err := w.WriteFields(
+			recfile.Field{Name: "Path", Value: p},
+			recfile.Field{Name: "Size", Value: strconv.FormatInt(h.Size, 10)},
+			recfile.Field{Name: "MTime", Value: h.MTime.UTC().Format(time.RFC3339Nano)},
+			recfile.Field{Name: "MTH", Value: Base32Codec.EncodeToString(h.MTH)},
+		); err != nil {
+			return err
+		}
+	}
+	if err := ensureDir(ctx.Spool); err != nil {
+		return err
+	}
+	tmp, err := TempFile(ctx.Spool, "freq")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = os.Rename(tmp.Name(), ctx.freqHashesPath()); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return DirSync(ctx.Spool)
+}
+
+// Manifest's entry of the file. Its MTH is taken from the cache, if
+// file's size and modification time are unchanged, otherwise it is
+// hashed and the cache is updated, what is reported.
+func freqEntry(
+	freqPath, rel string,
+	hashes map[string]*freqHash,
+) (*FreqEntry, bool, error) {
+	real, err := freqResolve(freqPath, rel)
+	if err != nil {
+		return nil, false, err
+	}
+	fd, err := os.Open(real)
+	if err != nil {
+		return nil, false, err
+	}
+	defer fd.Close()
+	fi, err := fd.Stat()
+	if err != nil {
+		return nil, false, err
+	}
+	e := FreqEntry{Size: fi.Size(), MTime: fi.ModTime().UTC()}
+	if h := hashes[real]; h != nil && h.Size == e.Size && h.MTime.Equal(e.MTime) {
+		e.MTH = h.MTH
+		return &e, false, nil
+	}
+	mth := MTHNew(fi.Size(), 0)
+	if _, err = io.Copy(mth, fd); err != nil {
+		return nil, false, err
+	}
+	e.MTH = mth.Sum(nil)
+	hashes[real] = &freqHash{Size: e.Size, MTime: e.MTime, MTH: e.MTH}
+	return &e, true, nil
+}
+
+func (m *FreqManifest) marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	w := recfile.NewWriter(&buf)
+	if _, err := w.RecordStart(); err != nil {
+		return nil, err
+	}
+	if _, err := w.WriteFields(
+		recfile.Field{Name: "Node", Value: m.Node.String()},
+		recfile.Field{Name: "Created", Value: m.Created.Format(time.RFC3339)},
+		recfile.Field{Name: "Pattern", Value: m.Pattern},
+	); err != nil {
+		return nil, err
+	}
+	for _, e := range m.Entries {
+		if _, err := w.RecordStart(); err != nil {
+			return nil, err
+		}
+		if _, err := w.WriteFields(
+			recfile.Field{Name: "Path", Value: e.Path},
+			recfile.Field{Name: "Size", Value: strconv.FormatInt(e.Size, 10)},
+			recfile.Field{Name: "MTime", Value: e.MTime.Format(time.RFC3339)},
+			recfile.Field{Name: "MTH", Value: Base32Codec.EncodeToString(e.MTH)},
+		); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Create listing of freq directories, signed by our node. Files
+// denied by the policy or exceeding freq maximal size without chunking
+// are skipped, as they can not be requested. Only new and modified
+// files are hashed, unreadable cache is rebuilt.
+func (ctx *Ctx) FreqManifest(node *Node, pattern string) ([]byte, error) {
+	roots, rootPattern, err := node.freqRoots(pattern)
+	if err != nil {
+		return nil, err
+	}
+	hashes, err := ctx.freqHashesLoad()
+	if err != nil {
+		hashes = make(map[string]*freqHash)
+	}
+	var hashed bool
+	m := FreqManifest{
+		Node:    ctx.SelfId,
+		Created: time.Now().UTC(),
+		Pattern: pattern,
+	}
+	for _, root := range roots {
+		rels, err := freqGlob(root.dir, rootPattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, rel := range rels {
+			if strings.ContainsRune(rel, '\n') || !node.freqAllowed(root.full(rel)) {
+				continue
+			}
+			e, fresh, err := freqEntry(root.dir, rel, hashes)
+			if err != nil {
+				return nil, err
+			}
+			hashed = hashed || fresh
+			if node.FreqChunked == 0 && e.Size > node.FreqMaxSize {
+				continue
+			}
+			e.Path = root.full(rel)
+			m.Entries = append(m.Entries, *e)
+		}
+	}
+	if hashed {
+		if err = ctx.freqHashesSave(hashes); err != nil {
+			return nil, err
+		}
+	}
+	data, err := m.marshal()
+	if err != nil {
+		return nil, err
+	}
+	sig := ed25519.Sign(ctx.Self.SignPrv, data)
+	var buf bytes.Buffer
+	buf.Write(data)
+	w := recfile.NewWriter(&buf)
+	if _, err = w.RecordStart(); err != nil {
+		return nil, err
+	}
+	if _, err = w.WriteFields(recfile.Field{
+		Name: "Signature", Value: Base32Codec.EncodeToString(sig),
+	}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Parse freq listing manifest and verify its signature.
+func (ctx *Ctx) FreqManifestVerify(data []byte) (*FreqManifest, error) {
+	r := recfile.NewReader(bytes.NewReader(data))
+	var m FreqManifest
+	var sig []byte
+	for {
+		fields, err := r.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if sig != nil {
+			return nil, errors.New("data after signature")
+		}
+		vals := make(map[string]string, len(fields))
+		for _, f := range fields {
+			vals[f.Name] = f.Value
+		}
+		switch {
+		case m.Node == nil:
+			if m.Node, err = NodeIdFromString(vals["Node"]); err != nil {
+				return nil, err
+			}
+			if m.Created, err = time.Parse(time.RFC3339, vals["Created"]); err != nil {
+				return nil, err
+			}
+			m.Pattern = vals["Pattern"]
+		case vals["Signature"] != "":
+			if sig, err = Base32Codec.DecodeString(vals["Signature"]); err != nil {
+				return nil, err
+			}
+		default:
+			e := FreqEntry{Path: vals["Path"]}
+			if e.Size, err = strconv.ParseInt(vals["Size"], 10, 64); err != nil {
+				return nil, err
+			}
+			if e.MTime, err = time.Parse(time.RFC3339, vals["MTime"]); err != nil {
+				return nil, err
+			}
+			if e.MTH, err = Base32Codec.DecodeString(vals["MTH"]); err != nil {
+				return nil, err
+			}
+			m.Entries = append(m.Entries, e)
+		}
+	}
+	if m.Node == nil || sig == nil {
+		return nil, errors.New("no signed manifest")
+	}
+	node := ctx.Neigh[*m.Node]
+	if node == nil {
+		return nil, errors.New("unknown manifest signer")
+	}
+	signed, err := m.marshal()
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(node.SignPub, signed, sig) {
+		return nil, errors.New("invalid manifest signature")
+	}
+	return &m, nil
+}
+
+type freqFile struct {
+	path string
+	dst  string
+	size int64
+}
+
+// Send requested file, the signed listing or all files matching the
+// glob. Files of the glob too big for freq size limit are skipped.
+// Policy violations are returned as errors, for which freqIsRejection
+// is true.
+func (ctx *Ctx) freqProcess(sender *Node, nice uint8, src, dst, kind string) error {
+	roots, pattern, err := sender.freqRoots(src)
+	if err != nil {
+		return err
+	}
+	var files []freqFile
+	switch kind {
+	case "":
+		rel, err := freqRel(pattern)
+		if err != nil {
+			return err
+		}
+		if !sender.freqAllowed(roots[0].full(rel)) {
+			return FreqDenied
+		}
+		p := filepath.Join(roots[0].dir, rel)
+		fi, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		size := fi.Size()
+		if fi.IsDir() {
+			// Directory is sent as an archive of its files
+			size = 0
+			err = filepath.Walk(p, func(_ string, fi os.FileInfo, err error) error {
+				if err == nil && fi.Mode().IsRegular() {
+					size += fi.Size()
+				}
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+		files = append(files, freqFile{p, dst, size})
+	case FreqKindGlob:
+		if pattern == "" {
+			return errors.New("empty glob")
+		}
+		for _, root := range roots {
+			rels, err := freqGlob(root.dir, pattern)
+			if err != nil {
+				return err
+			}
+			for _, rel := range rels {
+				if !sender.freqAllowed(root.full(rel)) {
+					continue
+				}
+				real, err := freqResolve(root.dir, rel)
+				if err != nil {
+					return err
+				}
+				fi, err := os.Stat(real)
+				if err != nil {
+					return err
+				}
+				if sender.FreqChunked == 0 && fi.Size() > sender.FreqMaxSize {
+					continue
+				}
+				files = append(files, freqFile{
+					real, path.Join(dst, filepath.ToSlash(rel)), fi.Size(),
+				})
+			}
+		}
+	case FreqKindList:
+		manifest, err := ctx.FreqManifest(sender, src)
+		if err != nil {
+			return err
+		}
+		tmp, err := ctx.NewTmpFile()
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		if _, err = tmp.Write(manifest); err != nil {
+			tmp.Close()
+			return err
+		}
+		if err = tmp.Close(); err != nil {
+			return err
+		}
+		files = append(files, freqFile{tmp.Name(), dst, int64(len(manifest))})
+	default:
+		return errors.New("unknown freq kind: " + kind)
+	}
+
+	var st *TransitState
+	if sender.FreqBytesPerDay > 0 {
+		ctx.freqLock.Lock()
+		defer ctx.freqLock.Unlock()
+		if st, err = ctx.dailyStateLoad(sender.Id, FreqStateFile); err != nil {
+			return err
+		}
+		var size int64
+		for _, f := range files {
+			size += f.size
+		}
+		if st.Bytes+size > sender.FreqBytesPerDay {
+			return FreqQuotaBytes
+		}
+	}
+	for _, f := range files {
+		err = ctx.TxFile(
+			sender, nice, f.path, f.dst,
+			sender.FreqChunked, sender.FreqMinSize, sender.FreqMaxSize, nil,
+		)
+		if err == TooBig && kind == FreqKindGlob {
+			err = nil
+			continue
+		}
+		if err != nil {
+			break
+		}
+		if st != nil {
+			st.Bytes += f.size
+			st.Pkts++
+		}
+	}
+	if st != nil {
+		if errSave := ctx.dailyStateSave(sender.Id, FreqStateFile, st); err == nil {
+			err = errSave
+		}
+	}
+	return err
+}
+
+// Send small notification file about rejected request to the requester.
+func (ctx *Ctx) freqReject(
+	sender *Node,
+	nice uint8,
+	src, dst, kind string,
+	reason error,
+) error {
+	var buf bytes.Buffer
+	w := recfile.NewWriter(&buf)
+	if _, err := w.RecordStart(); err != nil {
+		return err
+	}
+	if _, err := w.WriteFields(
+		recfile.Field{Name: "Src", Value: src},
+		recfile.Field{Name: "Reason", Value: reason.Error()},
+	); err != nil {
+		return err
+	}
+	dst = path.Clean(dst)
+	if kind == FreqKindGlob {
+		dst = path.Join(dst, FreqKindGlob)
+	}
+	dst += FreqRejectedSuffix
+	pkt, err := NewPkt(PktTypeFile, nice, []byte(dst))
+	if err != nil {
+		return err
+	}
+	_, _, _, err = ctx.Tx(
+		sender, pkt, nice, int64(buf.Len()), 0, MaxFileSize,
+		&buf, dst, nil,
+	)
+	return err
+}
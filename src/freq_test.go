@@ -0,0 +1,236 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFreqList(t *testing.T) {
+	spool, err := ioutil.TempDir("", "testfreq")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(spool)
+	freqPath := filepath.Join(spool, "pub")
+	for name, data := range map[string]string{
+		"a.txt":     "a",
+		"b.bin":     "bb",
+		"sub/c.txt": "ccc",
+		"big.txt":   string(make([]byte, 2048)),
+		"../secret": "secret",
+	} {
+		p := filepath.Join(freqPath, name)
+		if err = os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(p, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = os.Symlink(
+		filepath.Join(spool, "secret"), filepath.Join(freqPath, "leak.txt"),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	rels, err := freqGlob(freqPath, "*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rels, []string{"a.txt", "big.txt"}) {
+		t.Fatal("unexpected glob result:", rels)
+	}
+	if _, err = freqGlob(freqPath, "../*"); err != FreqEscape {
+		t.Fatal("glob escapes freq path:", err)
+	}
+
+	nodeOur, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := Ctx{
+		Spool:  spool,
+		Self:   nodeOur,
+		SelfId: nodeOur.Id,
+		Neigh:  make(map[NodeId]*Node),
+	}
+	node := nodeOur.Their()
+	node.FreqPath = &freqPath
+	node.FreqChunked = 0
+	node.FreqMaxSize = 1024
+	ctx.Neigh[*nodeOur.Id] = node
+	data, err := ctx.FreqManifest(node, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := ctx.FreqManifestVerify(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, e := range manifest.Entries {
+		paths = append(paths, e.Path)
+	}
+	if !reflect.DeepEqual(paths, []string{"a.txt", "b.bin", "sub/c.txt"}) {
+		t.Fatal("unexpected listing:", paths)
+	}
+	mth := MTHNew(3, 0)
+	mth.Write([]byte("ccc"))
+	if e := manifest.Entries[2]; e.Size != 3 || !bytes.Equal(e.MTH, mth.Sum(nil)) {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+	if _, err = ctx.FreqManifestVerify(
+		bytes.Replace(data, []byte("Size: 3"), []byte("Size: 4"), 1),
+	); err == nil {
+		t.Fatal("tampered listing is accepted")
+	}
+
+	cPath := filepath.Join(freqPath, "sub", "c.txt")
+	fi, err := os.Stat(cPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(cPath, []byte("xxx"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chtimes(cPath, fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	cMTH := func() []byte {
+		data, err := ctx.FreqManifest(node, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifest, err := ctx.FreqManifestVerify(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return manifest.Entries[2].MTH
+	}
+	if !bytes.Equal(cMTH(), mth.Sum(nil)) {
+		t.Fatal("unchanged file is rehashed")
+	}
+	mtime := fi.ModTime().Add(time.Second)
+	if err = os.Chtimes(cPath, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	mth = MTHNew(3, 0)
+	mth.Write([]byte("xxx"))
+	if !bytes.Equal(cMTH(), mth.Sum(nil)) {
+		t.Fatal("modified file is not rehashed")
+	}
+}
+
+func TestFreqPolicy(t *testing.T) {
+	spool, err := ioutil.TempDir("", "testfreq")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(spool)
+	for name, data := range map[string]string{
+		"pub/a.txt":            "a",
+		"pub/b.bin":            "bb",
+		"private/c.txt":        "ccc",
+		"private/secret/d.txt": "dddd",
+	} {
+		p := filepath.Join(spool, name)
+		if err = os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(p, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	nodeOur, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := Ctx{
+		Spool:   spool,
+		Self:    nodeOur,
+		SelfId:  nodeOur.Id,
+		Neigh:   make(map[NodeId]*Node),
+		LogPath: filepath.Join(spool, "log.log"),
+		Debug:   TDebug,
+	}
+	node := nodeOur.Their()
+	node.FreqRoots = map[string]string{
+		"pub":     filepath.Join(spool, "pub"),
+		"private": filepath.Join(spool, "private"),
+	}
+	node.FreqAllow = []string{"pub:*", "private:*.txt", "private:secret"}
+	node.FreqDeny = []string{"private:secret"}
+	node.FreqBytesPerDay = 3
+	ctx.Neigh[*nodeOur.Id] = node
+	txPath := filepath.Join(spool, nodeOur.Id.String(), string(TTx))
+
+	data, err := ctx.FreqManifest(node, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := ctx.FreqManifestVerify(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, e := range manifest.Entries {
+		paths = append(paths, e.Path)
+	}
+	if !reflect.DeepEqual(paths, []string{"private:c.txt", "pub:a.txt", "pub:b.bin"}) {
+		t.Fatal("unexpected listing:", paths)
+	}
+
+	for src, expected := range map[string]error{
+		"a.txt":                FreqUnknownRoot,
+		"pub:../private/c.txt": FreqEscape,
+		"private:secret/d.txt": FreqDenied,
+		"private:secret":       FreqDenied,
+	} {
+		if err = ctx.freqProcess(node, DefaultNiceFile, src, "dst", ""); err != expected {
+			t.Fatal("unexpected result for", src, err)
+		}
+	}
+	if err = ctx.freqProcess(node, DefaultNiceFile, "pub:a.txt", "a", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err = ctx.freqProcess(node, DefaultNiceFile, "*.bin", "dst", FreqKindGlob); err != FreqUnknownRoot {
+		t.Fatal("glob without root is not rejected:", err)
+	}
+	if err = ctx.freqProcess(node, DefaultNiceFile, "pub:*.bin", "dst", FreqKindGlob); err != nil {
+		t.Fatal(err)
+	}
+	if len(dirFiles(txPath)) != 2 {
+		t.Fatal("files are not sent")
+	}
+	if err = ctx.freqProcess(node, DefaultNiceFile, "pub:a.txt", "a", ""); err != FreqQuotaBytes {
+		t.Fatal("quota is not exceeded:", err)
+	}
+	if err = ctx.freqReject(node, DefaultNiceFile, "pub:a.txt", "a", "", err); err != nil {
+		t.Fatal(err)
+	}
+	if len(dirFiles(txPath)) != 3 {
+		t.Fatal("rejection is not sent")
+	}
+}
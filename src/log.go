@@ -20,6 +20,7 @@ package nncp
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"sync"
 	"time"
@@ -85,6 +86,12 @@ func (les LEs) Rec() string {
 }
 
 func (ctx *Ctx) Log(rec string) {
+	if ctx.LogWriter != nil {
+		LogFdLock.Lock()
+		io.WriteString(ctx.LogWriter, rec)
+		LogFdLock.Unlock()
+		return
+	}
 	if LogFd != nil {
 		LogFdLock.Lock()
 		LogFd.WriteString(rec)
@@ -121,21 +128,32 @@ func (ctx *Ctx) Log(rec string) {
 	fd.Close()
 }
 
+func (ctx *Ctx) debugWrite(rec string) {
+	if ctx.DebugWriter == nil {
+		fmt.Fprint(os.Stderr, rec)
+		return
+	}
+	LogFdLock.Lock()
+	io.WriteString(ctx.DebugWriter, rec)
+	LogFdLock.Unlock()
+}
+
 func (ctx *Ctx) LogD(who string, les LEs, msg func(LEs) string) {
 	if !ctx.Debug {
 		return
 	}
 	les = append(LEs{{"Debug", true}, {"Who", who}}, les...)
 	les = append(les, LE{"Msg", msg(les)})
-	fmt.Fprint(os.Stderr, les.Rec())
+	ctx.debugWrite(les.Rec())
 }
 
 func (ctx *Ctx) LogI(who string, les LEs, msg func(LEs) string) {
 	les = append(LEs{{"Who", who}}, les...)
 	les = append(les, LE{"Msg", msg(les)})
+	ctx.eventWrite(who, les[1:], false)
 	rec := les.Rec()
 	if ctx.Debug {
-		fmt.Fprint(os.Stderr, rec)
+		ctx.debugWrite(rec)
 	}
 	if !ctx.Quiet {
 		fmt.Fprintln(os.Stderr, ctx.HumanizeRec(rec))
@@ -146,9 +164,10 @@ func (ctx *Ctx) LogI(who string, les LEs, msg func(LEs) string) {
 func (ctx *Ctx) LogE(who string, les LEs, err error, msg func(LEs) string) {
 	les = append(LEs{{"Err", err.Error()}, {"Who", who}}, les...)
 	les = append(les, LE{"Msg", msg(les)})
+	ctx.eventWrite(who, append(LEs{les[0]}, les[2:]...), true)
 	rec := les.Rec()
 	if ctx.Debug {
-		fmt.Fprint(os.Stderr, rec)
+		ctx.debugWrite(rec)
 	}
 	if !ctx.Quiet {
 		fmt.Fprintln(os.Stderr, ctx.HumanizeRec(rec))
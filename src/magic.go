@@ -73,6 +73,30 @@ var (
 		B:    [8]byte{'N', 'N', 'C', 'P', 'E', 0, 0, 6},
 		Name: "NNCPEv6 (encrypted packet v6)", Till: "now",
 	}
+	MagicNNCPKv1 = Magic{
+		B:    [8]byte{'N', 'N', 'C', 'P', 'K', 0, 0, 1},
+		Name: "NNCPKv1 (area rekey v1)", Till: "now",
+	}
+	MagicNNCPRv1 = Magic{
+		B:    [8]byte{'N', 'N', 'C', 'P', 'R', 0, 0, 1},
+		Name: "NNCPRv1 (routing advertisement v1)", Till: "now",
+	}
+	MagicNNCPTv1 = Magic{
+		B:    [8]byte{'N', 'N', 'C', 'P', 'T', 0, 0, 1},
+		Name: "NNCPTv1 (trace probe v1)", Till: "now",
+	}
+	MagicNNCPUv1 = Magic{
+		B:    [8]byte{'N', 'N', 'C', 'P', 'U', 0, 0, 1},
+		Name: "NNCPUv1 (area subscription request v1)", Till: "now",
+	}
+	MagicNNCPVv1 = Magic{
+		B:    [8]byte{'N', 'N', 'C', 'P', 'V', 0, 0, 1},
+		Name: "NNCPVv1 (area author revocation v1)", Till: "now",
+	}
+	MagicNNCPYv1 = Magic{
+		B:    [8]byte{'N', 'N', 'C', 'P', 'Y', 0, 0, 1},
+		Name: "NNCPYv1 (area synchronization summary v1)", Till: "now",
+	}
 	MagicNNCPSv1 = Magic{
 		B:    [8]byte{'N', 'N', 'C', 'P', 'S', 0, 0, 1},
 		Name: "NNCPSv1 (sync protocol v1)", Till: "now",
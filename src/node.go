@@ -39,24 +39,36 @@ func (id NodeId) String() string {
 }
 
 type Node struct {
-	Name           string
-	Id             *NodeId
-	ExchPub        *[32]byte
-	SignPub        ed25519.PublicKey
-	NoisePub       *[32]byte
-	Exec           map[string][]string
-	Incoming       *string
-	FreqPath       *string
-	FreqChunked    int64
-	FreqMinSize    int64
-	FreqMaxSize    int64
-	Via            []*NodeId
-	Addrs          map[string]string
-	RxRate         int
-	TxRate         int
-	OnlineDeadline time.Duration
-	MaxOnlineTime  time.Duration
-	Calls          []*Call
+	Name            string
+	Id              *NodeId
+	ExchPub         *[32]byte
+	SignPub         ed25519.PublicKey
+	NoisePub        *[32]byte
+	Exec            map[string][]string
+	ExecPolicy      map[string]*ExecPolicy
+	Incoming        *string
+	Placement       []*PlacementRule
+	PostReceive     []string
+	FreqPath        *string
+	FreqRoots       map[string]string
+	FreqAllow       []string
+	FreqDeny        []string
+	FreqChunked     int64
+	FreqMinSize     int64
+	FreqMaxSize     int64
+	FreqBytesPerDay int64
+	Via             []*NodeId
+	ViaAlt          [][]*NodeId
+	ViaFailover     time.Duration
+	RouteCost       uint32
+	Transit         *Transit
+	SpoolQuota      *SpoolQuota
+	Addrs           map[string]string
+	RxRate          int
+	TxRate          int
+	OnlineDeadline  time.Duration
+	MaxOnlineTime   time.Duration
+	Calls           []*Call
 
 	Busy bool
 	sync.Mutex
@@ -0,0 +1,332 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	NotifyEventFile       = "file"
+	NotifyEventFreq       = "freq"
+	NotifyEventExec       = "exec"
+	NotifyEventTossFail   = "toss-fail"
+	NotifyEventQuarantine = "quarantine"
+	NotifyEventQuota      = "quota"
+	NotifyEventAreaSub    = "area-sub"
+	NotifyEventEvict      = "evict"
+
+	DefaultNotifyTimeout = 30 * time.Second
+)
+
+var (
+	NotifyEvents = []string{
+		NotifyEventFile,
+		NotifyEventFreq,
+		NotifyEventExec,
+		NotifyEventTossFail,
+		NotifyEventQuarantine,
+		NotifyEventQuota,
+		NotifyEventAreaSub,
+		NotifyEventEvict,
+	}
+
+	maildirCtr uint64
+)
+
+// Event passed to notifiers. It is JSON encoded for commands and
+// webhooks.
+type NotifyEvent struct {
+	Type     string    `json:"type"`
+	When     time.Time `json:"when"`
+	Self     string    `json:"self"`
+	Node     string    `json:"node,omitempty"`
+	NodeName string    `json:"node-name,omitempty"`
+	Pkt      string    `json:"pkt,omitempty"`
+	Subject  string    `json:"subject"`
+	Output   string    `json:"output,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Notification backend. Only one of Cmd, Webhook, Maildir is set.
+type Notifier struct {
+	// Nil means all events
+	Events  map[string]struct{}
+	Cmd     []string
+	Webhook string
+	Timeout time.Duration
+	Maildir string
+	From    string
+	To      string
+}
+
+func (n *Notifier) wants(typ string) bool {
+	if n.Events == nil {
+		return true
+	}
+	_, exists := n.Events[typ]
+	return exists
+}
+
+func (n *Notifier) String() string {
+	switch {
+	case len(n.Cmd) > 0:
+		return "cmd " + n.Cmd[0]
+	case n.Webhook != "":
+		return "webhook " + n.Webhook
+	}
+	return "maildir " + n.Maildir
+}
+
+func (n *Notifier) deliver(ev *NotifyEvent) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(n.Cmd) > 0:
+		ctx := context.Background()
+		if n.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, n.Timeout)
+			defer cancel()
+		}
+		cmd := exec.CommandContext(ctx, n.Cmd[0], n.Cmd[1:]...)
+		cmd.Env = append(os.Environ(), "NNCP_EVENT="+ev.Type)
+		cmd.Stdin = bytes.NewReader(data)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+		}
+		return nil
+	case n.Webhook != "":
+		client := http.Client{Timeout: n.Timeout}
+		resp, err := client.Post(n.Webhook, "application/json", bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("webhook replied: %s", resp.Status)
+		}
+		return nil
+	}
+	return n.maildirDeliver(ev)
+}
+
+// Deliver the message according to Maildir specification: write it to
+// tmp/ subdirectory and move to new/ one.
+func (n *Notifier) maildirDeliver(ev *NotifyEvent) error {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(n.Maildir, sub), os.FileMode(0700)); err != nil {
+			return err
+		}
+	}
+	lines := []string{"Type: " + ev.Type}
+	if ev.Node != "" {
+		lines = append(lines, "Node: "+ev.NodeName+" ("+ev.Node+")")
+	}
+	if ev.Pkt != "" {
+		lines = append(lines, "Pkt: "+ev.Pkt)
+	}
+	if ev.Error != "" {
+		lines = append(lines, "Error: "+ev.Error)
+	}
+	if ev.Output != "" {
+		lines = append(lines, "", ev.Output)
+	}
+	var msg bytes.Buffer
+	msg.WriteString("Date: " + ev.When.Format(time.RFC1123Z) + "\n")
+	if _, err := io.Copy(&msg, newNotification(
+		&FromToJSON{From: n.From, To: n.To},
+		ev.Subject,
+		[]byte(strings.Join(lines, "\n")+"\n"),
+	)); err != nil {
+		return err
+	}
+	msg.WriteString("\n")
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	name := fmt.Sprintf(
+		"%d.M%dP%dQ%d.%s",
+		ev.When.Unix(), ev.When.Nanosecond()/1000, os.Getpid(),
+		atomic.AddUint64(&maildirCtr, 1),
+		strings.NewReplacer("/", "\\057", ":", "\\072").Replace(hostname),
+	)
+	tmpPath := filepath.Join(n.Maildir, "tmp", name)
+	fd, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, os.FileMode(0600))
+	if err != nil {
+		return err
+	}
+	if _, err = fd.Write(msg.Bytes()); err != nil {
+		fd.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err = fd.Sync(); err != nil {
+		fd.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err = fd.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err = os.Rename(tmpPath, filepath.Join(n.Maildir, "new", name)); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return DirSync(filepath.Join(n.Maildir, "new"))
+}
+
+type notifySub struct {
+	events map[string]struct{}
+	c      chan NotifyEvent
+}
+
+// Subscribe to events inside the process. Empty events list means all
+// of them. Events are dropped if the channel is full. Returned function
+// cancels the subscription and closes the channel.
+func (ctx *Ctx) Subscribe(size int, events ...string) (<-chan NotifyEvent, func()) {
+	sub := &notifySub{c: make(chan NotifyEvent, size)}
+	if len(events) > 0 {
+		sub.events = make(map[string]struct{}, len(events))
+		for _, ev := range events {
+			sub.events[ev] = struct{}{}
+		}
+	}
+	ctx.subsLock.Lock()
+	if ctx.subs == nil {
+		ctx.subs = make(map[*notifySub]struct{})
+	}
+	ctx.subs[sub] = struct{}{}
+	ctx.subsLock.Unlock()
+	var once sync.Once
+	return sub.c, func() {
+		once.Do(func() {
+			ctx.subsLock.Lock()
+			delete(ctx.subs, sub)
+			close(sub.c)
+			ctx.subsLock.Unlock()
+		})
+	}
+}
+
+func (ctx *Ctx) notifySubs(ev NotifyEvent) {
+	ctx.subsLock.Lock()
+	defer ctx.subsLock.Unlock()
+	for sub := range ctx.subs {
+		if sub.events != nil {
+			if _, exists := sub.events[ev.Type]; !exists {
+				continue
+			}
+		}
+		select {
+		case sub.c <- ev:
+		default:
+			ctx.LogD("notify-drop", LEs{{"Type", ev.Type}}, func(les LEs) string {
+				return "Subscriber is too slow, dropping " + ev.Type
+			})
+		}
+	}
+}
+
+// Send event to all interested notifiers and subscribers. Delivery
+// failures are only logged.
+func (ctx *Ctx) Notify(ev NotifyEvent) {
+	ev.When = time.Now().UTC()
+	ev.Self = ctx.SelfId.String()
+	ctx.notifySubs(ev)
+	for _, n := range ctx.Notifiers {
+		if !n.wants(ev.Type) {
+			continue
+		}
+		if err := n.deliver(&ev); err != nil {
+			ctx.LogE("notify", LEs{
+				{"Type", ev.Type}, {"Notifier", n.String()},
+			}, err, func(les LEs) string {
+				return fmt.Sprintf("Notifying %s about %s", n, ev.Type)
+			})
+		}
+	}
+}
+
+// Notify about event related to the node's packet.
+func (ctx *Ctx) notifyPkt(
+	typ string,
+	nodeId *NodeId,
+	pktName, subject string,
+	output []byte,
+	err error,
+) {
+	ev := NotifyEvent{Type: typ, Pkt: pktName, Subject: subject, Output: string(output)}
+	if nodeId != nil {
+		ev.Node = nodeId.String()
+		ev.NodeName = ctx.NodeName(nodeId)
+	}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	ctx.Notify(ev)
+}
+
+// Notify about exceeded transit quota, ignoring other denial reasons.
+// Denied packets stay in the inbound queue and are retried on each toss
+// cycle, so notification is sent only once per sender per day.
+func (ctx *Ctx) notifyTransitQuota(sender *Node, pktName string, errQuota error) {
+	if !jobFailureTransient(errQuota) {
+		return
+	}
+	ctx.transitLock.Lock()
+	st, err := ctx.dailyStateLoad(sender.Id, TransitNotifiedFile)
+	notified := st != nil && st.Pkts > 0
+	if err == nil && !notified {
+		st.Pkts++
+		err = ctx.dailyStateSave(sender.Id, TransitNotifiedFile, st)
+	}
+	ctx.transitLock.Unlock()
+	if err != nil {
+		ctx.LogE("notify-quota", LEs{{"Node", sender.Id}}, err, func(les LEs) string {
+			return "Saving transit quota notification state of " + sender.Name
+		})
+		return
+	}
+	if notified {
+		return
+	}
+	ctx.notifyPkt(
+		NotifyEventQuota, sender.Id, pktName,
+		fmt.Sprintf("Transit quota of %s is exceeded", sender.Name),
+		nil, errQuota,
+	)
+}
@@ -0,0 +1,224 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotifyBackends(t *testing.T) {
+	spool, err := ioutil.TempDir("", "testnotify")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(spool)
+
+	var events []NotifyEvent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var ev NotifyEvent
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		events = append(events, ev)
+	}))
+	defer srv.Close()
+
+	cmdOut := filepath.Join(spool, "cmd.out")
+	maildir := filepath.Join(spool, "Maildir")
+	mkNotifier := func(cfg NotifierJSON) *Notifier {
+		n, err := NewNotifier(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	nodeOur, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := Ctx{
+		Spool:   spool,
+		Self:    nodeOur,
+		SelfId:  nodeOur.Id,
+		Neigh:   make(map[NodeId]*Node),
+		LogPath: filepath.Join(spool, "log.log"),
+		Debug:   TDebug,
+		Quiet:   true,
+		Notifiers: []*Notifier{
+			mkNotifier(NotifierJSON{Webhook: &srv.URL}),
+			mkNotifier(NotifierJSON{
+				Events: []string{NotifyEventQuarantine},
+				Cmd:    []string{"/bin/sh", "-c", "cat >> " + cmdOut + " ; echo >> " + cmdOut},
+			}),
+			mkNotifier(NotifierJSON{
+				Events:  []string{NotifyEventFile, NotifyEventQuota},
+				Maildir: &maildir,
+			}),
+		},
+	}
+	ctx.Neigh[*nodeOur.Id] = nodeOur.Their()
+
+	ctx.notifyPkt(NotifyEventFile, nodeOur.Id, "PKT", "File from self", nil, nil)
+	ctx.notifyPkt(
+		NotifyEventQuarantine, nodeOur.Id, "PKT", "Quarantined",
+		[]byte("output"), TransitQuotaPkts,
+	)
+
+	if len(events) != 2 ||
+		events[0].Type != NotifyEventFile ||
+		events[1].Type != NotifyEventQuarantine ||
+		events[1].Output != "output" ||
+		events[1].Error != TransitQuotaPkts.Error() ||
+		events[1].Node != nodeOur.Id.String() {
+		t.Fatalf("unexpected webhook events: %+v", events)
+	}
+
+	data, err := ioutil.ReadFile(cmdOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 {
+		t.Fatal("unexpected command invocations:", lines)
+	}
+	var ev NotifyEvent
+	if err = json.Unmarshal([]byte(lines[0]), &ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != NotifyEventQuarantine || ev.Pkt != "PKT" {
+		t.Fatalf("unexpected command event: %+v", ev)
+	}
+
+	mails := dirFiles(filepath.Join(maildir, "new"))
+	if len(mails) != 1 || len(dirFiles(filepath.Join(maildir, "tmp"))) != 0 {
+		t.Fatal("unexpected maildir state:", mails)
+	}
+	data, err = ioutil.ReadFile(filepath.Join(maildir, "new", mails[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "Subject: ") {
+		t.Fatal("no subject in message:", string(data))
+	}
+
+	// Failing webhook does not prevent other deliveries
+	srv.Close()
+	ctx.notifyPkt(NotifyEventQuota, nodeOur.Id, "", "Quota", nil, TransitQuotaBytes)
+	if len(dirFiles(filepath.Join(maildir, "new"))) != 2 {
+		t.Fatal("maildir delivery is not done")
+	}
+}
+
+func TestNotifyCmdTimeout(t *testing.T) {
+	n := Notifier{Cmd: []string{"sleep", "10"}, Timeout: 100 * time.Millisecond}
+	started := time.Now()
+	if err := n.deliver(&NotifyEvent{Type: NotifyEventFile}); err == nil {
+		t.Fatal("hanging command is not failed")
+	}
+	if time.Since(started) > 5*time.Second {
+		t.Fatal("hanging command is not killed in time")
+	}
+}
+
+func TestNotifyTransitQuotaOnce(t *testing.T) {
+	spool, err := ioutil.TempDir("", "testnotify")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(spool)
+	nodeOur, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []NotifyEvent
+	ctx := Ctx{
+		Spool:   spool,
+		Self:    nodeOur,
+		SelfId:  nodeOur.Id,
+		Neigh:   make(map[NodeId]*Node),
+		LogPath: filepath.Join(spool, "log.log"),
+		Debug:   TDebug,
+		Quiet:   true,
+	}
+	sub, unsubscribe := ctx.Subscribe(8, NotifyEventQuota)
+	defer unsubscribe()
+	node := nodeOur.Their()
+	ctx.Neigh[*nodeOur.Id] = node
+	collect := func() {
+		for {
+			select {
+			case ev := <-sub:
+				events = append(events, ev)
+			default:
+				return
+			}
+		}
+	}
+
+	ctx.notifyTransitQuota(node, "PKT1", TransitDstDenied)
+	ctx.notifyTransitQuota(node, "PKT1", TransitQuotaPkts)
+	ctx.notifyTransitQuota(node, "PKT1", TransitQuotaPkts)
+	ctx.notifyTransitQuota(node, "PKT2", TransitQuotaBytes)
+	collect()
+	if len(events) != 1 || events[0].Type != NotifyEventQuota || events[0].Pkt != "PKT1" {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+
+	// Next day it is notified again
+	if err = ctx.dailyStateSave(node.Id, TransitNotifiedFile, &TransitState{
+		Day: "2000-01-01", Pkts: 1,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	ctx.notifyTransitQuota(node, "PKT3", TransitQuotaPkts)
+	collect()
+	if len(events) != 2 || events[1].Pkt != "PKT3" {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+}
+
+func TestNotifierCfg(t *testing.T) {
+	webhook := "ftp://example.com/"
+	if _, err := NewNotifier(NotifierJSON{Webhook: &webhook}); err == nil {
+		t.Fatal("non-HTTP webhook is accepted")
+	}
+	if _, err := NewNotifier(NotifierJSON{
+		Cmd: []string{"true"}, Events: []string{"unknown"},
+	}); err == nil {
+		t.Fatal("unknown event is accepted")
+	}
+	maildir := "/tmp/Maildir"
+	if _, err := NewNotifier(NotifierJSON{
+		Cmd: []string{"true"}, Maildir: &maildir,
+	}); err == nil {
+		t.Fatal("several backends are accepted")
+	}
+}
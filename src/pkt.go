@@ -45,6 +45,7 @@ const (
 	PktTypeExecFat PktType = iota
 	PktTypeArea    PktType = iota
 	PktTypeACK     PktType = iota
+	PktTypeRoute   PktType = iota
 
 	MaxPathSize = 1<<8 - 1
 
@@ -0,0 +1,128 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"errors"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	PlacementRename    = "rename"
+	PlacementOverwrite = "overwrite"
+	PlacementReject    = "reject"
+)
+
+var (
+	PlacementCollision = errors.New("file already exists")
+	PlacementEscape    = errors.New("placement escapes incoming directory")
+)
+
+// Where and how received file is placed inside incoming directory.
+type PlacementRule struct {
+	Pattern   string
+	Dir       string
+	Collision string
+}
+
+var placementDefault = PlacementRule{Pattern: "*", Collision: PlacementRename}
+
+// First rule matching file's destination path. Patterns without slash
+// are matched against the base name.
+func (node *Node) PlacementFind(dst string) *PlacementRule {
+	for _, rule := range node.Placement {
+		name := dst
+		if !strings.Contains(rule.Pattern, "/") {
+			name = path.Base(dst)
+		}
+		if matched, _ := path.Match(rule.Pattern, name); matched {
+			return rule
+		}
+	}
+	return &placementDefault
+}
+
+// Expand subdirectory template: %n is sender's name, %i is its
+// identifier, %Y, %m, %d are the current date's year, month and day.
+func placementExpand(tmpl string, sender *Node, now time.Time) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(tmpl); i++ {
+		if tmpl[i] != '%' {
+			b.WriteByte(tmpl[i])
+			continue
+		}
+		i++
+		if i == len(tmpl) {
+			return "", errors.New("unfinished placeholder")
+		}
+		switch tmpl[i] {
+		case 'n':
+			b.WriteString(sender.Name)
+		case 'i':
+			b.WriteString(sender.Id.String())
+		case 'Y':
+			b.WriteString(now.Format("2006"))
+		case 'm':
+			b.WriteString(now.Format("01"))
+		case 'd':
+			b.WriteString(now.Format("02"))
+		case '%':
+			b.WriteByte('%')
+		default:
+			return "", errors.New("unknown placeholder: %" + string(tmpl[i]))
+		}
+	}
+	dir := path.Clean(b.String())
+	if path.IsAbs(dir) || dir == ".." || strings.HasPrefix(dir, "../") {
+		return "", PlacementEscape
+	}
+	return dir, nil
+}
+
+// Path of the received file relative to incoming directory. Destination
+// leaving rule's directory is rejected.
+func (rule *PlacementRule) Path(dst string, sender *Node, now time.Time) (string, error) {
+	dir, err := placementExpand(rule.Dir, sender, now)
+	if err != nil {
+		return "", err
+	}
+	p := path.Clean(path.Join(dir, dst))
+	if path.IsAbs(p) || p == ".." || strings.HasPrefix(p, "../") ||
+		p == dir || !(dir == "." || strings.HasPrefix(p, dir+"/")) {
+		return "", PlacementEscape
+	}
+	return p, nil
+}
+
+// Run post-receive hook with the final path of the received file.
+func (ctx *Ctx) postReceive(sender *Node, nice uint8, dstPath string) ([]byte, error) {
+	cmdline := sender.PostReceive
+	cmd := exec.Command(cmdline[0], append(cmdline[1:], dstPath)...)
+	cmd.Env = append(
+		cmd.Env,
+		"NNCP_SELF="+ctx.Self.Id.String(),
+		"NNCP_SENDER="+sender.Id.String(),
+		"NNCP_NICE="+strconv.Itoa(int(nice)),
+		"NNCP_PATH="+dstPath,
+	)
+	return cmd.CombinedOutput()
+}
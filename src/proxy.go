@@ -0,0 +1,163 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+const (
+	ProxySOCKS5Prefix      = "socks5://"
+	ProxyHTTPConnectPrefix = "http-connect://"
+)
+
+// Is that address an proxied one: socks5://[USER:PASS@]PROXY:PORT/HOST:PORT
+// or http-connect://[USER:PASS@]PROXY:PORT/HOST:PORT.
+func IsProxyAddr(addr string) bool {
+	return strings.HasPrefix(addr, ProxySOCKS5Prefix) ||
+		strings.HasPrefix(addr, ProxyHTTPConnectPrefix)
+}
+
+type proxyAddr struct {
+	scheme string
+	user   *url.Userinfo
+	proxy  string
+	target string
+}
+
+func proxyAddrParse(addr string) (*proxyAddr, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, errors.New("proxy address is missing")
+	}
+	target := strings.TrimPrefix(u.Path, "/")
+	if target == "" {
+		return nil, errors.New("target address is missing")
+	}
+	if _, _, err = net.SplitHostPort(target); err != nil {
+		return nil, fmt.Errorf("invalid target address: %w", err)
+	}
+	return &proxyAddr{
+		scheme: u.Scheme,
+		user:   u.User,
+		proxy:  u.Host,
+		target: target,
+	}, nil
+}
+
+// Redacted address representation without the password, suitable for logging.
+func ProxyAddrRedacted(addr string) string {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return addr
+	}
+	return u.Redacted()
+}
+
+// Establish TCP connection through either SOCKS5 or HTTP CONNECT proxy.
+// Whole dialing and proxy negotiation must fit in timeout.
+func NewProxyConn(addr string, timeout time.Duration) (net.Conn, error) {
+	pa, err := proxyAddrParse(addr)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	switch pa.scheme {
+	case "socks5":
+		var auth *proxy.Auth
+		if pa.user != nil {
+			auth = &proxy.Auth{User: pa.user.Username()}
+			auth.Password, _ = pa.user.Password()
+		}
+		d, err := proxy.SOCKS5("tcp", pa.proxy, auth, dialer)
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		conn, err := d.(proxy.ContextDialer).DialContext(ctx, "tcp", pa.target)
+		if err != nil {
+			return nil, fmt.Errorf("socks5 %s: %w", pa.proxy, err)
+		}
+		return conn, nil
+	case "http-connect":
+		return httpConnect(dialer, pa, timeout)
+	}
+	return nil, errors.New("unknown proxy scheme: " + pa.scheme)
+}
+
+func httpConnect(dialer *net.Dialer, pa *proxyAddr, timeout time.Duration) (net.Conn, error) {
+	conn, err := dialer.Dial("tcp", pa.proxy)
+	if err != nil {
+		return nil, fmt.Errorf("http-connect %s: %w", pa.proxy, err)
+	}
+	if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: pa.target},
+		Host:   pa.target,
+		Header: make(http.Header),
+	}
+	if pa.user != nil {
+		password, _ := pa.user.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString(
+			[]byte(pa.user.Username()+":"+password),
+		))
+	}
+	if err = req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("http-connect %s: %w", pa.proxy, err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("http-connect %s: %w", pa.proxy, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("http-connect %s: %s", pa.proxy, resp.Status)
+	}
+	if br.Buffered() > 0 {
+		conn.Close()
+		return nil, fmt.Errorf("http-connect %s: unexpected data after response", pa.proxy)
+	}
+	if err = conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,357 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.cypherpunks.ru/recfile"
+)
+
+const (
+	QuarantineDir = "quarantine"
+	FailDir       = "fail"
+)
+
+var (
+	DefaultQuarantineRetries = 3
+	DefaultQuarantineBackoff = time.Minute
+	QuarantineBackoffMax     = 24 * time.Hour
+)
+
+// Failed packets are retried with exponential backoff and then moved
+// to the quarantine.
+type Quarantine struct {
+	Retries int
+	Backoff time.Duration
+}
+
+// Error of the exec handler, with its exit code and output.
+type ExecFailure struct {
+	Err      error
+	ExitCode int
+	Output   []byte
+}
+
+func (e *ExecFailure) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExecFailure) Unwrap() error {
+	return e.Err
+}
+
+// Record of the packet's processing failures.
+type JobFailure struct {
+	Attempts int
+	Last     time.Time
+	Reason   string
+	ExitCode *int
+	Output   string
+}
+
+func JobPath2Fail(jobPath string) string {
+	return filepath.Join(filepath.Dir(jobPath), FailDir, filepath.Base(jobPath))
+}
+
+// Load failure record. Nil is returned if there is none.
+func JobFailureLoad(path string) (*JobFailure, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer fd.Close()
+	m, err := recfile.NewReader(fd).NextMap()
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	f := JobFailure{Reason: m["Reason"], Output: m["Output"]}
+	if f.Attempts, err = strconv.Atoi(m["Attempts"]); err != nil {
+		return nil, err
+	}
+	if f.Last, err = time.Parse(time.RFC3339Nano, m["Last"]); err != nil {
+		return nil, err
+	}
+	if s, exists := m["ExitCode"]; exists {
+		code, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, err
+		}
+		f.ExitCode = &code
+	}
+	return &f, nil
+}
+
+func jobFailureSave(path string, f *JobFailure) error {
+	var buf bytes.Buffer
+	w := recfile.NewWriter(&buf)
+	if _, err := w.RecordStart(); err != nil {
+		return err
+	}
+	fields := []recfile.Field{
+		{Name: "Attempts", Value: strconv.Itoa(f.Attempts)},
+		{Name: "Last", Value: f.Last.UTC().Format(time.RFC3339Nano)},
+		{Name: "Reason", Value: strings.ReplaceAll(f.Reason, "\n", " ")},
+	}
+	if f.ExitCode != nil {
+		fields = append(fields, recfile.Field{
+			Name: "ExitCode", Value: strconv.Itoa(*f.ExitCode),
+		})
+	}
+	if _, err := w.WriteFields(fields...); err != nil {
+		return err
+	}
+	if f.Output != "" {
+		if _, err := w.WriteFieldMultiline(
+			"Output", strings.Split(f.Output, "\n"),
+		); err != nil {
+			return err
+		}
+	}
+	dir := filepath.Dir(path)
+	if err := ensureDir(dir); err != nil {
+		return err
+	}
+	tmp, err := TempFile(dir, "fail")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return DirSync(dir)
+}
+
+func (q *Quarantine) backoff(attempts int) time.Duration {
+	backoff := q.Backoff
+	for i := 1; i < attempts && backoff < QuarantineBackoffMax; i++ {
+		backoff *= 2
+	}
+	if backoff > QuarantineBackoffMax {
+		backoff = QuarantineBackoffMax
+	}
+	return backoff
+}
+
+// Is failed job's next retry time not reached yet.
+func (ctx *Ctx) jobBackoff(job Job) bool {
+	if ctx.Quarantine == nil {
+		return false
+	}
+	f, err := JobFailureLoad(JobPath2Fail(job.Path))
+	if err != nil || f == nil {
+		return false
+	}
+	return time.Now().Before(f.Last.Add(ctx.Quarantine.backoff(f.Attempts)))
+}
+
+// Errors that are expected to go away by themselves.
+func jobFailureTransient(err error) bool {
+	return errors.Is(err, TransitQuotaBytes) || errors.Is(err, TransitQuotaPkts)
+}
+
+// Record job's processing failure, moving it to the quarantine if
+// retries are exhausted.
+func (ctx *Ctx) jobFailed(job Job, les LEs, jobErr error) {
+	if ctx.Quarantine == nil || jobFailureTransient(jobErr) {
+		return
+	}
+	if _, err := os.Stat(job.Path); err != nil {
+		return
+	}
+	failPath := JobPath2Fail(job.Path)
+	f, err := JobFailureLoad(failPath)
+	if err != nil || f == nil {
+		f = &JobFailure{}
+	}
+	f.Attempts++
+	f.Last = time.Now()
+	f.Reason = jobErr.Error()
+	f.ExitCode = nil
+	f.Output = ""
+	var execErr *ExecFailure
+	if errors.As(jobErr, &execErr) {
+		code := execErr.ExitCode
+		f.ExitCode = &code
+		f.Output = strings.TrimRight(string(execErr.Output), "\n")
+	}
+	les = append(les, LE{"Attempts", f.Attempts})
+	pktName := filepath.Base(job.Path)
+	logMsg := func(les LEs) string {
+		return fmt.Sprintf(
+			"Tossing %s/%s: failed %d times",
+			ctx.NodeName(job.PktEnc.Sender), pktName, f.Attempts,
+		)
+	}
+	if err = jobFailureSave(failPath, f); err != nil {
+		ctx.LogE("rx-fail", les, err, logMsg)
+		return
+	}
+	if f.Attempts <= ctx.Quarantine.Retries {
+		ctx.LogD("rx-fail", les, func(les LEs) string {
+			return logMsg(les) + ", retry in " + ctx.Quarantine.backoff(f.Attempts).String()
+		})
+		return
+	}
+	nodeDir := filepath.Dir(filepath.Dir(job.Path))
+	dstPath := filepath.Join(nodeDir, QuarantineDir, pktName)
+	if err = ensureDir(filepath.Dir(dstPath), FailDir); err != nil {
+		ctx.LogE("rx-quarantine", les, err, logMsg)
+		return
+	}
+	if err = os.Rename(job.Path, dstPath); err != nil {
+		ctx.LogE("rx-quarantine", les, err, logMsg)
+		return
+	}
+	if err = os.Rename(failPath, JobPath2Fail(dstPath)); err != nil {
+		ctx.LogE("rx-quarantine", les, err, logMsg)
+	}
+	os.Remove(JobPath2Hdr(job.Path))
+	if err = DirSync(filepath.Dir(dstPath)); err != nil {
+		ctx.LogE("rx-quarantine", les, err, logMsg)
+		return
+	}
+	ctx.LogI("rx-quarantine", append(les, LE{"Reason", f.Reason}), func(les LEs) string {
+		return logMsg(les) + ", quarantined: " + f.Reason
+	})
+	ctx.notifyPkt(
+		NotifyEventQuarantine, job.PktEnc.Sender, pktName,
+		fmt.Sprintf(
+			"Quarantined: %s/%s",
+			ctx.NodeName(job.PktEnc.Sender), pktName,
+		), []byte(f.Output), jobErr,
+	)
+}
+
+// Forget job's failures after its successful processing.
+func (ctx *Ctx) jobSucceeded(job Job) {
+	if ctx.Quarantine != nil {
+		os.Remove(JobPath2Fail(job.Path))
+	}
+}
+
+type QuarantineJob struct {
+	Node    *NodeId
+	PktName string
+	Size    int64
+	Failure *JobFailure
+}
+
+func (ctx *Ctx) quarantineDir(nodeId *NodeId) string {
+	return filepath.Join(ctx.Spool, nodeId.String(), QuarantineDir)
+}
+
+func (ctx *Ctx) quarantinePath(nodeId *NodeId, pktName string) string {
+	return filepath.Join(ctx.quarantineDir(nodeId), pktName)
+}
+
+// Quarantined packets of the node, oldest failed first.
+func (ctx *Ctx) QuarantineJobs(nodeId *NodeId) ([]*QuarantineJob, error) {
+	fis, err := ioutil.ReadDir(ctx.quarantineDir(nodeId))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	jobs := make([]*QuarantineJob, 0, len(fis))
+	for _, fi := range fis {
+		if fi.IsDir() || len(fi.Name()) != Base32Encoded32Len {
+			continue
+		}
+		job, err := ctx.QuarantineJob(nodeId, fi.Name())
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].Failure == nil || jobs[j].Failure == nil {
+			return jobs[i].PktName < jobs[j].PktName
+		}
+		return jobs[i].Failure.Last.Before(jobs[j].Failure.Last)
+	})
+	return jobs, nil
+}
+
+func (ctx *Ctx) QuarantineJob(nodeId *NodeId, pktName string) (*QuarantineJob, error) {
+	pth := ctx.quarantinePath(nodeId, pktName)
+	fi, err := os.Stat(pth)
+	if err != nil {
+		return nil, err
+	}
+	f, err := JobFailureLoad(JobPath2Fail(pth))
+	if err != nil {
+		return nil, err
+	}
+	return &QuarantineJob{Node: nodeId, PktName: pktName, Size: fi.Size(), Failure: f}, nil
+}
+
+// Move quarantined packet back to the inbound queue, forgetting its
+// failures.
+func (ctx *Ctx) QuarantineRetry(nodeId *NodeId, pktName string) error {
+	pth := ctx.quarantinePath(nodeId, pktName)
+	rxDir := filepath.Join(ctx.Spool, nodeId.String(), string(TRx))
+	if err := ensureDir(rxDir); err != nil {
+		return err
+	}
+	if err := os.Rename(pth, filepath.Join(rxDir, pktName)); err != nil {
+		return err
+	}
+	if err := os.Remove(JobPath2Fail(pth)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return DirSync(rxDir)
+}
+
+func (ctx *Ctx) QuarantineRemove(nodeId *NodeId, pktName string) error {
+	pth := ctx.quarantinePath(nodeId, pktName)
+	if err := os.Remove(pth); err != nil {
+		return err
+	}
+	if err := os.Remove(JobPath2Fail(pth)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestQuarantine(t *testing.T) {
+	spool, err := ioutil.TempDir("", "testquarantine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(spool)
+	nodeOur, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := Ctx{
+		Spool:      spool,
+		Self:       nodeOur,
+		SelfId:     nodeOur.Id,
+		Neigh:      make(map[NodeId]*Node),
+		Alias:      make(map[string]*NodeId),
+		LogPath:    filepath.Join(spool, "log.log"),
+		Debug:      TDebug,
+		Quarantine: &Quarantine{Retries: 1},
+	}
+	ctx.Neigh[*nodeOur.Id] = nodeOur.Their()
+	if err = ctx.TxExec(
+		ctx.Neigh[*nodeOur.Id], DefaultNiceExec, DefaultNiceExec,
+		"failing", nil, strings.NewReader("BODY\n"),
+		1<<15, MaxFileSize, false, nil,
+	); err != nil {
+		t.Fatal(err)
+	}
+	rxPath := filepath.Join(spool, nodeOur.Id.String(), string(TRx))
+	os.Rename(filepath.Join(spool, nodeOur.Id.String(), string(TTx)), rxPath)
+	toss := func() {
+		ctx.Toss(nodeOur.Id, TRx, DefaultNiceExec,
+			false, false, false, false, false, false, false, false)
+	}
+	ctx.Neigh[*nodeOur.Id].Exec = map[string][]string{"failing": {
+		"/bin/sh", "-c", "echo something bad ; exit 3",
+	}}
+
+	toss()
+	pktNames := dirFiles(rxPath)
+	var pktName string
+	for _, n := range pktNames {
+		if len(n) == Base32Encoded32Len {
+			pktName = n
+		}
+	}
+	if pktName == "" {
+		t.Fatal("packet is not left after the first failure")
+	}
+	f, err := JobFailureLoad(JobPath2Fail(filepath.Join(rxPath, pktName)))
+	if err != nil || f == nil || f.Attempts != 1 {
+		t.Fatal("failure is not recorded", f, err)
+	}
+
+	toss()
+	jobs, err := ctx.QuarantineJobs(nodeOur.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].PktName != pktName {
+		t.Fatal("packet is not quarantined")
+	}
+	f = jobs[0].Failure
+	if f == nil || f.Attempts != 2 || f.ExitCode == nil || *f.ExitCode != 3 ||
+		f.Output != "something bad" {
+		t.Fatalf("unexpected failure record: %+v", f)
+	}
+	if _, err = os.Stat(filepath.Join(rxPath, pktName)); !os.IsNotExist(err) {
+		t.Fatal("quarantined packet is left in inbound queue")
+	}
+
+	if err = ctx.QuarantineRetry(nodeOur.Id, pktName); err != nil {
+		t.Fatal(err)
+	}
+	ctx.Neigh[*nodeOur.Id].Exec["failing"] = []string{"/bin/sh", "-c", "cat >/dev/null"}
+	toss()
+	if _, err = os.Stat(filepath.Join(rxPath, pktName)); !os.IsNotExist(err) {
+		t.Fatal("retried packet is not tossed")
+	}
+	if jobs, _ = ctx.QuarantineJobs(nodeOur.Id); len(jobs) != 0 {
+		t.Fatal("quarantine is not empty")
+	}
+}
@@ -0,0 +1,224 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	RexecDir = "rexec"
+
+	// Built-in exec handle receiving replies to remote executions
+	RexecHandle = "nncp-rexec"
+
+	rexecIdSize    = 16
+	rexecReplySuff = ".reply"
+)
+
+type RexecReply struct {
+	ExitCode int
+	Stdout   []byte
+	Stderr   []byte
+}
+
+func rexecIdValid(id string) bool {
+	raw, err := Base32Codec.DecodeString(id)
+	return err == nil && len(raw) == rexecIdSize
+}
+
+func (ctx *Ctx) rexecDir() string {
+	return filepath.Join(ctx.Spool, ctx.SelfId.String(), RexecDir)
+}
+
+func (ctx *Ctx) rexecPath(id string) string {
+	return filepath.Join(ctx.rexecDir(), id)
+}
+
+func (ctx *Ctx) rexecWrite(name string, r io.Reader) error {
+	dir := ctx.rexecDir()
+	if err := ensureDir(dir); err != nil {
+		return err
+	}
+	tmp, err := TempFile(dir, "rexec")
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = os.Rename(tmp.Name(), filepath.Join(dir, name)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return DirSync(dir)
+}
+
+// Send exec packet with the correlation identifier prepended to the
+// arguments and remember the request. Remote handle has to use rexec
+// reply policy. Returned identifier is used to wait for the reply.
+func (ctx *Ctx) TxRexec(
+	node *Node,
+	nice, replyNice uint8,
+	handle string,
+	args []string,
+	in io.Reader,
+	minSize, maxSize int64,
+	noCompress bool,
+) (string, error) {
+	idRaw := make([]byte, rexecIdSize)
+	if _, err := rand.Read(idRaw); err != nil {
+		return "", err
+	}
+	id := Base32Codec.EncodeToString(idRaw)
+	if err := ctx.rexecWrite(id, strings.NewReader(node.Id.String())); err != nil {
+		return "", err
+	}
+	if err := ctx.TxExec(
+		node, nice, replyNice, handle, append([]string{id}, args...),
+		in, minSize, maxSize, noCompress, nil,
+	); err != nil {
+		os.Remove(ctx.rexecPath(id))
+		return "", err
+	}
+	return id, nil
+}
+
+// Send handle's exit code, stdout and stderr back to the rexec sender.
+func (ctx *Ctx) rexecReply(
+	sender *Node,
+	nice uint8,
+	id string,
+	exitCode int,
+	stdout *os.File,
+	stderr []byte,
+) error {
+	node := ctx.Neigh[*sender.Id]
+	if node == nil {
+		return errors.New("reply to unknown node")
+	}
+	fi, err := stdout.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err = stdout.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return ctx.TxExec(
+		node, nice, nice, RexecHandle,
+		[]string{id, strconv.Itoa(exitCode), strconv.FormatInt(fi.Size(), 10)},
+		io.MultiReader(stdout, bytes.NewReader(stderr)),
+		0, MaxFileSize, false, nil,
+	)
+}
+
+// Save rexec reply, if it corresponds to our request to that node.
+func (ctx *Ctx) rexecReplySave(sender *Node, args []string, body io.Reader) error {
+	if len(args) != 3 || !rexecIdValid(args[0]) {
+		return errors.New("invalid rexec reply")
+	}
+	id := args[0]
+	nodeId, err := ioutil.ReadFile(ctx.rexecPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errors.New("unknown rexec id")
+		}
+		return err
+	}
+	if string(nodeId) != sender.Id.String() {
+		return errors.New("rexec reply from unexpected node")
+	}
+	exitCode, err := strconv.Atoi(args[1])
+	if err != nil {
+		return err
+	}
+	stdoutSize, err := strconv.ParseUint(args[2], 10, 63)
+	if err != nil {
+		return err
+	}
+	return ctx.rexecWrite(id+rexecReplySuff, io.MultiReader(
+		strings.NewReader(fmt.Sprintf("%d %d\n", exitCode, stdoutSize)),
+		body,
+	))
+}
+
+// Load rexec reply. Nil is returned if it has not arrived yet.
+func (ctx *Ctx) RexecReplyLoad(id string) (*RexecReply, error) {
+	if !rexecIdValid(id) {
+		return nil, errors.New("invalid rexec id")
+	}
+	fd, err := os.Open(ctx.rexecPath(id + rexecReplySuff))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer fd.Close()
+	br := bufio.NewReader(fd)
+	var reply RexecReply
+	var stdoutSize int64
+	if _, err = fmt.Fscanf(br, "%d %d\n", &reply.ExitCode, &stdoutSize); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	if stdoutSize > int64(len(data)) {
+		return nil, errors.New("truncated rexec reply")
+	}
+	reply.Stdout, reply.Stderr = data[:stdoutSize], data[stdoutSize:]
+	return &reply, nil
+}
+
+// Forget about the request and its reply.
+func (ctx *Ctx) RexecRemove(id string) error {
+	if !rexecIdValid(id) {
+		return errors.New("invalid rexec id")
+	}
+	if err := os.Remove(ctx.rexecPath(id + rexecReplySuff)); err != nil &&
+		!os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(ctx.rexecPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return DirSync(ctx.rexecDir())
+}
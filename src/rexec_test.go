@@ -0,0 +1,189 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRexec(t *testing.T) {
+	spool, err := ioutil.TempDir("", "testrexec")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(spool)
+	nodeOur, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := Ctx{
+		Spool:   spool,
+		Self:    nodeOur,
+		SelfId:  nodeOur.Id,
+		Neigh:   make(map[NodeId]*Node),
+		Alias:   make(map[string]*NodeId),
+		LogPath: filepath.Join(spool, "log.log"),
+		Debug:   TDebug,
+	}
+	node := nodeOur.Their()
+	ctx.Neigh[*nodeOur.Id] = node
+	node.Exec = map[string][]string{
+		"handle": {"/bin/sh", "-c", "cat ; echo $0 ; echo oops >&2 ; exit 3"},
+	}
+	node.ExecPolicy = map[string]*ExecPolicy{"handle": {Reply: ExecReplyRexec}}
+	txPath := filepath.Join(spool, nodeOur.Id.String(), string(TTx))
+	rxPath := filepath.Join(spool, nodeOur.Id.String(), string(TRx))
+	toss := func() {
+		os.RemoveAll(rxPath)
+		if err := os.Rename(txPath, rxPath); err != nil {
+			t.Fatal(err)
+		}
+		ctx.Toss(nodeOur.Id, TRx, DefaultNiceExec,
+			false, false, false, false, false, false, false, false)
+	}
+
+	var ids []string
+	for _, arg := range []string{"arg0", "arg1"} {
+		id, err := ctx.TxRexec(
+			node, DefaultNiceExec, DefaultNiceExec, "handle", []string{arg},
+			strings.NewReader("BODY\n"), 0, MaxFileSize, false,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	toss()
+	if len(dirFiles(rxPath)) != 0 {
+		t.Fatal("requests are not processed")
+	}
+	if reply, err := ctx.RexecReplyLoad(ids[0]); err != nil || reply != nil {
+		t.Fatal("reply arrived too early:", err)
+	}
+	toss()
+	if len(dirFiles(rxPath)) != 0 {
+		t.Fatal("replies are not processed")
+	}
+	for i, id := range ids {
+		reply, err := ctx.RexecReplyLoad(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply == nil {
+			t.Fatal("no reply")
+		}
+		if reply.ExitCode != 3 ||
+			string(reply.Stdout) != "BODY\narg"+string(rune('0'+i))+"\n" ||
+			string(reply.Stderr) != "oops\n" {
+			t.Fatalf("unexpected reply: %+v", reply)
+		}
+		if err = ctx.RexecRemove(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Reply to forgotten request is rejected
+	if _, err = ctx.TxRexec(
+		node, DefaultNiceExec, DefaultNiceExec, "handle", nil,
+		strings.NewReader(""), 0, MaxFileSize, false,
+	); err != nil {
+		t.Fatal(err)
+	}
+	toss()
+	ids, err = filepath.Glob(filepath.Join(ctx.rexecDir(), "*"))
+	if err != nil || len(ids) != 1 {
+		t.Fatal("unexpected requests:", ids)
+	}
+	if err = os.Remove(ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	toss()
+	if len(dirFiles(rxPath)) != 1 {
+		t.Fatal("unexpected reply is accepted")
+	}
+}
+
+func TestRexecHandlerFailure(t *testing.T) {
+	spool, err := ioutil.TempDir("", "testrexec")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(spool)
+	nodeOur, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := Ctx{
+		Spool:   spool,
+		Self:    nodeOur,
+		SelfId:  nodeOur.Id,
+		Neigh:   make(map[NodeId]*Node),
+		Alias:   make(map[string]*NodeId),
+		LogPath: filepath.Join(spool, "log.log"),
+		Debug:   TDebug,
+	}
+	node := nodeOur.Their()
+	ctx.Neigh[*nodeOur.Id] = node
+	node.Exec = map[string][]string{
+		"handle": {"/bin/sh", "-c", "echo partial ; sleep 10"},
+	}
+	node.ExecPolicy = map[string]*ExecPolicy{"handle": {
+		Reply:   ExecReplyRexec,
+		Timeout: 100 * time.Millisecond,
+	}}
+	txPath := filepath.Join(spool, nodeOur.Id.String(), string(TTx))
+	rxPath := filepath.Join(spool, nodeOur.Id.String(), string(TRx))
+	toss := func() {
+		os.RemoveAll(rxPath)
+		if err := os.Rename(txPath, rxPath); err != nil {
+			t.Fatal(err)
+		}
+		ctx.Toss(nodeOur.Id, TRx, DefaultNiceExec,
+			false, false, false, false, false, false, false, false)
+	}
+
+	id, err := ctx.TxRexec(
+		node, DefaultNiceExec, DefaultNiceExec, "handle", nil,
+		strings.NewReader(strings.Repeat("BODY\n", 1<<16)), 0, MaxFileSize, false,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	toss()
+	if len(dirFiles(rxPath)) != 0 {
+		t.Fatal("failed request is not retired")
+	}
+	toss()
+	reply, err := ctx.RexecReplyLoad(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply == nil {
+		t.Fatal("no reply on handler failure")
+	}
+	if reply.ExitCode != -1 ||
+		string(reply.Stdout) != "partial\n" ||
+		!strings.HasPrefix(string(reply.Stderr), "nncp: ") {
+		t.Fatalf("unexpected reply: %+v", reply)
+	}
+}
@@ -0,0 +1,384 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	xdr "github.com/davecgh/go-xdr/xdr2"
+	"golang.org/x/crypto/ed25519"
+)
+
+const (
+	RouteAdvertFile = "route.advert"
+
+	// Cost treated as unreachable destination, like in RIP.
+	RouteCostInf = 16
+
+	RouteAdvertMaxSize = 1 << 20
+)
+
+var (
+	DefaultRouteInterval = time.Hour
+	DefaultRouteNice     = uint8(255)
+)
+
+type RouteEntry struct {
+	Dst  NodeId
+	Cost uint32
+}
+
+type RouteAdvertTbs struct {
+	Magic   [8]byte
+	Origin  NodeId
+	Seq     uint64
+	Entries []RouteEntry
+}
+
+// Reachability advertisement, signed by its origin. Seq is the UNIX
+// time of its generation: newer advertisement replaces the older one.
+type RouteAdvert struct {
+	Magic   [8]byte
+	Origin  NodeId
+	Seq     uint64
+	Entries []RouteEntry
+	Sign    [ed25519.SignatureSize]byte
+}
+
+type Route struct {
+	Dst     *NodeId
+	Via     *NodeId
+	Cost    uint32
+	Updated time.Time
+}
+
+func (ctx *Ctx) routeAdvertPath(nodeId *NodeId) string {
+	return filepath.Join(ctx.Spool, nodeId.String(), RouteAdvertFile)
+}
+
+func (advert *RouteAdvert) tbs() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := xdr.Marshal(&buf, RouteAdvertTbs{
+		Magic:   advert.Magic,
+		Origin:  advert.Origin,
+		Seq:     advert.Seq,
+		Entries: advert.Entries,
+	}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func RouteAdvertParse(data []byte, signer *Node) (*RouteAdvert, error) {
+	var advert RouteAdvert
+	if _, err := xdr.Unmarshal(bytes.NewReader(data), &advert); err != nil {
+		return nil, err
+	}
+	if advert.Magic != MagicNNCPRv1.B {
+		return nil, BadMagic
+	}
+	if advert.Origin != *signer.Id {
+		return nil, errors.New("advertisement is not from its sender")
+	}
+	tbs, err := advert.tbs()
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(signer.SignPub, tbs, advert.Sign[:]) {
+		return nil, errors.New("invalid advertisement signature")
+	}
+	return &advert, nil
+}
+
+// Load the latest advertisement received from routing neighbour.
+// Nil is returned if there is none.
+func (ctx *Ctx) RouteAdvertLoad(node *Node) (*RouteAdvert, error) {
+	data, err := ioutil.ReadFile(ctx.routeAdvertPath(node.Id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return RouteAdvertParse(data, node)
+}
+
+// Remember the advertisement, if it is newer than the known one.
+// Returns false if it was outdated.
+func (ctx *Ctx) RouteAdvertSave(node *Node, data []byte) (bool, error) {
+	advert, err := RouteAdvertParse(data, node)
+	if err != nil {
+		return false, err
+	}
+	prev, err := ctx.RouteAdvertLoad(node)
+	if err == nil && prev != nil && prev.Seq >= advert.Seq {
+		return false, nil
+	}
+	dir := filepath.Join(ctx.Spool, node.Id.String())
+	if err = ensureDir(dir); err != nil {
+		return false, err
+	}
+	tmp, err := TempFile(dir, "route")
+	if err != nil {
+		return false, err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return false, err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return false, err
+	}
+	if err = os.Rename(tmp.Name(), ctx.routeAdvertPath(node.Id)); err != nil {
+		os.Remove(tmp.Name())
+		return false, err
+	}
+	return true, DirSync(dir)
+}
+
+// Compute routing table from the received non-expired advertisements of
+// routing neighbours and their link costs. Only known nodes are present.
+// Table is cached until any advertisement is updated or expired.
+func (ctx *Ctx) RouteTable() map[NodeId]*Route {
+	stamps := make(map[NodeId]time.Time)
+	for _, node := range ctx.Neigh {
+		if node.RouteCost == 0 {
+			continue
+		}
+		if fi, err := os.Stat(ctx.routeAdvertPath(node.Id)); err == nil {
+			stamps[*node.Id] = fi.ModTime()
+		}
+	}
+	ctx.routeLock.Lock()
+	defer ctx.routeLock.Unlock()
+	if ctx.routeTable != nil && time.Now().Before(ctx.routeExpire) &&
+		len(stamps) == len(ctx.routeStamps) {
+		fresh := true
+		for nodeId, stamp := range stamps {
+			if prev, ok := ctx.routeStamps[nodeId]; !ok || !prev.Equal(stamp) {
+				fresh = false
+				break
+			}
+		}
+		if fresh {
+			return ctx.routeTable
+		}
+	}
+	table, expire := ctx.routeTableCompute()
+	ctx.routeTable, ctx.routeStamps, ctx.routeExpire = table, stamps, expire
+	return table
+}
+
+func (ctx *Ctx) routeTableCompute() (map[NodeId]*Route, time.Time) {
+	table := make(map[NodeId]*Route)
+	better := func(r *Route) {
+		if r.Cost >= RouteCostInf || *r.Dst == *ctx.SelfId {
+			return
+		}
+		if _, known := ctx.Neigh[*r.Dst]; !known {
+			return
+		}
+		cur := table[*r.Dst]
+		if cur == nil || r.Cost < cur.Cost || (r.Cost == cur.Cost &&
+			bytes.Compare(r.Via[:], cur.Via[:]) < 0) {
+			table[*r.Dst] = r
+		}
+	}
+	maxAge := ctx.RouteMaxAge
+	if maxAge == 0 {
+		maxAge = 3 * DefaultRouteInterval
+	}
+	now := time.Now()
+	expire := now.Add(maxAge)
+	for _, node := range ctx.Neigh {
+		if node.RouteCost == 0 {
+			continue
+		}
+		better(&Route{Dst: node.Id, Via: node.Id, Cost: node.RouteCost})
+		advert, err := ctx.RouteAdvertLoad(node)
+		if err != nil {
+			ctx.LogE("route-load", LEs{{"Node", node.Id}}, err, func(les LEs) string {
+				return "Loading routing advertisement of " + node.Name
+			})
+			continue
+		}
+		if advert == nil {
+			continue
+		}
+		updated := time.Unix(int64(advert.Seq), 0)
+		if now.Sub(updated) > maxAge {
+			continue
+		}
+		if updated.Add(maxAge).Before(expire) {
+			expire = updated.Add(maxAge)
+		}
+		for _, entry := range advert.Entries {
+			if entry.Cost >= RouteCostInf {
+				continue
+			}
+			dst := entry.Dst
+			better(&Route{
+				Dst:     &dst,
+				Via:     node.Id,
+				Cost:    entry.Cost + node.RouteCost,
+				Updated: updated,
+			})
+		}
+	}
+	return table, expire
+}
+
+// Find the route to the node. Nil is returned if it is unknown.
+func (ctx *Ctx) RouteFind(nodeId *NodeId) *Route {
+	return ctx.RouteTable()[*nodeId]
+}
+
+// Find the relaying route to the node, if packets to it have to be
+// routed at all. Nodes with explicit via, with known addresses or
+// calls, are reachable directly and are not routed.
+func (ctx *Ctx) RouteRelay(node *Node) *Route {
+	if node.Via != nil || len(node.Addrs) > 0 || len(node.Calls) > 0 {
+		return nil
+	}
+	route := ctx.RouteFind(node.Id)
+	if route == nil || *route.Via == *node.Id {
+		return nil
+	}
+	return route
+}
+
+// Sorted by destination name routes, for displaying purposes.
+func (ctx *Ctx) RoutesSorted() []*Route {
+	table := ctx.RouteTable()
+	routes := make([]*Route, 0, len(table))
+	for _, r := range table {
+		routes = append(routes, r)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		return ctx.NodeName(routes[i].Dst) < ctx.NodeName(routes[j].Dst)
+	})
+	return routes
+}
+
+func (ctx *Ctx) routeAdvertGen(node *Node, table map[NodeId]*Route) ([]byte, error) {
+	advert := RouteAdvert{
+		Magic:   MagicNNCPRv1.B,
+		Origin:  *ctx.SelfId,
+		Seq:     uint64(time.Now().Unix()),
+		Entries: []RouteEntry{{Dst: *ctx.SelfId}},
+	}
+	dsts := make([]NodeId, 0, len(table))
+	for dst := range table {
+		dsts = append(dsts, dst)
+	}
+	sort.Slice(dsts, func(i, j int) bool {
+		return bytes.Compare(dsts[i][:], dsts[j][:]) < 0
+	})
+	for _, dst := range dsts {
+		r := table[dst]
+		cost := r.Cost
+		if *r.Via == *node.Id {
+			// Poisoned reverse, preventing two-node loops
+			cost = RouteCostInf
+		}
+		advert.Entries = append(advert.Entries, RouteEntry{Dst: dst, Cost: cost})
+	}
+	tbs, err := advert.tbs()
+	if err != nil {
+		return nil, err
+	}
+	copy(advert.Sign[:], ed25519.Sign(ctx.Self.SignPrv, tbs))
+	var buf bytes.Buffer
+	if _, err = xdr.Marshal(&buf, advert); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Queue our reachability advertisements to all routing neighbours.
+func (ctx *Ctx) TxRouteAdverts(nice uint8) error {
+	table := ctx.RouteTable()
+	var errLast error
+	for _, node := range ctx.Neigh {
+		if node.RouteCost == 0 || *node.Id == *ctx.SelfId {
+			continue
+		}
+		les := LEs{{"Type", "route"}, {"Node", node.Id}, {"Nice", int(nice)}}
+		logMsg := func(les LEs) string {
+			return fmt.Sprintf("Routing advertisement to %s", node.Name)
+		}
+		data, err := ctx.routeAdvertGen(node, table)
+		if err != nil {
+			ctx.LogE("tx", les, err, logMsg)
+			errLast = err
+			continue
+		}
+		pkt, err := NewPkt(PktTypeRoute, nice, nil)
+		if err != nil {
+			panic(err)
+		}
+		// Advertisements are always sent directly
+		direct := &Node{Id: node.Id, ExchPub: node.ExchPub, Via: []*NodeId{}}
+		_, _, pktName, err := ctx.Tx(
+			direct, pkt, nice,
+			int64(len(data)), 0, MaxFileSize,
+			bytes.NewReader(data), "route", nil,
+		)
+		les = append(les, LE{"Pkt", pktName}, LE{"Size", int64(len(data))})
+		if err == nil {
+			ctx.LogI("tx", les, logMsg)
+		} else {
+			ctx.LogE("tx", les, err, logMsg)
+			errLast = err
+		}
+	}
+	return errLast
+}
+
+// Periodically send routing advertisements. It never returns.
+func (ctx *Ctx) RouteAdvertiser() {
+	interval := ctx.RouteInterval
+	if interval == 0 {
+		interval = DefaultRouteInterval
+	}
+	for {
+		ctx.TxRouteAdverts(ctx.RouteNice)
+		time.Sleep(interval)
+	}
+}
+
+func routeAdvertRead(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, RouteAdvertMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > RouteAdvertMaxSize {
+		return nil, TooBig
+	}
+	return data, nil
+}
@@ -0,0 +1,139 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRouteTable(t *testing.T) {
+	spool, err := ioutil.TempDir("", "testroute")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(spool)
+	newCtx := func(self *NodeOur) *Ctx {
+		ctx := Ctx{
+			Spool:   filepath.Join(spool, self.Id.String()),
+			LogPath: filepath.Join(spool, "log.log"),
+			Self:    self,
+			SelfId:  self.Id,
+			Neigh:   make(map[NodeId]*Node),
+			Alias:   make(map[string]*NodeId),
+			Debug:   TDebug,
+		}
+		ctx.Neigh[*self.Id] = self.Their()
+		return &ctx
+	}
+	neigh := func(ctx *Ctx, node *NodeOur, cost uint32) *Node {
+		n := node.Their()
+		n.RouteCost = cost
+		ctx.Neigh[*node.Id] = n
+		return n
+	}
+	var nodes [4]*NodeOur
+	for i := range nodes {
+		if nodes[i], err = NewNodeGenerate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ourId, bId, cId, dId := nodes[0].Id, nodes[1].Id, nodes[2].Id, nodes[3].Id
+
+	ctxB := newCtx(nodes[1])
+	ourAtB := neigh(ctxB, nodes[0], 1)
+	neigh(ctxB, nodes[2], 1)
+	ctxD := newCtx(nodes[3])
+	ourAtD := neigh(ctxD, nodes[0], 1)
+	neigh(ctxD, nodes[2], 5)
+
+	ctx := newCtx(nodes[0])
+	b := neigh(ctx, nodes[1], 1)
+	neigh(ctx, nodes[2], 0)
+	d := neigh(ctx, nodes[3], 1)
+
+	for _, v := range []struct {
+		ctxFrom *Ctx
+		to      *Node
+		from    *Node
+	}{{ctxB, ourAtB, b}, {ctxD, ourAtD, d}} {
+		data, err := v.ctxFrom.routeAdvertGen(v.to, v.ctxFrom.RouteTable())
+		if err != nil {
+			t.Fatal(err)
+		}
+		advert, err := RouteAdvertParse(data, v.from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range advert.Entries {
+			if entry.Dst == *ourId && entry.Cost != RouteCostInf {
+				t.Fatal("route to the recipient is not poisoned")
+			}
+		}
+		if updated, err := ctx.RouteAdvertSave(v.from, data); err != nil || !updated {
+			t.Fatal("advertisement is not saved", err)
+		}
+		if updated, err := ctx.RouteAdvertSave(v.from, data); err != nil || updated {
+			t.Fatal("outdated advertisement is saved", err)
+		}
+	}
+	if _, err = RouteAdvertParse([]byte("garbage"), b); err == nil {
+		t.Fatal("garbage advertisement is parsed")
+	}
+
+	table := ctx.RouteTable()
+	if len(table) != 3 {
+		t.Fatal("unexpected routing table size:", len(table))
+	}
+	if _, exists := table[*ourId]; exists {
+		t.Fatal("route to ourselves")
+	}
+	if r := table[*cId]; r == nil || *r.Via != *bId || r.Cost != 2 {
+		t.Fatalf("unexpected route to C: %+v", r)
+	}
+	if r := table[*dId]; r == nil || *r.Via != *dId || r.Cost != 1 {
+		t.Fatalf("unexpected route to D: %+v", r)
+	}
+
+	c := ctx.Neigh[*cId]
+	if r := ctx.RouteRelay(c); r == nil || *r.Via != *bId {
+		t.Fatalf("unexpected relaying route to C: %+v", r)
+	}
+	if r := ctx.RouteRelay(d); r != nil {
+		t.Fatalf("routing neighbour D is relayed: %+v", r)
+	}
+	c.Addrs = map[string]string{"main": "c.example.com:5400"}
+	if r := ctx.RouteRelay(c); r != nil {
+		t.Fatalf("directly reachable C is relayed: %+v", r)
+	}
+	c.Addrs = nil
+	c.Via = []*NodeId{}
+	if r := ctx.RouteRelay(c); r != nil {
+		t.Fatalf("C with explicit via is relayed: %+v", r)
+	}
+	c.Via = nil
+
+	if err = os.Remove(ctx.routeAdvertPath(bId)); err != nil {
+		t.Fatal(err)
+	}
+	if r := ctx.RouteTable()[*cId]; r == nil || *r.Via != *dId || r.Cost != 6 {
+		t.Fatalf("cached routing table is not invalidated: %+v", r)
+	}
+}
@@ -70,10 +70,6 @@ var (
 
 	DefaultDeadline = 10 * time.Second
 	PingTimeout     = time.Minute
-
-	spCheckerTasks chan SPCheckerTask
-	SPCheckerWg    sync.WaitGroup
-	spCheckerOnce  sync.Once
 )
 
 type FdAndFullSize struct {
@@ -178,7 +174,6 @@ func init() {
 		panic(err)
 	}
 	SPFileOverhead = buf.Len()
-	spCheckerTasks = make(chan SPCheckerTask)
 }
 
 func MarshalSP(typ SPType, sp interface{}) []byte {
@@ -664,6 +659,7 @@ func (state *SPState) StartWorkers(
 	payload []byte,
 ) error {
 	les := LEs{{"Node", state.Node.Id}, {"Nice", int(state.Nice)}}
+	state.Ctx.OnlineTouch(state.Node.Id)
 	state.fds = make(map[string]FdAndFullSize)
 	state.fileHashers = make(map[string]*MTHAndOffset)
 	state.isDead = make(chan struct{})
@@ -671,15 +667,14 @@ func (state *SPState) StartWorkers(
 		state.mustFinishAt = state.started.Add(state.maxOnlineTime)
 	}
 	if !state.NoCK {
-		spCheckerOnce.Do(func() { go SPChecker(state.Ctx) })
 		go func() {
 			for job := range state.Ctx.JobsNoCK(state.Node.Id) {
 				if job.PktEnc.Nice <= state.Nice {
-					spCheckerTasks <- SPCheckerTask{
+					state.Ctx.spCheckerQueue(SPCheckerTask{
 						nodeId: state.Node.Id,
 						hsh:    job.HshValue,
 						done:   state.payloads,
-					}
+					})
 				}
 			}
 		}()
@@ -789,7 +784,10 @@ func (state *SPState) StartWorkers(
 			)
 			if err != nil {
 				state.Ctx.LogE("sp-queue-dir-watch", les, err, logMsg)
-				log.Fatalln(err)
+				state.SetDead()
+				conn.Close()
+				state.wg.Done()
+				return
 			}
 			for {
 				select {
@@ -1299,12 +1297,23 @@ func (state *SPState) ProcessSP(payload []byte) ([][]byte, error) {
 			if err == nil {
 				offset = fi.Size()
 			}
-			if !state.Ctx.IsEnoughSpace(int64(info.Size) - offset) {
+			enough, err := state.Ctx.IsEnoughSpace(int64(info.Size) - offset)
+			if err != nil {
+				state.Ctx.LogE("sp-info-no-space", lesp, err, logMsg)
+				continue
+			}
+			if !enough {
 				state.Ctx.LogI("sp-info-no-space", lesp, func(les LEs) string {
 					return logMsg(les) + ": not enough space"
 				})
 				continue
 			}
+			if !state.Ctx.SpoolQuotaRxAllows(state.Node.Id, int64(info.Size)-offset) {
+				state.Ctx.LogI("sp-info-quota", lesp, func(les LEs) string {
+					return logMsg(les) + ": spool quota is exceeded"
+				})
+				continue
+			}
 			state.Ctx.LogI(
 				"sp-info",
 				append(lesp, LE{"Offset", offset}),
@@ -1561,7 +1570,7 @@ func (state *SPState) ProcessSP(payload []byte) ([][]byte, error) {
 				if hasherAndOffset != nil {
 					t.mth = hasherAndOffset.mth
 				}
-				spCheckerTasks <- t
+				state.Ctx.spCheckerQueue(t)
 			}()
 
 		case SPTypeDone:
@@ -1706,15 +1715,24 @@ func (state *SPState) ProcessSP(payload []byte) ([][]byte, error) {
 	return payloadsSplit(replies), nil
 }
 
+// Queue the task to the context's checker, starting it if necessary.
+func (ctx *Ctx) spCheckerQueue(t SPCheckerTask) {
+	ctx.spCheckerOnce.Do(func() {
+		ctx.spCheckerTasks = make(chan SPCheckerTask)
+		go SPChecker(ctx)
+	})
+	ctx.spCheckerTasks <- t
+}
+
 func SPChecker(ctx *Ctx) {
-	for t := range spCheckerTasks {
+	for t := range ctx.spCheckerTasks {
 		pktName := Base32Codec.EncodeToString(t.hsh[:])
 		les := LEs{
 			{"XX", string(TRx)},
 			{"Node", t.nodeId},
 			{"Pkt", pktName},
 		}
-		SPCheckerWg.Add(1)
+		ctx.SPCheckerWg.Add(1)
 		ctx.LogD("sp-checker", les, func(les LEs) string {
 			return fmt.Sprintf("Checksumming %s/rx/%s", ctx.NodeName(t.nodeId), pktName)
 		})
@@ -1727,7 +1745,7 @@ func SPChecker(ctx *Ctx) {
 					humanize.IBytes(uint64(size)),
 				)
 			})
-			SPCheckerWg.Done()
+			ctx.SPCheckerWg.Done()
 			continue
 		}
 		ctx.LogI("sp-checker-done", les, func(les LEs) string {
@@ -1736,7 +1754,7 @@ func SPChecker(ctx *Ctx) {
 				pktName, humanize.IBytes(uint64(size)),
 			)
 		})
-		SPCheckerWg.Done()
+		ctx.SPCheckerWg.Done()
 		go func(t SPCheckerTask) {
 			defer func() { recover() }()
 			t.done <- MarshalSP(SPTypeDone, SPDone{t.hsh})
@@ -0,0 +1,358 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	SpoolQuotaPolicyRefuse = "refuse"
+	SpoolQuotaPolicyEvict  = "evict"
+
+	// Built-in exec handle receiving notifications about packets
+	// evicted from the relaying node's spool
+	EvictedHandle = "nncp-evicted"
+
+	// Spool-wide lock, serializing outbound quota checking and packets
+	// committing between processes
+	SpoolQuotaLock = "quota.lock"
+)
+
+var SpoolQuotaExceeded = errors.New("spool quota is exceeded")
+
+// Limits of node's (or whole spool's) outbound and inbound packets
+// total size. Zero means no limit.
+type SpoolQuota struct {
+	Tx int64
+	Rx int64
+	// Evict queued outbound packets with the highest niceness and the
+	// oldest first, instead of refusing the new one
+	Evict bool
+}
+
+type spoolJob struct {
+	node    *NodeId
+	path    string
+	name    string
+	size    int64
+	nice    uint8
+	sender  *NodeId
+	modTime time.Time
+}
+
+// Total size of the packets in node's rx/tx directory, including
+// partly received ones.
+func (ctx *Ctx) SpoolUsage(nodeId *NodeId, xx TRxTx) (int64, error) {
+	fis, err := ioutil.ReadDir(filepath.Join(ctx.Spool, nodeId.String(), string(xx)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	var size int64
+	for _, fi := range fis {
+		if fi.Mode().IsRegular() {
+			size += fi.Size()
+		}
+	}
+	return size, nil
+}
+
+// Total size of the packets in rx/tx directories of all known nodes.
+func (ctx *Ctx) SpoolUsageTotal(xx TRxTx) (int64, error) {
+	var total int64
+	for nodeId := range ctx.Neigh {
+		size, err := ctx.SpoolUsage(&nodeId, xx)
+		if err != nil {
+			return 0, err
+		}
+		total += size
+	}
+	return total, nil
+}
+
+// Check if inbound packet of given size from the node fits into its
+// and global spool quotas.
+func (ctx *Ctx) SpoolQuotaRxAllows(nodeId *NodeId, want int64) bool {
+	if node := ctx.Neigh[*nodeId]; node != nil &&
+		node.SpoolQuota != nil && node.SpoolQuota.Rx > 0 {
+		used, err := ctx.SpoolUsage(nodeId, TRx)
+		if err != nil || used+want > node.SpoolQuota.Rx {
+			return false
+		}
+	}
+	if ctx.SpoolQuota != nil && ctx.SpoolQuota.Rx > 0 {
+		used, err := ctx.SpoolUsageTotal(TRx)
+		if err != nil || used+want > ctx.SpoolQuota.Rx {
+			return false
+		}
+	}
+	return true
+}
+
+// Outbound packets of the node, sized by their files. Their headers are
+// not read, see spoolJobHdr.
+func (ctx *Ctx) spoolJobsTx(nodeId *NodeId) []spoolJob {
+	txPath := filepath.Join(ctx.Spool, nodeId.String(), string(TTx))
+	fis, err := ioutil.ReadDir(txPath)
+	if err != nil {
+		return nil
+	}
+	var jobs []spoolJob
+	for _, fi := range fis {
+		name := fi.Name()
+		if !fi.Mode().IsRegular() || len(name) != Base32Encoded32Len {
+			continue
+		}
+		if _, err = Base32Codec.DecodeString(name); err != nil {
+			continue
+		}
+		jobs = append(jobs, spoolJob{
+			node:    nodeId,
+			path:    filepath.Join(txPath, name),
+			name:    name,
+			size:    fi.Size(),
+			modTime: fi.ModTime(),
+		})
+	}
+	return jobs
+}
+
+// Fill job's niceness and sender from its header.
+func (ctx *Ctx) spoolJobHdr(job *spoolJob) error {
+	fd, err := os.Open(JobPath2Hdr(job.path))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		if fd, err = os.Open(job.path); err != nil {
+			return err
+		}
+	}
+	pktEnc, _, err := ctx.HdrRead(fd)
+	fd.Close()
+	if err != nil {
+		return err
+	}
+	job.nice = pktEnc.Nice
+	job.sender = pktEnc.Sender
+	return nil
+}
+
+// Commit the outbound packet of given niceness to the node, making room
+// for it with spoolQuotaTx. Spool-wide lock is held during that, so
+// concurrent processes do not overcommit the quotas.
+func (ctx *Ctx) spoolQuotaCommit(nodeId *NodeId, nice uint8, tmp *TmpFileWHash) error {
+	txPath := filepath.Join(ctx.Spool, nodeId.String(), string(TTx))
+	if !ctx.spoolQuotaTxLimited(nodeId) {
+		return tmp.Commit(txPath)
+	}
+	if err := tmp.W.Flush(); err != nil {
+		tmp.Cancel()
+		return err
+	}
+	fi, err := tmp.Fd.Stat()
+	if err != nil {
+		tmp.Cancel()
+		return err
+	}
+	lockPath := filepath.Join(ctx.Spool, SpoolQuotaLock)
+	lock, err := os.OpenFile(lockPath, os.O_CREATE|os.O_WRONLY, os.FileMode(0666))
+	if err != nil {
+		tmp.Cancel()
+		return err
+	}
+	if err = unix.Flock(int(lock.Fd()), unix.LOCK_EX); err != nil {
+		lock.Close()
+		tmp.Cancel()
+		return err
+	}
+	evicted, err := ctx.spoolQuotaTx(nodeId, nice, fi.Size())
+	if err == nil {
+		err = tmp.Commit(txPath)
+	} else {
+		tmp.Cancel()
+	}
+	ctx.UnlockDir(lock)
+	ctx.spoolEvictedNotify(evicted)
+	return err
+}
+
+func (ctx *Ctx) spoolQuotaTxLimited(nodeId *NodeId) bool {
+	if node := ctx.Neigh[*nodeId]; node != nil &&
+		node.SpoolQuota != nil && node.SpoolQuota.Tx > 0 {
+		return true
+	}
+	return ctx.SpoolQuota != nil && ctx.SpoolQuota.Tx > 0
+}
+
+// Make room for the outbound packet of given size and niceness to the
+// node, according to its and global spool quotas. Only packets with
+// the same or higher niceness are evicted, and only if that is enough.
+// Evicted packets are returned for notifying their senders. Caller must
+// hold SpoolQuotaLock.
+func (ctx *Ctx) spoolQuotaTx(nodeId *NodeId, nice uint8, size int64) ([]spoolJob, error) {
+	type limit struct {
+		q     *SpoolQuota
+		nodes []*NodeId
+	}
+	var limits []limit
+	if node := ctx.Neigh[*nodeId]; node != nil &&
+		node.SpoolQuota != nil && node.SpoolQuota.Tx > 0 {
+		limits = append(limits, limit{node.SpoolQuota, []*NodeId{nodeId}})
+	}
+	if ctx.SpoolQuota != nil && ctx.SpoolQuota.Tx > 0 {
+		nodes := make([]*NodeId, 0, len(ctx.Neigh))
+		for _, node := range ctx.Neigh {
+			nodes = append(nodes, node.Id)
+		}
+		limits = append(limits, limit{ctx.SpoolQuota, nodes})
+	}
+	if len(limits) == 0 {
+		return nil, nil
+	}
+	jobs := make(map[NodeId][]spoolJob)
+	planned := make(map[string]struct{})
+	var evicted []spoolJob
+	for _, l := range limits {
+		if size > l.q.Tx {
+			return nil, SpoolQuotaExceeded
+		}
+		var used int64
+		for _, id := range l.nodes {
+			if _, loaded := jobs[*id]; !loaded {
+				jobs[*id] = ctx.spoolJobsTx(id)
+			}
+			for _, job := range jobs[*id] {
+				if _, exists := planned[job.path]; !exists {
+					used += job.size
+				}
+			}
+		}
+		if used+size <= l.q.Tx {
+			continue
+		}
+		if !l.q.Evict {
+			return nil, SpoolQuotaExceeded
+		}
+		// Headers are read only when eviction is really needed
+		var cands []spoolJob
+		for _, id := range l.nodes {
+			nodeJobs := jobs[*id]
+			for i := range nodeJobs {
+				job := &nodeJobs[i]
+				if _, exists := planned[job.path]; exists {
+					continue
+				}
+				if job.sender == nil {
+					if ctx.spoolJobHdr(job) != nil {
+						continue
+					}
+				}
+				if job.nice >= nice {
+					cands = append(cands, *job)
+				}
+			}
+		}
+		sort.Slice(cands, func(i, j int) bool {
+			if cands[i].nice != cands[j].nice {
+				return cands[i].nice > cands[j].nice
+			}
+			return cands[i].modTime.Before(cands[j].modTime)
+		})
+		for _, job := range cands {
+			if used+size <= l.q.Tx {
+				break
+			}
+			planned[job.path] = struct{}{}
+			evicted = append(evicted, job)
+			used -= job.size
+		}
+		if used+size > l.q.Tx {
+			return nil, SpoolQuotaExceeded
+		}
+	}
+	for _, job := range evicted {
+		les := LEs{
+			{"XX", string(TTx)},
+			{"Node", job.node},
+			{"Pkt", job.name},
+			{"Nice", int(job.nice)},
+			{"Size", job.size},
+			{"Sender", job.sender},
+		}
+		logMsg := func(les LEs) string {
+			return fmt.Sprintf(
+				"Evicting %s/tx/%s (%s) (nice %s)",
+				ctx.NodeName(job.node), job.name,
+				humanize.IBytes(uint64(job.size)), NicenessFmt(job.nice),
+			)
+		}
+		if err := os.Remove(job.path); err != nil {
+			ctx.LogE("tx-evict", les, err, logMsg)
+			return evicted, err
+		}
+		os.Remove(JobPath2Hdr(job.path))
+		os.Remove(filepath.Join(filepath.Dir(job.path), ViaDir, job.name))
+		ctx.LogI("tx-evict", les, logMsg)
+	}
+	return evicted, nil
+}
+
+// Notify senders of evicted packets: locally and, for relayed packets
+// from known nodes, by sending them notification.
+func (ctx *Ctx) spoolEvictedNotify(evicted []spoolJob) {
+	for _, job := range evicted {
+		subject := fmt.Sprintf(
+			"Packet %s to %s (%s) is evicted from the spool",
+			job.name, ctx.NodeName(job.node), humanize.IBytes(uint64(job.size)),
+		)
+		ctx.notifyPkt(NotifyEventEvict, job.sender, job.name, subject, nil, nil)
+		if *job.sender == *ctx.SelfId {
+			continue
+		}
+		sender := ctx.Neigh[*job.sender]
+		if sender == nil {
+			continue
+		}
+		if err := ctx.TxExec(
+			sender, job.nice, job.nice, EvictedHandle,
+			[]string{job.name, job.node.String()},
+			strings.NewReader(subject), 0, MaxFileSize, false, nil,
+		); err != nil {
+			ctx.LogE("tx-evict-notify", LEs{
+				{"Node", sender.Id},
+				{"Pkt", job.name},
+			}, err, func(les LEs) string {
+				return "Notifying " + sender.Name + " about evicted " + job.name
+			})
+		}
+	}
+}
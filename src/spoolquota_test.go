@@ -0,0 +1,189 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSpoolQuota(t *testing.T) {
+	spool, err := ioutil.TempDir("", "testspoolquota")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(spool)
+	nodeOur, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeA, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeB, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := testAreaCtx(spool, nodeOur, nodeA, nodeB)
+	a := ctx.Neigh[*nodeA.Id]
+	b := ctx.Neigh[*nodeB.Id]
+	const pktSize = 10 << 10
+	tx := func(node *Node, nice uint8) (string, error) {
+		return ctx.txExec(
+			node, nice, nice, "whatever", nil, strings.NewReader("BODY"),
+			pktSize, MaxFileSize, false, nil,
+		)
+	}
+	queued := func(node *Node) map[string]struct{} {
+		fis, _ := ioutil.ReadDir(filepath.Join(spool, node.Id.String(), string(TTx)))
+		names := make(map[string]struct{})
+		for _, fi := range fis {
+			if fi.Mode().IsRegular() {
+				names[fi.Name()] = struct{}{}
+			}
+		}
+		return names
+	}
+
+	a.SpoolQuota = &SpoolQuota{Tx: 5 * pktSize / 2}
+	pkt1, err := tx(a, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkt2, err := tx(a, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx(a, 100); !errors.Is(err, SpoolQuotaExceeded) {
+		t.Fatal("quota is not enforced", err)
+	}
+	if used, err := ctx.SpoolUsage(a.Id, TTx); err != nil || used != 2*pktSize {
+		t.Fatal("invalid usage", used, err)
+	}
+	if len(queued(a)) != 2 {
+		t.Fatal("refused packet is queued")
+	}
+
+	// The nicest packet is evicted, but never the more urgent one
+	a.SpoolQuota.Evict = true
+	pkt3, err := tx(a, 150)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := queued(a)
+	if _, exists := names[pkt1]; exists || len(names) != 2 {
+		t.Fatal("nicest packet is not evicted", names)
+	}
+	if _, err = tx(a, 250); !errors.Is(err, SpoolQuotaExceeded) {
+		t.Fatal("more urgent packet is evicted", err)
+	}
+	names = queued(a)
+	for _, pkt := range []string{pkt2, pkt3} {
+		if _, exists := names[pkt]; !exists {
+			t.Fatal("packet is evicted", pkt)
+		}
+	}
+
+	// Global quota takes packets to all nodes into account
+	a.SpoolQuota = nil
+	ctx.SpoolQuota = &SpoolQuota{Tx: 5 * pktSize / 2, Evict: true}
+	if _, err = tx(b, 100); err != nil {
+		t.Fatal(err)
+	}
+	names = queued(a)
+	if _, exists := names[pkt3]; exists || len(names) != 1 {
+		t.Fatal("packet to another node is not evicted", names)
+	}
+
+	// Senders of relayed packets are notified
+	ctx.spoolEvictedNotify([]spoolJob{{
+		node: a.Id, name: pkt3, size: pktSize, nice: 150, sender: b.Id,
+	}})
+	if len(queued(b)) != 2 {
+		t.Fatal("sender is not notified")
+	}
+
+	ctx.SpoolQuota.Rx = pktSize
+	if !ctx.SpoolQuotaRxAllows(a.Id, pktSize) {
+		t.Fatal("rx quota is exceeded in advance")
+	}
+	rxDir := filepath.Join(spool, a.Id.String(), string(TRx))
+	if err = os.MkdirAll(rxDir, os.FileMode(0777)); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(
+		filepath.Join(rxDir, pkt3+PartSuffix), make([]byte, 10), os.FileMode(0666),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if ctx.SpoolQuotaRxAllows(b.Id, pktSize) {
+		t.Fatal("rx quota is not enforced")
+	}
+}
+
+func TestSpoolQuotaConcurrent(t *testing.T) {
+	spool, err := ioutil.TempDir("", "testspoolquota")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(spool)
+	nodeOur, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeA, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const pktSize = 10 << 10
+	const procs = 16
+	// Separate contexts behave like separate processes
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < procs; i++ {
+		ctx := testAreaCtx(spool, nodeOur, nodeA)
+		ctx.Neigh[*nodeA.Id].SpoolQuota = &SpoolQuota{Tx: 5 * pktSize / 2}
+		tmp, err := ctx.NewTmpFileWHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := make([]byte, pktSize)
+		data[0] = byte(i)
+		if _, err = tmp.W.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			ctx.spoolQuotaCommit(nodeA.Id, DefaultNiceExec, tmp)
+		}()
+	}
+	close(start)
+	wg.Wait()
+	ctx := testAreaCtx(spool, nodeOur, nodeA)
+	if used, err := ctx.SpoolUsage(nodeA.Id, TTx); err != nil || used != 2*pktSize {
+		t.Fatal("quota is overcommitted", used, err)
+	}
+}
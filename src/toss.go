@@ -33,6 +33,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	xdr "github.com/davecgh/go-xdr/xdr2"
@@ -124,52 +125,156 @@ func jobProcess(
 		}
 		argsStr := strings.Join(append([]string{handle}, args...), " ")
 		les = append(les, LE{"Type", "exec"}, LE{"Dst", argsStr})
+		logMsg := func(les LEs) string {
+			return fmt.Sprintf(
+				"Tossing exec %s/%s (%s): %s",
+				sender.Name, pktName,
+				humanize.IBytes(pktSize), argsStr,
+			)
+		}
 		cmdline := sender.Exec[handle]
-		if len(cmdline) == 0 {
+		fn := ctx.execFuncFind(sender.Id, handle)
+		builtin := execBuiltins[handle]
+		if len(cmdline) == 0 && fn == nil && builtin == nil {
 			err = errors.New("No handle found")
-			ctx.LogE(
-				"rx-no-handle", les, err,
-				func(les LEs) string {
-					return fmt.Sprintf(
-						"Tossing exec %s/%s (%s): %s",
-						sender.Name, pktName,
-						humanize.IBytes(pktSize), argsStr,
-					)
-				},
-			)
+			ctx.LogE("rx-no-handle", les, err, logMsg)
+			return err
+		}
+		if builtin != nil && !ctx.execBuiltinAllowed(sender) {
+			err = ExecBuiltinDenied
+			ctx.LogE("rx-no-handle", les, err, logMsg)
 			return err
 		}
+		var in io.Reader = pipeR
 		if pkt.Type == PktTypeExec {
 			if err = decompressor.Reset(pipeR); err != nil {
-				log.Fatalln(err)
+				ctx.LogE("rx-decompress", les, err, logMsg)
+				return err
 			}
+			in = decompressor
 		}
-		if !dryRun {
-			cmd := exec.Command(cmdline[0], append(cmdline[1:], args...)...)
-			cmd.Env = append(
-				cmd.Env,
-				"NNCP_SELF="+ctx.Self.Id.String(),
-				"NNCP_SENDER="+sender.Id.String(),
-				"NNCP_NICE="+strconv.Itoa(int(pkt.Nice)),
-			)
-			if pkt.Type == PktTypeExec {
-				cmd.Stdin = decompressor
+		if !dryRun && builtin != nil {
+			les, err = builtin(ctx, &ExecRequest{
+				Sender: sender,
+				Handle: handle,
+				Args:   args,
+				Nice:   pkt.Nice,
+				Body:   in,
+			}, pktName, les, logMsg)
+			if err != nil {
+				return err
+			}
+		} else if !dryRun {
+			policy := sender.ExecPolicyFind(handle)
+			var rexecId string
+			if policy != nil && policy.Reply == ExecReplyRexec {
+				if len(args) == 0 {
+					err = errors.New("no rexec id")
+					ctx.LogE("rx-rexec", les, err, logMsg)
+					return err
+				}
+				rexecId, args = args[0], args[1:]
+			}
+			var cmd *exec.Cmd
+			if fn == nil {
+				cmd = exec.Command(cmdline[0], append(cmdline[1:], args...)...)
+				cmd.Env = append(
+					cmd.Env,
+					"NNCP_SELF="+ctx.Self.Id.String(),
+					"NNCP_SENDER="+sender.Id.String(),
+					"NNCP_NICE="+strconv.Itoa(int(pkt.Nice)),
+				)
+				cmd.Stdin = in
+			}
+			var reply *os.File
+			if policy != nil && policy.Reply != "" {
+				reply, err = ctx.NewTmpFile()
+				if err != nil {
+					ctx.LogE("rx-reply-tmp", les, err, func(les LEs) string {
+						return fmt.Sprintf(
+							"Tossing exec %s/%s (%s): %s: creating reply",
+							sender.Name, pktName,
+							humanize.IBytes(pktSize), argsStr,
+						)
+					})
+					return err
+				}
+				defer os.Remove(reply.Name())
+				defer reply.Close()
+			}
+			var output []byte
+			exitCode := -1
+			if fn == nil {
+				if reply != nil {
+					cmd.Stdout = reply
+				}
+				output, err = execRun(cmd, policy)
+				if cmd.ProcessState != nil {
+					exitCode = cmd.ProcessState.ExitCode()
+				}
 			} else {
-				cmd.Stdin = pipeR
+				var stdout io.Writer
+				if reply != nil {
+					stdout = reply
+				}
+				output, exitCode, err = execFuncRun(fn, &ExecRequest{
+					Sender: sender,
+					Handle: handle,
+					Args:   args,
+					Nice:   pkt.Nice,
+					Body:   in,
+				}, policy, stdout)
+			}
+			if execIsExit(err) && reply != nil && exitCode >= 0 {
+				// Non-zero exit code is delivered with the reply
+				les = append(les, LE{"ExitCode", exitCode})
+				err = nil
 			}
-			output, err := cmd.CombinedOutput()
 			if err != nil {
 				les = append(les, LE{"Output", strings.Split(
 					strings.Trim(string(output), "\n"), "\n"),
 				})
-				ctx.LogE("rx-handle", les, err, func(les LEs) string {
+				where := "rx-handle"
+				if execIsLimitErr(err) {
+					where = "rx-handle-limit"
+				}
+				ctx.LogE(where, les, err, func(les LEs) string {
 					return fmt.Sprintf(
 						"Tossing exec %s/%s (%s): %s: handling",
 						sender.Name, pktName,
 						humanize.IBytes(uint64(pktSize)), argsStr,
 					)
 				})
-				return err
+				if rexecId == "" {
+					return &ExecFailure{Err: err, ExitCode: exitCode, Output: output}
+				}
+				// Waiting nncp-rexec is told about the failure, so the
+				// packet is considered processed
+				io.Copy(ioutil.Discard, in)
+				output = append(output, []byte("nncp: "+err.Error()+"\n")...)
+				exitCode = -1
+			}
+			if reply != nil {
+				if rexecId == "" {
+					err = ctx.execReply(
+						sender, handle, pktName, pkt.Nice, exitCode, policy, reply,
+					)
+				} else {
+					err = ctx.rexecReply(
+						sender, pkt.Nice, rexecId, exitCode, reply, output,
+					)
+				}
+				if err != nil {
+					// Handler has already run, so the packet is retired
+					// anyway, not to repeat its side effects
+					ctx.LogE("rx-reply", les, err, func(les LEs) string {
+						return fmt.Sprintf(
+							"Tossing exec %s/%s (%s): %s: replying",
+							sender.Name, pktName,
+							humanize.IBytes(pktSize), argsStr,
+						)
+					})
+				}
 			}
 			if len(sendmail) > 0 && ctx.NotifyExec != nil {
 				notify := ctx.NotifyExec[sender.Name+"."+handle]
@@ -195,6 +300,11 @@ func jobProcess(
 					}
 				}
 			}
+			ctx.notifyPkt(
+				NotifyEventExec, sender.Id, pktName,
+				fmt.Sprintf("Exec from %s: %s", sender.Name, argsStr),
+				output, nil,
+			)
 		}
 		ctx.LogI("rx", les, func(les LEs) string {
 			return fmt.Sprintf(
@@ -272,7 +382,19 @@ func jobProcess(
 			)
 			return err
 		}
-		dir := filepath.Join(*incoming, path.Dir(dst))
+		placement := sender.PlacementFind(dst)
+		dstRel, err := placement.Path(dst, sender, time.Now())
+		if err != nil {
+			ctx.LogE("rx-placement", les, err, func(les LEs) string {
+				return fmt.Sprintf(
+					"Tossing file %s/%s (%s): %s: placing",
+					sender.Name, pktName,
+					humanize.IBytes(pktSize), dst,
+				)
+			})
+			return err
+		}
+		dir := filepath.Join(*incoming, path.Dir(dstRel))
 		if err = os.MkdirAll(dir, os.FileMode(0777)); err != nil {
 			ctx.LogE("rx-mkdir", les, err, func(les LEs) string {
 				return fmt.Sprintf(
@@ -352,17 +474,37 @@ func jobProcess(
 				})
 				return err
 			}
-			dstPathOrig := filepath.Join(*incoming, dst)
+			dstPathOrig := filepath.Join(*incoming, dstRel)
 			dstPath := dstPathOrig
 			dstPathCtr := 0
-			for {
-				if _, err = os.Stat(dstPath); err != nil {
-					if os.IsNotExist(err) {
-						break
-					}
+			claimed := false
+			// Name is claimed by its exclusive creation, so concurrently
+			// tossed files can not take the same one
+			for placement.Collision != PlacementOverwrite {
+				fd, err := os.OpenFile(
+					dstPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, os.FileMode(0666),
+				)
+				if err == nil {
+					fd.Close()
+					claimed = true
+					break
+				}
+				if !os.IsExist(err) {
 					ctx.LogE("rx-stat", les, err, func(les LEs) string {
 						return fmt.Sprintf(
-							"Tossing file %s/%s (%s): %s: stating: %s",
+							"Tossing file %s/%s (%s): %s: claiming: %s",
+							sender.Name, pktName,
+							humanize.IBytes(pktSize), dst, dstPath,
+						)
+					})
+					return err
+				}
+				if placement.Collision == PlacementReject {
+					os.Remove(tmp.Name())
+					err = PlacementCollision
+					ctx.LogE("rx-collision", les, err, func(les LEs) string {
+						return fmt.Sprintf(
+							"Tossing file %s/%s (%s): %s: %s",
 							sender.Name, pktName,
 							humanize.IBytes(pktSize), dst, dstPath,
 						)
@@ -373,6 +515,9 @@ func jobProcess(
 				dstPathCtr++
 			}
 			if err = os.Rename(tmp.Name(), dstPath); err != nil {
+				if claimed {
+					os.Remove(dstPath)
+				}
 				ctx.LogE("rx-rename", les, err, func(les LEs) string {
 					return fmt.Sprintf(
 						"Tossing file %s/%s (%s): %s: renaming",
@@ -382,7 +527,7 @@ func jobProcess(
 				})
 				return err
 			}
-			if err = DirSync(*incoming); err != nil {
+			if err = DirSync(dir); err != nil {
 				ctx.LogE("rx-dirsync", les, err, func(les LEs) string {
 					return fmt.Sprintf(
 						"Tossing file %s/%s (%s): %s: dirsyncing",
@@ -393,6 +538,20 @@ func jobProcess(
 				return err
 			}
 			les = les[:len(les)-1] // delete Tmp
+			les = append(les, LE{"Path", dstPath})
+			if len(sender.PostReceive) > 0 {
+				if output, err := ctx.postReceive(sender, nice, dstPath); err != nil {
+					ctx.LogE("rx-hook", append(les, LE{"Output", strings.Split(
+						strings.Trim(string(output), "\n"), "\n"),
+					}), err, func(les LEs) string {
+						return fmt.Sprintf(
+							"Tossing file %s/%s (%s): %s: post-receive hook",
+							sender.Name, pktName,
+							humanize.IBytes(pktSize), dst,
+						)
+					})
+				}
+			}
 		}
 		ctx.LogI("rx", les, func(les LEs) string {
 			return fmt.Sprintf(
@@ -453,14 +612,24 @@ func jobProcess(
 					})
 				}
 			}
+			ctx.notifyPkt(
+				NotifyEventFile, sender.Id, pktName,
+				fmt.Sprintf(
+					"File from %s: %s (%s)",
+					sender.Name, dst, humanize.IBytes(pktSize),
+				), nil, nil,
+			)
 		}
 
 	case PktTypeFreq:
 		if noFreq {
 			return nil
 		}
-		src := string(pkt.Path[:int(pkt.PathLen)])
+		src, kind := freqSplit(string(pkt.Path[:int(pkt.PathLen)]))
 		les := append(les, LE{"Type", "freq"}, LE{"Src", src})
+		if kind != "" {
+			les = append(les, LE{"Kind", kind})
+		}
 		if filepath.IsAbs(src) {
 			err = errors.New("non-relative source path")
 			ctx.LogE(
@@ -488,8 +657,7 @@ func jobProcess(
 		}
 		dst := string(dstRaw)
 		les = append(les, LE{"Dst", dst})
-		freqPath := sender.FreqPath
-		if freqPath == nil {
+		if sender.FreqPath == nil && len(sender.FreqRoots) == 0 {
 			err = errors.New("freqing is not allowed")
 			ctx.LogE(
 				"rx-no-freq", les, err,
@@ -504,16 +672,24 @@ func jobProcess(
 			return err
 		}
 		if !dryRun {
-			err = ctx.TxFile(
-				sender,
-				pkt.Nice,
-				filepath.Join(*freqPath, src),
-				dst,
-				sender.FreqChunked,
-				sender.FreqMinSize,
-				sender.FreqMaxSize,
-				nil,
-			)
+			err = ctx.freqProcess(sender, pkt.Nice, src, dst, kind)
+			if err != nil && freqIsRejection(err) {
+				ctx.LogE("rx-freq-reject", les, err, func(les LEs) string {
+					return fmt.Sprintf(
+						"Tossing freq %s/%s (%s): %s -> %s: rejecting",
+						sender.Name, pktName,
+						humanize.IBytes(pktSize), src, dst,
+					)
+				})
+				if err == FreqQuotaBytes {
+					ctx.notifyPkt(
+						NotifyEventQuota, sender.Id, pktName,
+						fmt.Sprintf("Freq quota of %s is exceeded", sender.Name),
+						nil, err,
+					)
+				}
+				err = ctx.freqReject(sender, pkt.Nice, src, dst, kind, err)
+			}
 			if err != nil {
 				ctx.LogE("rx-tx", les, err, func(les LEs) string {
 					return fmt.Sprintf(
@@ -580,71 +756,185 @@ func jobProcess(
 					})
 				}
 			}
+			ctx.notifyPkt(
+				NotifyEventFreq, sender.Id, pktName,
+				fmt.Sprintf("Freq from %s: %s", sender.Name, src),
+				nil, nil,
+			)
 		}
 
 	case PktTypeTrns:
 		if noTrns {
 			return nil
 		}
-		dst := new([MTHSize]byte)
-		copy(dst[:], pkt.Path[:int(pkt.PathLen)])
-		nodeId := NodeId(*dst)
-		les := append(les, LE{"Type", "trns"}, LE{"Dst", nodeId})
-		logMsg := func(les LEs) string {
-			return fmt.Sprintf(
-				"Tossing trns %s/%s (%s): %s",
-				sender.Name, pktName,
-				humanize.IBytes(pktSize),
-				nodeId.String(),
-			)
-		}
-		node := ctx.Neigh[nodeId]
-		if node == nil {
-			err = errors.New("unknown node")
-			ctx.LogE("rx-unknown", les, err, logMsg)
+		path, err := transitPathParse(&pkt)
+		if err != nil {
+			ctx.LogE("rx-trns", les, err, func(les LEs) string {
+				return fmt.Sprintf("Tossing trns %s/%s", sender.Name, pktName)
+			})
 			return err
 		}
-		ctx.LogD("rx-tx", les, logMsg)
-		if !dryRun {
-			if len(node.Via) == 0 {
-				if err = ctx.TxTrns(node, nice, int64(pktSize), pipeR); err != nil {
-					ctx.LogE("rx", les, err, func(les LEs) string {
-						return logMsg(les) + ": txing"
+		// Transitional packet addressed to ourselves could carry the
+		// trace probe instead of encrypted packet, that has to pass the
+		// rest of the path
+		var payload io.Reader = pipeR
+		typ := "trns"
+		if *path[0] == *ctx.SelfId {
+			br := bufio.NewReader(pipeR)
+			magic, err := br.Peek(len(MagicNNCPTv1.B))
+			if err == nil && bytes.Equal(magic, MagicNNCPTv1.B[:]) {
+				typ = "trace"
+			}
+			payload = br
+		}
+		les := append(les, LE{"Type", typ})
+		var probe *TraceProbe
+		if typ == "trace" {
+			path = path[1:]
+			probe, err = traceProbeRead(payload)
+			if err != nil {
+				ctx.LogE("rx-trace", les, err, func(les LEs) string {
+					return fmt.Sprintf("Tossing trace %s/%s: reading", sender.Name, pktName)
+				})
+				return err
+			}
+			les = append(les, LE{"Trace", probe.IdString()})
+			arrived := time.Now()
+			if fi, err := os.Stat(jobPath); err == nil {
+				arrived = fi.ModTime()
+			}
+			ctx.traceHopAdd(probe, arrived)
+		}
+		if probe != nil && len(path) == 0 {
+			logMsg := func(les LEs) string {
+				return fmt.Sprintf(
+					"Tossing trace %s/%s: %s", sender.Name, pktName, probe.IdString(),
+				)
+			}
+			les = append(les, LE{"Dst", probe.Origin})
+			if !dryRun {
+				if _, err = ctx.traceReturn(probe, nice); err != nil {
+					ctx.LogE("rx-trace", les, err, func(les LEs) string {
+						return logMsg(les) + ": returning"
 					})
 					return err
 				}
-			} else {
-				via := node.Via[:len(node.Via)-1]
-				node = ctx.Neigh[*node.Via[len(node.Via)-1]]
-				node = &Node{Id: node.Id, Via: via, ExchPub: node.ExchPub}
-				pktTrns, err := NewPkt(PktTypeTrns, 0, nodeId[:])
-				if err != nil {
-					panic(err)
+			}
+			ctx.LogI("rx", les, func(les LEs) string {
+				return fmt.Sprintf(
+					"Got trace probe %s from %s, returned to %s",
+					probe.IdString(), sender.Name, ctx.NodeName(&probe.Origin),
+				)
+			})
+		} else {
+			nodeId := *path[0]
+			les = append(les, LE{"Dst", nodeId})
+			logMsg := func(les LEs) string {
+				return fmt.Sprintf(
+					"Tossing trns %s/%s (%s): %s",
+					sender.Name, pktName,
+					humanize.IBytes(pktSize),
+					nodeId.String(),
+				)
+			}
+			node := ctx.Neigh[nodeId]
+			if node == nil {
+				err = errors.New("unknown node")
+				ctx.LogE("rx-unknown", les, err, logMsg)
+				return err
+			}
+			ctx.LogD("rx-tx", les, logMsg)
+			transitState, err := ctx.TransitCheck(sender, &nodeId, nice, int64(pktSize))
+			if err != nil {
+				ctx.LogE("rx-transit-deny", les, err, logMsg)
+				ctx.notifyTransitQuota(sender, pktName, err)
+				if !transitDenied(err) || dryRun || jobPath == "" {
+					return err
 				}
-				if _, _, _, err = ctx.Tx(
-					node,
-					pktTrns,
-					nice,
-					int64(pktSize), 0, MaxFileSize,
-					pipeR,
-					pktName,
-					nil,
+				return ctx.transitDrop(payload, jobPath, doSeen, les, logMsg)
+			}
+			relayed := false
+			defer func() {
+				if relayed {
+					return
+				}
+				if err := ctx.TransitRelease(
+					sender, transitState, int64(pktSize),
 				); err != nil {
-					ctx.LogE("rx", les, err, func(les LEs) string {
-						return logMsg(les) + ": txing"
+					ctx.LogE("rx-transit-release", les, err, logMsg)
+				}
+			}()
+			if sender.Transit != nil {
+				ctx.LogD("rx-transit-allow", les, func(les LEs) string {
+					return logMsg(les) + ": allowed by transit policy"
+				})
+			}
+			if !dryRun && probe != nil {
+				hop, err := ctx.traceForward(probe, path, nice)
+				if err != nil {
+					ctx.LogE("rx-trace", les, err, func(les LEs) string {
+						return logMsg(les) + ": forwarding trace"
 					})
 					return err
 				}
+				les = append(les, LE{"Via", hop.Id})
+			} else if !dryRun {
+				vias := node.Via
+				if vias == nil {
+					if route := ctx.RouteRelay(node); route != nil {
+						ctx.LogD("rx-tx-route", append(les, LE{"Via", route.Via}), func(les LEs) string {
+							return logMsg(les) + ": routed via " + ctx.NodeName(route.Via)
+						})
+						vias = []*NodeId{route.Via}
+					}
+				}
+				if len(vias) == 0 {
+					if err = ctx.TxTrns(node, nice, int64(pktSize), payload); err != nil {
+						ctx.LogE("rx", les, err, func(les LEs) string {
+							return logMsg(les) + ": txing"
+						})
+						return err
+					}
+				} else {
+					via := vias[:len(vias)-1]
+					node = ctx.Neigh[*vias[len(vias)-1]]
+					node = &Node{Id: node.Id, Via: via, ExchPub: node.ExchPub}
+					pktTrns, err := NewPkt(PktTypeTrns, 0, nodeId[:])
+					if err != nil {
+						panic(err)
+					}
+					if _, _, _, err = ctx.Tx(
+						node,
+						pktTrns,
+						nice,
+						int64(pktSize), 0, MaxFileSize,
+						payload,
+						pktName,
+						nil,
+					); err != nil {
+						ctx.LogE("rx", les, err, func(les LEs) string {
+							return logMsg(les) + ": txing"
+						})
+						return err
+					}
+				}
 			}
+			relayed = !dryRun
+			ctx.LogI("rx", les, func(les LEs) string {
+				if probe != nil {
+					return fmt.Sprintf(
+						"Got trace probe %s from %s, forwarded to %s",
+						probe.IdString(), sender.Name, ctx.NodeName(&nodeId),
+					)
+				}
+				return fmt.Sprintf(
+					"Got transitional packet from %s to %s (%s)",
+					sender.Name,
+					ctx.NodeName(&nodeId),
+					humanize.IBytes(pktSize),
+				)
+			})
 		}
-		ctx.LogI("rx", les, func(les LEs) string {
-			return fmt.Sprintf(
-				"Got transitional packet from %s to %s (%s)",
-				sender.Name,
-				ctx.NodeName(&nodeId),
-				humanize.IBytes(pktSize),
-			)
-		})
 		if !dryRun && jobPath != "" {
 			if doSeen {
 				if err := ensureDir(filepath.Dir(jobPath), SeenDir); err != nil {
@@ -655,7 +945,7 @@ func jobProcess(
 					if err = DirSync(filepath.Dir(jobPath)); err != nil {
 						ctx.LogE("rx-dirsync", les, err, func(les LEs) string {
 							return fmt.Sprintf(
-								"Tossing file %s/%s (%s): %s: dirsyncing",
+								"Tossing trns %s/%s (%s): %s: dirsyncing",
 								sender.Name, pktName,
 								humanize.IBytes(pktSize),
 								filepath.Base(jobPath),
@@ -668,10 +958,9 @@ func jobProcess(
 			if err = os.Remove(jobPath); err != nil {
 				ctx.LogE("rx", les, err, func(les LEs) string {
 					return fmt.Sprintf(
-						"Tossing trns %s/%s (%s): %s: removing",
+						"Tossing trns %s/%s (%s): removing",
 						sender.Name, pktName,
 						humanize.IBytes(pktSize),
-						ctx.NodeName(&nodeId),
 					)
 				})
 				return err
@@ -701,6 +990,9 @@ func jobProcess(
 			ctx.LogE("rx-area-unknown", les, err, logMsg)
 			return err
 		}
+		areaLock := ctx.areaLock(areaId)
+		areaLock.Lock()
+		defer areaLock.Unlock()
 		pktEnc, pktEncRaw, err := ctx.HdrRead(pipeR)
 		fullPipeR := io.MultiReader(bytes.NewReader(pktEncRaw), pipeR)
 		if err != nil {
@@ -712,6 +1004,34 @@ func jobProcess(
 		les = append(les, LE{"AreaMsg", msgHash})
 		ctx.LogD("rx-area", les, logMsg)
 
+		modState, err := ctx.areaModerate(area, pktEnc, int64(pktSize))
+		if err != nil {
+			// Violating message is dropped, neither tossed, nor echoed
+			lesMod := append(les, LE{"Author", pktEnc.Sender})
+			ctx.LogE("rx-area-moderate", lesMod, err, func(les LEs) string {
+				return logMsg(les) + ": author: " + ctx.NodeName(pktEnc.Sender)
+			})
+			if errors.Is(err, AreaQuotaMsgs) || errors.Is(err, AreaQuotaBytes) {
+				ctx.notifyPkt(
+					NotifyEventQuota, pktEnc.Sender, pktName,
+					fmt.Sprintf(
+						"Area %s quota of %s is exceeded",
+						area.Name, ctx.NodeName(pktEnc.Sender),
+					),
+					nil, err,
+				)
+			}
+			if !dryRun && jobPath != "" {
+				if err = os.Remove(jobPath); err != nil {
+					ctx.LogE("rx-area-remove", lesMod, err, logMsg)
+					return err
+				} else if ctx.HdrUsage {
+					os.Remove(JobPath2Hdr(jobPath))
+				}
+			}
+			return nil
+		}
+
 		if dryRun {
 			for _, nodeId := range area.Subs {
 				node := ctx.Neigh[*nodeId]
@@ -781,6 +1101,16 @@ func jobProcess(
 				}
 				return JobRepeatProcess
 			}
+			if area.Archive > 0 && !ctx.areaArchived(area, msgHash) {
+				if err = ctx.areaArchive(area, msgHash, fullPipeR); err != nil {
+					ctx.LogE("rx-area-archive", les, err, logMsg)
+					return err
+				}
+				ctx.LogD("rx-area-archive", les, func(les LEs) string {
+					return logMsg(les) + ": archived"
+				})
+				return JobRepeatProcess
+			}
 		}
 
 		seenDir := filepath.Join(
@@ -809,10 +1139,45 @@ func jobProcess(
 			return nil
 		}
 
+		var areaKey *AreaKey
+		keyExpired := false
+		if area.Prv != nil {
+			areaKey, err = ctx.areaKeyFind(area, pktEnc.Recipient)
+			if errors.Is(err, AreaKeyExpired) {
+				keyExpired = true
+			} else if err != nil {
+				ctx.LogE("rx-area-key", les, err, logMsg)
+				return err
+			}
+		}
 		if area.Prv == nil {
 			ctx.LogD("rx-area-no-prv", les, func(les LEs) string {
 				return logMsg(les) + ": no private key for decoding"
 			})
+		} else if keyExpired {
+			ctx.LogI("rx-area-no-key", les, func(les LEs) string {
+				return logMsg(les) + ": expired epoch key"
+			})
+		} else if areaKey == nil {
+			// Message can be encrypted with the newer epoch's key, whose
+			// rekey has not arrived yet. Keep it for retrying, unless it
+			// waits longer than the grace period, as removed member
+			// never gets the key
+			var waiting bool
+			if jobPath != "" {
+				if fi, err := os.Stat(jobPath); err == nil {
+					waiting = time.Since(fi.ModTime()) < AreaRekeyGrace
+				}
+			}
+			if waiting {
+				ctx.LogI("rx-area-no-key", les, func(les LEs) string {
+					return logMsg(les) + ": unknown epoch key, waiting for rekey"
+				})
+				return nil
+			}
+			ctx.LogI("rx-area-no-key", les, func(les LEs) string {
+				return logMsg(les) + ": unknown epoch key"
+			})
 		} else {
 			signatureVerify := true
 			if _, senderKnown := ctx.Neigh[*pktEnc.Sender]; !senderKnown {
@@ -831,13 +1196,14 @@ func jobProcess(
 				signatureVerify = false
 			}
 			areaNodeOur := NodeOur{Id: new(NodeId), ExchPrv: new([32]byte)}
-			copy(areaNodeOur.Id[:], area.Id[:])
-			copy(areaNodeOur.ExchPrv[:], area.Prv[:])
+			copy(areaNodeOur.Id[:], areaKey.Id[:])
+			copy(areaNodeOur.ExchPrv[:], areaKey.Prv[:])
 			areaNode := Node{
-				Id:       new(NodeId),
-				Name:     area.Name,
-				Incoming: area.Incoming,
-				Exec:     area.Exec,
+				Id:         new(NodeId),
+				Name:       area.Name,
+				Incoming:   area.Incoming,
+				Exec:       area.Exec,
+				ExecPolicy: area.ExecPolicy,
 			}
 			copy(areaNode.Id[:], area.Id[:])
 			pktName := fmt.Sprintf(
@@ -893,6 +1259,12 @@ func jobProcess(
 					return err
 				}
 			}
+			if err = ctx.areaAccount(
+				area, pktEnc.Sender, modState, int64(pktSize),
+			); err != nil {
+				ctx.LogE("rx-area-account", les, err, logMsg)
+				return err
+			}
 			if err = os.Remove(jobPath); err != nil {
 				ctx.LogE("rx", les, err, func(les LEs) string {
 					return fmt.Sprintf(
@@ -908,6 +1280,72 @@ func jobProcess(
 			}
 		}
 
+	case PktTypeRoute:
+		if noTrns {
+			return nil
+		}
+		les := append(les, LE{"Type", "route"})
+		logMsg := func(les LEs) string {
+			return fmt.Sprintf("Tossing route %s/%s", sender.Name, pktName)
+		}
+		ctx.LogD("rx-route", les, logMsg)
+		if sender.RouteCost == 0 {
+			err = errors.New("routing is disabled with that node")
+			ctx.LogE("rx-route", les, err, logMsg)
+			return err
+		}
+		data, err := routeAdvertRead(pipeR)
+		if err != nil {
+			ctx.LogE("rx-route", les, err, func(les LEs) string {
+				return logMsg(les) + ": reading"
+			})
+			return err
+		}
+		if !dryRun {
+			updated, err := ctx.RouteAdvertSave(sender, data)
+			if err != nil {
+				ctx.LogE("rx-route", les, err, logMsg)
+				return err
+			}
+			if !updated {
+				ctx.LogD("rx-route", les, func(les LEs) string {
+					return logMsg(les) + ": outdated"
+				})
+			}
+		}
+		if !dryRun && doSeen {
+			if err := ensureDir(filepath.Dir(jobPath), SeenDir); err != nil {
+				return err
+			}
+			if fd, err := os.Create(jobPath2Seen(jobPath)); err == nil {
+				fd.Close()
+				if err = DirSync(filepath.Dir(jobPath)); err != nil {
+					ctx.LogE("rx-dirsync", les, err, func(les LEs) string {
+						return fmt.Sprintf(
+							"Tossing file %s/%s (%s): %s: dirsyncing",
+							sender.Name, pktName,
+							humanize.IBytes(pktSize),
+							filepath.Base(jobPath),
+						)
+					})
+					return err
+				}
+			}
+		}
+		if !dryRun {
+			if err = os.Remove(jobPath); err != nil {
+				ctx.LogE("rx", les, err, func(les LEs) string {
+					return logMsg(les) + ": removing job"
+				})
+				return err
+			} else if ctx.HdrUsage {
+				os.Remove(JobPath2Hdr(jobPath))
+			}
+		}
+		ctx.LogI("rx", les, func(les LEs) string {
+			return fmt.Sprintf("Got routing advertisement from %s", sender.Name)
+		})
+
 	case PktTypeACK:
 		if noACK {
 			return nil
@@ -984,11 +1422,179 @@ func jobProcess(
 	return nil
 }
 
+// Decoders pool, limiting the number of concurrently tossed packets.
+func (ctx *Ctx) tossPoolGet() chan *zstd.Decoder {
+	ctx.tossPoolOnce.Do(func() {
+		ctx.tossPool = make(chan *zstd.Decoder, ctx.TossWorkers)
+		for i := 0; i < ctx.TossWorkers; i++ {
+			decompressor, err := zstd.NewReader(nil)
+			if err != nil {
+				panic(err)
+			}
+			ctx.tossPool <- decompressor
+		}
+	})
+	return ctx.tossPool
+}
+
+// Serialize processing of the area's messages, making echo and seen
+// bookkeeping race-free.
+func (ctx *Ctx) areaLock(areaId *AreaId) *sync.Mutex {
+	m, _ := ctx.areaLocks.LoadOrStore(*areaId, new(sync.Mutex))
+	return m.(*sync.Mutex)
+}
+
+var errJobPeeked = errors.New("peeked")
+
+type pktPeeker struct {
+	buf bytes.Buffer
+}
+
+func (w *pktPeeker) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	if int64(w.buf.Len()) >= PktOverhead {
+		return len(p), errJobPeeked
+	}
+	return len(p), nil
+}
+
+// Decrypt the beginning of the job to determine packets that must be
+// tossed in order: exec ones with the same sender and handle, and area
+// ones of the same area. Empty key means no ordering requirement.
+func (ctx *Ctx) jobOrderKey(job Job) string {
+	fd, err := os.Open(job.Path)
+	if err != nil {
+		return ""
+	}
+	defer fd.Close()
+	var w pktPeeker
+	_, _, _, err = PktEncRead(
+		ctx.Self, ctx.Neigh, bufio.NewReaderSize(fd, MTHBlockSize), &w, false, nil,
+	)
+	if err != nil && err != errJobPeeked {
+		return ""
+	}
+	var pkt Pkt
+	if _, err = xdr.Unmarshal(&w.buf, &pkt); err != nil {
+		return ""
+	}
+	switch pkt.Type {
+	case PktTypeExec, PktTypeExecFat:
+		handle := bytes.SplitN(pkt.Path[:int(pkt.PathLen)], []byte{0}, 2)[0]
+		return "exec/" + job.PktEnc.Sender.String() + "/" + string(handle)
+	case PktTypeArea:
+		return "area/" + Base32Codec.EncodeToString(pkt.Path[:int(pkt.PathLen)])
+	}
+	return ""
+}
+
+func (ctx *Ctx) jobToss(
+	job Job,
+	decompressor *zstd.Decoder,
+	dryRun, doSeen, noFile, noFreq, noExec, noTrns, noArea, noACK bool,
+) error {
+	pktName := filepath.Base(job.Path)
+	les := LEs{
+		{"Node", job.PktEnc.Sender},
+		{"Pkt", pktName},
+		{"Nice", int(job.PktEnc.Nice)},
+	}
+	fd, err := os.Open(job.Path)
+	if err != nil {
+		ctx.LogE("rx-open", les, err, func(les LEs) string {
+			return fmt.Sprintf(
+				"Tossing %s/%s: opening %s",
+				ctx.NodeName(job.PktEnc.Sender), pktName, job.Path,
+			)
+		})
+		return err
+	}
+	defer fd.Close()
+	sender := ctx.Neigh[*job.PktEnc.Sender]
+	if sender == nil {
+		err := errors.New("unknown node")
+		ctx.LogE("rx-open", les, err, func(les LEs) string {
+			return fmt.Sprintf(
+				"Tossing %s/%s",
+				ctx.NodeName(job.PktEnc.Sender), pktName,
+			)
+		})
+		return err
+	}
+	errs := make(chan error, 1)
+	var sharedKey []byte
+Retry:
+	pipeR, pipeW := io.Pipe()
+	go func() {
+		errs <- jobProcess(
+			ctx,
+			pipeR,
+			pktName,
+			les,
+			sender,
+			job.PktEnc.Nice,
+			uint64(pktSizeWithoutEnc(job.Size)),
+			job.Path,
+			decompressor,
+			dryRun, doSeen, noFile, noFreq, noExec, noTrns, noArea, noACK,
+		)
+	}()
+	pipeWB := bufio.NewWriter(pipeW)
+	sharedKey, _, _, err = PktEncRead(
+		ctx.Self,
+		ctx.Neigh,
+		bufio.NewReaderSize(fd, MTHBlockSize),
+		pipeWB,
+		sharedKey == nil,
+		sharedKey,
+	)
+	if err != nil {
+		pipeW.CloseWithError(err)
+	}
+	if err := pipeWB.Flush(); err != nil {
+		pipeW.CloseWithError(err)
+	}
+	pipeW.Close()
+
+	if err != nil {
+		<-errs
+		return err
+	}
+	if err = <-errs; err == JobRepeatProcess {
+		if _, err = fd.Seek(0, io.SeekStart); err != nil {
+			ctx.LogE("rx-seek", les, err, func(les LEs) string {
+				return fmt.Sprintf(
+					"Tossing %s/%s: can not seek",
+					ctx.NodeName(job.PktEnc.Sender),
+					pktName,
+				)
+			})
+			return err
+		}
+		goto Retry
+	}
+	return err
+}
+
 func (ctx *Ctx) Toss(
 	nodeId *NodeId,
 	xx TRxTx,
 	nice uint8,
 	dryRun, doSeen, noFile, noFreq, noExec, noTrns, noArea, noACK bool,
+) bool {
+	return ctx.tossJobs(
+		nil, nodeId, xx, nice,
+		dryRun, doSeen, noFile, noFreq, noExec, noTrns, noArea, noACK,
+	)
+}
+
+// Toss node's jobs, skipping the remaining ones after done is closed.
+func (ctx *Ctx) tossJobs(
+	done <-chan struct{},
+	nodeId *NodeId,
+	xx TRxTx,
+	nice uint8,
+	dryRun, doSeen, noFile, noFreq, noExec, noTrns, noArea, noACK bool,
 ) bool {
 	dirLock, err := ctx.LockDir(nodeId, "toss")
 	if err != nil {
@@ -996,110 +1602,127 @@ func (ctx *Ctx) Toss(
 	}
 	defer ctx.UnlockDir(dirLock)
 	isBad := false
-	decompressor, err := zstd.NewReader(nil)
-	if err != nil {
-		panic(err)
+	var decompressor *zstd.Decoder
+	var pool chan *zstd.Decoder
+	if ctx.TossWorkers > 1 {
+		pool = ctx.tossPoolGet()
+	} else {
+		decompressor, err = zstd.NewReader(nil)
+		if err != nil {
+			panic(err)
+		}
+		defer decompressor.Close()
 	}
-	defer decompressor.Close()
-	for job := range ctx.Jobs(nodeId, xx) {
-		pktName := filepath.Base(job.Path)
-		les := LEs{
+	toss := func(job Job, decompressor *zstd.Decoder) bool {
+		err := ctx.jobToss(
+			job, decompressor,
+			dryRun, doSeen, noFile, noFreq, noExec, noTrns, noArea, noACK,
+		)
+		if dryRun || xx != TRx {
+			return err != nil
+		}
+		if err == nil {
+			ctx.jobSucceeded(job)
+			return false
+		}
+		if !jobFailureTransient(err) {
+			ctx.notifyPkt(
+				NotifyEventTossFail, job.PktEnc.Sender, filepath.Base(job.Path),
+				fmt.Sprintf(
+					"Tossing failed: %s/%s",
+					ctx.NodeName(job.PktEnc.Sender), filepath.Base(job.Path),
+				), nil, err,
+			)
+		}
+		ctx.jobFailed(job, LEs{
 			{"Node", job.PktEnc.Sender},
-			{"Pkt", pktName},
-			{"Nice", int(job.PktEnc.Nice)},
+			{"Pkt", filepath.Base(job.Path)},
+		}, err)
+		return true
+	}
+
+	// Fixed number of workers toss the jobs. Job waits for the previous
+	// one with the same ordering key, that is always dispatched earlier
+	type tossTask struct {
+		job     Job
+		prev    chan struct{}
+		jobDone chan struct{}
+	}
+	var wg sync.WaitGroup
+	var isBadM sync.Mutex
+	var tasks chan tossTask
+	if pool != nil {
+		tasks = make(chan tossTask)
+		for i := 0; i < ctx.TossWorkers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for task := range tasks {
+					if task.prev != nil {
+						<-task.prev
+					}
+					decompressor := <-pool
+					bad := toss(task.job, decompressor)
+					pool <- decompressor
+					close(task.jobDone)
+					if bad {
+						isBadM.Lock()
+						isBad = true
+						isBadM.Unlock()
+					}
+				}
+			}()
+		}
+	}
+	prevs := make(map[string]chan struct{})
+	for job := range ctx.Jobs(nodeId, xx) {
+		select {
+		case <-done:
+			// Jobs are still read to let their finder to finish
+			continue
+		default:
 		}
 		if job.PktEnc.Nice > nice {
-			ctx.LogD("rx-too-nice", les, func(les LEs) string {
+			ctx.LogD("rx-too-nice", LEs{
+				{"Node", job.PktEnc.Sender},
+				{"Pkt", filepath.Base(job.Path)},
+				{"Nice", int(job.PktEnc.Nice)},
+			}, func(les LEs) string {
 				return fmt.Sprintf(
 					"Tossing %s/%s: too nice: %s",
-					ctx.NodeName(job.PktEnc.Sender), pktName,
+					ctx.NodeName(job.PktEnc.Sender), filepath.Base(job.Path),
 					NicenessFmt(job.PktEnc.Nice),
 				)
 			})
 			continue
 		}
-		fd, err := os.Open(job.Path)
-		if err != nil {
-			ctx.LogE("rx-open", les, err, func(les LEs) string {
+		if ctx.jobBackoff(job) {
+			ctx.LogD("rx-backoff", LEs{
+				{"Node", job.PktEnc.Sender},
+				{"Pkt", filepath.Base(job.Path)},
+			}, func(les LEs) string {
 				return fmt.Sprintf(
-					"Tossing %s/%s: opening %s",
-					ctx.NodeName(job.PktEnc.Sender), pktName, job.Path,
+					"Tossing %s/%s: failed recently, retry postponed",
+					ctx.NodeName(job.PktEnc.Sender), filepath.Base(job.Path),
 				)
 			})
-			isBad = true
 			continue
 		}
-		sender := ctx.Neigh[*job.PktEnc.Sender]
-		if sender == nil {
-			err := errors.New("unknown node")
-			ctx.LogE("rx-open", les, err, func(les LEs) string {
-				return fmt.Sprintf(
-					"Tossing %s/%s",
-					ctx.NodeName(job.PktEnc.Sender), pktName,
-				)
-			})
-			isBad = true
-			continue
-		}
-		errs := make(chan error, 1)
-		var sharedKey []byte
-	Retry:
-		pipeR, pipeW := io.Pipe()
-		go func() {
-			errs <- jobProcess(
-				ctx,
-				pipeR,
-				pktName,
-				les,
-				sender,
-				job.PktEnc.Nice,
-				uint64(pktSizeWithoutEnc(job.Size)),
-				job.Path,
-				decompressor,
-				dryRun, doSeen, noFile, noFreq, noExec, noTrns, noArea, noACK,
-			)
-		}()
-		pipeWB := bufio.NewWriter(pipeW)
-		sharedKey, _, _, err = PktEncRead(
-			ctx.Self,
-			ctx.Neigh,
-			bufio.NewReaderSize(fd, MTHBlockSize),
-			pipeWB,
-			sharedKey == nil,
-			sharedKey,
-		)
-		if err != nil {
-			pipeW.CloseWithError(err)
-		}
-		if err := pipeWB.Flush(); err != nil {
-			pipeW.CloseWithError(err)
-		}
-		pipeW.Close()
-
-		if err != nil {
-			isBad = true
-			fd.Close()
-			<-errs
+		if tasks == nil {
+			isBad = toss(job, decompressor) || isBad
 			continue
 		}
-		if err = <-errs; err == JobRepeatProcess {
-			if _, err = fd.Seek(0, io.SeekStart); err != nil {
-				ctx.LogE("rx-seek", les, err, func(les LEs) string {
-					return fmt.Sprintf(
-						"Tossing %s/%s: can not seek",
-						ctx.NodeName(job.PktEnc.Sender),
-						pktName,
-					)
-				})
-				isBad = true
-				break
-			}
-			goto Retry
-		} else if err != nil {
-			isBad = true
+		task := tossTask{job: job, jobDone: make(chan struct{})}
+		if key := ctx.jobOrderKey(job); key != "" {
+			task.prev = prevs[key]
+			prevs[key] = task.jobDone
 		}
-		fd.Close()
+		tasks <- task
+	}
+	if tasks != nil {
+		close(tasks)
 	}
+	wg.Wait()
 	return isBad
 }
 
@@ -19,6 +19,7 @@ package nncp
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"fmt"
 	"io"
@@ -27,8 +28,10 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"testing/quick"
+	"time"
 
 	xdr "github.com/davecgh/go-xdr/xdr2"
 )
@@ -528,3 +531,521 @@ func TestTossTrns(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestTossTrnsDenied(t *testing.T) {
+	spool, err := ioutil.TempDir("", "testtoss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(spool)
+	nodeOur, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := Ctx{
+		Spool:      spool,
+		Self:       nodeOur,
+		SelfId:     nodeOur.Id,
+		Neigh:      make(map[NodeId]*Node),
+		Alias:      make(map[string]*NodeId),
+		LogPath:    filepath.Join(spool, "log.log"),
+		Debug:      TDebug,
+		Quarantine: &Quarantine{Retries: 0, Backoff: time.Nanosecond},
+	}
+	ctx.Neigh[*nodeOur.Id] = nodeOur.Their()
+	rxPath := filepath.Join(spool, nodeOur.Id.String(), string(TRx))
+	txPath := filepath.Join(spool, nodeOur.Id.String(), string(TTx))
+	os.MkdirAll(rxPath, os.FileMode(0700))
+	os.MkdirAll(txPath, os.FileMode(0700))
+	trns := func() {
+		pkt := Pkt{Magic: MagicNNCPPv3.B, Type: PktTypeTrns, PathLen: MTHSize}
+		copy(pkt.Path[:], nodeOur.Id[:])
+		var dst bytes.Buffer
+		if _, _, err := PktEncWrite(
+			ctx.Self, ctx.Neigh[*nodeOur.Id], &pkt, 123,
+			0, MaxFileSize, 1, strings.NewReader("DATA"), &dst,
+		); err != nil {
+			t.Fatal(err)
+		}
+		hasher := MTHNew(0, 0)
+		hasher.Write(dst.Bytes())
+		if err := ioutil.WriteFile(
+			filepath.Join(rxPath, Base32Codec.EncodeToString(hasher.Sum(nil))),
+			dst.Bytes(), os.FileMode(0600),
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx.Neigh[*nodeOur.Id].Transit = &Transit{BytesPerDay: 1}
+	trns()
+	for i := 0; i < 2; i++ {
+		ctx.Toss(ctx.Self.Id, TRx, 123,
+			false, false, false, false, false, false, false, false)
+	}
+	if len(dirFiles(rxPath)) != 1 {
+		t.Fatal("packet exceeding quota is not kept")
+	}
+	if len(dirFiles(txPath)) != 0 {
+		t.Fatal("packet exceeding quota is relayed")
+	}
+
+	// Failed quota checking is not a denial
+	statePath := ctx.dailyStatePath(nodeOur.Id, TransitStateFile)
+	if err = ioutil.WriteFile(statePath, []byte(fmt.Sprintf(
+		"Day: %s\nBytes: garbage\nPkts: 0\n",
+		time.Now().UTC().Format(transitDayLayout),
+	)), os.FileMode(0666)); err != nil {
+		t.Fatal(err)
+	}
+	ctx.Neigh[*nodeOur.Id].Transit = &Transit{BytesPerDay: 1 << 20}
+	ctx.Quarantine = nil
+	if !ctx.Toss(ctx.Self.Id, TRx, 123,
+		false, true, false, false, false, false, false, false) {
+		t.Fatal("failed quota checking is not considered bad")
+	}
+	if len(dirFiles(rxPath)) != 1 || len(dirFiles(txPath)) != 0 {
+		t.Fatal("packet is dropped after failed quota checking")
+	}
+	os.Remove(statePath)
+	ctx.Quarantine = &Quarantine{Retries: 0, Backoff: time.Nanosecond}
+
+	ctx.Neigh[*nodeOur.Id].Transit = &Transit{Dsts: map[NodeId]struct{}{}}
+	if ctx.Toss(ctx.Self.Id, TRx, 123,
+		false, true, false, false, false, false, false, false) {
+		t.Fatal("denied packet is considered bad")
+	}
+	if names := dirFiles(rxPath); len(names) != 1 || names[0] != SeenDir {
+		t.Fatal("denied packet is not dropped", names)
+	}
+	if len(dirFiles(txPath)) != 0 {
+		t.Fatal("denied packet is relayed")
+	}
+	if _, err = os.Stat(filepath.Join(spool, nodeOur.Id.String(), QuarantineDir)); err == nil {
+		t.Fatal("denied packet is quarantined")
+	}
+}
+
+func TestTossParallel(t *testing.T) {
+	spool, err := ioutil.TempDir("", "testtoss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(spool)
+	nodeOur, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := Ctx{
+		Spool:       spool,
+		Self:        nodeOur,
+		SelfId:      nodeOur.Id,
+		Neigh:       make(map[NodeId]*Node),
+		Alias:       make(map[string]*NodeId),
+		LogPath:     filepath.Join(spool, "log.log"),
+		Debug:       TDebug,
+		TossWorkers: 4,
+	}
+	ctx.Neigh[*nodeOur.Id] = nodeOur.Their()
+	const execs = 8
+	for i := 0; i < execs; i++ {
+		if err = ctx.TxExec(
+			ctx.Neigh[*nodeOur.Id], DefaultNiceExec, DefaultNiceExec,
+			"sequential", []string{strconv.Itoa(i)}, strings.NewReader("BODY\n"),
+			1<<15, MaxFileSize, false, nil,
+		); err != nil {
+			t.Fatal(err)
+		}
+		src := filepath.Join(spool, "src"+strconv.Itoa(i))
+		if err = ioutil.WriteFile(src, []byte("FILE\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if err = ctx.TxFile(
+			ctx.Neigh[*nodeOur.Id], DefaultNiceFile,
+			src, "file"+strconv.Itoa(i), MaxFileSize, 1<<15, MaxFileSize, nil,
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys := make(map[string]int)
+	for job := range ctx.Jobs(nodeOur.Id, TTx) {
+		keys[ctx.jobOrderKey(job)]++
+	}
+	if keys["exec/"+nodeOur.Id.String()+"/sequential"] != execs || keys[""] != execs {
+		t.Fatal("exec packets are not ordered", keys)
+	}
+
+	rxPath := filepath.Join(spool, nodeOur.Id.String(), string(TRx))
+	os.Rename(filepath.Join(spool, nodeOur.Id.String(), string(TTx)), rxPath)
+	lock := filepath.Join(spool, "lock")
+	mbox := filepath.Join(spool, "mbox")
+	incoming := filepath.Join(spool, "incoming")
+	ctx.Neigh[*nodeOur.Id].Incoming = &incoming
+	ctx.Neigh[*nodeOur.Id].Exec = map[string][]string{"sequential": {
+		"/bin/sh", "-c", fmt.Sprintf(
+			"mkdir %s || echo overlap >> %s ; sleep 0.05 ; rmdir %s ; echo $0 >> %s",
+			lock, mbox, lock, mbox,
+		),
+	}}
+	if ctx.Toss(nodeOur.Id, TRx, 255,
+		false, false, false, false, false, false, false, false) {
+		t.Fatal("tossing failed")
+	}
+	if len(dirFiles(rxPath)) != 0 {
+		t.Fatal("not all packets are tossed")
+	}
+	if len(dirFiles(incoming)) != execs {
+		t.Fatal("not all files are received")
+	}
+	data, err := ioutil.ReadFile(mbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != execs {
+		t.Fatal("unexpected exec invocations:", lines)
+	}
+	for _, line := range lines {
+		if line == "overlap" {
+			t.Fatal("exec handle invocations overlap")
+		}
+	}
+}
+
+func TestTossWorkers(t *testing.T) {
+	spool, err := ioutil.TempDir("", "testtoss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(spool)
+	nodeOur, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const workers = 3
+	ctx := Ctx{
+		Spool:       spool,
+		Self:        nodeOur,
+		SelfId:      nodeOur.Id,
+		Neigh:       make(map[NodeId]*Node),
+		Alias:       make(map[string]*NodeId),
+		LogPath:     filepath.Join(spool, "log.log"),
+		Debug:       TDebug,
+		TossWorkers: workers,
+	}
+	ctx.Neigh[*nodeOur.Id] = nodeOur.Their()
+	incoming := filepath.Join(spool, "incoming")
+	ctx.Neigh[*nodeOur.Id].Incoming = &incoming
+	src := filepath.Join(spool, "src")
+	if err = ioutil.WriteFile(src, []byte("FILE\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	var running, runningMax int
+	var runningM sync.Mutex
+	handler := func(cctx context.Context, req *ExecRequest) error {
+		runningM.Lock()
+		running++
+		if running > runningMax {
+			runningMax = running
+		}
+		runningM.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		runningM.Lock()
+		running--
+		runningM.Unlock()
+		return nil
+	}
+	const pkts = 4 * workers
+	for i := 0; i < pkts; i++ {
+		// Different handles are not ordered between each other
+		handle := "handle" + strconv.Itoa(i)
+		ctx.ExecRegister(nil, handle, handler)
+		if err = ctx.TxExec(
+			ctx.Neigh[*nodeOur.Id], DefaultNiceExec, DefaultNiceExec,
+			handle, nil, strings.NewReader("BODY\n"),
+			1<<15, MaxFileSize, false, nil,
+		); err != nil {
+			t.Fatal(err)
+		}
+		if err = ctx.TxFile(
+			ctx.Neigh[*nodeOur.Id], DefaultNiceFile,
+			src, "samefile", MaxFileSize, 1<<15, MaxFileSize, nil,
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rxPath := filepath.Join(spool, nodeOur.Id.String(), string(TRx))
+	os.Rename(filepath.Join(spool, nodeOur.Id.String(), string(TTx)), rxPath)
+	if ctx.Toss(nodeOur.Id, TRx, 255,
+		false, false, false, false, false, false, false, false) {
+		t.Fatal("tossing failed")
+	}
+	if len(dirFiles(rxPath)) != 0 {
+		t.Fatal("not all packets are tossed")
+	}
+	if runningMax > workers {
+		t.Fatal("more jobs are tossed simultaneously than workers:", runningMax)
+	}
+	if runningMax < 2 {
+		t.Fatal("jobs are not tossed concurrently")
+	}
+
+	// Concurrently received files with the same name get distinct ones
+	expected := map[string]struct{}{"samefile": {}}
+	for i := 0; i < pkts-1; i++ {
+		expected["samefile."+strconv.Itoa(i)] = struct{}{}
+	}
+	for _, filename := range dirFiles(incoming) {
+		if _, exists := expected[filename]; !exists {
+			t.Fatal("unexpected file:", filename)
+		}
+		delete(expected, filename)
+	}
+	if len(expected) != 0 {
+		t.Fatal("files are missing:", expected)
+	}
+}
+
+func TestTossExecReply(t *testing.T) {
+	spool, err := ioutil.TempDir("", "testtoss")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(spool)
+	nodeOur, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := Ctx{
+		Spool:   spool,
+		Self:    nodeOur,
+		SelfId:  nodeOur.Id,
+		Neigh:   make(map[NodeId]*Node),
+		Alias:   make(map[string]*NodeId),
+		LogPath: filepath.Join(spool, "log.log"),
+		Debug:   TDebug,
+	}
+	node := nodeOur.Their()
+	ctx.Neigh[*nodeOur.Id] = node
+	replyNice := uint8(123)
+	if err = ctx.TxExec(
+		node, DefaultNiceExec, replyNice, "handle", []string{"arg0"},
+		strings.NewReader("BODY\n"), 1<<15, MaxFileSize, false, nil,
+	); err != nil {
+		t.Fatal(err)
+	}
+	txPath := filepath.Join(spool, nodeOur.Id.String(), string(TTx))
+	rxPath := filepath.Join(spool, nodeOur.Id.String(), string(TRx))
+	if err = os.Rename(txPath, rxPath); err != nil {
+		t.Fatal(err)
+	}
+	pktNames := dirFiles(rxPath)
+	if len(pktNames) != 1 {
+		t.Fatal("unexpected packets:", pktNames)
+	}
+	incomingPath := filepath.Join(spool, "incoming")
+	node.Incoming = &incomingPath
+	node.Exec = map[string][]string{
+		"handle": {"/bin/sh", "-c", "cat ; echo $0 ; echo oops >&2 ; exit 3"},
+	}
+	node.ExecPolicy = map[string]*ExecPolicy{"handle": {Reply: ExecReplyFile}}
+	ctx.Toss(nodeOur.Id, TRx, DefaultNiceExec,
+		false, false, false, false, false, false, false, false)
+	if len(dirFiles(rxPath)) != 0 {
+		t.Fatal("exec packet is not processed")
+	}
+	if err = os.RemoveAll(rxPath); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Rename(txPath, rxPath); err != nil {
+		t.Fatal(err)
+	}
+	if len(dirFiles(rxPath)) != 1 {
+		t.Fatal("no reply is sent")
+	}
+	ctx.Toss(nodeOur.Id, TRx, replyNice-1,
+		false, false, false, false, false, false, false, false)
+	if len(dirFiles(rxPath)) != 1 {
+		t.Fatal("reply has niceness other than requested")
+	}
+	ctx.Toss(nodeOur.Id, TRx, replyNice,
+		false, false, false, false, false, false, false, false)
+	if len(dirFiles(rxPath)) != 0 {
+		t.Fatal("reply is not processed")
+	}
+	data, err := ioutil.ReadFile(filepath.Join(
+		incomingPath, "handle."+pktNames[0]+".3",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "BODY\narg0\n" {
+		t.Fatalf("unexpected reply: %q", data)
+	}
+}
+
+func TestTossExecReplyUnknown(t *testing.T) {
+	spool, err := ioutil.TempDir("", "testtoss")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(spool)
+	nodeOur, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeStranger, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := Ctx{
+		Spool:   spool,
+		Self:    nodeOur,
+		SelfId:  nodeOur.Id,
+		Neigh:   make(map[NodeId]*Node),
+		Alias:   make(map[string]*NodeId),
+		LogPath: filepath.Join(spool, "log.log"),
+		Debug:   TDebug,
+		Quiet:   true,
+	}
+	ctx.Neigh[*nodeOur.Id] = nodeOur.Their()
+	// Sender is unknown, like the author of the area message
+	stranger := nodeStranger.Their()
+	mbox := filepath.Join(spool, "mbox")
+	stranger.Exec = map[string][]string{
+		"handle": {"/bin/sh", "-c", fmt.Sprintf("cat >> %s", mbox)},
+	}
+	stranger.ExecPolicy = map[string]*ExecPolicy{"handle": {Reply: ExecReplyFile}}
+	pkt, err := NewPkt(PktTypeExecFat, DefaultNiceExec, []byte("handle"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipeR, pipeW := io.Pipe()
+	go func() {
+		xdr.Marshal(pipeW, pkt)
+		pipeW.Write([]byte("BODY\n"))
+		pipeW.Close()
+	}()
+	if err = jobProcess(
+		&ctx, pipeR, "pkt", nil, stranger, DefaultNiceExec, 5, "", nil,
+		false, false, false, false, false, false, false, false,
+	); err != nil {
+		t.Fatal("exec packet with failed reply is not processed:", err)
+	}
+	data, err := ioutil.ReadFile(mbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "BODY\n" {
+		t.Fatalf("unexpected handler input: %q", data)
+	}
+}
+
+func TestTossFilePlacement(t *testing.T) {
+	spool, err := ioutil.TempDir("", "testtoss")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(spool)
+	nodeOur, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := Ctx{
+		Spool:   spool,
+		Self:    nodeOur,
+		SelfId:  nodeOur.Id,
+		Neigh:   make(map[NodeId]*Node),
+		Alias:   make(map[string]*NodeId),
+		LogPath: filepath.Join(spool, "log.log"),
+		Debug:   TDebug,
+	}
+	node := nodeOur.Their()
+	node.Name = "alice"
+	ctx.Neigh[*nodeOur.Id] = node
+	incomingPath := filepath.Join(spool, "incoming")
+	hookLog := filepath.Join(spool, "hook.log")
+	node.Incoming = &incomingPath
+	node.Placement = []*PlacementRule{
+		{Pattern: "*.txt", Dir: "%n/%Y", Collision: PlacementReject},
+		{Pattern: "sub/*", Dir: "..", Collision: PlacementRename},
+		{Pattern: "*.bin", Collision: PlacementOverwrite},
+	}
+	node.PostReceive = []string{
+		"/bin/sh", "-c", fmt.Sprintf("echo $NNCP_NICE $0 >> %s", hookLog),
+	}
+	src := filepath.Join(spool, "src")
+	rxPath := filepath.Join(spool, nodeOur.Id.String(), string(TRx))
+	txPath := filepath.Join(spool, nodeOur.Id.String(), string(TTx))
+	send := func(dst, data string) {
+		if err := ioutil.WriteFile(src, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := ctx.TxFile(
+			node, DefaultNiceFile, src, dst, 0, 0, MaxFileSize, nil,
+		); err != nil {
+			t.Fatal(err)
+		}
+		os.RemoveAll(rxPath)
+		if err := os.Rename(txPath, rxPath); err != nil {
+			t.Fatal(err)
+		}
+		ctx.Toss(nodeOur.Id, TRx, DefaultNiceFile,
+			false, false, false, false, false, false, false, false)
+	}
+	year := time.Now().Format("2006")
+	txtPath := filepath.Join(incomingPath, "alice", year, "a.txt")
+	binPath := filepath.Join(incomingPath, "b.bin")
+
+	for _, f := range [][2]string{
+		{"a.txt", "first"}, {"b.bin", "first"}, {"b.bin", "second"},
+	} {
+		send(f[0], f[1])
+		if len(dirFiles(rxPath)) != 0 {
+			t.Fatal("file is not tossed")
+		}
+	}
+	if data, err := ioutil.ReadFile(txtPath); err != nil || string(data) != "first" {
+		t.Fatal("file is not placed:", err)
+	}
+	if data, err := ioutil.ReadFile(binPath); err != nil || string(data) != "second" {
+		t.Fatal("file is not overwritten:", err)
+	}
+	send("a.txt", "second")
+	if len(dirFiles(rxPath)) != 1 {
+		t.Fatal("collision is not rejected")
+	}
+	if data, err := ioutil.ReadFile(txtPath); err != nil || string(data) != "first" {
+		t.Fatal("rejected file is overwritten:", err)
+	}
+	send("sub/c", "escape")
+	if len(dirFiles(rxPath)) != 1 {
+		t.Fatal("placement escaped incoming")
+	}
+	send("../escape.bin", "escape")
+	if len(dirFiles(rxPath)) != 1 {
+		t.Fatal("destination escaped incoming")
+	}
+	send("alice/../../escape.txt", "escape")
+	if len(dirFiles(rxPath)) != 1 {
+		t.Fatal("destination escaped placement directory")
+	}
+	for _, p := range []string{
+		filepath.Join(spool, "escape.bin"),
+		filepath.Join(incomingPath, "alice", "escape.txt"),
+	} {
+		if _, err = os.Stat(p); err == nil {
+			t.Fatal("escaped file is placed:", p)
+		}
+	}
+	hook, err := ioutil.ReadFile(hookLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nice := strconv.Itoa(int(DefaultNiceFile))
+	if string(hook) != strings.Join([]string{
+		nice + " " + txtPath, nice + " " + binPath, nice + " " + binPath, "",
+	}, "\n") {
+		t.Fatalf("unexpected hook calls: %q", hook)
+	}
+}
@@ -0,0 +1,341 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	xdr "github.com/davecgh/go-xdr/xdr2"
+	"golang.org/x/crypto/ed25519"
+)
+
+const (
+	TraceDir = "trace"
+
+	// Exec handle the accumulated trace is returned to its origin with
+	TraceHandle = "nncp-trace"
+
+	// Maximal number of hops in the trace probe's path
+	TraceMaxPath = MaxPathSize / len(NodeId{})
+
+	TraceProbeMaxSize = 1 << 16
+)
+
+type TraceHopTbs struct {
+	Magic   [8]byte
+	Id      [16]byte
+	Node    NodeId
+	Arrived uint64
+	Tossed  uint64
+}
+
+// Hop record, appended by each node the probe passes through.
+// Times are UNIX nanoseconds of the packet appearance in the inbound
+// queue and of its tossing.
+type TraceHop struct {
+	Node    NodeId
+	Arrived uint64
+	Tossed  uint64
+	Sign    [ed25519.SignatureSize]byte
+}
+
+type TraceProbe struct {
+	Magic  [8]byte
+	Id     [16]byte
+	Origin NodeId
+	Sent   uint64
+	Hops   []TraceHop
+}
+
+func (probe *TraceProbe) IdString() string {
+	return Base32Codec.EncodeToString(probe.Id[:])
+}
+
+func (probe *TraceProbe) hopTbs(hop *TraceHop) []byte {
+	var buf bytes.Buffer
+	if _, err := xdr.Marshal(&buf, TraceHopTbs{
+		Magic:   probe.Magic,
+		Id:      probe.Id,
+		Node:    hop.Node,
+		Arrived: hop.Arrived,
+		Tossed:  hop.Tossed,
+	}); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// Verify hop's signature. False is returned if node is unknown.
+func (ctx *Ctx) TraceHopVerify(probe *TraceProbe, hop *TraceHop) (known, valid bool) {
+	node := ctx.Neigh[hop.Node]
+	if node == nil {
+		return false, false
+	}
+	return true, ed25519.Verify(node.SignPub, probe.hopTbs(hop), hop.Sign[:])
+}
+
+func TraceProbeParse(data []byte) (*TraceProbe, error) {
+	var probe TraceProbe
+	if _, err := xdr.UnmarshalLimited(
+		bytes.NewReader(data), &probe, uint(len(data)),
+	); err != nil {
+		return nil, err
+	}
+	if probe.Magic != MagicNNCPTv1.B {
+		return nil, BadMagic
+	}
+	return &probe, nil
+}
+
+func (probe *TraceProbe) marshal() []byte {
+	var buf bytes.Buffer
+	if _, err := xdr.Marshal(&buf, probe); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func traceProbeRead(r io.Reader) (*TraceProbe, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, TraceProbeMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > TraceProbeMaxSize {
+		return nil, TooBig
+	}
+	return TraceProbeParse(data)
+}
+
+// Parse transitional packet's path: concatenated node ids. Ordinary
+// transitional packet has the single id of the node it must be relayed
+// on. Trace probe's path begins with its recipient's id, followed by
+// the nodes it has still to pass through.
+func transitPathParse(pkt *Pkt) ([]*NodeId, error) {
+	raw := pkt.Path[:int(pkt.PathLen)]
+	if len(raw) == 0 || len(raw)%len(NodeId{}) != 0 {
+		return nil, errors.New("invalid transitional path")
+	}
+	path := make([]*NodeId, 0, len(raw)/len(NodeId{}))
+	for len(raw) > 0 {
+		nodeId := new(NodeId)
+		copy(nodeId[:], raw)
+		path = append(path, nodeId)
+		raw = raw[len(NodeId{}):]
+	}
+	return path, nil
+}
+
+// First hop on the way to the node, according to its via paths and
+// routing table.
+func (ctx *Ctx) FirstHop(node *Node) *Node {
+	via := node.Via
+	if len(node.ViaAlt) > 0 {
+		via = ctx.ViaSelect(node)
+	}
+	if len(via) > 0 {
+		return ctx.Neigh[*via[0]]
+	}
+	if via == nil {
+		if route := ctx.RouteRelay(node); route != nil {
+			return ctx.Neigh[*route.Via]
+		}
+	}
+	return node
+}
+
+// Send the probe directly to the first hop on the way to the path's
+// head, as a transitional packet addressed to that hop.
+func (ctx *Ctx) traceForward(
+	probe *TraceProbe,
+	path []*NodeId,
+	nice uint8,
+) (*Node, error) {
+	target := ctx.Neigh[*path[0]]
+	if target == nil {
+		return nil, errors.New("unknown node")
+	}
+	hop := ctx.FirstHop(target)
+	pathRaw := make([]byte, 0, (len(path)+1)*len(NodeId{}))
+	if *hop.Id != *target.Id {
+		pathRaw = append(pathRaw, hop.Id[:]...)
+	}
+	for _, nodeId := range path {
+		pathRaw = append(pathRaw, nodeId[:]...)
+	}
+	pkt, err := NewPkt(PktTypeTrns, nice, pathRaw)
+	if err != nil {
+		return nil, err
+	}
+	data := probe.marshal()
+	_, _, _, err = ctx.Tx(
+		&Node{Id: hop.Id, ExchPub: hop.ExchPub, Via: []*NodeId{}},
+		pkt, nice,
+		int64(len(data)), 0, MaxFileSize,
+		bytes.NewReader(data), probe.IdString(), nil,
+	)
+	return hop, err
+}
+
+// Return the accumulated trace to its origin.
+func (ctx *Ctx) traceReturn(probe *TraceProbe, nice uint8) (*Node, error) {
+	origin := ctx.Neigh[probe.Origin]
+	if origin == nil {
+		return nil, errors.New("unknown origin")
+	}
+	data := probe.marshal()
+	return origin, ctx.TxExec(
+		origin, nice, nice, TraceHandle, []string{probe.IdString()},
+		bytes.NewReader(data), int64(len(data)), MaxFileSize, false, nil,
+	)
+}
+
+// Save the returned trace, if it is ours.
+func (ctx *Ctx) traceReturned(r io.Reader) (*TraceProbe, error) {
+	probe, err := traceProbeRead(r)
+	if err != nil {
+		return nil, err
+	}
+	if probe.Origin != *ctx.SelfId {
+		return nil, errors.New("trace is not ours")
+	}
+	return probe, ctx.TraceSave(probe)
+}
+
+// Queue the trace probe to the node through its via chain.
+func (ctx *Ctx) TxTrace(node *Node, nice uint8) (*TraceProbe, error) {
+	via := node.Via
+	if len(node.ViaAlt) > 0 {
+		via = ctx.ViaSelect(node)
+	}
+	path := append(append([]*NodeId{}, via...), node.Id)
+	if len(path) >= TraceMaxPath {
+		return nil, errors.New("too long via chain")
+	}
+	probe := TraceProbe{
+		Magic:  MagicNNCPTv1.B,
+		Origin: *ctx.SelfId,
+		Sent:   uint64(time.Now().UnixNano()),
+	}
+	if _, err := rand.Read(probe.Id[:]); err != nil {
+		return nil, err
+	}
+	les := LEs{
+		{"Type", "trace"},
+		{"Node", node.Id},
+		{"Nice", int(nice)},
+		{"Trace", probe.IdString()},
+	}
+	logMsg := func(les LEs) string {
+		return fmt.Sprintf("Trace probe %s to %s", probe.IdString(), node.Name)
+	}
+	hop, err := ctx.traceForward(&probe, path, nice)
+	if err != nil {
+		ctx.LogE("tx", les, err, logMsg)
+		return nil, err
+	}
+	ctx.LogI("tx", append(les, LE{"Via", hop.Id}), logMsg)
+	return &probe, nil
+}
+
+// Append our signed hop record to the probe.
+func (ctx *Ctx) traceHopAdd(probe *TraceProbe, arrived time.Time) {
+	hop := TraceHop{
+		Node:    *ctx.SelfId,
+		Arrived: uint64(arrived.UnixNano()),
+		Tossed:  uint64(time.Now().UnixNano()),
+	}
+	copy(hop.Sign[:], ed25519.Sign(ctx.Self.SignPrv, probe.hopTbs(&hop)))
+	probe.Hops = append(probe.Hops, hop)
+}
+
+func (ctx *Ctx) traceDir() string {
+	return filepath.Join(ctx.Spool, ctx.SelfId.String(), TraceDir)
+}
+
+func (ctx *Ctx) tracePath(id string) string {
+	return filepath.Join(ctx.traceDir(), id)
+}
+
+// Remember returned trace for displaying.
+func (ctx *Ctx) TraceSave(probe *TraceProbe) error {
+	dir := ctx.traceDir()
+	if err := ensureDir(dir); err != nil {
+		return err
+	}
+	tmp, err := TempFile(dir, "trace")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(probe.marshal()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = os.Rename(tmp.Name(), ctx.tracePath(probe.IdString())); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return DirSync(dir)
+}
+
+// Load returned trace. Nil is returned if it has not arrived yet.
+func (ctx *Ctx) TraceLoad(id string) (*TraceProbe, error) {
+	data, err := ioutil.ReadFile(ctx.tracePath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return TraceProbeParse(data)
+}
+
+// Identifiers of all returned traces, oldest first.
+func (ctx *Ctx) Traces() ([]string, error) {
+	fis, err := ioutil.ReadDir(ctx.traceDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	sort.Slice(fis, func(i, j int) bool {
+		return fis[i].ModTime().Before(fis[j].ModTime())
+	})
+	ids := make([]string, 0, len(fis))
+	for _, fi := range fis {
+		if fi.IsDir() || len(fi.Name()) != Base32Codec.EncodedLen(len(TraceProbe{}.Id)) {
+			continue
+		}
+		ids = append(ids, fi.Name())
+	}
+	return ids, nil
+}
@@ -0,0 +1,163 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTraceHops(t *testing.T) {
+	spool, err := ioutil.TempDir("", "testtrace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(spool)
+	our, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay, err := NewNodeGenerate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := Ctx{
+		Spool:  spool,
+		Self:   our,
+		SelfId: our.Id,
+		Neigh:  map[NodeId]*Node{*our.Id: our.Their()},
+	}
+	ctxRelay := Ctx{Self: relay, SelfId: relay.Id}
+
+	probe := TraceProbe{Magic: MagicNNCPTv1.B, Origin: *our.Id}
+	probe.Id[0] = 123
+	ctxRelay.traceHopAdd(&probe, time.Now())
+	ctx.traceHopAdd(&probe, time.Now())
+	parsed, err := TraceProbeParse(probe.marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.Hops) != 2 {
+		t.Fatal("hops are lost")
+	}
+	if known, _ := ctx.TraceHopVerify(parsed, &parsed.Hops[0]); known {
+		t.Fatal("unknown relay is verified")
+	}
+	ctx.Neigh[*relay.Id] = relay.Their()
+	for i := range parsed.Hops {
+		if known, valid := ctx.TraceHopVerify(parsed, &parsed.Hops[i]); !known || !valid {
+			t.Fatal("hop is not verified", i)
+		}
+	}
+	parsed.Hops[0].Arrived++
+	if _, valid := ctx.TraceHopVerify(parsed, &parsed.Hops[0]); valid {
+		t.Fatal("tampered hop is verified")
+	}
+
+	if err = ctx.TraceSave(&probe); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := ctx.Traces()
+	if err != nil || len(ids) != 1 || ids[0] != probe.IdString() {
+		t.Fatal("trace is not listed", ids, err)
+	}
+	loaded, err := ctx.TraceLoad(probe.IdString())
+	if err != nil || loaded == nil || len(loaded.Hops) != 2 {
+		t.Fatal("trace is not loaded", err)
+	}
+}
+
+func TestTraceRelayed(t *testing.T) {
+	spool, err := ioutil.TempDir("", "testtrace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(spool)
+	var nodes [3]*NodeOur
+	for i := range nodes {
+		if nodes[i], err = NewNodeGenerate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	our, relay, dst := nodes[0], nodes[1], nodes[2]
+	ctx := testAreaCtx(filepath.Join(spool, "our"), our, relay, dst)
+	ctx.Neigh[*dst.Id].Via = []*NodeId{relay.Id}
+	ctxRelay := testAreaCtx(filepath.Join(spool, "relay"), relay, our, dst)
+	ctxDst := testAreaCtx(filepath.Join(spool, "dst"), dst, our, relay)
+	ctxDst.Neigh[*our.Id].Via = []*NodeId{relay.Id}
+	for _, c := range []*Ctx{ctx, ctxRelay, ctxDst} {
+		if err = os.MkdirAll(c.Spool, os.FileMode(0777)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	xx := func(src, dst *Ctx, from, to *NodeOur) {
+		testMove(t,
+			filepath.Join(src.Spool, to.Id.String(), string(TTx)),
+			filepath.Join(dst.Spool, from.Id.String(), string(TRx)),
+		)
+	}
+	toss := func(ctx *Ctx, from *NodeOur) {
+		if ctx.Toss(from.Id, TRx, 255,
+			false, false, false, false, false, false, false, false) {
+			t.Fatal("tossing failed")
+		}
+	}
+
+	probe, err := ctx.TxTrace(ctx.Neigh[*dst.Id], DefaultNiceFreq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctxRelay.Neigh[*our.Id].Transit = &Transit{Dsts: map[NodeId]struct{}{}}
+	xx(ctx, ctxRelay, our, relay)
+	toss(ctxRelay, our)
+	if _, err = os.Stat(filepath.Join(
+		ctxRelay.Spool, dst.Id.String(), string(TTx),
+	)); err == nil {
+		t.Fatal("trace is forwarded despite transit policy")
+	}
+
+	if probe, err = ctx.TxTrace(ctx.Neigh[*dst.Id], DefaultNiceFreq); err != nil {
+		t.Fatal(err)
+	}
+	ctxRelay.Neigh[*our.Id].Transit = nil
+	xx(ctx, ctxRelay, our, relay)
+	toss(ctxRelay, our)
+	xx(ctxRelay, ctxDst, relay, dst)
+	toss(ctxDst, relay)
+	xx(ctxDst, ctxRelay, dst, relay)
+	toss(ctxRelay, dst)
+	xx(ctxRelay, ctx, relay, our)
+	toss(ctx, relay)
+
+	returned, err := ctx.TraceLoad(probe.IdString())
+	if err != nil || returned == nil {
+		t.Fatal("trace is not returned", err)
+	}
+	if len(returned.Hops) != 2 ||
+		returned.Hops[0].Node != *relay.Id || returned.Hops[1].Node != *dst.Id {
+		t.Fatal("unexpected trace hops", returned.Hops)
+	}
+	for i := range returned.Hops {
+		if known, valid := ctx.TraceHopVerify(returned, &returned.Hops[i]); !known || !valid {
+			t.Fatal("hop is not verified", i)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"go.cypherpunks.ru/recfile"
+)
+
+const (
+	TransitStateFile    = "transit.state"
+	TransitNotifiedFile = "transit-notified.state"
+
+	transitDayLayout = "2006-01-02"
+)
+
+var (
+	TransitDstDenied  = errors.New("transit to that destination is denied")
+	TransitTooBig     = errors.New("transit packet is too big")
+	TransitNiceTooLow = errors.New("transit packet niceness is below the floor")
+	TransitQuotaBytes = errors.New("transit daily bytes quota is exceeded")
+	TransitQuotaPkts  = errors.New("transit daily packets quota is exceeded")
+)
+
+// Policy of relaying transitional packets received from the node.
+type Transit struct {
+	// Allowed destinations. Nil means any of them.
+	Dsts        map[NodeId]struct{}
+	MaxSize     int64
+	BytesPerDay int64
+	PktsPerDay  int64
+	// Niceness floor: more urgent packets are denied.
+	Nice uint8
+}
+
+// Relayed (or sent by freq) traffic from the node during the day.
+type TransitState struct {
+	Day   string
+	Bytes int64
+	Pkts  int64
+}
+
+func (ctx *Ctx) dailyStatePath(nodeId *NodeId, name string) string {
+	return filepath.Join(ctx.Spool, nodeId.String(), name)
+}
+
+// Load today's transit traffic state of the node.
+func (ctx *Ctx) TransitStateLoad(nodeId *NodeId) (*TransitState, error) {
+	return ctx.dailyStateLoad(nodeId, TransitStateFile)
+}
+
+func (ctx *Ctx) TransitStateSave(nodeId *NodeId, st *TransitState) error {
+	return ctx.dailyStateSave(nodeId, TransitStateFile, st)
+}
+
+// Load today's traffic state of the node from the specified file.
+func (ctx *Ctx) dailyStateLoad(nodeId *NodeId, name string) (*TransitState, error) {
+	return dailyStateRead(ctx.dailyStatePath(nodeId, name))
+}
+
+func (ctx *Ctx) dailyStateSave(nodeId *NodeId, name string, st *TransitState) error {
+	return dailyStateWrite(ctx.dailyStatePath(nodeId, name), st)
+}
+
+func dailyStateRead(path string) (*TransitState, error) {
+	st := TransitState{Day: time.Now().UTC().Format(transitDayLayout)}
+	fd, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &st, nil
+		}
+		return nil, err
+	}
+	defer fd.Close()
+	m, err := recfile.NewReader(fd).NextMap()
+	if err != nil {
+		if err == io.EOF {
+			return &st, nil
+		}
+		return nil, err
+	}
+	if m["Day"] != st.Day {
+		return &st, nil
+	}
+	if st.Bytes, err = strconv.ParseInt(m["Bytes"], 10, 64); err != nil {
+		return nil, err
+	}
+	if st.Pkts, err = strconv.ParseInt(m["Pkts"], 10, 64); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+func dailyStateWrite(path string, st *TransitState) error {
+	var buf bytes.Buffer
+	w := recfile.NewWriter(&buf)
+	if _, err := w.RecordStart(); err != nil {
+		return err
+	}
+	if _, err := w.WriteFields(
+		recfile.Field{Name: "Day", Value: st.Day},
+		recfile.Field{Name: "Bytes", Value: strconv.FormatInt(st.Bytes, 10)},
+		recfile.Field{Name: "Pkts", Value: strconv.FormatInt(st.Pkts, 10)},
+	); err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := ensureDir(dir); err != nil {
+		return err
+	}
+	tmp, err := TempFile(dir, "state")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return DirSync(dir)
+}
+
+// Check if transitional packet from the sender to the destination is
+// allowed by the policy. Packet is accounted in the sender's quota at
+// once, so concurrently tossed packets do not exceed it. Returned
+// state must be passed to TransitRelease if packet is not relayed.
+func (ctx *Ctx) TransitCheck(
+	sender *Node,
+	dst *NodeId,
+	nice uint8,
+	size int64,
+) (*TransitState, error) {
+	t := sender.Transit
+	if t == nil {
+		return nil, nil
+	}
+	if t.Dsts != nil {
+		if _, allowed := t.Dsts[*dst]; !allowed {
+			return nil, TransitDstDenied
+		}
+	}
+	if t.MaxSize > 0 && size > t.MaxSize {
+		return nil, TransitTooBig
+	}
+	if nice < t.Nice {
+		return nil, TransitNiceTooLow
+	}
+	if t.BytesPerDay == 0 && t.PktsPerDay == 0 {
+		return nil, nil
+	}
+	ctx.transitLock.Lock()
+	defer ctx.transitLock.Unlock()
+	st, err := ctx.TransitStateLoad(sender.Id)
+	if err != nil {
+		return nil, err
+	}
+	if t.BytesPerDay > 0 && st.Bytes+size > t.BytesPerDay {
+		return nil, TransitQuotaBytes
+	}
+	if t.PktsPerDay > 0 && st.Pkts+1 > t.PktsPerDay {
+		return nil, TransitQuotaPkts
+	}
+	st.Bytes += size
+	st.Pkts++
+	if err = ctx.TransitStateSave(sender.Id, st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Return not relayed packet's size, accounted by TransitCheck, back to
+// the sender's quota, unless the day has already changed.
+func (ctx *Ctx) TransitRelease(sender *Node, reserved *TransitState, size int64) error {
+	if reserved == nil {
+		return nil
+	}
+	ctx.transitLock.Lock()
+	defer ctx.transitLock.Unlock()
+	st, err := ctx.TransitStateLoad(sender.Id)
+	if err != nil {
+		return err
+	}
+	if st.Day != reserved.Day || st.Pkts == 0 {
+		return nil
+	}
+	st.Bytes -= size
+	if st.Bytes < 0 {
+		st.Bytes = 0
+	}
+	st.Pkts--
+	return ctx.TransitStateSave(sender.Id, st)
+}
+
+// Is the transitional packet denied by the policy itself, not by the
+// exceeded quota or failed state reading.
+func transitDenied(err error) bool {
+	return errors.Is(err, TransitDstDenied) ||
+		errors.Is(err, TransitTooBig) ||
+		errors.Is(err, TransitNiceTooLow)
+}
+
+// Drop the transitional packet denied by the policy. Denial is final,
+// unlike the exceeded quotas, so the packet is not retried.
+func (ctx *Ctx) transitDrop(
+	pipeR io.Reader,
+	jobPath string,
+	doSeen bool,
+	les LEs,
+	logMsg func(les LEs) string,
+) error {
+	if _, err := io.Copy(ioutil.Discard, pipeR); err != nil {
+		return err
+	}
+	if doSeen {
+		if err := ensureDir(filepath.Dir(jobPath), SeenDir); err != nil {
+			return err
+		}
+		if fd, err := os.Create(jobPath2Seen(jobPath)); err == nil {
+			fd.Close()
+		}
+	}
+	if err := os.Remove(jobPath); err != nil {
+		ctx.LogE("rx-transit-drop", les, err, func(les LEs) string {
+			return logMsg(les) + ": removing"
+		})
+		return err
+	} else if ctx.HdrUsage {
+		os.Remove(JobPath2Hdr(jobPath))
+	}
+	if err := DirSync(filepath.Dir(jobPath)); err != nil {
+		return err
+	}
+	ctx.LogI("rx-transit-drop", les, func(les LEs) string {
+		return logMsg(les) + ": dropped, denied by transit policy"
+	})
+	return nil
+}
@@ -0,0 +1,108 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestTransitCheck(t *testing.T) {
+	spool, err := ioutil.TempDir("", "testtransit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(spool)
+	ctx := Ctx{Spool: spool}
+	sender := Node{Id: new(NodeId)}
+	sender.Id[0] = 1
+	allowed, denied := new(NodeId), new(NodeId)
+	allowed[0], denied[0] = 2, 3
+
+	if st, err := ctx.TransitCheck(&sender, denied, 1, 1<<30); st != nil || err != nil {
+		t.Fatal("unrestricted sender is restricted")
+	}
+	sender.Transit = &Transit{
+		Dsts:        map[NodeId]struct{}{*allowed: {}},
+		MaxSize:     100,
+		BytesPerDay: 150,
+		PktsPerDay:  3,
+		Nice:        100,
+	}
+	if _, err = ctx.TransitCheck(&sender, denied, 200, 10); err != TransitDstDenied {
+		t.Fatal("denied destination is allowed")
+	}
+	if _, err = ctx.TransitCheck(&sender, allowed, 200, 101); err != TransitTooBig {
+		t.Fatal("too big packet is allowed")
+	}
+	if _, err = ctx.TransitCheck(&sender, allowed, 1, 10); err != TransitNiceTooLow {
+		t.Fatal("too urgent packet is allowed")
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := ctx.TransitCheck(&sender, allowed, 200, 60); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = ctx.TransitCheck(&sender, allowed, 200, 60); err != TransitQuotaBytes {
+		t.Fatal("bytes quota is not exceeded")
+	}
+	st, err := ctx.TransitCheck(&sender, allowed, 200, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ctx.TransitCheck(&sender, allowed, 200, 1); err != TransitQuotaPkts {
+		t.Fatal("packets quota is not exceeded")
+	}
+	if err = ctx.TransitRelease(&sender, st, 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ctx.TransitCheck(&sender, allowed, 200, 10); err != nil {
+		t.Fatal("released packet is still accounted", err)
+	}
+}
+
+func TestTransitCheckConcurrent(t *testing.T) {
+	spool, err := ioutil.TempDir("", "testtransit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(spool)
+	ctx := Ctx{Spool: spool}
+	sender := Node{Id: new(NodeId), Transit: &Transit{PktsPerDay: 3}}
+	sender.Id[0] = 1
+	var wg sync.WaitGroup
+	var allowedM sync.Mutex
+	allowed := 0
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := ctx.TransitCheck(&sender, new(NodeId), 0, 1); err == nil {
+				allowedM.Lock()
+				allowed++
+				allowedM.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 3 {
+		t.Fatal("quota is overshot", allowed)
+	}
+}
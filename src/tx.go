@@ -59,11 +59,47 @@ func (ctx *Ctx) Tx(
 	areaId *AreaId,
 ) (*Node, int64, string, error) {
 	var area *Area
+	var areaKey *AreaKey
 	if areaId != nil {
 		area = ctx.AreaId2Area[*areaId]
 		if area.Prv == nil {
 			return nil, 0, "", errors.New("area has no encryption keys")
 		}
+		var err error
+		if areaKey, err = ctx.areaKeyCurrent(area); err != nil {
+			return nil, 0, "", err
+		}
+	}
+	var keepInner bool
+	if len(node.ViaAlt) > 0 && areaId == nil {
+		via := ctx.ViaSelect(node)
+		keepInner = len(via) > 0
+		node = &Node{
+			Name:    node.Name,
+			Id:      node.Id,
+			ExchPub: node.ExchPub,
+			Via:     via,
+		}
+	}
+	if areaId == nil {
+		if route := ctx.RouteRelay(node); route != nil {
+			ctx.LogD("tx-route", LEs{
+				{"Node", node.Id},
+				{"Via", route.Via},
+				{"Cost", int(route.Cost)},
+			}, func(les LEs) string {
+				return fmt.Sprintf(
+					"Tx packet to %s is routed via %s (cost %d)",
+					ctx.NodeName(node.Id), ctx.NodeName(route.Via), route.Cost,
+				)
+			})
+			node = &Node{
+				Name:    node.Name,
+				Id:      node.Id,
+				ExchPub: node.ExchPub,
+				Via:     []*NodeId{route.Via},
+			}
+		}
 	}
 	hops := make([]*Node, 0, 1+len(node.Via))
 	hops = append(hops, node)
@@ -84,7 +120,11 @@ func (ctx *Ctx) Tx(
 		if maxSize != 0 && expectedSize > maxSize {
 			return nil, 0, "", TooBig
 		}
-		if !ctx.IsEnoughSpace(expectedSize) {
+		enough, err := ctx.IsEnoughSpace(expectedSize)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		if !enough {
 			return nil, 0, "", errors.New("is not enough space")
 		}
 	}
@@ -92,6 +132,16 @@ func (ctx *Ctx) Tx(
 	if err != nil {
 		return nil, 0, "", err
 	}
+	var inner *os.File
+	if keepInner {
+		inner, err = ctx.NewTmpFile()
+		if err != nil {
+			tmp.Cancel()
+			return nil, 0, "", err
+		}
+		defer os.Remove(inner.Name())
+		defer inner.Close()
+	}
 
 	results := make(chan PktEncWriteResult)
 	pipeR, pipeW := io.Pipe()
@@ -131,8 +181,8 @@ func (ctx *Ctx) Tx(
 				)
 			})
 			areaNode := Node{Id: new(NodeId), ExchPub: new([32]byte)}
-			copy(areaNode.Id[:], area.Id[:])
-			copy(areaNode.ExchPub[:], area.Pub[:])
+			copy(areaNode.Id[:], areaKey.Id[:])
+			copy(areaNode.ExchPub[:], areaKey.Pub[:])
 			pktEncRaw, size, err := PktEncWrite(
 				ctx.Self, &areaNode, pkt, nice, 0, maxSize, 0, src, dst,
 			)
@@ -171,6 +221,9 @@ func (ctx *Ctx) Tx(
 			panic(err)
 		}
 		pipeRPrev = pipeR
+		if i == 1 && inner != nil {
+			pipeRPrev = io.TeeReader(pipeR, inner)
+		}
 		pipeR, pipeW = io.Pipe()
 		go func(node *Node, pkt *Pkt, src io.Reader, dst io.WriteCloser) {
 			ctx.LogD("tx", LEs{
@@ -220,8 +273,12 @@ func (ctx *Ctx) Tx(
 			}
 		}
 	}
+	if err = tmp.W.Flush(); err != nil {
+		tmp.Cancel()
+		return lastNode, 0, "", err
+	}
 	nodePath := filepath.Join(ctx.Spool, lastNode.Id.String())
-	err = tmp.Commit(filepath.Join(nodePath, string(TTx)))
+	err = ctx.spoolQuotaCommit(lastNode.Id, nice, tmp)
 	os.Symlink(nodePath, filepath.Join(ctx.Spool, lastNode.Name))
 	if err != nil {
 		return lastNode, 0, "", err
@@ -229,6 +286,17 @@ func (ctx *Ctx) Tx(
 	if ctx.HdrUsage {
 		ctx.HdrWrite(pktEncRaw, filepath.Join(nodePath, string(TTx), tmp.Checksum()))
 	}
+	if inner != nil {
+		viaDir := filepath.Join(nodePath, string(TTx), ViaDir)
+		if err = ensureDir(viaDir); err == nil {
+			err = os.Rename(inner.Name(), filepath.Join(viaDir, tmp.Checksum()))
+		}
+		if err != nil {
+			ctx.LogE("tx-via", LEs{{"Node", node.Id}}, err, func(les LEs) string {
+				return "Tx packet to " + ctx.NodeName(node.Id) + ": saving for rerouting"
+			})
+		}
+	}
 	if area != nil {
 		msgHashRaw := blake2b.Sum256(pktEncMsg)
 		msgHash := Base32Codec.EncodeToString(msgHashRaw[:])
@@ -411,10 +479,25 @@ func (ctx *Ctx) TxFile(
 	chunkSize, minSize, maxSize int64,
 	areaId *AreaId,
 ) error {
+	_, err := ctx.txFile(
+		node, nice, srcPath, dstPath, chunkSize, minSize, maxSize, areaId,
+	)
+	return err
+}
+
+// Send the file, returning the names of all created packets. Chunked
+// file's metadata packet is the last one.
+func (ctx *Ctx) txFile(
+	node *Node,
+	nice uint8,
+	srcPath, dstPath string,
+	chunkSize, minSize, maxSize int64,
+	areaId *AreaId,
+) (pktNames []string, err error) {
 	dstPathSpecified := false
 	if dstPath == "" {
 		if srcPath == "-" {
-			return errors.New("Must provide destination filename")
+			return nil, errors.New("Must provide destination filename")
 		}
 		dstPath = filepath.Base(srcPath)
 	} else {
@@ -422,14 +505,14 @@ func (ctx *Ctx) TxFile(
 	}
 	dstPath = filepath.Clean(dstPath)
 	if filepath.IsAbs(dstPath) {
-		return errors.New("Relative destination path required")
+		return nil, errors.New("Relative destination path required")
 	}
 	reader, closer, srcSize, archived, err := prepareTxFile(srcPath)
 	if closer != nil {
 		defer closer.Close()
 	}
 	if err != nil {
-		return err
+		return pktNames, err
 	}
 	if archived && !dstPathSpecified {
 		dstPath += TarExt
@@ -438,7 +521,7 @@ func (ctx *Ctx) TxFile(
 	if chunkSize == 0 || (srcSize > 0 && srcSize <= chunkSize) {
 		pkt, err := NewPkt(PktTypeFile, nice, []byte(dstPath))
 		if err != nil {
-			return err
+			return pktNames, err
 		}
 		_, finalSize, pktName, err := ctx.Tx(
 			node, pkt, nice,
@@ -465,10 +548,11 @@ func (ctx *Ctx) TxFile(
 		}
 		if err == nil {
 			ctx.LogI("tx", les, logMsg)
+			pktNames = append(pktNames, pktName)
 		} else {
 			ctx.LogE("tx", les, err, logMsg)
 		}
-		return err
+		return pktNames, err
 	}
 
 	br := bufio.NewReaderSize(reader, MTHBlockSize)
@@ -480,7 +564,7 @@ func (ctx *Ctx) TxFile(
 		path := dstPath + ChunkedSuffixPart + strconv.Itoa(chunkNum)
 		pkt, err := NewPkt(PktTypeFile, nice, []byte(path))
 		if err != nil {
-			return err
+			return pktNames, err
 		}
 		hsh := MTHNew(0, 0)
 		_, size, pktName, err := ctx.Tx(
@@ -510,9 +594,10 @@ func (ctx *Ctx) TxFile(
 		}
 		if err == nil {
 			ctx.LogI("tx", les, logMsg)
+			pktNames = append(pktNames, pktName)
 		} else {
 			ctx.LogE("tx", les, err, logMsg)
-			return err
+			return pktNames, err
 		}
 
 		sizeFull += size - PktOverhead
@@ -537,12 +622,12 @@ func (ctx *Ctx) TxFile(
 	var buf bytes.Buffer
 	_, err = xdr.Marshal(&buf, metaPkt)
 	if err != nil {
-		return err
+		return pktNames, err
 	}
 	path := dstPath + ChunkedSuffixMeta
 	pkt, err := NewPkt(PktTypeFile, nice, []byte(path))
 	if err != nil {
-		return err
+		return pktNames, err
 	}
 	metaPktSize := int64(buf.Len())
 	_, _, pktName, err := ctx.Tx(
@@ -572,10 +657,11 @@ func (ctx *Ctx) TxFile(
 	}
 	if err == nil {
 		ctx.LogI("tx", les, logMsg)
+		pktNames = append(pktNames, pktName)
 	} else {
 		ctx.LogE("tx", les, err, logMsg)
 	}
-	return err
+	return pktNames, err
 }
 
 func (ctx *Ctx) TxFreq(
@@ -584,17 +670,48 @@ func (ctx *Ctx) TxFreq(
 	srcPath, dstPath string,
 	minSize int64,
 ) error {
+	return ctx.TxFreqKind(node, nice, replyNice, srcPath, dstPath, minSize, "")
+}
+
+// Send file request of specified kind: ordinary one for single file,
+// FreqKindList for the signed listing of files matching optional srcPath
+// glob, FreqKindGlob for all files matching srcPath glob, placed under
+// dstPath directory.
+func (ctx *Ctx) TxFreqKind(
+	node *Node,
+	nice, replyNice uint8,
+	srcPath, dstPath string,
+	minSize int64,
+	kind string,
+) error {
+	_, err := ctx.txFreq(node, nice, replyNice, srcPath, dstPath, minSize, kind)
+	return err
+}
+
+func (ctx *Ctx) txFreq(
+	node *Node,
+	nice, replyNice uint8,
+	srcPath, dstPath string,
+	minSize int64,
+	kind string,
+) (string, error) {
 	dstPath = filepath.Clean(dstPath)
 	if filepath.IsAbs(dstPath) {
-		return errors.New("Relative destination path required")
+		return "", errors.New("Relative destination path required")
+	}
+	if srcPath != "" || kind != FreqKindList {
+		srcPath = filepath.Clean(srcPath)
 	}
-	srcPath = filepath.Clean(srcPath)
 	if filepath.IsAbs(srcPath) {
-		return errors.New("Relative source path required")
+		return "", errors.New("Relative source path required")
+	}
+	pktPath := srcPath
+	if kind != "" {
+		pktPath += "\x00" + kind
 	}
-	pkt, err := NewPkt(PktTypeFreq, replyNice, []byte(srcPath))
+	pkt, err := NewPkt(PktTypeFreq, replyNice, []byte(pktPath))
 	if err != nil {
-		return err
+		return "", err
 	}
 	src := strings.NewReader(dstPath)
 	size := int64(src.Len())
@@ -610,6 +727,9 @@ func (ctx *Ctx) TxFreq(
 		{"Dst", dstPath},
 		{"Pkt", pktName},
 	}
+	if kind != "" {
+		les = append(les, LE{"Kind", kind})
+	}
 	logMsg := func(les LEs) string {
 		return fmt.Sprintf(
 			"File request from %s:%s to %s is sent",
@@ -622,7 +742,7 @@ func (ctx *Ctx) TxFreq(
 	} else {
 		ctx.LogE("tx", les, err, logMsg)
 	}
-	return err
+	return pktName, err
 }
 
 func (ctx *Ctx) TxExec(
@@ -635,6 +755,23 @@ func (ctx *Ctx) TxExec(
 	noCompress bool,
 	areaId *AreaId,
 ) error {
+	_, err := ctx.txExec(
+		node, nice, replyNice, handle, args, in,
+		minSize, maxSize, noCompress, areaId,
+	)
+	return err
+}
+
+func (ctx *Ctx) txExec(
+	node *Node,
+	nice, replyNice uint8,
+	handle string,
+	args []string,
+	in io.Reader,
+	minSize int64, maxSize int64,
+	noCompress bool,
+	areaId *AreaId,
+) (string, error) {
 	path := make([][]byte, 0, 1+len(args))
 	path = append(path, []byte(handle))
 	for _, arg := range args {
@@ -646,14 +783,14 @@ func (ctx *Ctx) TxExec(
 	}
 	pkt, err := NewPkt(pktType, replyNice, bytes.Join(path, []byte{0}))
 	if err != nil {
-		return err
+		return "", err
 	}
 	compressErr := make(chan error, 1)
 	if !noCompress {
 		pr, pw := io.Pipe()
 		compressor, err := zstd.NewWriter(pw, zstd.WithEncoderLevel(zstd.SpeedDefault))
 		if err != nil {
-			return err
+			return "", err
 		}
 		go func(r io.Reader) {
 			if _, err := io.Copy(compressor, r); err != nil {
@@ -695,7 +832,7 @@ func (ctx *Ctx) TxExec(
 	} else {
 		ctx.LogE("tx", les, err, logMsg)
 	}
-	return err
+	return pktName, err
 }
 
 func (ctx *Ctx) TxTrns(node *Node, nice uint8, size int64, src io.Reader) error {
@@ -714,8 +851,11 @@ func (ctx *Ctx) TxTrns(node *Node, nice uint8, size int64, src io.Reader) error
 		)
 	}
 	ctx.LogD("tx", les, logMsg)
-	if !ctx.IsEnoughSpace(size) {
-		err := errors.New("is not enough space")
+	enough, err := ctx.IsEnoughSpace(size)
+	if err == nil && !enough {
+		err = errors.New("is not enough space")
+	}
+	if err != nil {
 		ctx.LogE("tx", les, err, logMsg)
 		return err
 	}
@@ -731,7 +871,7 @@ func (ctx *Ctx) TxTrns(node *Node, nice uint8, size int64, src io.Reader) error
 		return err
 	}
 	nodePath := filepath.Join(ctx.Spool, node.Id.String())
-	err = tmp.Commit(filepath.Join(nodePath, string(TTx)))
+	err = ctx.spoolQuotaCommit(node.Id, nice, tmp)
 	if err == nil {
 		ctx.LogI("tx", les, logMsg)
 	} else {
@@ -18,8 +18,28 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 package nncp
 
 import (
+	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
 	"strings"
+	"time"
+)
+
+const (
+	// Directory near tx packets, holding copies of their innermost
+	// packets (encrypted to the destination) for possible rerouting
+	ViaDir = "via"
+
+	// File, which modification time is the last successful online
+	// session with the node
+	LastOnlineFile = "last-online"
+)
+
+var (
+	DefaultViaFailover       = 24 * time.Hour
+	ViaFailoverCheckInterval = 10 * time.Minute
 )
 
 // Helper function for parsing -via command line option
@@ -27,6 +47,7 @@ func ViaOverride(argValue string, ctx *Ctx, node *Node) {
 	if argValue == "" {
 		return
 	}
+	node.ViaAlt = nil
 	if argValue == "-" {
 		node.Via = make([]*NodeId, 0)
 		return
@@ -41,3 +62,235 @@ func ViaOverride(argValue string, ctx *Ctx, node *Node) {
 	}
 	node.Via = vias
 }
+
+// Remember that we have just successfully talked with the node.
+func (ctx *Ctx) OnlineTouch(nodeId *NodeId) {
+	dir := filepath.Join(ctx.Spool, nodeId.String())
+	if err := ensureDir(dir); err != nil {
+		return
+	}
+	pth := filepath.Join(dir, LastOnlineFile)
+	now := time.Now()
+	if err := os.Chtimes(pth, now, now); err == nil {
+		return
+	}
+	if fd, err := os.Create(pth); err == nil {
+		fd.Close()
+	}
+}
+
+// Time of the last successful online session with the node, if known.
+func (ctx *Ctx) LastOnline(nodeId *NodeId) (time.Time, bool) {
+	fi, err := os.Stat(filepath.Join(ctx.Spool, nodeId.String(), LastOnlineFile))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return fi.ModTime(), true
+}
+
+// Is the first hop considered unreachable: it has not been online
+// longer than the failover time. Never seen online hop is, if it has
+// packets waiting in its outbound queue longer than that.
+func (ctx *Ctx) viaIsDown(hop *NodeId, failover time.Duration) bool {
+	if t, known := ctx.LastOnline(hop); known {
+		return time.Since(t) > failover
+	}
+	fis, err := ioutil.ReadDir(filepath.Join(ctx.Spool, hop.String(), string(TTx)))
+	if err != nil {
+		return false
+	}
+	for _, fi := range fis {
+		if fi.Mode().IsRegular() && len(fi.Name()) == Base32Encoded32Len &&
+			time.Since(fi.ModTime()) > failover {
+			return true
+		}
+	}
+	return false
+}
+
+// All node's paths in preference order: main via and alternative ones.
+func (node *Node) ViaPaths() [][]*NodeId {
+	return append([][]*NodeId{node.Via}, node.ViaAlt...)
+}
+
+func viaFirstHop(node *Node, via []*NodeId) *NodeId {
+	if len(via) == 0 {
+		return node.Id
+	}
+	return via[0]
+}
+
+// Choose the most preferred path, which first hop is not unreachable.
+// Main path is returned if all of them are unreachable.
+func (ctx *Ctx) ViaSelect(node *Node) []*NodeId {
+	for _, via := range node.ViaPaths() {
+		if !ctx.viaIsDown(viaFirstHop(node, via), node.ViaFailover) {
+			return via
+		}
+	}
+	return node.Via
+}
+
+func (ctx *Ctx) viaReroute(
+	node *Node,
+	hop *NodeId,
+	job Job,
+	innerPath string,
+	via []*NodeId,
+) error {
+	pktName := Base32Codec.EncodeToString(job.HshValue[:])
+	les := LEs{
+		{"Node", node.Id},
+		{"Pkt", pktName},
+		{"Nice", int(job.PktEnc.Nice)},
+		{"OldVia", hop},
+		{"Via", viaFirstHop(node, via)},
+	}
+	logMsg := func(les LEs) string {
+		return fmt.Sprintf(
+			"Rerouting packet %s to %s: %s is unreachable, now via %s",
+			pktName, node.Name, ctx.NodeName(hop),
+			ctx.NodeName(viaFirstHop(node, via)),
+		)
+	}
+	fd, err := os.Open(innerPath)
+	if err != nil {
+		ctx.LogE("tx-reroute", les, err, logMsg)
+		return err
+	}
+	fi, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		ctx.LogE("tx-reroute", les, err, logMsg)
+		return err
+	}
+	if len(via) == 0 {
+		err = ctx.TxTrns(node, job.PktEnc.Nice, fi.Size(), fd)
+		fd.Close()
+		if err != nil {
+			ctx.LogE("tx-reroute", les, err, logMsg)
+			return err
+		}
+		if innerPath != job.Path {
+			os.Remove(innerPath)
+		}
+	} else {
+		relay := ctx.Neigh[*via[len(via)-1]]
+		pktTrns, err := NewPkt(PktTypeTrns, 0, node.Id[:])
+		if err != nil {
+			panic(err)
+		}
+		lastNode, _, newPktName, err := ctx.Tx(
+			&Node{Id: relay.Id, ExchPub: relay.ExchPub, Via: via[:len(via)-1]},
+			pktTrns,
+			job.PktEnc.Nice,
+			fi.Size(), 0, MaxFileSize,
+			fd,
+			pktName,
+			nil,
+		)
+		fd.Close()
+		if err != nil {
+			ctx.LogE("tx-reroute", les, err, logMsg)
+			return err
+		}
+		les = append(les, LE{"NewPkt", newPktName})
+		// Keep the innermost packet for possible further rerouting
+		dir := filepath.Join(ctx.Spool, lastNode.Id.String(), string(TTx), ViaDir)
+		if err = ensureDir(dir); err != nil {
+			ctx.LogE("tx-reroute", les, err, logMsg)
+			return err
+		}
+		if err = os.Rename(innerPath, filepath.Join(dir, newPktName)); err != nil {
+			ctx.LogE("tx-reroute", les, err, logMsg)
+			return err
+		}
+	}
+	ctx.LogI("tx-reroute", les, logMsg)
+	if err = os.Remove(job.Path); err != nil && !os.IsNotExist(err) {
+		ctx.LogE("tx-retire", les, err, logMsg)
+		return err
+	}
+	if ctx.HdrUsage {
+		os.Remove(JobPath2Hdr(job.Path))
+	}
+	ctx.LogI("tx-retire", LEs{{"Node", hop}, {"Pkt", pktName}}, func(les LEs) string {
+		return fmt.Sprintf("Retired packet %s queued to %s", pktName, ctx.NodeName(hop))
+	})
+	return nil
+}
+
+// Reroute packets to the node, queued to its unreachable first hops,
+// through the alternative paths. Stale copies of innermost packets,
+// which outer ones have already gone, are removed. Rerouting is skipped
+// if it is already being done by another process.
+func (ctx *Ctx) ViaFailover(node *Node) error {
+	if len(node.ViaAlt) == 0 {
+		return nil
+	}
+	dirLock, err := ctx.LockDir(node.Id, ViaDir)
+	if err != nil {
+		return nil
+	}
+	defer ctx.UnlockDir(dirLock)
+	via := ctx.ViaSelect(node)
+	target := viaFirstHop(node, via)
+	seen := make(map[NodeId]struct{})
+	var errLast error
+	for _, path := range node.ViaPaths() {
+		hop := viaFirstHop(node, path)
+		if _, exists := seen[*hop]; exists {
+			continue
+		}
+		seen[*hop] = struct{}{}
+		txPath := filepath.Join(ctx.Spool, hop.String(), string(TTx))
+		if *hop != *node.Id {
+			if fis, err := ioutil.ReadDir(filepath.Join(txPath, ViaDir)); err == nil {
+				for _, fi := range fis {
+					if _, err = os.Stat(filepath.Join(txPath, fi.Name())); os.IsNotExist(err) {
+						os.Remove(filepath.Join(txPath, ViaDir, fi.Name()))
+					}
+				}
+			}
+		}
+		if *hop == *target || !ctx.viaIsDown(hop, node.ViaFailover) {
+			continue
+		}
+		var jobs []Job
+		for job := range ctx.Jobs(hop, TTx) {
+			jobs = append(jobs, job)
+		}
+		for _, job := range jobs {
+			innerPath := job.Path
+			if *hop == *node.Id {
+				if *job.PktEnc.Recipient != *node.Id {
+					continue
+				}
+			} else {
+				innerPath = filepath.Join(txPath, ViaDir, filepath.Base(job.Path))
+				fd, err := os.Open(innerPath)
+				if err != nil {
+					continue
+				}
+				pktEnc, _, err := ctx.HdrRead(fd)
+				fd.Close()
+				if err != nil || *pktEnc.Recipient != *node.Id {
+					continue
+				}
+			}
+			if err := ctx.viaReroute(node, hop, job, innerPath, via); err != nil {
+				errLast = err
+			}
+		}
+	}
+	return errLast
+}
+
+// Run ViaFailover for all nodes having alternative paths.
+func (ctx *Ctx) ViaFailoverAll() {
+	for _, node := range ctx.Neigh {
+		if len(node.ViaAlt) > 0 {
+			ctx.ViaFailover(node)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+/*
+NNCP -- Node to Node copy, utilities for store-and-forward data exchange
+Copyright (C) 2016-2022 Sergey Matveev <stargrave@stargrave.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, version 3 of the License.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package nncp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestViaIsDown(t *testing.T) {
+	spool, err := ioutil.TempDir("", "testvia")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(spool)
+	ctx := Ctx{Spool: spool}
+	hop := new(NodeId)
+	failover := time.Hour
+	if ctx.viaIsDown(hop, failover) {
+		t.Fatal("never seen hop without packets is down")
+	}
+	txPath := filepath.Join(spool, hop.String(), string(TTx))
+	if err = ensureDir(txPath); err != nil {
+		t.Fatal(err)
+	}
+	pktPath := filepath.Join(txPath, Base32Codec.EncodeToString(make([]byte, 32)))
+	if err = ioutil.WriteFile(pktPath, []byte("pkt"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if ctx.viaIsDown(hop, failover) {
+		t.Fatal("never seen hop with fresh packet is down")
+	}
+	old := time.Now().Add(-2 * failover)
+	if err = os.Chtimes(pktPath, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if !ctx.viaIsDown(hop, failover) {
+		t.Fatal("never seen hop with stale packet is not down")
+	}
+	ctx.OnlineTouch(hop)
+	if ctx.viaIsDown(hop, failover) {
+		t.Fatal("recently seen hop is down")
+	}
+	lastOnline := filepath.Join(spool, hop.String(), LastOnlineFile)
+	if err = os.Chtimes(lastOnline, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if !ctx.viaIsDown(hop, failover) {
+		t.Fatal("long unseen hop is not down")
+	}
+}